@@ -0,0 +1,188 @@
+package exporter
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ProbeResult is the JSON rendering of a Measurement served by
+// /api/v1/probe.
+type ProbeResult struct {
+	Script      string            `json:"script"`
+	Target      string            `json:"target"`
+	Labels      map[string]string `json:"labels"`
+	Start       time.Time         `json:"start"`
+	Duration    float64           `json:"duration_seconds"`
+	Success     bool              `json:"success"`
+	ExitCode    int               `json:"exit_code"`
+	Attempts    int               `json:"attempts"`
+	ErrorReason string            `json:"error_reason,omitempty"`
+	Signal      string            `json:"signal,omitempty"`
+	CircuitOpen bool              `json:"circuit_open"`
+	Cancelled   bool              `json:"cancelled"`
+	Disabled    bool              `json:"disabled,omitempty"`
+	Paused      bool              `json:"paused,omitempty"`
+
+	// Output is the truncated output of the script, OutputBytes the size of
+	// the complete output.
+	Output          string `json:"output"`
+	OutputBytes     int64  `json:"output_bytes"`
+	OutputTruncated bool   `json:"output_truncated"`
+
+	Metrics []ProbeMetricFamily `json:"metrics,omitempty"`
+}
+
+// ProbeMetricFamily is a metric parsed from the output of a script with
+// output_metrics.
+type ProbeMetricFamily struct {
+	Name    string        `json:"name"`
+	Help    string        `json:"help,omitempty"`
+	Type    string        `json:"type"`
+	Samples []ProbeSample `json:"samples"`
+}
+
+// ProbeSample is a sample of a ProbeMetricFamily. Counters, gauges and
+// untyped metrics have a Value, histograms and summaries a Count, a Sum and
+// their Buckets or Quantiles. As in the Prometheus HTTP API, values are
+// strings so that NaN and infinities can be represented.
+type ProbeSample struct {
+	Labels    map[string]string `json:"labels,omitempty"`
+	Value     string            `json:"value,omitempty"`
+	Count     *uint64           `json:"count,omitempty"`
+	Sum       string            `json:"sum,omitempty"`
+	Buckets   map[string]uint64 `json:"buckets,omitempty"`
+	Quantiles map[string]string `json:"quantiles,omitempty"`
+}
+
+// NewProbeResult renders measurement as served by /api/v1/probe.
+func NewProbeResult(measurement *Measurement) ProbeResult {
+	script := measurement.Script
+	labels := map[string]string{"script": script.Name}
+	for name, value := range script.Labels {
+		labels[name] = value
+	}
+	if script.Runner == runnerSSH {
+		labels["remote_host"] = script.SSH.remoteHost(measurement.Target)
+	}
+
+	result := ProbeResult{
+		Script:      script.Name,
+		Target:      measurement.Target,
+		Labels:      labels,
+		Start:       measurement.Start,
+		Duration:    measurement.Duration,
+		Success:     measurement.Success == 1,
+		ExitCode:    measurement.ExitCode,
+		Attempts:    measurement.Attempts,
+		ErrorReason: measurement.ErrorReason,
+		Signal:      measurement.Signal,
+		CircuitOpen: measurement.CircuitOpen,
+		Cancelled:   measurement.Cancelled,
+		Disabled:    measurement.Disabled,
+		Paused:      measurement.Paused,
+
+		Output:          measurement.Output,
+		OutputBytes:     measurement.OutputBytes,
+		OutputTruncated: measurement.OutputTruncated,
+	}
+
+	for _, family := range measurement.Metrics {
+		result.Metrics = append(result.Metrics, probeMetricFamily(family))
+	}
+	return result
+}
+
+func probeMetricFamily(family *dto.MetricFamily) ProbeMetricFamily {
+	out := ProbeMetricFamily{
+		Name:    family.GetName(),
+		Help:    family.GetHelp(),
+		Type:    strings.ToLower(family.GetType().String()),
+		Samples: make([]ProbeSample, 0, len(family.Metric)),
+	}
+
+	for _, metric := range family.Metric {
+		var sample ProbeSample
+		if len(metric.Label) > 0 {
+			sample.Labels = make(map[string]string, len(metric.Label))
+			for _, label := range metric.Label {
+				sample.Labels[label.GetName()] = label.GetValue()
+			}
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			sample.Value = formatSample(metric.Counter.GetValue())
+		case dto.MetricType_GAUGE:
+			sample.Value = formatSample(metric.Gauge.GetValue())
+		case dto.MetricType_UNTYPED:
+			sample.Value = formatSample(metric.Untyped.GetValue())
+		case dto.MetricType_HISTOGRAM:
+			sample.Count = metric.Histogram.SampleCount
+			sample.Sum = formatSample(metric.Histogram.GetSampleSum())
+			sample.Buckets = make(map[string]uint64, len(metric.Histogram.Bucket))
+			for _, bucket := range metric.Histogram.Bucket {
+				sample.Buckets[formatSample(bucket.GetUpperBound())] = bucket.GetCumulativeCount()
+			}
+		case dto.MetricType_SUMMARY:
+			sample.Count = metric.Summary.SampleCount
+			sample.Sum = formatSample(metric.Summary.GetSampleSum())
+			sample.Quantiles = make(map[string]string, len(metric.Summary.Quantile))
+			for _, quantile := range metric.Summary.Quantile {
+				sample.Quantiles[formatSample(quantile.GetQuantile())] = formatSample(quantile.GetValue())
+			}
+		}
+		out.Samples = append(out.Samples, sample)
+	}
+	return out
+}
+
+func formatSample(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// apiProbeHandler runs the probe described by the same parameters as /probe
+// and serves the results as JSON once all scripts completed.
+func (e *Exporter) apiProbeHandler(w http.ResponseWriter, r *http.Request, config *Config) {
+	ctx, probeErr := e.probeContext(r, config)
+	if probeErr == nil {
+		results := make([]ProbeResult, 0)
+		err := e.ProbeQuery(ctx, config, r.URL.Query(), func(measurement *Measurement) {
+			results = append(results, NewProbeResult(measurement))
+		})
+		if err == nil {
+			writeAPIData(w, http.StatusOK, results)
+			return
+		}
+		var ok bool
+		if probeErr, ok = err.(*ProbeError); !ok {
+			probeErr = &ProbeError{http.StatusInternalServerError, err.Error()}
+		}
+	}
+
+	if probeErr.Status == 401 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	writeAPIError(w, &apiError{probeErr.Status, probeErrorType(probeErr.Status), probeErr.Message})
+}
+
+// probeErrorType returns the errorType of the API error for a probe failing
+// with status.
+func probeErrorType(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusTooManyRequests:
+		return "too_many_requests"
+	case http.StatusInternalServerError:
+		return "internal"
+	}
+	return "bad_data"
+}
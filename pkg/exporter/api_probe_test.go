@@ -0,0 +1,84 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIProbe(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `
+scripts:
+  - name: metrics
+    script: |
+      echo '# HELP ndt_download_mbps Download speed.'
+      echo 'ndt_download_mbps{server="lga03"} 94.5'
+      echo 'ndt_errors_total NaN'
+    output_metrics: true
+    labels:
+      site: lga03
+  - name: failure
+    script: echo oops; exit 3
+    output_limit: 2
+`))
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	w := httptest.NewRecorder()
+	testExporter.apiProbeHandler(w, httptest.NewRequest("GET", "/api/v1/probe?pattern=.*&target=mlab1.lga03", nil), config)
+	if w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Status string        `json:"status"`
+		Data   []ProbeResult `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	if response.Status != "success" || len(response.Data) != 2 {
+		t.Fatalf("Unexpected response: %s", w.Body.String())
+	}
+
+	results := map[string]ProbeResult{}
+	for _, result := range response.Data {
+		results[result.Script] = result
+	}
+
+	metrics := results["metrics"]
+	if !metrics.Success || metrics.Target != "mlab1.lga03" || metrics.Labels["site"] != "lga03" || metrics.Labels["script"] != "metrics" {
+		t.Errorf("Unexpected result: %+v", metrics)
+	}
+	if len(metrics.Metrics) != 2 {
+		t.Fatalf("Expected 2 metric families, got %+v", metrics.Metrics)
+	}
+	download := metrics.Metrics[0]
+	if download.Name != "ndt_download_mbps" || download.Help != "Download speed." || download.Type != "untyped" ||
+		download.Samples[0].Value != "94.5" || download.Samples[0].Labels["server"] != "lga03" {
+		t.Errorf("Unexpected metric: %+v", download)
+	}
+	if value := metrics.Metrics[1].Samples[0].Value; value != "NaN" {
+		t.Errorf("Expected NaN, got %q", value)
+	}
+
+	failure := results["failure"]
+	if failure.Success || failure.ExitCode != 3 || failure.ErrorReason != reasonNonzeroExit ||
+		!strings.HasPrefix(failure.Output, "oo\n[truncated") || failure.OutputBytes != 5 || !failure.OutputTruncated {
+		t.Errorf("Unexpected result: %+v", failure)
+	}
+
+	for query, expected := range map[string]string{
+		"name=missing&module=missing": `"errorType":"bad_data"`,
+		"name=metrics&target=a%27b":   `"error":"Invalid target parameter"`,
+		"pattern=(":                   `"errorType":"bad_data"`,
+	} {
+		w := httptest.NewRecorder()
+		testExporter.apiProbeHandler(w, httptest.NewRequest("GET", "/api/v1/probe?"+query, nil), config)
+		if w.Code == 200 || !strings.Contains(w.Body.String(), expected) || !strings.Contains(w.Body.String(), `"status":"error"`) {
+			t.Errorf("%s: expected %s, got %d: %s", query, expected, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package exporter
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stagingDir holds the ARTIFACTS_DIR of running scripts within the artifacts
+// directory of the Exporter, so that they can be moved into place by renaming.
+const stagingDir = ".staging"
+
+// Artifacts keeps the files a script writes to ARTIFACTS_DIR, e.g. network
+// traces of a failed measurement, to be served on /artifacts/<script>/.
+type Artifacts struct {
+	// Runs is the number of the most recent runs whose artifacts are kept,
+	// 1 by default.
+	Runs int `yaml:"runs,omitempty"`
+
+	// MaxBytes limits the artifacts kept per run, 10MiB by default. Files
+	// past the limit are discarded.
+	MaxBytes int64 `yaml:"max_bytes,omitempty"`
+
+	// TTL is the number of seconds artifacts are kept, a day by default.
+	TTL int64 `yaml:"ttl,omitempty"`
+
+	// OnFailureOnly discards the artifacts of successful runs.
+	OnFailureOnly bool `yaml:"on_failure_only,omitempty"`
+}
+
+func (a *Artifacts) setDefaults() error {
+	if a.Runs == 0 {
+		a.Runs = 1
+	}
+	if a.MaxBytes == 0 {
+		a.MaxBytes = 10 << 20
+	}
+	if a.TTL == 0 {
+		a.TTL = 86400
+	}
+	if a.Runs < 0 || a.MaxBytes < 0 || a.TTL < 0 {
+		return errors.New("runs, max_bytes and ttl must be positive")
+	}
+	return nil
+}
+
+// artifactsName returns the name of the directory the artifacts of the script
+// with the given name are kept in.
+func artifactsName(name string) string {
+	return unsafeDirRegexp.ReplaceAllString(name, "_")
+}
+
+// newArtifactsDir creates the ARTIFACTS_DIR for a run of script.
+func (e *Exporter) newArtifactsDir(script *Script) (string, error) {
+	staging := filepath.Join(e.ArtifactsDir, stagingDir)
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return "", err
+	}
+	return ioutil.TempDir(staging, artifactsName(script.Name)+"-")
+}
+
+// storeArtifacts moves the artifacts a run of script left in dir into place
+// and discards those of runs no longer kept.
+func (e *Exporter) storeArtifacts(script *Script, target string, start time.Time, dir string, failed bool) error {
+	defer os.RemoveAll(dir)
+
+	if script.Artifacts.OnFailureOnly && !failed {
+		return nil
+	}
+
+	size, err := capArtifacts(dir, script.Artifacts.MaxBytes)
+	if err != nil || size == 0 {
+		return err
+	}
+
+	scriptDir := filepath.Join(e.ArtifactsDir, artifactsName(script.Name))
+	if err := os.MkdirAll(scriptDir, 0755); err != nil {
+		return err
+	}
+
+	run := start.UTC().Format("20060102T150405.000Z")
+	if target != "" {
+		run += "_" + unsafeDirRegexp.ReplaceAllString(target, "_")
+	}
+	if err := os.Rename(dir, filepath.Join(scriptDir, run)); err != nil {
+		return err
+	}
+	os.Chmod(filepath.Join(scriptDir, run), 0755)
+
+	return pruneArtifacts(scriptDir, script.Artifacts)
+}
+
+// capArtifacts removes the files in dir past the first maxBytes, returning the
+// size of the files kept.
+func capArtifacts(dir string, maxBytes int64) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if size+info.Size() > maxBytes {
+			log.Printf("ERROR: discarding artifact %s: exceeds max_bytes %d\n", path, maxBytes)
+			return os.Remove(path)
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// pruneArtifacts removes the runs in scriptDir that are expired or not among
+// the most recent ones kept.
+func pruneArtifacts(scriptDir string, artifacts *Artifacts) error {
+	entries, err := ioutil.ReadDir(scriptDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	// Run directories are named after their start, so that the most recent
+	// sort last.
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	expiry := time.Now().Add(-time.Duration(artifacts.TTL) * time.Second)
+	for i, entry := range entries {
+		if len(entries)-i > artifacts.Runs || entry.ModTime().Before(expiry) {
+			if err := os.RemoveAll(filepath.Join(scriptDir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// artifactsHandler serves the kept artifacts of the scripts of config, after
+// discarding expired ones.
+func (e *Exporter) artifactsHandler(w http.ResponseWriter, r *http.Request, config *Config) {
+	name := strings.SplitN(strings.TrimPrefix(path.Clean(r.URL.Path), "/artifacts/"), "/", 2)[0]
+
+	var script *Script
+	for _, candidate := range config.allScripts() {
+		if candidate.Artifacts != nil && artifactsName(candidate.Name) == name {
+			script = candidate
+		}
+	}
+	if script == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := pruneArtifacts(filepath.Join(e.ArtifactsDir, name), script.Artifacts); err != nil {
+		log.Printf("ERROR: pruning artifacts of %s: %s\n", script.Name, err)
+	}
+
+	http.StripPrefix("/artifacts/", http.FileServer(http.Dir(e.ArtifactsDir))).ServeHTTP(w, r)
+}
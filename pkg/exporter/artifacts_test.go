@@ -0,0 +1,54 @@
+package exporter
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArtifacts(t *testing.T) {
+	e := New("")
+	e.ArtifactsDir = t.TempDir()
+
+	script := &Script{
+		Name:      "trace",
+		Content:   "echo hop > $ARTIFACTS_DIR/trace.txt; head -c 2000 /dev/zero > $ARTIFACTS_DIR/trace.pcap; exit 1",
+		Timeout:   1,
+		Artifacts: &Artifacts{MaxBytes: 1000},
+	}
+	if err := script.setDefaults(); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+	config := &Config{Scripts: []*Script{script}}
+
+	e.runScripts(config.Scripts, "mlab1.lga03")
+	e.runScripts(config.Scripts, "mlab1.lga03")
+
+	runs, err := ioutil.ReadDir(filepath.Join(e.ArtifactsDir, "trace"))
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+	if len(runs) != 1 || !strings.HasSuffix(runs[0].Name(), "_mlab1_lga03") {
+		t.Fatalf("Expected the artifacts of the latest run only, got %v", runs)
+	}
+
+	w := httptest.NewRecorder()
+	e.artifactsHandler(w, httptest.NewRequest("GET", "/artifacts/trace/"+runs[0].Name()+"/trace.txt", nil), config)
+	if w.Body.String() != "hop\n" {
+		t.Errorf("Expected artifact to be served, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	e.artifactsHandler(w, httptest.NewRequest("GET", "/artifacts/trace/"+runs[0].Name()+"/trace.pcap", nil), config)
+	if w.Code != 404 {
+		t.Errorf("Expected artifact exceeding max_bytes to be discarded, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	e.artifactsHandler(w, httptest.NewRequest("GET", "/artifacts/.staging/", nil), config)
+	if w.Code != 404 {
+		t.Errorf("Expected 404 for staging directory, got %d", w.Code)
+	}
+}
@@ -0,0 +1,129 @@
+package exporter
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// ProbeAuth protects /probe, which executes scripts, more strictly than the
+// other endpoints. A probe must pass every configured method.
+type ProbeAuth struct {
+	// BearerTokens are the tokens accepted in an "Authorization: Bearer"
+	// header.
+	BearerTokens []string `yaml:"bearer_tokens,omitempty"`
+
+	// ClientCertificate requires a client certificate verified against the
+	// CA given with --web.tls-client-ca-file.
+	ClientCertificate *ClientCertificateAuth `yaml:"client_certificate,omitempty"`
+}
+
+// ClientCertificateAuth restricts the client certificates accepted for /probe.
+type ClientCertificateAuth struct {
+	// AllowedNames, if set, are regular expressions one of which must match
+	// the common name or a subject alternative name of the certificate in
+	// full.
+	AllowedNames []string `yaml:"allowed_names,omitempty"`
+
+	regexps []*regexp.Regexp
+}
+
+func (a *ProbeAuth) compile() error {
+	for _, token := range a.BearerTokens {
+		if token == "" {
+			return errors.New("empty bearer token")
+		}
+	}
+
+	if c := a.ClientCertificate; c != nil {
+		c.regexps = c.regexps[:0]
+		for _, name := range c.AllowedNames {
+			re, err := regexp.Compile("^(?:" + name + ")$")
+			if err != nil {
+				return fmt.Errorf("invalid allowed_names: %s", err)
+			}
+			c.regexps = append(c.regexps, re)
+		}
+	}
+
+	return nil
+}
+
+// authError is returned by authorize with the HTTP status to respond with.
+type authError struct {
+	status  int
+	message string
+}
+
+func (e *authError) Error() string {
+	return e.message
+}
+
+// authorize checks r against every configured method.
+func (a *ProbeAuth) authorize(r *http.Request) *authError {
+	if a == nil {
+		return nil
+	}
+
+	if len(a.BearerTokens) > 0 {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			return &authError{401, "Bearer token required"}
+		}
+		if !a.validToken(strings.TrimPrefix(header, "Bearer ")) {
+			return &authError{401, "Invalid bearer token"}
+		}
+	}
+
+	if c := a.ClientCertificate; c != nil {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			return &authError{403, "Client certificate required"}
+		}
+		if len(c.regexps) > 0 && !c.allowed(certificateNames(r)) {
+			return &authError{403, "Client certificate not allowed"}
+		}
+	}
+
+	return nil
+}
+
+func (a *ProbeAuth) validToken(token string) bool {
+	valid := false
+	for _, expected := range a.BearerTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func (c *ClientCertificateAuth) allowed(names []string) bool {
+	for _, name := range names {
+		for _, re := range c.regexps {
+			if re.MatchString(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// certificateNames returns the common name and subject alternative names of
+// the verified client certificate of r.
+func certificateNames(r *http.Request) []string {
+	cert := r.TLS.VerifiedChains[0][0]
+
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
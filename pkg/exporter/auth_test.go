@@ -0,0 +1,65 @@
+package exporter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbeAuth(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `
+probe_auth:
+  bearer_tokens: [s3cret]
+  client_certificate:
+    allowed_names: ['prometheus-.*\.monitoring']
+scripts:
+  - name: success
+    script: exit 0
+`))
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	cert := func(cn string) *tls.ConnectionState {
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: cn}}}}}
+	}
+
+	for _, test := range []struct {
+		name   string
+		token  string
+		tls    *tls.ConnectionState
+		status int
+	}{
+		{"NoToken", "", cert("prometheus-0.monitoring"), 401},
+		{"WrongToken", "guess", cert("prometheus-0.monitoring"), 401},
+		{"NoCertificate", "s3cret", nil, 403},
+		{"WrongName", "s3cret", cert("laptop"), 403},
+		{"Authorized", "s3cret", cert("prometheus-0.monitoring"), 200},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/probe?name=success", nil)
+			if test.token != "" {
+				r.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			r.TLS = test.tls
+			w := httptest.NewRecorder()
+
+			testExporter.scriptRunHandler(w, r, config)
+
+			if w.Code != test.status {
+				t.Errorf("Expected %d, got %d: %s", test.status, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	out, err := config.redactedYAML()
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+	if strings.Contains(string(out), "s3cret") {
+		t.Errorf("Expected bearer tokens to be redacted:\n%s", out)
+	}
+}
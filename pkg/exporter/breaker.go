@@ -0,0 +1,84 @@
+package exporter
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Closed circuits idle for longer than the cooldown of their script are
+// dropped once a breaker tracks more targets than this.
+const circuitBreakerPruneSize = 10000
+
+// circuitBreaker tracks consecutive failures of a script per target and stops
+// executing the script once its failure threshold is reached. While the
+// circuit is open the last failed measurement is returned instead. Targets
+// whose last run succeeded are not tracked.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	failures  int
+	openUntil time.Time
+	last      *Measurement
+	updated   time.Time
+}
+
+// run executes measure unless the circuit for target is open.
+func (b *circuitBreaker) run(script *Script, target string, measure func() *Measurement) *Measurement {
+	if script.FailureThreshold <= 0 {
+		return measure()
+	}
+
+	b.mu.Lock()
+	if c, ok := b.circuits[target]; ok && time.Now().Before(c.openUntil) {
+		cached := *c.last
+		b.mu.Unlock()
+		log.Printf("SKIP: %s to %s: circuit open after %d consecutive failures.\n", script.Name, target, script.FailureThreshold)
+		cached.CircuitOpen = true
+		return &cached
+	}
+	b.mu.Unlock()
+
+	measurement := measure()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if measurement.Cancelled {
+		// An abandoned run says nothing about the health of the target.
+		return measurement
+	}
+	if measurement.Success == 1 {
+		delete(b.circuits, target)
+		return measurement
+	}
+
+	now := time.Now()
+	cooldown := time.Duration(script.Cooldown) * time.Second
+	if b.circuits == nil {
+		b.circuits = make(map[string]*circuit)
+	}
+	if len(b.circuits) > circuitBreakerPruneSize {
+		for t, c := range b.circuits {
+			if now.After(c.openUntil) && now.Sub(c.updated) > cooldown {
+				delete(b.circuits, t)
+			}
+		}
+	}
+	c := b.circuits[target]
+	if c == nil {
+		c = &circuit{}
+		b.circuits[target] = c
+	}
+
+	c.failures++
+	c.last = measurement
+	c.updated = now
+	if c.failures >= script.FailureThreshold {
+		c.openUntil = now.Add(cooldown)
+		measurement.CircuitOpen = true
+	}
+	return measurement
+}
@@ -0,0 +1,45 @@
+package exporter
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	script := &Script{Name: "failure", Content: "exit 1", Timeout: 1, FailureThreshold: 2, Cooldown: 60}
+
+	for i, expected := range []bool{false, true, true} {
+		measurement := testExporter.runScripts([]*Script{script}, "fake-target")[0]
+
+		if measurement.CircuitOpen != expected {
+			t.Errorf("Run %d: expected circuit open %t, got %t", i, expected, measurement.CircuitOpen)
+		}
+
+		if measurement.Success != 0 {
+			t.Errorf("Run %d: expected cached failure", i)
+		}
+	}
+
+	// Other targets have their own circuit.
+	if measurement := testExporter.runScripts([]*Script{script}, "other-target")[0]; measurement.CircuitOpen {
+		t.Errorf("Expected circuit for other-target to be closed")
+	}
+}
+
+func TestCircuitBreakerPrune(t *testing.T) {
+	script := &Script{Name: "failure", FailureThreshold: 2}
+	var breaker circuitBreaker
+
+	breaker.run(script, "healthy", func() *Measurement { return &Measurement{Success: 1} })
+	if _, ok := breaker.circuits["healthy"]; ok {
+		t.Errorf("Expected no circuit for a target whose run succeeded")
+	}
+
+	// Without a cooldown every closed circuit is idle for long enough.
+	for i := 0; i < circuitBreakerPruneSize+10; i++ {
+		breaker.run(script, fmt.Sprintf("target-%d", i), func() *Measurement { return &Measurement{} })
+	}
+	if n := len(breaker.circuits); n > circuitBreakerPruneSize+1 {
+		t.Errorf("Expected idle circuits to be dropped, got %d", n)
+	}
+}
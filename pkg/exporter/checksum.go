@@ -0,0 +1,87 @@
+package exporter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// checksumPrefix is the algorithm prefix of the checksums scripts are pinned
+// to.
+const checksumPrefix = "sha256:"
+
+// checksumError is returned when the content of a script does not match the
+// checksum it is pinned to.
+type checksumError struct {
+	checksum string
+	actual   string
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch: content is %s, expected %s", e.actual, e.checksum)
+}
+
+// contentChecksum returns the checksum of content in the form of Checksum.
+func contentChecksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return checksumPrefix + hex.EncodeToString(sum[:])
+}
+
+// verifyChecksums checks that every script of c pinned to a checksum has
+// content matching it.
+func (c *Config) verifyChecksums() error {
+	for _, script := range c.allScripts() {
+		err := script.checkChecksum()
+		if err == nil {
+			err = script.verifyChecksum([]byte(script.Content))
+		}
+		if err != nil {
+			return fmt.Errorf("script %s: %s", script.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkChecksum validates the format of the Checksum of s.
+func (s *Script) checkChecksum() error {
+	if s.Checksum == "" {
+		if s.VerifyChecksumOnRun {
+			return errors.New("verify_checksum_on_run requires checksum")
+		}
+		return nil
+	}
+
+	sum := strings.TrimPrefix(s.Checksum, checksumPrefix)
+	if decoded, err := hex.DecodeString(sum); sum == s.Checksum || err != nil || len(decoded) != sha256.Size {
+		return fmt.Errorf("invalid checksum %q, expected sha256:<hex digest>", s.Checksum)
+	}
+	if s.VerifyChecksumOnRun && s.path == "" {
+		return errors.New("verify_checksum_on_run requires a local script_file")
+	}
+	return nil
+}
+
+// verifyChecksum checks that content matches the Checksum of s, if set.
+func (s *Script) verifyChecksum(content []byte) error {
+	if s.Checksum == "" {
+		return nil
+	}
+	if actual := contentChecksum(content); actual != strings.ToLower(s.Checksum) {
+		return &checksumError{s.Checksum, actual}
+	}
+	return nil
+}
+
+// verifyFileChecksum reads the script_file of s again and checks it against
+// the Checksum, so that a file changed since the config was loaded is not
+// trusted.
+func (s *Script) verifyFileChecksum() error {
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	return s.verifyChecksum(content)
+}
@@ -0,0 +1,72 @@
+package exporter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	content := "exit 0\n"
+	pin := contentChecksum([]byte(content))
+	other := contentChecksum([]byte("exit 1\n"))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, content)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"check.sh": content})
+
+	for config, expected := range map[string]string{
+		"script_file: check.sh\n    checksum: " + pin:                                 "",
+		"script_file: check.sh\n    checksum: sha256:" + strings.ToUpper(pin[7:]):     "",
+		"script: exit 0\n    checksum: " + contentChecksum([]byte("exit 0")):          "",
+		"script_file: " + server.URL + "/check.sh\n    checksum: " + pin:              "",
+		"script_file: check.sh\n    checksum: " + other:                               "checksum mismatch",
+		"script_file: " + server.URL + "/check.sh\n    checksum: " + other:            "checksum mismatch",
+		"script_file: check.sh\n    checksum: md5:abc":                                "invalid checksum",
+		"script_file: check.sh\n    checksum: " + pin[:20]:                            "invalid checksum",
+		"script_file: check.sh\n    verify_checksum_on_run: true":                     "requires checksum",
+		"script: exit 0\n    checksum: " + pin + "\n    verify_checksum_on_run: true": "requires a local script_file",
+	} {
+		writeFiles(t, dir, map[string]string{"config.yml": "scripts:\n  - name: check\n    " + config + "\n"})
+
+		_, err := LoadConfig(filepath.Join(dir, "config.yml"))
+		if expected == "" && err != nil {
+			t.Errorf("Unexpected: %s", err)
+		}
+		if expected != "" && (err == nil || !strings.Contains(err.Error(), expected)) {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestVerifyChecksumOnRun(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"check.sh":   "exit 0\n",
+		"config.yml": "scripts:\n  - name: check\n    script_file: check.sh\n    checksum: " + contentChecksum([]byte("exit 0\n")) + "\n    verify_checksum_on_run: true\n",
+	})
+	e := New(filepath.Join(dir, "config.yml"))
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	script := e.Config().Script("check")
+
+	if measurement := e.runScripts([]*Script{script}, "")[0]; measurement.Success != 1 {
+		t.Errorf("Expected success, got reason %q", measurement.ErrorReason)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "check.sh"), []byte("exit 1\n"), 0644); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	if measurement := e.runScripts([]*Script{script}, "")[0]; measurement.ErrorReason != reasonChecksumMismatch {
+		t.Errorf("Expected reason %q, got %q", reasonChecksumMismatch, measurement.ErrorReason)
+	}
+}
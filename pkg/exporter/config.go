@@ -0,0 +1,952 @@
+package exporter
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	labelNameRegexp  = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+	metricNameRegexp = regexp.MustCompile("^[a-zA-Z_:][a-zA-Z0-9_:]*$")
+)
+
+// Config is a loaded configuration file.
+type Config struct {
+	Scripts []*Script `yaml:"scripts"`
+	Modules []*Module `yaml:"modules,omitempty"`
+
+	// If AllowedTargets is set and not empty, only targets it matches may be
+	// probed, and targets DeniedTargets matches may never be. Scripts may
+	// replace either, e.g. with an empty list to lift the restriction.
+	AllowedTargets *TargetList `yaml:"allowed_targets,omitempty"`
+	DeniedTargets  *TargetList `yaml:"denied_targets,omitempty"`
+
+	// ProbeAuth is required of probes in addition to any protection of the
+	// other endpoints.
+	ProbeAuth *ProbeAuth `yaml:"probe_auth,omitempty"`
+
+	// ClientRateLimit limits the probes each client address may make.
+	ClientRateLimit *RateLimit `yaml:"client_rate_limit,omitempty"`
+
+	// DisablePatterns refuses probes selecting scripts with the pattern
+	// parameter, so that they can only be probed by name.
+	DisablePatterns bool `yaml:"disable_patterns,omitempty"`
+
+	// ScriptsAPIAuth is required of requests to /api/v1/scripts, which is
+	// refused unless it is set.
+	ScriptsAPIAuth *ProbeAuth `yaml:"scripts_api_auth,omitempty"`
+
+	// Vault configures the Vault server secrets referenced in the env of
+	// scripts are fetched from.
+	Vault *Vault `yaml:"vault,omitempty"`
+
+	// Webhooks are notified when a scheduled script starts failing or
+	// succeeds again.
+	Webhooks []*Webhook `yaml:"webhooks,omitempty"`
+
+	// Snippets are named templates scripts with template: true include with
+	// {{ template "<name>" . }}, such as common preambles and helper
+	// functions.
+	Snippets map[string]string `yaml:"snippets,omitempty"`
+
+	// SnippetSignatures are the base64 Ed25519 signatures of the snippets,
+	// by name, required if the exporter is given signing keys.
+	SnippetSignatures map[string]string `yaml:"snippet_signatures,omitempty"`
+
+	// Include lists glob patterns of further config files, relative to the
+	// including file, whose scripts and modules are merged into the config.
+	Include includePatterns `yaml:"include,omitempty"`
+
+	// hash is the SHA-256 of the files the config was loaded from, and
+	// generation counts the configs activated by the exporter up to this one.
+	hash       string
+	generation int64
+	loadedAt   time.Time
+
+	// configFiles lists the config files merged into the config, files
+	// these and all script files they reference.
+	configFiles []string
+	files       []string
+
+	// sums maps the config files to the SHA-256 of their content.
+	sums map[string]string
+}
+
+// Script is a script that can be probed by name, along with the state of its
+// previous runs.
+type Script struct {
+	Name    string `yaml:"name"`
+	Content string `yaml:"script"`
+	Timeout int64  `yaml:"timeout"`
+
+	// Description tells what the script measures. It is shown on the landing
+	// page and the scripts API, and is the HELP of the metrics of its runs.
+	Description string `yaml:"description,omitempty"`
+
+	// Interval, if set, runs the script every Interval seconds in the
+	// background once Schedule is called, exposing its latest result on
+	// /metrics. Schedule instead runs it at the times of a cron expression,
+	// with an optional seconds field, in Timezone or else local time.
+	Interval int64  `yaml:"interval,omitempty"`
+	Schedule string `yaml:"schedule,omitempty"`
+	Timezone string `yaml:"timezone,omitempty"`
+
+	// Mode "daemon" makes the exporter run the script continuously instead
+	// of on probes, configured by Daemon.
+	Mode   string  `yaml:"mode,omitempty"`
+	Daemon *Daemon `yaml:"daemon,omitempty"`
+
+	// MaxInterval, if set, doubles the interval after every consecutive
+	// failed run, up to MaxInterval seconds, until a run succeeds.
+	MaxInterval int64 `yaml:"max_interval,omitempty"`
+
+	// Jitter adds up to Jitter seconds, chosen at random, to every scheduled
+	// run, so that exporters started together do not run the script in
+	// lockstep.
+	Jitter float64 `yaml:"jitter,omitempty"`
+
+	// ExpireAfter is the number of seconds the latest result of the script
+	// is exposed on /metrics for, replacing the TTL of the Exporter. Expired
+	// results are removed, unless KeepExpired is set, in which case they are
+	// reported with a result_expired metric of 1.
+	ExpireAfter int64 `yaml:"expire_after,omitempty"`
+	KeepExpired bool  `yaml:"keep_expired,omitempty"`
+
+	// File, if set, is read into Content when the config is loaded. Relative
+	// paths are resolved against the directory of the config file, and URLs
+	// are fetched like remote config files.
+	File string `yaml:"script_file,omitempty"`
+
+	// Checksum pins the content of the script to a "sha256:<hex digest>",
+	// checked whenever the config is loaded. With VerifyChecksumOnRun the
+	// local script_file is read again and checked before every run.
+	Checksum            string `yaml:"checksum,omitempty"`
+	VerifyChecksumOnRun bool   `yaml:"verify_checksum_on_run,omitempty"`
+
+	// Signature is the base64 Ed25519 signature of the content of the
+	// script, required if the exporter is given signing keys. It defaults to
+	// the content of the script_file with the extension .sig appended.
+	Signature string `yaml:"signature,omitempty"`
+
+	// Env is added to the environment the script runs with, and Labels to
+	// every metric reported for the script. Values referring to secrets, such
+	// as vault:<path>#<key>, are replaced by the secret when the config is
+	// loaded.
+	Env    map[string]string `yaml:"env,omitempty"`
+	Labels map[string]string `yaml:"labels,omitempty"`
+
+	// MLabLabels adds the machine, site, metro and project of targets naming
+	// M-Lab machines to the labels of the metrics of the script.
+	MLabLabels bool `yaml:"mlab_labels,omitempty"`
+
+	// Requires lists the binaries the script needs, which are looked up in
+	// PATH when the config is loaded.
+	Requires []string `yaml:"requires,omitempty"`
+
+	// Template renders the content of the script as a text/template before
+	// every run, with the target, its components, the query parameters of
+	// the probe listed in Params and Vars.
+	Template bool              `yaml:"template,omitempty"`
+	Params   []string          `yaml:"params,omitempty"`
+	Vars     map[string]string `yaml:"vars,omitempty"`
+
+	// InterpreterArgs are passed to the shell of the shell runner before the
+	// arguments that make it run the script, such as ["-e", "-u"] for a
+	// strict mode.
+	InterpreterArgs []string `yaml:"interpreter_args,omitempty"`
+
+	// SyntaxCheck is the command the script is fed to on stdin to check its
+	// syntax when the config is loaded, e.g. "bash -n", replacing the check
+	// of the shell. "none" disables the check.
+	SyntaxCheck string `yaml:"syntax_check,omitempty"`
+
+	// CleanEnv keeps scripts run by the shell runner from inheriting the
+	// environment of the exporter, except for the variables listed in
+	// EnvPassthrough, PATH and HOME by default.
+	CleanEnv       bool     `yaml:"clean_env,omitempty"`
+	EnvPassthrough []string `yaml:"env_passthrough,omitempty"`
+
+	// Path lists directories searched for the commands of the script, run by
+	// the shell runner, and its requires before PATH, and Workdir is the
+	// directory it starts in instead of that of the exporter.
+	Path    []string `yaml:"path,omitempty"`
+	Workdir string   `yaml:"workdir,omitempty"`
+
+	// EphemeralWorkdir runs the script with the shell runner in a new
+	// temporary directory, passed as WORKDIR, that is removed after the run
+	// unless the run failed and KeepWorkdirOnFailure is set.
+	EphemeralWorkdir     bool `yaml:"ephemeral_workdir,omitempty"`
+	KeepWorkdirOnFailure bool `yaml:"keep_workdir_on_failure,omitempty"`
+
+	// Artifacts keeps the files the script, run by the shell runner, writes
+	// to the directory ARTIFACTS_DIR.
+	Artifacts *Artifacts `yaml:"artifacts,omitempty"`
+
+	// After FailureThreshold consecutive failures against a target the
+	// script is not executed again for Cooldown seconds. Zero disables it.
+	FailureThreshold int   `yaml:"failure_threshold,omitempty"`
+	Cooldown         int64 `yaml:"cooldown,omitempty"`
+
+	// A failed run is retried up to Retries times within the script timeout,
+	// waiting RetryBackoff seconds before the first retry and doubling after.
+	Retries      int     `yaml:"retries,omitempty"`
+	RetryBackoff float64 `yaml:"retry_backoff,omitempty"`
+
+	// Disabled keeps the script from being run, its probes reporting only
+	// that it is disabled, unless it is enabled through the scripts API.
+	Disabled bool `yaml:"disabled,omitempty"`
+
+	// AcceptBody lets POST probes feed their body to the script on stdin.
+	AcceptBody bool `yaml:"accept_body,omitempty"`
+
+	// FailureStatus is the HTTP status /probe responds with when the script
+	// and all other scripts probed with it fail, e.g. 503.
+	FailureStatus int `yaml:"failure_status,omitempty"`
+
+	// Concurrent probes of the script against the same target share a
+	// single execution.
+	Coalesce bool `yaml:"coalesce,omitempty"`
+
+	// When false, a probe arriving while the script is still running from a
+	// previous probe returns the previous result instead. Defaults to true.
+	AllowOverlap *bool `yaml:"allow_overlap,omitempty"`
+
+	// Runner selects how the script is executed: "shell" (the default) feeds
+	// it to the local shell, "docker" runs it in a container described by
+	// Docker, "ssh" on the remote machine described by SSH, "kubernetes" in
+	// the running container described by Kubernetes, "starlark" in the
+	// embedded Starlark interpreter and "wasm" executes the WebAssembly
+	// module described by WASM. Other runners can be added with
+	// RegisterRunner.
+	Runner     string            `yaml:"runner,omitempty"`
+	Docker     *DockerRunner     `yaml:"docker,omitempty"`
+	SSH        *SSHRunner        `yaml:"ssh,omitempty"`
+	Kubernetes *KubernetesRunner `yaml:"kubernetes,omitempty"`
+	WASM       *WASMRunner       `yaml:"wasm,omitempty"`
+
+	// Hardening restricts the privileges of scripts run by the shell runner,
+	// and Sandbox what they can read and write.
+	Hardening *Hardening `yaml:"hardening,omitempty"`
+	Sandbox   *Sandbox   `yaml:"sandbox,omitempty"`
+
+	// MetricPrefix replaces "script" as the prefix of the names of the
+	// metrics reported for the script.
+	MetricPrefix string `yaml:"metric_prefix,omitempty"`
+
+	// DurationBuckets are the buckets of the script's histogram of run
+	// durations on /metrics.
+	DurationBuckets []float64 `yaml:"duration_buckets,omitempty"`
+
+	// ResolveTarget makes the exporter resolve the host of the target before
+	// running the script and pass the address as TARGET_IP, preferring the IP
+	// version IPProtocol, "ip4" or "ip6" (the default).
+	ResolveTarget bool   `yaml:"resolve_target,omitempty"`
+	IPProtocol    string `yaml:"ip_protocol,omitempty"`
+
+	// AllowedTargets and DeniedTargets replace those of the config for the
+	// script.
+	AllowedTargets *TargetList `yaml:"allowed_targets,omitempty"`
+	DeniedTargets  *TargetList `yaml:"denied_targets,omitempty"`
+
+	// RateLimit limits the number of probes of the script, over all targets
+	// and clients.
+	RateLimit *RateLimit `yaml:"rate_limit,omitempty"`
+
+	// OutputLimit is the number of bytes of stdout and stderr captured per
+	// run, replacing the default of the Exporter.
+	OutputLimit int64 `yaml:"output_limit,omitempty"`
+
+	// OutputMetrics makes the metrics the script writes to stdout in the
+	// Prometheus text format part of the probe result, after applying
+	// MetricRelabelConfigs to them in order.
+	OutputMetrics        bool             `yaml:"output_metrics,omitempty"`
+	MetricRelabelConfigs []*RelabelConfig `yaml:"metric_relabel_configs,omitempty"`
+
+	// SuccessWhen replaces the default rule that a run succeeds if it exits
+	// with status 0.
+	SuccessWhen *SuccessCriteria `yaml:"success_when,omitempty"`
+
+	// metricPrefix is MetricPrefix or the default of the Exporter the
+	// script was loaded by.
+	metricPrefix string
+
+	// cron is the compiled Schedule, in location.
+	cron     cron.Schedule
+	location *time.Location
+
+	// template is the compiled content of a script with Template.
+	template *template.Template
+
+	breaker circuitBreaker
+	limiter rateLimiter
+	flights flightGroup
+	overlap overlapGuard
+	history historyRing
+
+	// origin is the config file the script is defined in, and path the
+	// local script_file it was read from.
+	origin string
+	path   string
+
+	// secrets maps the names of the variables of Env referring to secrets
+	// to their values.
+	secrets map[string]string
+}
+
+func (s *Script) allowOverlap() bool {
+	return s.AllowOverlap == nil || *s.AllowOverlap
+}
+
+// prefix returns the prefix of the script's metric names.
+func (s *Script) prefix() string {
+	switch {
+	case s.MetricPrefix != "":
+		return s.MetricPrefix
+	case s.metricPrefix != "":
+		return s.metricPrefix
+	}
+	return "script"
+}
+
+// Module bundles a script with its parameters, labels and target validation
+// so that it can be probed by name with /probe?module=<name>&target=<target>.
+type Module struct {
+	Script `yaml:",inline"`
+
+	// TargetPattern, if set, is a regular expression every target probed
+	// with the module must match.
+	TargetPattern string `yaml:"target_pattern,omitempty"`
+
+	// Targets are probed with the module by the Prometheus jobs the exporter
+	// generates, in scrape configs or file_sd documents.
+	Targets []string `yaml:"targets,omitempty"`
+
+	targetRegexp *regexp.Regexp
+}
+
+// module returns the module with the given name, or nil.
+func (c *Config) module(name string) *Module {
+	for _, module := range c.Modules {
+		if module.Name == name {
+			return module
+		}
+	}
+	return nil
+}
+
+// Script returns the script with the given name, or the script of the module
+// with the given name, or nil.
+func (c *Config) Script(name string) *Script {
+	for _, script := range c.allScripts() {
+		if script.Name == name {
+			return script
+		}
+	}
+	return nil
+}
+
+// targetAllowed reports whether script may be probed against target under the
+// allowed and denied targets of the script, or else those of the config.
+func (c *Config) targetAllowed(script *Script, target string) bool {
+	allowed, denied := c.AllowedTargets, c.DeniedTargets
+	if script.AllowedTargets != nil {
+		allowed = script.AllowedTargets
+	}
+	if script.DeniedTargets != nil {
+		denied = script.DeniedTargets
+	}
+
+	if denied != nil && denied.matches(target) {
+		return false
+	}
+	return allowed == nil || allowed.empty() || allowed.matches(target)
+}
+
+// allScripts returns the configured scripts followed by the scripts of all
+// modules.
+func (c *Config) allScripts() []*Script {
+	return append(append([]*Script{}, c.Scripts...), c.moduleScripts()...)
+}
+
+func (c *Config) moduleScripts() []*Script {
+	scripts := make([]*Script, 0, len(c.Modules))
+	for _, module := range c.Modules {
+		scripts = append(scripts, &module.Script)
+	}
+	return scripts
+}
+
+// LoadConfig reads and validates the configuration file at path, along with
+// the files it includes, and fills in default values.
+func LoadConfig(path string) (*Config, error) {
+	return loadConfig([]string{path}, loadOptions{})
+}
+
+// loadOptions change how config files are read.
+type loadOptions struct {
+	// expandEnv enables expanding environment variable references in the
+	// config values.
+	expandEnv bool
+
+	// format is the format of all config files, which is otherwise told by
+	// their extension.
+	format string
+
+	remote remoteOptions
+
+	// apiScripts are the scripts added through /api/v1/scripts.
+	apiScripts []*Script
+
+	// signingKeys, if any, are the keys one of which every script must be
+	// signed with.
+	signingKeys []ed25519.PublicKey
+}
+
+// loadConfig is LoadConfig for the configuration merged from all files at
+// paths.
+func loadConfig(paths []string, options loadOptions) (*Config, error) {
+	config := &Config{loadedAt: time.Now(), sums: make(map[string]string)}
+	hash := sha256.New()
+
+	for _, path := range paths {
+		if err := config.merge(path, options, hash, nil); err != nil {
+			return nil, err
+		}
+	}
+	if err := config.addAPIScripts(options.apiScripts, hash); err != nil {
+		return nil, err
+	}
+	config.hash = hex.EncodeToString(hash.Sum(nil))
+
+	var err error
+	for _, list := range []*TargetList{config.AllowedTargets, config.DeniedTargets} {
+		if list == nil {
+			continue
+		}
+		if err = list.compile(); err != nil {
+			return nil, fmt.Errorf("target list: %s", err)
+		}
+	}
+
+	if config.ProbeAuth != nil {
+		if err = config.ProbeAuth.compile(); err != nil {
+			return nil, fmt.Errorf("probe_auth: %s", err)
+		}
+	}
+
+	if config.ScriptsAPIAuth != nil {
+		if err = config.ScriptsAPIAuth.compile(); err != nil {
+			return nil, fmt.Errorf("scripts_api_auth: %s", err)
+		}
+	}
+
+	if config.ClientRateLimit != nil {
+		if err = config.ClientRateLimit.setDefaults(); err != nil {
+			return nil, fmt.Errorf("client_rate_limit: %s", err)
+		}
+	}
+
+	if config.Vault != nil {
+		if err = config.Vault.setDefaults(); err != nil {
+			return nil, fmt.Errorf("vault: %s", err)
+		}
+	}
+
+	for i, hook := range config.Webhooks {
+		if err = hook.setDefaults(); err != nil {
+			return nil, fmt.Errorf("webhooks[%d]: %s", i, err)
+		}
+	}
+
+	if err = config.checkNames(); err != nil {
+		return nil, err
+	}
+
+	if err = config.verifyChecksums(); err != nil {
+		return nil, err
+	}
+
+	if err = config.verifySignatures(options.signingKeys); err != nil {
+		return nil, err
+	}
+
+	for name := range config.Snippets {
+		if name == "" {
+			return nil, errors.New("snippets: empty snippet name")
+		}
+	}
+
+	for _, script := range config.Scripts {
+		if err = script.setDefaults(); err != nil {
+			return nil, fmt.Errorf("script %s: %s", script.Name, err)
+		}
+		if err = script.compileTemplate(config.Snippets); err != nil {
+			return nil, fmt.Errorf("script %s: %s", script.Name, err)
+		}
+	}
+
+	for _, module := range config.Modules {
+		if err = module.setDefaults(); err != nil {
+			return nil, fmt.Errorf("module %s: %s", module.Name, err)
+		}
+		if err = module.compileTemplate(config.Snippets); err != nil {
+			return nil, fmt.Errorf("module %s: %s", module.Name, err)
+		}
+		if module.Interval != 0 || module.Schedule != "" {
+			return nil, fmt.Errorf("module %s: modules cannot be scheduled, having no target", module.Name)
+		}
+		if module.daemon() {
+			return nil, fmt.Errorf("module %s: modules cannot be daemons", module.Name)
+		}
+
+		if module.TargetPattern != "" {
+			module.targetRegexp, err = regexp.Compile(module.TargetPattern)
+
+			if err != nil {
+				return nil, fmt.Errorf("module %s: invalid target_pattern: %s", module.Name, err)
+			}
+		}
+		for _, target := range module.Targets {
+			if err = module.validateTarget(target); err != nil {
+				return nil, fmt.Errorf("module %s: invalid target %q: %s", module.Name, target, err)
+			}
+			if module.targetRegexp != nil && !module.targetRegexp.MatchString(target) {
+				return nil, fmt.Errorf("module %s: target %q does not match target_pattern", module.Name, target)
+			}
+		}
+	}
+
+	if err = config.resolveSecrets(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// checkNames rejects empty script and module names, names that cannot be
+// label values and names used twice, which would make the metrics of the
+// scripts collide.
+func (c *Config) checkNames() error {
+	seen := make(map[string]string)
+	positions := make(map[string]int)
+
+	check := func(kind string, script *Script) error {
+		// Scripts are numbered per config file, which is named if several
+		// were merged.
+		positions[kind+" "+script.origin]++
+		position := fmt.Sprintf("%s %d", kind, positions[kind+" "+script.origin])
+		if len(c.configFiles) > 1 || script.origin == apiScriptsOrigin {
+			position += " in " + script.origin
+		}
+
+		switch name := script.Name; {
+		case name == "":
+			return fmt.Errorf("%s has no name", position)
+		case !utf8.ValidString(name):
+			return fmt.Errorf("%s: name %q is not valid UTF-8", position, name)
+		case strings.IndexFunc(name, unicode.IsControl) >= 0:
+			return fmt.Errorf("%s: name %q contains control characters", position, name)
+		}
+		if previous, ok := seen[script.Name]; ok {
+			return fmt.Errorf("%s: name %q is already used by %s", position, script.Name, previous)
+		}
+		seen[script.Name] = position
+		return nil
+	}
+
+	for _, script := range c.Scripts {
+		if err := check("script", script); err != nil {
+			return err
+		}
+	}
+	for _, module := range c.Modules {
+		if err := check("module", &module.Script); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadScriptFile reads the content of script from its script_file, if any.
+func (c *Config) loadScriptFile(script *Script, dir string, options remoteOptions) error {
+	if script.File == "" {
+		return nil
+	}
+
+	if script.Content != "" {
+		return errors.New("only one of script and script_file may be set")
+	}
+
+	if isRemote(script.File) {
+		content, err := options.readRemote(script.File)
+		if err != nil {
+			return err
+		}
+		script.Content = string(content)
+
+		// Remote script files are refreshed along with remote config
+		// files.
+		sum := sha256.Sum256(content)
+		c.sums[script.File] = hex.EncodeToString(sum[:])
+		return nil
+	}
+
+	path := script.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	script.Content = string(content)
+	script.path = path
+	c.files = append(c.files, path)
+
+	// A detached signature is kept next to the script file.
+	if script.Signature == "" {
+		signature, err := ioutil.ReadFile(path + signatureExt)
+		if err == nil {
+			script.Signature = strings.TrimSpace(string(signature))
+			c.files = append(c.files, path+signatureExt)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Script) setDefaults() error {
+	if s.Timeout == 0 {
+		s.Timeout = 15
+	}
+	if s.Interval < 0 {
+		return errors.New("interval must not be negative")
+	}
+	if s.MaxInterval != 0 && (s.Interval == 0 || s.MaxInterval < s.Interval) {
+		return errors.New("max_interval requires an interval no longer than it")
+	}
+	if s.Jitter < 0 {
+		return errors.New("jitter must not be negative")
+	}
+	if err := s.compileSchedule(); err != nil {
+		return err
+	}
+	if err := s.checkMode(); err != nil {
+		return err
+	}
+	if s.Jitter > 0 && !s.scheduled() {
+		return errors.New("jitter requires interval or schedule")
+	}
+	if s.ExpireAfter < 0 {
+		return errors.New("expire_after must not be negative")
+	}
+	if s.KeepExpired && s.ExpireAfter == 0 {
+		return errors.New("keep_expired requires expire_after")
+	}
+	if s.SyntaxCheck != "" && len(strings.Fields(s.SyntaxCheck)) == 0 {
+		return errors.New("empty syntax_check")
+	}
+	if s.FailureThreshold > 0 && s.Cooldown == 0 {
+		s.Cooldown = 60
+	}
+
+	switch s.Runner {
+	case "", runnerShell:
+	case runnerDocker:
+		if s.Docker == nil || s.Docker.Image == "" {
+			return errors.New("docker runner requires docker.image")
+		}
+	case runnerSSH:
+		if s.SSH == nil || s.SSH.User == "" {
+			return errors.New("ssh runner requires ssh.user")
+		}
+		if s.SSH.KeyFile == "" && !s.SSH.UseAgent {
+			return errors.New("ssh runner requires ssh.key_file or ssh.use_agent")
+		}
+		if _, ok := s.Labels["remote_host"]; ok {
+			return errors.New(`label "remote_host" is reserved for the ssh runner`)
+		}
+	case runnerKubernetes:
+		if s.Kubernetes == nil {
+			s.Kubernetes = &KubernetesRunner{}
+		}
+	case runnerWASM:
+		if s.WASM == nil {
+			return errors.New("wasm runner requires wasm.module")
+		}
+		if err := s.WASM.setDefaults(); err != nil {
+			return err
+		}
+	default:
+		if !runnerRegistered(s.Runner) {
+			return fmt.Errorf("unknown runner %q", s.Runner)
+		}
+	}
+
+	if s.AcceptBody && (s.Runner == runnerDocker || s.Runner == runnerKubernetes) {
+		return fmt.Errorf("accept_body is not supported by the %s runner", s.Runner)
+	}
+
+	if (s.Hardening != nil || s.Sandbox != nil) && s.Runner != "" && s.Runner != runnerShell {
+		return errors.New("hardening and sandbox require the shell runner")
+	}
+	if len(s.Requires) > 0 && s.Runner != "" && s.Runner != runnerShell {
+		return errors.New("requires is only checked for the shell runner")
+	}
+	if len(s.InterpreterArgs) > 0 && s.Runner != "" && s.Runner != runnerShell {
+		return errors.New("interpreter_args require the shell runner")
+	}
+	if s.EphemeralWorkdir && s.Runner != "" && s.Runner != runnerShell {
+		return errors.New("ephemeral_workdir requires the shell runner")
+	}
+	if (len(s.Path) > 0 || s.Workdir != "") && s.Runner != "" && s.Runner != runnerShell {
+		return errors.New("path and workdir require the shell runner")
+	}
+	for _, dir := range s.Path {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("path %q is not absolute", dir)
+		}
+	}
+	if s.Workdir != "" {
+		if !filepath.IsAbs(s.Workdir) {
+			return fmt.Errorf("workdir %q is not absolute", s.Workdir)
+		}
+		if s.EphemeralWorkdir || s.Sandbox != nil {
+			return errors.New("workdir, ephemeral_workdir and sandbox are exclusive")
+		}
+	}
+	if s.Artifacts != nil {
+		if s.Runner != "" && s.Runner != runnerShell {
+			return errors.New("artifacts require the shell runner")
+		}
+		if err := s.Artifacts.setDefaults(); err != nil {
+			return fmt.Errorf("artifacts: %s", err)
+		}
+	}
+	if s.EphemeralWorkdir && s.Sandbox != nil {
+		return errors.New("ephemeral_workdir and sandbox are exclusive, the scratch directory of the sandbox is the working directory")
+	}
+	if s.Hardening != nil {
+		if err := s.Hardening.compile(); err != nil {
+			return fmt.Errorf("hardening: %s", err)
+		}
+	}
+	if s.Sandbox != nil {
+		if err := s.Sandbox.setDefaults(); err != nil {
+			return fmt.Errorf("sandbox: %s", err)
+		}
+	}
+
+	if s.MetricPrefix != "" && !metricNameRegexp.MatchString(s.MetricPrefix) {
+		return fmt.Errorf("invalid metric_prefix %q", s.MetricPrefix)
+	}
+
+	if s.AllowedTargets != nil {
+		if err := s.AllowedTargets.compile(); err != nil {
+			return fmt.Errorf("allowed_targets: %s", err)
+		}
+	}
+	if s.DeniedTargets != nil {
+		if err := s.DeniedTargets.compile(); err != nil {
+			return fmt.Errorf("denied_targets: %s", err)
+		}
+	}
+
+	if s.RateLimit != nil {
+		if err := s.RateLimit.setDefaults(); err != nil {
+			return fmt.Errorf("rate_limit: %s", err)
+		}
+	}
+
+	switch s.IPProtocol {
+	case "", "ip4", "ip6":
+	default:
+		return fmt.Errorf("invalid ip_protocol %q", s.IPProtocol)
+	}
+
+	if s.OutputLimit < 0 {
+		return errors.New("output_limit must not be negative")
+	}
+
+	if s.FailureStatus != 0 && (s.FailureStatus < 400 || s.FailureStatus > 599) {
+		return fmt.Errorf("invalid failure_status %d", s.FailureStatus)
+	}
+
+	if err := validateBuckets(s.DurationBuckets); err != nil {
+		return fmt.Errorf("duration_buckets: %s", err)
+	}
+
+	for i, config := range s.MetricRelabelConfigs {
+		if err := config.compile(); err != nil {
+			return fmt.Errorf("metric_relabel_configs[%d]: %s", i, err)
+		}
+	}
+
+	if s.SuccessWhen != nil {
+		if err := s.SuccessWhen.compile(); err != nil {
+			return fmt.Errorf("success_when: %s", err)
+		}
+	}
+
+	for name := range s.Labels {
+		if !labelNameRegexp.MatchString(name) || name == "script" || name == "reason" || name == "signal" {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	if s.MLabLabels {
+		for _, name := range mlabLabels {
+			if _, ok := s.Labels[name]; ok {
+				return fmt.Errorf("label %q is reserved by mlab_labels", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// redactedYAML renders the config with the values of all script and module
+// environment variables replaced, as these commonly hold credentials, as well
+// as the bearer tokens accepted for probes and the userinfo and query values
+// of URLs.
+func (c *Config) redactedYAML() ([]byte, error) {
+	out, err := yaml.Marshal(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	redacted := &Config{}
+
+	if err = yaml.Unmarshal(out, redacted); err != nil {
+		return nil, err
+	}
+
+	for _, auth := range []*ProbeAuth{redacted.ProbeAuth, redacted.ScriptsAPIAuth} {
+		if auth == nil {
+			continue
+		}
+		for i := range auth.BearerTokens {
+			auth.BearerTokens[i] = "<redacted>"
+		}
+	}
+	for _, hook := range redacted.Webhooks {
+		hook.URL = redactURL(hook.URL)
+	}
+	if redacted.Vault != nil {
+		redacted.Vault.Address = redactURL(redacted.Vault.Address)
+	}
+	scripts := redacted.Scripts
+	for _, module := range redacted.Modules {
+		scripts = append(scripts, &module.Script)
+	}
+	for _, script := range scripts {
+		redactEnv(script.Env)
+		if script.Daemon != nil {
+			script.Daemon.MetricsURL = redactURL(script.Daemon.MetricsURL)
+		}
+		if script.Kubernetes != nil {
+			script.Kubernetes.APIServer = redactURL(script.Kubernetes.APIServer)
+		}
+	}
+
+	return yaml.Marshal(redacted)
+}
+
+// redactURL returns raw with its userinfo and the values of its query
+// replaced, or replaces all of it if it cannot be parsed.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<redacted>"
+	}
+	if u.User == nil && u.RawQuery == "" {
+		return raw
+	}
+
+	query, fragment := u.Query(), u.EscapedFragment()
+	user := u.User
+	u.User, u.RawQuery, u.Fragment, u.RawFragment = nil, "", "", ""
+	redacted := u.String()
+	if user != nil {
+		redacted = strings.Replace(redacted, "//", "//<redacted>@", 1)
+	}
+	if len(query) > 0 {
+		pairs := make([]string, 0, len(query))
+		for name := range query {
+			pairs = append(pairs, url.QueryEscape(name)+"=<redacted>")
+		}
+		sort.Strings(pairs)
+		redacted += "?" + strings.Join(pairs, "&")
+	}
+	if fragment != "" {
+		redacted += "#" + fragment
+	}
+	return redacted
+}
+
+func redactEnv(env map[string]string) {
+	for name := range env {
+		env[name] = "<redacted>"
+	}
+}
+
+// configHandler serves the active configuration as YAML.
+func configHandler(w http.ResponseWriter, r *http.Request, config *Config) {
+	out, err := config.redactedYAML()
+
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "# sha256: %s\n# generation: %d\n# loaded: %s\n", config.hash, config.generation, config.loadedAt.Format(time.RFC3339))
+	w.Write(out)
+}
+
+// apiConfigHandler serves the active configuration wrapped in a JSON document,
+// following the layout of Prometheus' /api/v1/status/config.
+func apiConfigHandler(w http.ResponseWriter, r *http.Request, config *Config) {
+	out, err := config.redactedYAML()
+
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"yaml":       string(out),
+			"hash":       config.hash,
+			"generation": config.generation,
+			"loaded_at":  config.loadedAt.Format(time.RFC3339),
+		},
+	})
+}
@@ -0,0 +1,478 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// modeDaemon is the mode of scripts the exporter starts once and supervises.
+const modeDaemon = "daemon"
+
+// daemonRestartBackoff is the delay before a daemon that exited is started
+// again, doubled after every exit up to daemonMaxRestartBackoff and reset
+// once a daemon ran for daemonStableAfter.
+var (
+	daemonRestartBackoff    = time.Second
+	daemonMaxRestartBackoff = time.Minute
+	daemonStableAfter       = time.Minute
+)
+
+// daemonScrapeTimeout bounds reading the metrics of a daemon from its
+// metrics_url.
+const daemonScrapeTimeout = 5 * time.Second
+
+var daemonClient = &http.Client{Timeout: daemonScrapeTimeout}
+
+// Daemon configures a script with mode: daemon, which the exporter runs
+// continuously and whose metrics it reads from MetricsFile or MetricsURL, in
+// the Prometheus text format, on every scrape of /metrics.
+type Daemon struct {
+	MetricsFile string `yaml:"metrics_file,omitempty"`
+	MetricsURL  string `yaml:"metrics_url,omitempty"`
+
+	// RestartBackoff and MaxRestartBackoff, in seconds, replace the initial
+	// and maximum delay before the daemon is started again after exiting.
+	RestartBackoff    float64 `yaml:"restart_backoff,omitempty"`
+	MaxRestartBackoff float64 `yaml:"max_restart_backoff,omitempty"`
+
+	// MaxRestarts, if set, is the number of times the daemon is restarted
+	// within RestartWindow seconds, or ever if unset, before the exporter
+	// gives up on it until it is restarted through the scripts API or
+	// changed.
+	MaxRestarts   int   `yaml:"max_restarts,omitempty"`
+	RestartWindow int64 `yaml:"restart_window,omitempty"`
+}
+
+// backoff returns the initial and maximum restart backoff of the daemon.
+func (d *Daemon) backoff() (time.Duration, time.Duration) {
+	initial, max := daemonRestartBackoff, daemonMaxRestartBackoff
+	if d.RestartBackoff > 0 {
+		initial = time.Duration(d.RestartBackoff * float64(time.Second))
+	}
+	if d.MaxRestartBackoff > 0 {
+		max = time.Duration(d.MaxRestartBackoff * float64(time.Second))
+	}
+	if max < initial {
+		max = initial
+	}
+	return initial, max
+}
+
+// daemon reports whether the script is run as a daemon.
+func (s *Script) daemon() bool {
+	return s.Mode == modeDaemon
+}
+
+// checkMode validates the mode of the script and its daemon settings.
+func (s *Script) checkMode() error {
+	switch s.Mode {
+	case "", "probe":
+	case modeDaemon:
+		if s.Daemon == nil || (s.Daemon.MetricsFile == "") == (s.Daemon.MetricsURL == "") {
+			return errors.New("daemon requires either daemon.metrics_file or daemon.metrics_url")
+		}
+		if s.scheduled() {
+			return errors.New("daemons cannot be scheduled")
+		}
+		d := s.Daemon
+		if d.RestartBackoff < 0 || d.MaxRestartBackoff < 0 || d.MaxRestarts < 0 || d.RestartWindow < 0 {
+			return errors.New("daemon restart settings must not be negative")
+		}
+		if d.RestartWindow != 0 && d.MaxRestarts == 0 {
+			return errors.New("daemon.restart_window requires daemon.max_restarts")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown mode %q", s.Mode)
+	}
+	if s.Daemon != nil {
+		return errors.New("daemon requires mode: daemon")
+	}
+	return nil
+}
+
+// Reasons reported by script_daemon_last_exit_reason for the last exit of a
+// daemon, along with those of script_error.
+const reasonExited = "exited"
+
+// runningDaemon is a daemon script supervised by RunDaemons.
+type runningDaemon struct {
+	script *Script
+	spec   string
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// wake restarts a daemon waiting for its restart backoff or given up.
+	wake chan struct{}
+
+	mu       sync.Mutex
+	state    daemonState
+	kill     context.CancelFunc
+	restarts []time.Time
+
+	// requested is set while a restart through the scripts API kills the
+	// daemon.
+	requested bool
+}
+
+// daemonState is the state of a daemon reported on /metrics.
+type daemonState struct {
+	up           bool
+	started      time.Time
+	restarts     int
+	gaveUp       bool
+	exited       bool
+	lastExitCode int
+	lastReason   string
+}
+
+func (d *runningDaemon) snapshot() daemonState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state
+}
+
+// restart kills the daemon so that it is started again at once, or starts
+// it if it is waiting to be restarted or was given up on.
+func (d *runningDaemon) restart() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.state.up {
+		d.requested = true
+		d.kill()
+		return
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// exitedAt records an exit of the daemon that is not requested, returning
+// whether the daemon should be given up on.
+func (d *runningDaemon) exitedAt(now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.state.restarts++
+	config := d.script.Daemon
+	if config.MaxRestarts == 0 {
+		return false
+	}
+	d.restarts = append(d.restarts, now)
+	if window := time.Duration(config.RestartWindow) * time.Second; window > 0 {
+		for len(d.restarts) > 0 && now.Sub(d.restarts[0]) > window {
+			d.restarts = d.restarts[1:]
+		}
+	}
+	if len(d.restarts) <= config.MaxRestarts {
+		return false
+	}
+	d.state.gaveUp = true
+	d.state.restarts--
+	return true
+}
+
+// daemonRegistry exposes the metrics of the running daemons on /metrics.
+type daemonRegistry struct {
+	mu      sync.Mutex
+	daemons map[string]*runningDaemon
+}
+
+var daemons = &daemonRegistry{daemons: make(map[string]*runningDaemon)}
+
+func init() {
+	prometheus.MustRegister(daemons)
+}
+
+// Describe sends no descriptors, making daemons an unchecked collector, as
+// the metrics of the daemons are only known once read.
+func (r *daemonRegistry) Describe(ch chan<- *prometheus.Desc) {}
+
+func (r *daemonRegistry) Collect(ch chan<- prometheus.Metric) {
+	r.mu.Lock()
+	running := make([]*runningDaemon, 0, len(r.daemons))
+	for _, d := range r.daemons {
+		running = append(running, d)
+	}
+	r.mu.Unlock()
+
+	var collected []*dto.MetricFamily
+	for _, d := range running {
+		script := d.script
+		labels := map[string]string{"script": script.Name}
+		for name, value := range script.Labels {
+			labels[name] = value
+		}
+		prefix := script.prefix()
+		state := d.snapshot()
+		gauge := func(name, help string, value float64) {
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(prefix+name, help, nil, labels), prometheus.GaugeValue, value)
+		}
+
+		gauge("_daemon_up", "Whether the daemon script is running.", float64(boolToInt(state.up)))
+		gauge("_daemon_gave_up", "Whether the daemon script exceeded its max_restarts and is no longer restarted.", float64(boolToInt(state.gaveUp)))
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(prefix+"_daemon_restarts_total", "Number of times the daemon script was restarted after exiting.", nil, labels),
+			prometheus.CounterValue, float64(state.restarts))
+		if state.up {
+			gauge("_daemon_uptime_seconds", "Seconds since the daemon script was last started.", time.Since(state.started).Seconds())
+		}
+		if state.exited {
+			gauge("_daemon_last_exit_code", "Exit code of the last exit of the daemon script.", float64(state.lastExitCode))
+			ch <- prometheus.MustNewConstMetric(
+				prometheus.NewDesc(prefix+"_daemon_last_exit_reason", "Why the daemon script last exited.", []string{"reason"}, labels),
+				prometheus.GaugeValue, 1, state.lastReason)
+		}
+
+		text, err := readDaemonMetrics(script.Daemon)
+		if err != nil {
+			log.Printf("ERROR: Cannot read the metrics of daemon %s: %s\n", script.Name, err)
+			continue
+		}
+		families, err := script.outputMetrics(text)
+		if err != nil {
+			log.Printf("ERROR: Cannot parse the metrics of daemon %s: %s\n", script.Name, err)
+			continue
+		}
+		for _, family := range families {
+			if strings.HasPrefix(family.GetName(), prefix+"_daemon_") {
+				log.Printf("ERROR: Dropping %s of daemon %s, colliding with the metrics of its supervision\n", family.GetName(), script.Name)
+				continue
+			}
+			labelFamily(family, labels)
+			collected = append(collected, family)
+		}
+	}
+
+	// Daemons sharing metric names, e.g. running the same client, need not
+	// agree on their HELP.
+	unifyHelp(collected)
+	for _, family := range collected {
+		collectFamily(ch, family)
+	}
+}
+
+// labelFamily adds labels to every sample of family, so that those of
+// different daemons do not collide. Labels of the same names the daemon set
+// are kept as exported_<name>, as Prometheus does with conflicting target
+// labels.
+func labelFamily(family *dto.MetricFamily, labels map[string]string) {
+	for _, metric := range family.Metric {
+		pairs := make([]*dto.LabelPair, 0, len(metric.Label)+len(labels))
+		for _, pair := range metric.Label {
+			if _, ok := labels[pair.GetName()]; ok {
+				pair.Name = proto.String("exported_" + pair.GetName())
+			}
+			pairs = append(pairs, pair)
+		}
+		for _, name := range sortedKeys(labels) {
+			pairs = append(pairs, &dto.LabelPair{Name: proto.String(name), Value: proto.String(labels[name])})
+		}
+		metric.Label = pairs
+	}
+}
+
+// readDaemonMetrics returns the metrics the daemon last wrote.
+func readDaemonMetrics(daemon *Daemon) (string, error) {
+	if daemon.MetricsFile != "" {
+		data, err := ioutil.ReadFile(daemon.MetricsFile)
+		return string(data), err
+	}
+
+	resp, err := daemonClient.Get(daemon.MetricsURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	return string(data), err
+}
+
+// RunDaemons starts the scripts of the active configuration with mode: daemon
+// in the background and restarts them whenever they exit, until ctx is
+// cancelled. Daemons are stopped when removed by a reload, and restarted
+// when changed.
+func (e *Exporter) RunDaemons(ctx context.Context) {
+	go func() {
+		for {
+			current := make(map[string]bool)
+			for _, script := range e.Config().Scripts {
+				if !script.daemon() {
+					continue
+				}
+				current[script.Name] = true
+
+				spec := fmt.Sprintf("%s\x00%s\x00%+v", script.Content, script.Runner, *script.Daemon)
+				daemons.mu.Lock()
+				d := daemons.daemons[script.Name]
+				daemons.mu.Unlock()
+				if d != nil && d.spec == spec {
+					continue
+				}
+				if d != nil {
+					d.stop()
+				}
+				e.startDaemon(ctx, script, spec)
+			}
+
+			daemons.mu.Lock()
+			var removed []*runningDaemon
+			for name, d := range daemons.daemons {
+				if !current[name] {
+					removed = append(removed, d)
+				}
+			}
+			daemons.mu.Unlock()
+			for _, d := range removed {
+				d.stop()
+			}
+
+			select {
+			case <-time.After(scheduleRecheck):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stop kills the daemon, waiting for it to exit, and forgets it.
+func (d *runningDaemon) stop() {
+	d.cancel()
+	<-d.done
+
+	daemons.mu.Lock()
+	defer daemons.mu.Unlock()
+	if daemons.daemons[d.script.Name] == d {
+		delete(daemons.daemons, d.script.Name)
+	}
+}
+
+// startDaemon runs script until ctx is done, restarting it whenever it
+// exits after the restart backoff, unless it exceeded its max_restarts.
+func (e *Exporter) startDaemon(parent context.Context, script *Script, spec string) {
+	ctx, cancel := context.WithCancel(parent)
+	d := &runningDaemon{script: script, spec: spec, cancel: cancel, done: make(chan struct{}), wake: make(chan struct{}, 1)}
+	daemons.mu.Lock()
+	daemons.daemons[script.Name] = d
+	daemons.mu.Unlock()
+
+	output := &lineWriter{emit: func(line string) {
+		log.Printf("daemon %s: %s\n", script.Name, line)
+	}}
+
+	go func() {
+		defer close(d.done)
+		initial, max := script.Daemon.backoff()
+		backoff := initial
+		for {
+			run, kill := context.WithCancel(ctx)
+			d.mu.Lock()
+			d.kill = kill
+			d.state.up, d.state.started = true, time.Now()
+			d.mu.Unlock()
+
+			var result Result
+			content, err := script.render("", nil)
+			if err == nil {
+				result, err = e.runScript(run, script, Env{Vars: append(e.nodeEnv(), scriptEnv(script, "")...), Content: content, Stdout: output, Stderr: output})
+			}
+			kill()
+			output.Flush()
+
+			d.mu.Lock()
+			requested := d.requested
+			d.requested = false
+			d.state.up, d.state.exited = false, true
+			d.state.lastExitCode, d.state.lastReason = daemonExit(result, err)
+			started := d.state.started
+			d.mu.Unlock()
+			if ctx.Err() != nil {
+				return
+			}
+
+			if requested {
+				log.Printf("OK: daemon %s restarted through the scripts API\n", script.Name)
+				backoff = initial
+				continue
+			}
+			if time.Since(started) >= daemonStableAfter {
+				backoff = initial
+			}
+			if err == nil {
+				err = fmt.Errorf("exit code %d", result.ExitCode)
+			}
+
+			wait := time.After(backoff)
+			if d.exitedAt(time.Now()) {
+				log.Printf("ERROR: daemon %s exited (%s), giving up after %d restarts\n", script.Name, err, script.Daemon.MaxRestarts)
+				wait = nil
+			} else {
+				log.Printf("ERROR: daemon %s exited (%s), restarting in %s\n", script.Name, err, backoff)
+			}
+
+			select {
+			case <-wait:
+				if backoff *= 2; backoff > max {
+					backoff = max
+				}
+			case <-d.wake:
+				log.Printf("OK: daemon %s restarted through the scripts API\n", script.Name)
+				backoff = initial
+				d.mu.Lock()
+				d.state.gaveUp, d.restarts = false, nil
+				d.mu.Unlock()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// daemonExit returns the exit code and the reason of a daemon exiting with
+// result and err.
+func daemonExit(result Result, err error) (int, string) {
+	switch {
+	case err != nil:
+		return 1, reasonStartFailure
+	case result.Signal != "":
+		return result.ExitCode, reasonSignal
+	case result.ExitCode != 0:
+		return result.ExitCode, reasonNonzeroExit
+	}
+	return 0, reasonExited
+}
+
+// restartDaemon restarts the daemon script name of config on POST
+// /api/v1/scripts/<name>/restart.
+func (e *Exporter) restartDaemon(w http.ResponseWriter, config *Config, name string) error {
+	script := config.Script(name)
+	if script == nil || !script.daemon() {
+		return &apiError{http.StatusNotFound, "not_found", fmt.Sprintf("daemon %q not found", name)}
+	}
+
+	daemons.mu.Lock()
+	d := daemons.daemons[name]
+	daemons.mu.Unlock()
+	if d == nil {
+		return &apiError{http.StatusConflict, "conflict", fmt.Sprintf("daemon %q is not running", name)}
+	}
+	d.restart()
+	writeAPIData(w, http.StatusOK, map[string]interface{}{"name": name})
+	return nil
+}
@@ -0,0 +1,221 @@
+package exporter
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDaemons(t *testing.T) {
+	daemonRestartBackoff = 100 * time.Millisecond
+	dir := t.TempDir()
+	path := writeConfig(t, `
+scripts:
+  - name: client
+    mode: daemon
+    daemon:
+      metrics_file: `+dir+`/client.prom
+    script: |
+      echo started >> `+dir+`/starts
+      echo 'measurements_total 7' > `+dir+`/client.prom
+      sleep 0.3
+`)
+	e := New(path)
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e.RunDaemons(ctx)
+
+	time.Sleep(200 * time.Millisecond)
+	metrics := gatherDefault(t)
+	for _, line := range []string{`script_daemon_up{script="client"} 1`, `measurements_total{script="client"} 7`} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("Expected %s:\n%s", line, metrics)
+		}
+	}
+
+	// The daemon exiting after 0.3s is restarted after the backoff.
+	time.Sleep(500 * time.Millisecond)
+	if starts, _ := ioutil.ReadFile(filepath.Join(dir, "starts")); strings.Count(string(starts), "started") < 2 {
+		t.Errorf("Expected the daemon to be restarted, started %d times", strings.Count(string(starts), "started"))
+	}
+
+	err := testExporter.ProbeQuery(context.Background(), e.Config(), map[string][]string{"name": {"client"}}, func(*Measurement) {})
+	if probeError, ok := err.(*ProbeError); !ok || probeError.Status != 400 {
+		t.Errorf("Expected probing a daemon to be refused, got %v", err)
+	}
+
+	// A reload removing the daemon stops it.
+	writeFiles(t, "", map[string]string{path: "scripts: []\n"})
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	time.Sleep(scheduleRecheck + 200*time.Millisecond)
+	if metrics := gatherDefault(t); strings.Contains(metrics, "script_daemon_up") {
+		t.Errorf("Expected the removed daemon to be stopped:\n%s", metrics)
+	}
+
+	for content, message := range map[string]string{
+		"scripts:\n  - name: a\n    mode: daemon\n    script: sleep 1\n":                                                                   "requires either",
+		"scripts:\n  - name: a\n    daemon: {metrics_file: /tmp/m}\n    script: sleep 1\n":                                                 "requires mode: daemon",
+		"scripts:\n  - name: a\n    mode: cron\n    script: sleep 1\n":                                                                     "unknown mode",
+		"modules:\n  - name: a\n    mode: daemon\n    daemon: {metrics_url: http://localhost/}\n    script: sleep 1\n":                     "cannot be daemons",
+		"scripts:\n  - name: a\n    mode: daemon\n    daemon: {metrics_url: http://localhost/, restart_window: 60}\n    script: sleep 1\n": "requires daemon.max_restarts",
+		"scripts:\n  - name: a\n    mode: daemon\n    daemon: {metrics_url: http://localhost/, max_restarts: -1}\n    script: sleep 1\n":   "must not be negative",
+	} {
+		if _, err := LoadConfig(writeConfig(t, content)); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected an error containing %q, got %v", message, err)
+		}
+	}
+}
+
+func TestDaemonRestartPolicy(t *testing.T) {
+	dir := t.TempDir()
+	e := New(writeConfig(t, `
+scripts_api_auth:
+  bearer_tokens: [secret]
+scripts:
+  - name: flaky
+    mode: daemon
+    daemon:
+      metrics_file: `+dir+`/flaky.prom
+      restart_backoff: 0.05
+      max_restarts: 2
+    script: |
+      echo started >> `+dir+`/starts
+      exit 3
+  - name: steady
+    mode: daemon
+    daemon:
+      metrics_file: `+dir+`/steady.prom
+    script: |
+      echo started >> `+dir+`/steady
+      sleep 10
+`))
+	e.ScriptsAPI = true
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e.RunDaemons(ctx)
+
+	// The daemon is started 3 times, restarted twice, and then given up on.
+	time.Sleep(500 * time.Millisecond)
+	starts := func(file string) int {
+		content, _ := ioutil.ReadFile(filepath.Join(dir, file))
+		return strings.Count(string(content), "started")
+	}
+	if n := starts("starts"); n != 3 {
+		t.Errorf("Expected the daemon to be started 3 times, got %d", n)
+	}
+	metrics := gatherDefault(t)
+	for _, line := range []string{
+		`script_daemon_restarts_total{script="flaky"} 2`,
+		`script_daemon_gave_up{script="flaky"} 1`,
+		`script_daemon_last_exit_code{script="flaky"} 3`,
+		`script_daemon_last_exit_reason{reason="nonzero_exit",script="flaky"} 1`,
+		`script_daemon_up{script="steady"} 1`,
+		`script_daemon_uptime_seconds{script="steady"}`,
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("Expected %s:\n%s", line, metrics)
+		}
+	}
+
+	mux := http.NewServeMux()
+	e.RegisterHandlers(mux)
+	restart := func(name string) int {
+		req := httptest.NewRequest("POST", "/api/v1/scripts/"+name+"/restart", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Restarting a daemon given up on starts it again with a fresh budget,
+	// and restarting a running one kills it and starts it at once.
+	if status := restart("flaky"); status != 200 {
+		t.Errorf("Expected 200 restarting a daemon, got %d", status)
+	}
+	if status := restart("steady"); status != 200 {
+		t.Errorf("Expected 200 restarting a daemon, got %d", status)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if n := starts("starts"); n != 6 {
+		t.Errorf("Expected the daemon to be started 3 more times, got %d", n)
+	}
+	if n := starts("steady"); n != 2 {
+		t.Errorf("Expected the running daemon to be started again, got %d starts", n)
+	}
+	if metrics := gatherDefault(t); !strings.Contains(metrics, `script_daemon_last_exit_reason{reason="signal",script="steady"} 1`) ||
+		!strings.Contains(metrics, `script_daemon_restarts_total{script="steady"} 0`) {
+		t.Errorf("Expected a requested restart to be reported as such:\n%s", metrics)
+	}
+
+	if status := restart("missing"); status != 404 {
+		t.Errorf("Expected 404 restarting an unknown daemon, got %d", status)
+	}
+}
+
+func TestDaemonMetricCollisions(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"client.prom": `
+# HELP measurements_total Measurements made.
+measurements_total{script="ndt"} 7
+script_daemon_up 0
+script_success 1
+`})
+	path := writeConfig(t, `
+scripts:
+  - name: client_a
+    mode: daemon
+    daemon:
+      metrics_file: `+dir+`/client.prom
+    script: sleep 10
+  - name: client_b
+    mode: daemon
+    labels:
+      site: lga03
+    daemon:
+      metrics_file: `+dir+`/client.prom
+    script: sleep 10
+`)
+	e := New(path)
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e.RunDaemons(ctx)
+	time.Sleep(200 * time.Millisecond)
+
+	// Two daemons running the same client, one writing metrics named like
+	// those of the exporter, leave /metrics consistent.
+	metrics := gatherDefault(t)
+	for _, line := range []string{
+		`measurements_total{exported_script="ndt",script="client_a"} 7`,
+		`measurements_total{exported_script="ndt",script="client_b",site="lga03"} 7`,
+		`script_success{script="client_a"} 1`,
+		`script_daemon_up{script="client_a"} 1`,
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("Expected %s:\n%s", line, metrics)
+		}
+	}
+	if strings.Contains(metrics, `script_daemon_up{script="client_a"} 0`) {
+		t.Errorf("Expected the daemon's own script_daemon_up to be dropped:\n%s", metrics)
+	}
+
+	writeFiles(t, "", map[string]string{path: "scripts: []\n"})
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+}
@@ -0,0 +1,69 @@
+package exporter
+
+import (
+	"errors"
+
+	"gopkg.in/yaml.v2"
+)
+
+// rawConfig is a config file with its defaults, scripts and modules left
+// undecoded.
+type rawConfig struct {
+	Defaults yaml.MapSlice   `yaml:"defaults"`
+	Scripts  []yaml.MapSlice `yaml:"scripts"`
+	Modules  []yaml.MapSlice `yaml:"modules"`
+}
+
+// applyDefaults decodes the scripts and modules of fragment, loaded from data,
+// again on top of the defaults inherited from including files and those of
+// data, so that they inherit every setting they do not give themselves. Maps
+// such as env and labels are merged key by key. It returns the defaults for
+// the files fragment includes.
+func applyDefaults(fragment *Config, data []byte, inherited []yaml.MapSlice) ([]yaml.MapSlice, error) {
+	var raw rawConfig
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	defaults := inherited
+	if raw.Defaults != nil {
+		for _, item := range raw.Defaults {
+			if key, _ := item.Key.(string); key == "name" || key == "script" || key == "script_file" {
+				return nil, errors.New("defaults may not set name, script or script_file")
+			}
+		}
+		defaults = append(inherited[:len(inherited):len(inherited)], raw.Defaults)
+	}
+	if len(defaults) == 0 {
+		return nil, nil
+	}
+
+	for i, script := range raw.Scripts {
+		fragment.Scripts[i] = &Script{}
+		if err := decodeLayers(fragment.Scripts[i], append(defaults[:len(defaults):len(defaults)], script)); err != nil {
+			return nil, err
+		}
+	}
+	for i, module := range raw.Modules {
+		fragment.Modules[i] = &Module{}
+		if err := decodeLayers(fragment.Modules[i], append(defaults[:len(defaults):len(defaults)], module)); err != nil {
+			return nil, err
+		}
+	}
+
+	return defaults, nil
+}
+
+// decodeLayers decodes each of layers into out in turn.
+func decodeLayers(out interface{}, layers []yaml.MapSlice) error {
+	for _, layer := range layers {
+		data, err := yaml.Marshal(layer)
+		if err != nil {
+			return err
+		}
+		if err = yaml.Unmarshal(data, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
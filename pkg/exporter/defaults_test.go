@@ -0,0 +1,79 @@
+package exporter
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaults(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.yml": `
+include: conf.d/*.yml
+defaults:
+  timeout: 30
+  env:
+    SERVER: ndt.example.org
+  labels:
+    team: measurement
+scripts:
+  - name: inherit
+    script: exit 0
+  - name: override
+    script: exit 0
+    timeout: 5
+    env:
+      PORT: "443"
+modules:
+  - name: module
+    script: exit 0
+`,
+		"conf.d/a.yml": `
+defaults:
+  labels:
+    team: platform
+scripts:
+  - name: included
+    script: exit 0
+`,
+	})
+
+	config, err := LoadConfig(filepath.Join(dir, "config.yml"))
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	for _, tc := range []struct {
+		script  *Script
+		timeout int64
+		env     map[string]string
+		team    string
+	}{
+		{config.Scripts[0], 30, map[string]string{"SERVER": "ndt.example.org"}, "measurement"},
+		{config.Scripts[1], 5, map[string]string{"SERVER": "ndt.example.org", "PORT": "443"}, "measurement"},
+		{config.Scripts[2], 30, map[string]string{"SERVER": "ndt.example.org"}, "platform"},
+		{&config.Modules[0].Script, 30, map[string]string{"SERVER": "ndt.example.org"}, "measurement"},
+	} {
+		if tc.script.Timeout != tc.timeout {
+			t.Errorf("Expected timeout %d for %s, got %d", tc.timeout, tc.script.Name, tc.script.Timeout)
+		}
+		if len(tc.script.Env) != len(tc.env) {
+			t.Errorf("Expected env %v for %s, got %v", tc.env, tc.script.Name, tc.script.Env)
+		}
+		for name, value := range tc.env {
+			if tc.script.Env[name] != value {
+				t.Errorf("Expected env %v for %s, got %v", tc.env, tc.script.Name, tc.script.Env)
+			}
+		}
+		if tc.script.Labels["team"] != tc.team {
+			t.Errorf("Expected team %s for %s, got %v", tc.team, tc.script.Name, tc.script.Labels)
+		}
+	}
+
+	t.Run("Name", func(t *testing.T) {
+		_, err := LoadConfig(writeConfig(t, "defaults:\n  name: a\nscripts:\n  - name: b\n    script: exit 0\n"))
+		if err == nil {
+			t.Errorf("Expected error for name in defaults")
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package exporter
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// scriptSwitches keeps the scripts enabled or disabled through the scripts
+// API, which take precedence over their disabled setting and outlive
+// reloads.
+type scriptSwitches struct {
+	mu       sync.Mutex
+	disabled map[string]bool
+}
+
+func (s *scriptSwitches) set(name string, disabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.disabled == nil {
+		s.disabled = make(map[string]bool)
+	}
+	s.disabled[name] = disabled
+}
+
+// get returns whether the script name was disabled through the scripts API,
+// and whether it was switched at all.
+func (s *scriptSwitches) get(name string) (disabled, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	disabled, ok = s.disabled[name]
+	return
+}
+
+// scriptDisabled reports whether script is disabled, by the scripts API or
+// else by its disabled setting.
+func (e *Exporter) scriptDisabled(script *Script) bool {
+	if disabled, ok := e.switches.get(script.Name); ok {
+		return disabled
+	}
+	return script.Disabled
+}
+
+// switchScript enables or disables the script name of config on POST
+// /api/v1/scripts/<name>/enable or /disable.
+func (e *Exporter) switchScript(w http.ResponseWriter, config *Config, name string, disabled bool) error {
+	script := config.Script(name)
+	if script == nil {
+		if module := config.module(name); module != nil {
+			script = &module.Script
+		}
+	}
+	if script == nil {
+		return &apiError{http.StatusNotFound, "not_found", fmt.Sprintf("script %q not found", name)}
+	}
+
+	e.switches.set(name, disabled)
+	state := "enabled"
+	if disabled {
+		state = "disabled"
+	}
+	log.Printf("OK: script %s %s through the scripts API\n", name, state)
+	writeAPIData(w, http.StatusOK, map[string]interface{}{"name": name, "disabled": disabled})
+	return nil
+}
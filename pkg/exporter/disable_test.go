@@ -0,0 +1,71 @@
+package exporter
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDisabledScripts(t *testing.T) {
+	e := New(writeConfig(t, `
+scripts_api_auth:
+  bearer_tokens: [secret]
+scripts:
+  - name: maintenance
+    disabled: true
+    script: exit 1
+  - name: enabled
+    script: exit 0
+`))
+	e.ScriptsAPI = true
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	measurements := e.runScripts(e.Config().Scripts, "")
+	var out bytes.Buffer
+	for _, measurement := range measurements {
+		WriteMeasurement(&out, measurement)
+	}
+	if metrics := out.String(); !strings.Contains(metrics, `script_disabled{script="maintenance"} 1`) || strings.Contains(metrics, `script_success{script="maintenance"}`) {
+		t.Errorf("Expected the disabled script to be reported as disabled only:\n%s", metrics)
+	}
+	if len(e.Config().Script("maintenance").history.snapshot()) != 0 {
+		t.Errorf("Expected the disabled script not to be run")
+	}
+
+	mux := http.NewServeMux()
+	e.RegisterHandlers(mux)
+	request := func(path, token string) int {
+		req := httptest.NewRequest("POST", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if status := request("/api/v1/scripts/enabled/disable", "wrong"); status != 401 {
+		t.Errorf("Expected 401 without the token, got %d", status)
+	}
+	if status := request("/api/v1/scripts/enabled/disable", "secret"); status != 200 {
+		t.Errorf("Expected 200 disabling a script, got %d", status)
+	}
+	if status := request("/api/v1/scripts/maintenance/enable", "secret"); status != 200 {
+		t.Errorf("Expected 200 enabling a script, got %d", status)
+	}
+	if status := request("/api/v1/scripts/missing/enable", "secret"); status != 404 {
+		t.Errorf("Expected 404 for an unknown script, got %d", status)
+	}
+
+	// The switches outlive reloads.
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	for _, measurement := range e.runScripts(e.Config().Scripts, "") {
+		if expected := measurement.Script.Name == "enabled"; measurement.Disabled != expected {
+			t.Errorf("Expected %s disabled to be %t", measurement.Script.Name, expected)
+		}
+	}
+}
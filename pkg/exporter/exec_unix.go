@@ -0,0 +1,56 @@
+//go:build !windows
+// +build !windows
+
+package exporter
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultShell is the shell scripts are fed to unless Exporter.Shell is set.
+const DefaultShell = "/bin/sh"
+
+// shellArgs returns the arguments that make the shell read the script from
+// stdin.
+func shellArgs(shell string) []string {
+	return nil
+}
+
+// shellCommandArgs returns the arguments that make the shell run script,
+// leaving stdin to the script.
+func shellCommandArgs(shell, script string) []string {
+	return []string{"-c", script}
+}
+
+// syntaxCheckArgs returns the arguments that make the shell check the syntax
+// of the script on stdin without running it, or nil if it has none.
+func syntaxCheckArgs() []string {
+	return []string{"-n"}
+}
+
+// setProcessGroup makes the script the leader of a new process group, so that
+// on timeout any children still holding the output pipes open are killed with
+// it.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// exitSignal returns the name of the signal that terminated the process, such
+// as "SIGKILL", if any.
+func exitSignal(state *os.ProcessState) string {
+	if status := state.Sys().(syscall.WaitStatus); status.Signaled() {
+		if name := unix.SignalName(status.Signal()); name != "" {
+			return name
+		}
+		return status.Signal().String()
+	}
+	return ""
+}
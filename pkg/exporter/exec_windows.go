@@ -0,0 +1,58 @@
+//go:build windows
+// +build windows
+
+package exporter
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// DefaultShell is the shell scripts are fed to unless Exporter.Shell is set.
+const DefaultShell = "powershell.exe"
+
+// shellArgs returns the arguments that make the shell read the script from
+// stdin.
+func shellArgs(shell string) []string {
+	switch strings.TrimSuffix(strings.ToLower(filepath.Base(shell)), ".exe") {
+	case "powershell", "pwsh":
+		return []string{"-NoProfile", "-NonInteractive", "-Command", "-"}
+	case "cmd":
+		return []string{"/Q"}
+	}
+	return nil
+}
+
+// shellCommandArgs returns the arguments that make the shell run script,
+// leaving stdin to the script.
+func shellCommandArgs(shell, script string) []string {
+	switch strings.TrimSuffix(strings.ToLower(filepath.Base(shell)), ".exe") {
+	case "powershell", "pwsh":
+		return []string{"-NoProfile", "-NonInteractive", "-Command", script}
+	case "cmd":
+		return []string{"/Q", "/C", script}
+	}
+	return []string{"-c", script}
+}
+
+// syntaxCheckArgs returns nil as the Windows shells have no syntax check mode.
+func syntaxCheckArgs() []string {
+	return nil
+}
+
+// setProcessGroup starts the script in a new process group so that it does
+// not receive console signals meant for the exporter.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
+
+func exitSignal(state *os.ProcessState) string {
+	return ""
+}
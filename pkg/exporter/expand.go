@@ -0,0 +1,95 @@
+package exporter
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// envReferenceRegexp matches the ${VAR} and ${VAR:-default} references
+// expanded in config values, and $${ escaping a literal ${. References whose
+// name is not an environment variable name, such as the ${1} of a relabel
+// replacement, are left alone.
+var envReferenceRegexp = regexp.MustCompile(`\$\$\{|\$\{([a-zA-Z_][a-zA-Z0-9_]*)(:-([^}]*))?\}`)
+
+// unexpandedKeys are the config keys whose values are never expanded, as
+// scripts and the snippets they include reference the TARGET and their own
+// variables the same way.
+var unexpandedKeys = map[string]bool{"script": true, "snippets": true}
+
+// expandEnv replaces the environment variable references in the values of the
+// YAML config in data. Expanded values are read again as YAML scalars, so
+// that e.g. a timeout can be given as ${TIMEOUT:-10}.
+func expandEnv(data []byte) ([]byte, error) {
+	var config yaml.MapSlice
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	expanded, err := expandValue(config)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(expanded)
+}
+
+func expandValue(value interface{}) (interface{}, error) {
+	var err error
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		for i, item := range v {
+			if key, ok := item.Key.(string); ok && unexpandedKeys[key] {
+				continue
+			}
+			if v[i].Value, err = expandValue(item.Value); err != nil {
+				return nil, fmt.Errorf("%v: %s", item.Key, err)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			if v[i], err = expandValue(item); err != nil {
+				return nil, fmt.Errorf("%d: %s", i+1, err)
+			}
+		}
+	case string:
+		return expandString(v)
+	}
+	return value, nil
+}
+
+func expandString(s string) (interface{}, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var missing []string
+	expanded := envReferenceRegexp.ReplaceAllStringFunc(s, func(reference string) string {
+		if reference == "$${" {
+			return "${"
+		}
+		match := envReferenceRegexp.FindStringSubmatch(reference)
+		if value, ok := os.LookupEnv(match[1]); ok && (value != "" || match[2] == "") {
+			return value
+		}
+		if match[2] != "" {
+			return match[3]
+		}
+		missing = append(missing, match[1])
+		return ""
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+
+	var scalar interface{}
+	if err := yaml.Unmarshal([]byte(expanded), &scalar); err == nil {
+		switch scalar.(type) {
+		case int, float64, bool:
+			return scalar, nil
+		}
+	}
+	return expanded, nil
+}
@@ -0,0 +1,91 @@
+package exporter
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("SCRIPT_EXPORTER_TEST_TIMEOUT", "7")
+	os.Setenv("SCRIPT_EXPORTER_TEST_SERVER", "ndt.example.org")
+	os.Setenv("SCRIPT_EXPORTER_TEST_EMPTY", "")
+	defer os.Unsetenv("SCRIPT_EXPORTER_TEST_TIMEOUT")
+	defer os.Unsetenv("SCRIPT_EXPORTER_TEST_SERVER")
+	defer os.Unsetenv("SCRIPT_EXPORTER_TEST_EMPTY")
+
+	config, err := loadConfig([]string{writeConfig(t, `
+scripts:
+  - name: ndt
+    script: echo ${TARGET} ${SERVER}
+    timeout: ${SCRIPT_EXPORTER_TEST_TIMEOUT}
+    env:
+      SERVER: https://${SCRIPT_EXPORTER_TEST_SERVER}/
+      PORT: ${SCRIPT_EXPORTER_TEST_PORT:-443}
+      EMPTY: ${SCRIPT_EXPORTER_TEST_EMPTY:-default}
+      ESCAPED: $${SCRIPT_EXPORTER_TEST_SERVER}
+`)}, loadOptions{expandEnv: true})
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	script := config.Scripts[0]
+	if script.Content != "echo ${TARGET} ${SERVER}" {
+		t.Errorf("Expected the script not to be expanded, got %q", script.Content)
+	}
+	if script.Timeout != 7 {
+		t.Errorf("Expected timeout 7, got %d", script.Timeout)
+	}
+	for name, expected := range map[string]string{
+		"SERVER":  "https://ndt.example.org/",
+		"PORT":    "443",
+		"EMPTY":   "default",
+		"ESCAPED": "${SCRIPT_EXPORTER_TEST_SERVER}",
+	} {
+		if script.Env[name] != expected {
+			t.Errorf("Expected %s %q, got %q", name, expected, script.Env[name])
+		}
+	}
+
+	t.Run("Snippets", func(t *testing.T) {
+		config, err := loadConfig([]string{writeConfig(t, `
+snippets:
+  preamble: echo ${TARGET}
+`)}, loadOptions{expandEnv: true})
+		if err != nil {
+			t.Fatalf("Unexpected: %s", err)
+		}
+		if config.Snippets["preamble"] != "echo ${TARGET}" {
+			t.Errorf("Expected the snippet not to be expanded, got %q", config.Snippets["preamble"])
+		}
+	})
+
+	t.Run("Unset", func(t *testing.T) {
+		_, err := loadConfig([]string{writeConfig(t, `
+scripts:
+  - name: ndt
+    script: exit 0
+    env:
+      SERVER: ${SCRIPT_EXPORTER_TEST_UNSET}
+`)}, loadOptions{expandEnv: true})
+		if err == nil || !strings.Contains(err.Error(), "SCRIPT_EXPORTER_TEST_UNSET is not set") {
+			t.Errorf("Expected unset variable error, got %v", err)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		config, err := LoadConfig(writeConfig(t, `
+scripts:
+  - name: ndt
+    script: exit 0
+    env:
+      SERVER: ${SCRIPT_EXPORTER_TEST_SERVER}
+`))
+		if err != nil {
+			t.Fatalf("Unexpected: %s", err)
+		}
+		if config.Scripts[0].Env["SERVER"] != "${SCRIPT_EXPORTER_TEST_SERVER}" {
+			t.Errorf("Expected no expansion, got %q", config.Scripts[0].Env["SERVER"])
+		}
+	})
+}
@@ -0,0 +1,1281 @@
+// Package exporter runs the scripts of a script_exporter configuration on
+// request and renders the results as Prometheus metrics. It is the core of
+// the script_exporter binary and can be embedded in other services:
+//
+//	e := exporter.New("script-exporter.yml")
+//	if err := e.Reload(); err != nil {
+//		log.Fatal(err)
+//	}
+//	e.RegisterHandlers(mux)
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// DefaultDockerHost is the Docker daemon socket used by scripts with the
+// docker runner unless Exporter.DockerHost is set.
+const DefaultDockerHost = "unix:///var/run/docker.sock"
+
+// DefaultArtifactsDir is where the artifacts of scripts are kept unless
+// Exporter.ArtifactsDir is set.
+var DefaultArtifactsDir = filepath.Join(os.TempDir(), "script_exporter-artifacts")
+
+// A regex pattern that only matches valid ASCII domain name characters to
+// prevent inadvertent or malicious injection of special shell characters
+// into the scripts environment through the host of a target.
+var targetRegexp = regexp.MustCompile("^[a-zA-Z0-9-.]{4,253}$")
+
+// Exporter serves probes of the scripts in a configuration file. Its options
+// may be changed after New until the first call to Reload.
+type Exporter struct {
+	// ConfigFile is the path of the configuration file, or an http://,
+	// https://, s3:// or gs:// URL to fetch it from.
+	ConfigFile string
+
+	// AdditionalConfigFiles are further configuration files whose scripts
+	// and modules are merged with those of ConfigFile.
+	AdditionalConfigFiles []string
+
+	// Shell is the local shell scripts are fed to on stdin.
+	Shell string
+
+	// DockerHost is the Docker daemon socket used by the docker runner.
+	DockerHost string
+
+	// HistorySize is the number of recent executions kept per script.
+	HistorySize int
+
+	// DryRun enables checking script syntax and name uniqueness before a
+	// loaded configuration is activated.
+	DryRun bool
+
+	// SyntaxCheck enables checking script syntax before a loaded
+	// configuration is activated, which DryRun implies.
+	SyntaxCheck bool
+
+	// SigningKeys, if any, are the keys one of which every script must be
+	// signed with for a configuration to be loaded. They are given outside
+	// the configuration, which may come from a less trusted source.
+	SigningKeys []ed25519.PublicKey
+
+	// ScriptsAPI enables adding, updating and deleting scripts at runtime
+	// through /api/v1/scripts.
+	ScriptsAPI bool
+
+	// ScriptsFile, if set, persists the scripts added through the scripts
+	// API, which are restored from it on the first Reload.
+	ScriptsFile string
+
+	// RemoteCacheDir keeps the last fetched copy of remote configuration
+	// files, used when they cannot be fetched.
+	RemoteCacheDir string
+
+	// RemoteBearerTokenFile holds the bearer token remote configuration
+	// files are fetched with from http://, https:// and gs:// URLs.
+	RemoteBearerTokenFile string
+
+	// ConfigFormat is the format of the configuration files, "yaml", "json"
+	// or "toml". If empty, it is told by the extension of each file, with
+	// YAML as default.
+	ConfigFormat string
+
+	// ExpandEnv enables expanding ${VAR} and ${VAR:-default} references to
+	// environment variables in the values of the configuration, except in
+	// inline scripts.
+	ExpandEnv bool
+
+	// MetricsPath is linked from the landing page.
+	MetricsPath string
+
+	// LinkPrefix is prepended to the links of the landing page, such as the
+	// path the exporter is reachable at behind a reverse proxy.
+	LinkPrefix string
+
+	// MetricPrefix replaces "script" as the prefix of the metrics of scripts
+	// without a metric_prefix of their own.
+	MetricPrefix string
+
+	// DurationBuckets are the buckets of the duration histogram of scripts
+	// without duration_buckets of their own.
+	DurationBuckets []float64
+
+	// ArtifactsDir is where the artifacts of scripts are kept.
+	ArtifactsDir string
+
+	// OutputLimit is the number of bytes of output captured per run of
+	// scripts without an output_limit of their own. Zero means no limit.
+	OutputLimit int64
+
+	// ExposeResults keeps the latest measurement of every probed script and
+	// target for /metrics, as is always done for scheduled scripts. Results
+	// older than ResultsTTL, if positive, are no longer exposed.
+	ExposeResults bool
+	ResultsTTL    time.Duration
+
+	// ScheduleSplay delays the first run of every scheduled script by a
+	// random duration up to ScheduleSplay.
+	ScheduleSplay time.Duration
+
+	// MaxWait, if positive, bounds how long a probe waits for the scripts it
+	// matched, whatever its max_wait parameter; scripts still running are
+	// reported as timed out.
+	MaxWait time.Duration
+
+	// StatusCodes makes /probe respond with 503 when all the scripts probed
+	// fail, and 404 instead of 400 when none match its name or pattern.
+	StatusCodes bool
+
+	// AllowNoMatch makes probes whose name and pattern match no script
+	// succeed with an empty response instead of failing with 400.
+	AllowNoMatch bool
+
+	// MetadataProvider, "gce" or "ec2" if set, is the cloud metadata server
+	// the zone and instance of the node are passed to every run from, along
+	// with the host name and IP address of the node.
+	MetadataProvider string
+
+	// FileSDPath, if set, is where a Prometheus file_sd document of the
+	// scripts and module targets is written whenever a configuration is
+	// activated, for Prometheus to scrape the exporter at FileSDAddress over
+	// FileSDScheme.
+	FileSDPath    string
+	FileSDAddress string
+	FileSDScheme  string
+
+	mu     sync.RWMutex
+	config *Config
+
+	runnersMu sync.Mutex
+	runners   map[string]Runner
+
+	clients rateLimiter
+
+	// switches are the scripts enabled or disabled through the scripts API.
+	switches scriptSwitches
+
+	// webhooks tracks the states of scheduled scripts notified to webhooks.
+	webhooks webhookStates
+
+	// live tracks the runs in flight for /probe/tail.
+	live liveRuns
+
+	// apiScripts are the scripts added through the scripts API, loaded
+	// from ScriptsFile once apiLoaded. apiMu is held across every reload.
+	apiMu      sync.Mutex
+	apiScripts []*Script
+	apiLoaded  bool
+
+	// kvWatches are the Consul and etcd keys watched, nil unless WatchKV
+	// was called.
+	kvMu      sync.Mutex
+	kvWatches map[string]bool
+
+	// startupChecks counts the startup checks in progress.
+	startupChecks int32
+
+	// paused is 1 while running scripts is paused by Pause.
+	paused int32
+
+	// historyDB records every execution if OpenHistoryDatabase was called.
+	historyDB *historyDB
+
+	// node describes the node to the scripts.
+	node nodeMetadata
+}
+
+// New returns an Exporter for the configuration file at path with the
+// defaults of the script_exporter binary. The configuration is not loaded
+// until Reload is called.
+func New(path string) *Exporter {
+	return &Exporter{
+		ConfigFile:      path,
+		Shell:           DefaultShell,
+		DockerHost:      DefaultDockerHost,
+		HistorySize:     10,
+		SyntaxCheck:     true,
+		MetricsPath:     "/metrics",
+		DurationBuckets: prometheus.DefBuckets,
+		OutputLimit:     1 << 20,
+		ArtifactsDir:    DefaultArtifactsDir,
+		RemoteCacheDir:  DefaultRemoteCacheDir,
+	}
+}
+
+// Config returns the active configuration.
+func (e *Exporter) Config() *Config {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.config
+}
+
+// RegisterHandlers registers the handlers of RegisterPublicHandlers and
+// RegisterAdminHandlers on mux.
+func (e *Exporter) RegisterHandlers(mux *http.ServeMux) {
+	e.RegisterPublicHandlers(mux)
+	e.RegisterAdminHandlers(mux)
+}
+
+// RegisterPublicHandlers registers the health and landing page handlers on
+// mux, which do not run scripts nor reveal the configuration.
+func (e *Exporter) RegisterPublicHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/-/healthy", healthyHandler)
+	mux.HandleFunc("/-/ready", e.readyHandler)
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		e.landingHandler(w, r, e.Config())
+	})
+}
+
+// RegisterAdminHandlers registers the probe, streaming probe, tail, JSON
+// probe, configuration, reload, scripts API, history and artifacts handlers
+// on mux, which may be served on a listener of their own to be firewalled
+// separately from scraping.
+func (e *Exporter) RegisterAdminHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/probe", func(w http.ResponseWriter, r *http.Request) {
+		e.scriptRunHandler(w, r, e.Config())
+	})
+
+	mux.HandleFunc("/probe/stream", func(w http.ResponseWriter, r *http.Request) {
+		e.streamHandler(w, r, e.Config())
+	})
+
+	mux.HandleFunc("/probe/tail", func(w http.ResponseWriter, r *http.Request) {
+		e.tailHandler(w, r, e.Config())
+	})
+
+	mux.HandleFunc("/api/v1/probe", func(w http.ResponseWriter, r *http.Request) {
+		e.apiProbeHandler(w, r, e.Config())
+	})
+
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		configHandler(w, r, e.Config())
+	})
+
+	mux.HandleFunc("/api/v1/config", func(w http.ResponseWriter, r *http.Request) {
+		apiConfigHandler(w, r, e.Config())
+	})
+
+	mux.HandleFunc("/api/v1/scripts", func(w http.ResponseWriter, r *http.Request) {
+		e.scriptsAPIHandler(w, r, e.Config())
+	})
+
+	mux.HandleFunc("/api/v1/scripts/", func(w http.ResponseWriter, r *http.Request) {
+		e.scriptsAPIHandler(w, r, e.Config())
+	})
+
+	mux.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+		historyHandler(w, r, e.Config())
+	})
+
+	mux.HandleFunc("/api/v1/history", func(w http.ResponseWriter, r *http.Request) {
+		e.apiHistoryHandler(w, r, e.Config())
+	})
+
+	mux.HandleFunc("/artifacts/", func(w http.ResponseWriter, r *http.Request) {
+		e.artifactsHandler(w, r, e.Config())
+	})
+
+	mux.HandleFunc("/-/reload", e.reloadHandler)
+	mux.HandleFunc("/-/pause", e.maintenanceHandler(true))
+	mux.HandleFunc("/-/resume", e.maintenanceHandler(false))
+}
+
+// Reasons reported by script_error for a failed run.
+const (
+	reasonTimeout          = "timeout"
+	reasonNonzeroExit      = "nonzero_exit"
+	reasonStartFailure     = "start_failure"
+	reasonSignal           = "signal"
+	reasonOutputParseError = "output_parse_error"
+	reasonCriteriaNotMet   = "criteria_not_met"
+	reasonDNSFailure       = "dns_failure"
+	reasonChecksumMismatch = "checksum_mismatch"
+)
+
+var errorReasons = []string{reasonTimeout, reasonNonzeroExit, reasonStartFailure, reasonSignal, reasonOutputParseError, reasonCriteriaNotMet, reasonDNSFailure, reasonChecksumMismatch}
+
+// Measurement is the result of probing a script against a target.
+type Measurement struct {
+	Script      *Script
+	Target      string
+	Start       time.Time
+	Success     int
+	ExitCode    int
+	Duration    float64
+	Attempts    int
+	CircuitOpen bool
+
+	// Cancelled is set if the probe was abandoned before the script completed.
+	Cancelled bool
+
+	// Disabled is set if the script was not run because it is disabled.
+	Disabled bool
+
+	// Paused is set if the script was not run because running scripts is
+	// paused, the measurement being the latest kept for /metrics, if any.
+	Paused bool
+
+	// ErrorReason classifies why a failed run failed, one of errorReasons.
+	ErrorReason string
+
+	// Signal names the signal that terminated the last attempt, if any,
+	// such as "SIGKILL" for runs killed on timeout or by the OOM killer.
+	Signal string
+
+	// Output is the combined stdout and stderr of the last attempt, truncated
+	// to the output limit of the script.
+	Output string
+
+	// OutputBytes is the size of the output before truncation, and
+	// OutputTruncated is set if it was truncated.
+	OutputBytes     int64
+	OutputTruncated bool
+
+	// DNSLookup is the time spent resolving the target of a script with
+	// resolve_target, in seconds.
+	DNSLookup float64
+
+	// LastSuccess is when the latest successful run of the script against
+	// the target completed: this run, or one kept for /metrics. It is zero if
+	// none is known.
+	LastSuccess time.Time
+
+	// Metrics are the relabeled metrics parsed from stdout of a successful
+	// run of a script with output_metrics.
+	Metrics []*dto.MetricFamily
+}
+
+func init() {
+	RegisterRunner(runnerShell, func(e *Exporter) Runner { return RunnerFunc(e.runShell) })
+}
+
+// runScript runs script once with the runner it is configured for.
+func (e *Exporter) runScript(ctx context.Context, script *Script, env Env) (Result, error) {
+	runner, err := e.runner(script.Runner)
+	if err != nil {
+		return Result{}, err
+	}
+	return runner.Run(ctx, script, env)
+}
+
+// runShell runs script by feeding it to the local shell on stdin, after its
+// interpreter_args, in its workdir and with its path prepended to PATH.
+func (e *Exporter) runShell(ctx context.Context, script *Script, env Env) (Result, error) {
+	args := append([]string(nil), script.InterpreterArgs...)
+	if env.Stdin != nil {
+		args = append(args, shellCommandArgs(e.Shell, env.content(script))...)
+	} else {
+		args = append(args, shellArgs(e.Shell)...)
+	}
+
+	cmd := exec.Command(e.Shell, args...)
+	cmd.Stdout = env.Stdout
+	cmd.Stderr = env.Stderr
+	cmd.Dir = env.Dir
+	if cmd.Dir == "" {
+		cmd.Dir = script.Workdir
+	}
+	setProcessGroup(cmd)
+	cmd.Env = withPath(append(inheritedEnv(script), env.Vars...), script)
+	if err := isolate(cmd, script); err != nil {
+		return Result{}, err
+	}
+
+	// The script is copied to stdin as the shell reads it: writing it before
+	// starting the shell would block on the pipe buffer for large scripts.
+	if env.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(env.Stdin)
+	} else {
+		cmd.Stdin = strings.NewReader(env.content(script))
+	}
+
+	err := children.start(cmd)
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				if ctx.Err() == context.DeadlineExceeded {
+					killedProcesses.Inc()
+				}
+				killProcessGroup(cmd)
+			case <-done:
+			}
+		}()
+		err = children.wait(cmd)
+		close(done)
+	}
+
+	if exitError, ok := err.(*exec.ExitError); ok {
+		return Result{ExitCode: exitError.ExitCode(), Signal: exitSignal(exitError.ProcessState)}, nil
+	}
+	if err != nil {
+		log.Printf("ERROR: running %s failed with error: %v\n", e.Shell, err)
+		return Result{}, err
+	}
+	return Result{}, nil
+}
+
+type timeoutKey struct{}
+
+// withTimeout returns a copy of ctx that runs the scripts probed with it for
+// at most timeout, or their own timeout if shorter.
+func withTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, timeout)
+}
+
+// scriptTimeout returns the timeout of script for a probe with ctx.
+func scriptTimeout(ctx context.Context, script *Script) time.Duration {
+	timeout := time.Duration(script.Timeout) * time.Second
+	if override, ok := ctx.Value(timeoutKey{}).(time.Duration); ok && override < timeout {
+		return override
+	}
+	return timeout
+}
+
+func (e *Exporter) measureScript(parent context.Context, script *Script, target string) *Measurement {
+	ctx, cancel := context.WithTimeout(parent, scriptTimeout(parent, script))
+	defer cancel()
+
+	start := time.Now()
+	success := 0
+	attempts := 0
+	backoff := time.Duration(script.RetryBackoff * float64(time.Second))
+
+	limit := script.OutputLimit
+	if limit == 0 {
+		limit = e.OutputLimit
+	}
+	stdout := &syncBuffer{limit: limit}
+	output := &syncBuffer{limit: limit}
+	vars := append(e.nodeEnv(), scriptEnv(script, target)...)
+
+	var workdir string
+	var setupErr error
+	if script.VerifyChecksumOnRun {
+		setupErr = script.verifyFileChecksum()
+	}
+	if script.EphemeralWorkdir && setupErr == nil {
+		if workdir, setupErr = newWorkdir(script); setupErr == nil {
+			vars = append(vars, "WORKDIR="+workdir)
+		}
+	}
+
+	var artifacts string
+	if script.Artifacts != nil && setupErr == nil {
+		if artifacts, setupErr = e.newArtifactsDir(script); setupErr == nil {
+			vars = append(vars, "ARTIFACTS_DIR="+artifacts)
+		}
+	}
+
+	var content string
+	if setupErr == nil {
+		content, setupErr = script.render(target, paramsFrom(parent))
+	}
+
+	env := Env{
+		Target:  target,
+		Dir:     workdir,
+		Vars:    vars,
+		Content: content,
+		Stdout:  io.MultiWriter(stdout, output),
+		Stderr:  output,
+		Stdin:   stdinFrom(parent),
+	}
+
+	// The output is published line by line to the clients tailing the run
+	// and, for streamed probes, to the listener.
+	listener := streamListener(parent)
+	run := e.live.start(script, target)
+	tap := func(stream string) *lineWriter {
+		return &lineWriter{emit: func(line string) {
+			event := StreamEvent{Script: script.Name, Target: target, Attempt: attempts, Stream: stream, Line: line}
+			run.publish(event)
+			if listener != nil {
+				listener(streamOutput, event)
+			}
+		}}
+	}
+	stdoutLines, stderrLines := tap("stdout"), tap("stderr")
+	env.Stdout = io.MultiWriter(env.Stdout, stdoutLines)
+	env.Stderr = io.MultiWriter(env.Stderr, stderrLines)
+	streams := []*lineWriter{stdoutLines, stderrLines}
+
+	var result Result
+	var err error
+	var rc int
+	var signal string
+	var reason string
+	var metrics []*dto.MetricFamily
+	var lookup float64
+	for {
+		attempts++
+		stdout.Reset()
+		output.Reset()
+
+		if listener != nil {
+			event := streamStarted
+			if attempts > 1 {
+				event = streamRetry
+			}
+			listener(event, StreamEvent{Script: script.Name, Target: target, Attempt: attempts})
+		}
+
+		result, err = Result{}, setupErr
+		if script.ResolveTarget && target != "" && err == nil {
+			var ip string
+			lookupStart := time.Now()
+			ip, err = resolveTarget(ctx, target, script.IPProtocol)
+			lookup = time.Since(lookupStart).Seconds()
+			env.Vars = append(vars[:len(vars):len(vars)], "TARGET_IP="+ip)
+		}
+		if err == nil {
+			result, err = e.runScript(ctx, script, env)
+		}
+		for _, lines := range streams {
+			lines.Flush()
+		}
+		rc = result.ExitCode
+		signal = result.Signal
+
+		if err == nil && result.Signal == "" {
+			reason, err = script.SuccessWhen.evaluate(rc, stdout.String())
+			if err == nil && script.OutputMetrics {
+				if metrics, err = script.outputMetrics(stdout.String()); err != nil {
+					reason = reasonOutputParseError
+				}
+			}
+		} else {
+			// Runs that could not be completed report exit code 1.
+			if err != nil {
+				rc = 1
+			}
+			reason, err = failureReason(ctx, result, err)
+		}
+		if err == nil || attempts > script.Retries {
+			break
+		}
+
+		log.Printf("RETRY: %s to %s: %s (attempt %d of %d).\n", script.Name, target, err, attempts, script.Retries+1)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		backoff *= 2
+	}
+	duration := time.Since(start).Seconds()
+	cancelled := parent.Err() != nil
+
+	if err == nil {
+		log.Printf("OK: %s to %s (after %fs).\n", script.Name, target, duration)
+		success = 1
+	} else if cancelled {
+		log.Printf("CANCELLED: %s to %s: %s (after %fs).\n", script.Name, target, parent.Err(), duration)
+		runsCancelled.WithLabelValues(script.Name).Inc()
+	} else {
+		log.Printf("ERROR: %s to %s: %s (failed after %fs).\n", script.Name, target, err, duration)
+	}
+
+	if artifacts != "" {
+		if err := e.storeArtifacts(script, target, start, artifacts, err != nil); err != nil {
+			log.Printf("ERROR: storing artifacts of %s: %s\n", script.Name, err)
+		}
+	}
+
+	if workdir != "" {
+		if err != nil && script.KeepWorkdirOnFailure {
+			log.Printf("ERROR: %s to %s: kept working directory %s.\n", script.Name, target, workdir)
+		} else if err := os.RemoveAll(workdir); err != nil {
+			log.Printf("ERROR: removing working directory of %s: %s\n", script.Name, err)
+		}
+	}
+
+	if !cancelled {
+		buckets := script.DurationBuckets
+		if buckets == nil {
+			buckets = e.DurationBuckets
+		}
+		durations.observe(script, buckets, duration)
+	}
+
+	measurement := &Measurement{
+		Script:   script,
+		Target:   target,
+		Start:    start,
+		Duration: duration,
+		Success:  success,
+		ExitCode: rc,
+		Attempts: attempts,
+		Output:   output.truncatedString(),
+		Metrics:  metrics,
+
+		OutputBytes:     output.written(),
+		OutputTruncated: output.truncated(),
+		DNSLookup:       lookup,
+
+		Cancelled:   cancelled,
+		ErrorReason: reason,
+		Signal:      signal,
+	}
+	if success == 1 {
+		measurement.LastSuccess = measurement.end()
+	} else if latest := results.latest(script, target); latest != nil {
+		measurement.LastSuccess = latest.LastSuccess
+	}
+	script.history.record(measurement, e.HistorySize)
+	if e.historyDB != nil {
+		if err := e.historyDB.record(newHistoryEntry(measurement)); err != nil {
+			log.Printf("ERROR: recording %s in the history database failed: %s\n", script.Name, err)
+		}
+	}
+	e.live.finish(run, measurement)
+
+	return measurement
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes, used to collect the
+// interleaved stdout and stderr of a script. If limit is positive, writes past
+// the first limit bytes are counted but discarded.
+type syncBuffer struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	limit int64
+	total int64
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.total += int64(len(p))
+	if b.limit > 0 {
+		if room := b.limit - int64(b.buf.Len()); room < int64(len(p)) {
+			if room > 0 {
+				b.buf.Write(p[:room])
+			}
+			return len(p), nil
+		}
+	}
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+	b.total = 0
+}
+
+// String returns the captured output.
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// truncatedString returns the captured output followed by a marker if writes
+// were discarded.
+func (b *syncBuffer) truncatedString() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if dropped := b.total - int64(b.buf.Len()); dropped > 0 {
+		return fmt.Sprintf("%s\n[truncated %d bytes]", b.buf.String(), dropped)
+	}
+	return b.buf.String()
+}
+
+// truncated tells whether writes since the last Reset were discarded.
+func (b *syncBuffer) truncated() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.total > int64(b.buf.Len())
+}
+
+// written returns the number of bytes written since the last Reset, including
+// discarded ones.
+func (b *syncBuffer) written() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.total
+}
+
+// failureReason classifies a run that could not be completed or was killed,
+// returning the failure reason and an error describing it. Runs cancelled by
+// the probe going away are not classified.
+func failureReason(ctx context.Context, result Result, err error) (string, error) {
+	if err == nil {
+		err = fmt.Errorf("signal: %s", result.Signal)
+	}
+
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return reasonTimeout, err
+	case context.Canceled:
+		return "", err
+	}
+
+	if result.Signal != "" {
+		return reasonSignal, err
+	}
+	if _, ok := err.(*resolveError); ok {
+		return reasonDNSFailure, err
+	}
+	if _, ok := err.(*checksumError); ok {
+		return reasonChecksumMismatch, err
+	}
+	return reasonStartFailure, err
+}
+
+// Probe measures script against target, subject to the script's coalescing,
+// overlap and circuit breaker settings. The script is killed if ctx is
+// cancelled before it completes. The measurement is kept for /metrics if
+// ExposeResults is set or the script is scheduled.
+func (e *Exporter) Probe(ctx context.Context, script *Script, target string) *Measurement {
+	var measurement *Measurement
+	switch {
+	case e.scriptDisabled(script):
+		measurement = &Measurement{Script: script, Target: target, Start: time.Now(), Disabled: true}
+	case e.Paused():
+		return pausedMeasurement(script, target)
+	default:
+		measurement = e.probe(ctx, script, target)
+	}
+	if e.ExposeResults || script.scheduled() {
+		results.record(measurement)
+	}
+	return measurement
+}
+
+func (e *Exporter) probe(ctx context.Context, script *Script, target string) *Measurement {
+	measure := func() *Measurement {
+		return script.breaker.run(script, target, func() *Measurement {
+			return e.measureScript(ctx, script, target)
+		})
+	}
+
+	// A probe with its own input can share neither the execution nor the
+	// result of another one.
+	if stdinFrom(ctx) != nil {
+		return measure()
+	}
+
+	if !script.allowOverlap() {
+		guarded := measure
+		measure = func() *Measurement {
+			return script.overlap.run(script, target, guarded)
+		}
+	}
+
+	if script.Coalesce {
+		// A coalesced execution is shared with other probes, so it must not
+		// be cancelled when the probe that started it goes away.
+		ctx = detach(ctx)
+		return script.flights.do(flightKey(ctx, script, target), measure)
+	}
+	return measure()
+}
+
+func (e *Exporter) runScripts(scripts []*Script, target string) []*Measurement {
+	measurements := make([]*Measurement, 0)
+
+	e.ProbeAll(context.Background(), scripts, target, 0, func(measurement *Measurement) {
+		measurements = append(measurements, measurement)
+	})
+
+	return measurements
+}
+
+// ProbeAll probes scripts concurrently and calls emit with each measurement as
+// soon as it completes. If maxWait is positive, scripts still running after
+// maxWait are emitted as failed measurements and left to finish on their own
+// until ctx is cancelled.
+func (e *Exporter) ProbeAll(ctx context.Context, scripts []*Script, target string, maxWait time.Duration, emit func(*Measurement)) {
+	start := time.Now()
+
+	// Buffered so that scripts outliving maxWait do not block forever.
+	ch := make(chan *Measurement, len(scripts))
+
+	for _, script := range scripts {
+		go func(script *Script) {
+			ch <- e.Probe(ctx, script, target)
+		}(script)
+	}
+
+	var deadline <-chan time.Time
+	if maxWait > 0 {
+		timer := time.NewTimer(maxWait)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	pending := make(map[*Script]bool, len(scripts))
+	for _, script := range scripts {
+		pending[script] = true
+	}
+
+	for len(pending) > 0 {
+		select {
+		case measurement := <-ch:
+			delete(pending, measurement.Script)
+			emit(measurement)
+		case <-deadline:
+			duration := time.Since(start).Seconds()
+			for _, script := range scripts {
+				if !pending[script] {
+					continue
+				}
+				log.Printf("ERROR: %s to %s: still running after max_wait (%fs).\n", script.Name, target, duration)
+				delete(pending, script)
+				emit(&Measurement{
+					Script:      script,
+					Target:      target,
+					Start:       start,
+					Duration:    duration,
+					ExitCode:    1,
+					ErrorReason: reasonTimeout,
+				})
+			}
+		}
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricLabels renders the label set of a script's metrics for target: the
+// script name, the script's configured labels in sorted order and, for the ssh
+// runner, the remote host.
+func metricLabels(script *Script, target string) string {
+	labels := fmt.Sprintf("script=\"%s\"", labelValueEscaper.Replace(script.Name))
+	for _, name := range sortedKeys(script.Labels) {
+		labels += fmt.Sprintf(",%s=\"%s\"", name, labelValueEscaper.Replace(script.Labels[name]))
+	}
+	if script.Runner == runnerSSH {
+		labels += fmt.Sprintf(",remote_host=\"%s\"", labelValueEscaper.Replace(script.SSH.remoteHost(target)))
+	}
+	if script.MLabLabels {
+		if host := targetMLabHost(target); host != nil {
+			labels += host.labels()
+		}
+	}
+	return labels
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteMeasurement renders measurement in the Prometheus text format, with the
+// metric names prefixed by the script's metric prefix.
+func WriteMeasurement(w io.Writer, measurement *Measurement) {
+	labels := metricLabels(measurement.Script, measurement.Target)
+	prefix := measurement.Script.prefix()
+	metrics := w
+	if description := measurement.Script.Description; description != "" {
+		w = &describedWriter{w: w, help: helpEscaper.Replace(description), seen: make(map[string]bool)}
+	}
+	if measurement.Disabled {
+		fmt.Fprintf(w, "%s_disabled{%s} 1\n", prefix, labels)
+		return
+	}
+	if measurement.Paused {
+		fmt.Fprintf(w, "%s_paused{%s} 1\n", prefix, labels)
+		if measurement.Start.IsZero() {
+			return
+		}
+	}
+	fmt.Fprintf(w, "%s_duration_seconds{%s} %f\n", prefix, labels, measurement.Duration)
+	fmt.Fprintf(w, "%s_success{%s} %d\n", prefix, labels, measurement.Success)
+	fmt.Fprintf(w, "%s_exit_code{%s} %d\n", prefix, labels, measurement.ExitCode)
+	fmt.Fprintf(w, "%s_attempts{%s} %d\n", prefix, labels, measurement.Attempts)
+	fmt.Fprintf(w, "%s_circuit_open{%s} %d\n", prefix, labels, boolToInt(measurement.CircuitOpen))
+	fmt.Fprintf(w, "%s_timed_out{%s} %d\n", prefix, labels, boolToInt(measurement.ErrorReason == reasonTimeout))
+	fmt.Fprintf(w, "%s_output_bytes{%s} %d\n", prefix, labels, measurement.OutputBytes)
+	if measurement.Script.ResolveTarget {
+		fmt.Fprintf(w, "%s_dns_lookup_seconds{%s} %f\n", prefix, labels, measurement.DNSLookup)
+	}
+	if !measurement.Start.IsZero() {
+		end := unixSeconds(measurement.end())
+		fmt.Fprintf(w, "%s_start_time_seconds{%s} %f\n", prefix, labels, unixSeconds(measurement.Start))
+		fmt.Fprintf(w, "%s_end_time_seconds{%s} %f\n", prefix, labels, end)
+		fmt.Fprintf(w, "%s_last_run_timestamp_seconds{%s} %f\n", prefix, labels, end)
+	}
+	if !measurement.LastSuccess.IsZero() {
+		fmt.Fprintf(w, "%s_last_success_timestamp_seconds{%s} %f\n", prefix, labels, unixSeconds(measurement.LastSuccess))
+	}
+	for _, reason := range errorReasons {
+		fmt.Fprintf(w, "%s_error{%s,reason=\"%s\"} %d\n", prefix, labels, reason, boolToInt(measurement.ErrorReason == reason))
+	}
+	if measurement.Signal != "" {
+		fmt.Fprintf(w, "%s_terminated_by_signal{%s,signal=\"%s\"} 1\n", prefix, labels, labelValueEscaper.Replace(measurement.Signal))
+	}
+	if !measurement.Script.allowOverlap() {
+		fmt.Fprintf(w, "%s_skipped_overlap_total{%s} %d\n", prefix, labels, measurement.Script.overlap.skippedCount())
+	}
+	for _, family := range measurement.Metrics {
+		expfmt.MetricFamilyToText(metrics, family)
+	}
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// describedWriter precedes the first sample written to it of every metric by
+// a HELP line, for the metrics of a script with a description. Samples are
+// written one line per call.
+type describedWriter struct {
+	w    io.Writer
+	help string
+	seen map[string]bool
+}
+
+func (d *describedWriter) Write(p []byte) (int, error) {
+	name := string(p)
+	if i := strings.IndexAny(name, "{ "); i >= 0 {
+		name = name[:i]
+	}
+	if !d.seen[name] {
+		d.seen[name] = true
+		if _, err := fmt.Fprintf(d.w, "# HELP %s %s\n", name, d.help); err != nil {
+			return 0, err
+		}
+	}
+	return d.w.Write(p)
+}
+
+// end returns when the run of measurement completed.
+func (m *Measurement) end() time.Time {
+	return m.Start.Add(time.Duration(m.Duration * float64(time.Second)))
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func scriptFilter(scripts []*Script, name, pattern string) (filteredScripts []*Script, err error) {
+	if name == "" && pattern == "" {
+		err = errors.New("`name` or `pattern` required")
+		return
+	}
+
+	var patternRegexp *regexp.Regexp
+
+	if pattern != "" {
+		patternRegexp, err = patterns.compile(pattern)
+
+		if err != nil {
+			return
+		}
+	}
+
+	for _, script := range scripts {
+		if script.Name == name || (pattern != "" && patternRegexp.MatchString(script.Name)) {
+			filteredScripts = append(filteredScripts, script)
+		}
+	}
+
+	return
+}
+
+// unmatchedMessage describes a probe whose name and pattern match no script.
+func unmatchedMessage(name, pattern string) string {
+	switch {
+	case name != "" && pattern != "":
+		return fmt.Sprintf("No script is named %q or matches pattern %q", name, pattern)
+	case name != "":
+		return fmt.Sprintf("No script is named %q", name)
+	}
+	return fmt.Sprintf("No script matches pattern %q", pattern)
+}
+
+// ProbeError is returned by ProbeQuery for invalid probe parameters.
+type ProbeError struct {
+	// Status is the HTTP status /probe responds with.
+	Status  int
+	Message string
+}
+
+func (e *ProbeError) Error() string {
+	return e.Message
+}
+
+// ProbeQuery runs the probe described by the /probe query parameters params
+// against config, calling emit with each measurement as it completes.
+func (e *Exporter) ProbeQuery(ctx context.Context, config *Config, params url.Values, emit func(*Measurement)) error {
+	ctx = withParams(ctx, params)
+	name := params.Get("name")
+	pattern := params.Get("pattern")
+	target := params.Get("target")
+
+	var scripts []*Script
+
+	if moduleName := params.Get("module"); moduleName != "" {
+		module := config.module(moduleName)
+		if module == nil {
+			return &ProbeError{400, fmt.Sprintf("Unknown module %q", moduleName)}
+		}
+
+		if module.targetRegexp != nil && !module.targetRegexp.MatchString(target) {
+			log.Printf("ERROR: Target %s failed to match target_pattern of module %s\n", target, module.Name)
+			return &ProbeError{400, "Invalid target parameter"}
+		}
+
+		scripts = []*Script{&module.Script}
+	} else {
+		if pattern != "" && config.DisablePatterns {
+			return &ProbeError{403, "The pattern parameter is disabled"}
+		}
+
+		var err error
+		scripts, err = scriptFilter(config.Scripts, name, pattern)
+
+		if err != nil {
+			return &ProbeError{400, err.Error()}
+		}
+
+		// Daemons are not run by probes, their metrics being on /metrics.
+		probed := scripts[:0:0]
+		for _, script := range scripts {
+			if !script.daemon() {
+				probed = append(probed, script)
+			} else if script.Name == name {
+				return &ProbeError{400, fmt.Sprintf("Script %s is a daemon, exposing its metrics on /metrics", name)}
+			}
+		}
+		scripts = probed
+		if len(scripts) == 0 {
+			probesUnmatched.Inc()
+			if message := unmatchedMessage(name, pattern); e.StatusCodes {
+				return &ProbeError{404, message}
+			} else if !e.AllowNoMatch {
+				return &ProbeError{400, message}
+			}
+		}
+	}
+
+	// If the passed target does not validate return an error.
+	if target != "" {
+		var err error
+		if len(scripts) == 0 {
+			_, err = ParseTarget(target)
+		}
+		for _, script := range scripts {
+			if err == nil {
+				err = script.validateTarget(target)
+			}
+		}
+		if err != nil {
+			log.Printf("ERROR: Target %s is invalid: %s\n", target, err)
+			return &ProbeError{400, "Invalid target parameter"}
+		}
+
+		for _, script := range scripts {
+			if !config.targetAllowed(script, target) {
+				log.Printf("ERROR: Target %s is not allowed for script %s\n", target, script.Name)
+				return &ProbeError{403, "Target not allowed"}
+			}
+		}
+	}
+
+	if stdinFrom(ctx) != nil {
+		for _, script := range scripts {
+			if !script.AcceptBody {
+				return &ProbeError{400, fmt.Sprintf("Script %s does not accept a request body", script.Name)}
+			}
+		}
+	}
+
+	for _, script := range scripts {
+		if script.RateLimit != nil && !script.limiter.allow("", script.RateLimit) {
+			log.Printf("ERROR: Rate limit of script %s exceeded\n", script.Name)
+			probesRejected.WithLabelValues("script").Inc()
+			return &ProbeError{429, fmt.Sprintf("Rate limit of script %s exceeded", script.Name)}
+		}
+	}
+
+	maxWait := e.MaxWait
+	if v := params.Get("max_wait"); v != "" {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err != nil || seconds < 0 {
+			return &ProbeError{400, "Invalid max_wait parameter"}
+		}
+		if wait := time.Duration(seconds * float64(time.Second)); wait > 0 && (maxWait == 0 || wait < maxWait) {
+			maxWait = wait
+		}
+	}
+
+	if v := params.Get("timeout"); v != "" {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err != nil || seconds <= 0 {
+			return &ProbeError{400, "Invalid timeout parameter"}
+		}
+		ctx = withTimeout(ctx, time.Duration(seconds*float64(time.Second)))
+	}
+
+	e.ProbeAll(ctx, scripts, target, maxWait, emit)
+	return nil
+}
+
+// probeContext admits the probe r, checking probe_auth and the client rate
+// limit of config, and returns the context to run it with, which feeds the
+// body of a POST probe to the scripts. Scripts are killed when the client,
+// e.g. a Prometheus server that hit its scrape timeout, disconnects.
+func (e *Exporter) probeContext(r *http.Request, config *Config) (context.Context, *ProbeError) {
+	if err := config.ProbeAuth.authorize(r); err != nil {
+		log.Printf("ERROR: Probe from %s rejected: %s\n", clientAddress(r), err)
+		return nil, &ProbeError{err.status, err.message}
+	}
+
+	if limit := config.ClientRateLimit; limit != nil {
+		if client := clientAddress(r); !e.clients.allow(client, limit) {
+			log.Printf("ERROR: Rate limit of client %s exceeded\n", client)
+			probesRejected.WithLabelValues("client").Inc()
+			return nil, &ProbeError{429, "Rate limit exceeded"}
+		}
+	}
+
+	ctx := r.Context()
+
+	body, err := readProbeBody(r)
+	if err != nil {
+		return nil, &ProbeError{http.StatusRequestEntityTooLarge, err.Error()}
+	}
+	if body != nil {
+		ctx = WithStdin(ctx, body)
+	}
+	return ctx, nil
+}
+
+func (e *Exporter) scriptRunHandler(w http.ResponseWriter, r *http.Request, config *Config) {
+	flusher, _ := w.(http.Flusher)
+
+	ctx, probeErr := e.probeContext(r, config)
+	if probeErr != nil {
+		if probeErr.Status == 401 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, probeErr.Message, probeErr.Status)
+		return
+	}
+
+	// The status depends on the outcome of every script, so the response is
+	// only streamed when it is always 200.
+	var out io.Writer = w
+	var buffered *bytes.Buffer
+	if e.failureStatuses(config) {
+		buffered = &bytes.Buffer{}
+		out, flusher = buffered, nil
+	}
+
+	var measurements []*Measurement
+	err := e.ProbeQuery(ctx, config, r.URL.Query(), func(measurement *Measurement) {
+		measurements = append(measurements, measurement)
+		WriteMeasurement(out, measurement)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+
+	if probeError, ok := err.(*ProbeError); ok {
+		http.Error(w, probeError.Message, probeError.Status)
+		return
+	}
+	if buffered != nil {
+		if status := e.failureStatus(measurements); status != 0 {
+			w.WriteHeader(status)
+		}
+		buffered.WriteTo(w)
+	}
+}
+
+// failureStatuses reports whether a probe with config may respond with a
+// failure status.
+func (e *Exporter) failureStatuses(config *Config) bool {
+	if e.StatusCodes {
+		return true
+	}
+	for _, script := range config.allScripts() {
+		if script.FailureStatus != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// failureStatus returns the HTTP status of a probe with the given
+// measurements, or 0 for 200: if all those of scripts run failed, the
+// failure_status of the first script having one, or else 503 with
+// StatusCodes.
+func (e *Exporter) failureStatus(measurements []*Measurement) int {
+	status, failed := 0, 0
+	for _, measurement := range measurements {
+		if measurement.Disabled || measurement.Paused {
+			continue
+		}
+		if measurement.Success == 1 {
+			return 0
+		}
+		if failed++; status == 0 {
+			status = measurement.Script.FailureStatus
+		}
+	}
+	if status == 0 && e.StatusCodes && failed > 0 {
+		status = http.StatusServiceUnavailable
+	}
+	return status
+}
+
+var runsCancelled = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "script_exporter_runs_cancelled_total",
+		Help: "Number of script runs killed because the probe was cancelled, e.g. by the client disconnecting.",
+	},
+	[]string{"script"},
+)
+
+var probesUnmatched = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "script_exporter_probes_unmatched_total",
+		Help: "Number of probes whose name or pattern matched no script.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(runsCancelled)
+	prometheus.MustRegister(probesUnmatched)
+}
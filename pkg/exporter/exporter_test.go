@@ -0,0 +1,389 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testExporter = New("")
+
+var config = &Config{
+	Scripts: []*Script{
+		{Name: "success", Content: "exit 0", Timeout: 1},
+		{Name: "failure", Content: "exit 1", Timeout: 1},
+		{Name: "timeout", Content: "sleep 5", Timeout: 2},
+		{Name: "target", Content: "testdata/check_target.sh", Timeout: 5},
+	},
+}
+
+func TestTargetRegexp(t *testing.T) {
+	target := "example.com;rf -rf /"
+
+	if targetRegexp.MatchString(target) {
+		t.Errorf("Expected target to not match targetRegexp: %s", target)
+	}
+}
+
+func TestRunScripts(t *testing.T) {
+	measurements := testExporter.runScripts(config.Scripts, "fake-target")
+
+	expectedResults := map[string]struct {
+		success     int
+		minDuration float64
+	}{
+		"success": {1, 0},
+		"failure": {0, 0},
+		"timeout": {0, 2},
+		"target":  {1, 0},
+	}
+
+	for _, measurement := range measurements {
+		expectedResult := expectedResults[measurement.Script.Name]
+
+		if measurement.Success != expectedResult.success {
+			t.Errorf("Expected result not found: %s", measurement.Script.Name)
+		}
+
+		if measurement.Duration < expectedResult.minDuration {
+			t.Errorf("Expected duration %f < %f: %s", measurement.Duration, expectedResult.minDuration, measurement.Script.Name)
+		}
+	}
+}
+
+func TestScriptFilter(t *testing.T) {
+	t.Run("RequiredParameters", func(t *testing.T) {
+		_, err := scriptFilter(config.Scripts, "", "")
+
+		if err.Error() != "`name` or `pattern` required" {
+			t.Errorf("Expected failure when supplying no parameters")
+		}
+	})
+
+	t.Run("NameMatch", func(t *testing.T) {
+		scripts, err := scriptFilter(config.Scripts, "success", "")
+
+		if err != nil {
+			t.Errorf("Unexpected: %s", err.Error())
+		}
+
+		if len(scripts) != 1 || scripts[0] != config.Scripts[0] {
+			t.Errorf("Expected script not found")
+		}
+	})
+
+	t.Run("PatternMatch", func(t *testing.T) {
+		scripts, err := scriptFilter(config.Scripts, "", "fail.*")
+
+		if err != nil {
+			t.Errorf("Unexpected: %s", err.Error())
+		}
+
+		if len(scripts) != 1 || scripts[0] != config.Scripts[1] {
+			t.Errorf("Expected script not found")
+		}
+	})
+
+	t.Run("AllMatch", func(t *testing.T) {
+		scripts, err := scriptFilter(config.Scripts, "success", ".*")
+
+		if err != nil {
+			t.Errorf("Unexpected: %s", err.Error())
+		}
+
+		if len(scripts) != 4 {
+			t.Fatalf("Expected 4 scripts, received %d", len(scripts))
+		}
+
+		for i, script := range config.Scripts {
+			if scripts[i] != script {
+				t.Fatalf("Expected script not found")
+			}
+		}
+	})
+}
+
+func TestRetries(t *testing.T) {
+	// Fails on the first attempt only, by leaving a marker file behind.
+	marker := filepath.Join(t.TempDir(), "marker")
+	script := &Script{
+		Name:         "flaky",
+		Content:      fmt.Sprintf("test -f %s && exit 0; touch %s; exit 1", marker, marker),
+		Timeout:      5,
+		Retries:      2,
+		RetryBackoff: 0.1,
+	}
+
+	measurement := testExporter.runScripts([]*Script{script}, "")[0]
+
+	if measurement.Success != 1 {
+		t.Errorf("Expected flaky script to succeed after retrying")
+	}
+
+	if measurement.Attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", measurement.Attempts)
+	}
+}
+
+func TestStreamScriptsMaxWait(t *testing.T) {
+	start := time.Now()
+	var measurements []*Measurement
+
+	testExporter.ProbeAll(context.Background(), config.Scripts[:3], "", 500*time.Millisecond, func(m *Measurement) {
+		measurements = append(measurements, m)
+	})
+
+	if elapsed := time.Since(start); elapsed >= 2*time.Second {
+		t.Errorf("Expected max_wait to cut the probe short, took %s", elapsed)
+	}
+
+	if len(measurements) != 3 {
+		t.Fatalf("Expected 3 measurements, received %d", len(measurements))
+	}
+
+	// The timeout script is the only one still running at max_wait.
+	if last := measurements[2]; last.Script.Name != "timeout" || last.Success != 0 {
+		t.Errorf("Expected unfinished timeout script to be reported last as failed")
+	}
+}
+
+func TestProbeMaxWait(t *testing.T) {
+	e := New("")
+	e.MaxWait = 300 * time.Millisecond
+
+	var measurements []*Measurement
+	emit := func(m *Measurement) { measurements = append(measurements, m) }
+
+	// A longer max_wait parameter does not extend the deadline.
+	start := time.Now()
+	if err := e.ProbeQuery(context.Background(), config, url.Values{"pattern": {"timeout|success"}, "max_wait": {"10"}}, emit); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("Expected the probe to end at the deadline, took %s", elapsed)
+	}
+	if len(measurements) != 2 || measurements[1].Script.Name != "timeout" || measurements[1].ErrorReason != reasonTimeout {
+		t.Errorf("Expected the unfinished script to be reported as timed out")
+	}
+}
+
+func TestProbeCancelled(t *testing.T) {
+	script := &Script{Name: "slow", Content: "sleep 5", Timeout: 10}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	measurement := testExporter.Probe(ctx, script, "")
+
+	if elapsed := time.Since(start); elapsed >= 2*time.Second {
+		t.Errorf("Expected cancelled script to be killed, took %s", elapsed)
+	}
+
+	if !measurement.Cancelled || measurement.Success != 0 {
+		t.Errorf("Expected cancelled, failed measurement")
+	}
+}
+
+func TestErrorReason(t *testing.T) {
+	for _, test := range []struct {
+		shell  string
+		script *Script
+		reason string
+		signal string
+	}{
+		{"/bin/sh", &Script{Name: "success", Content: "exit 0", Timeout: 1}, "", ""},
+		{"/bin/sh", &Script{Name: "failure", Content: "exit 1", Timeout: 1}, reasonNonzeroExit, ""},
+		{"/bin/sh", &Script{Name: "timeout", Content: "sleep 5", Timeout: 1}, reasonTimeout, "SIGKILL"},
+		{"/bin/sh", &Script{Name: "signal", Content: "kill -TERM $$", Timeout: 1}, reasonSignal, "SIGTERM"},
+		{"/nonexistent/sh", &Script{Name: "start", Content: "exit 0", Timeout: 1}, reasonStartFailure, ""},
+	} {
+		t.Run(test.script.Name, func(t *testing.T) {
+			e := New("")
+			e.Shell = test.shell
+
+			measurement := e.runScripts([]*Script{test.script}, "")[0]
+			if measurement.ErrorReason != test.reason {
+				t.Errorf("Expected reason %q, got %q", test.reason, measurement.ErrorReason)
+			}
+			if measurement.Signal != test.signal {
+				t.Errorf("Expected signal %q, got %q", test.signal, measurement.Signal)
+			}
+
+			var out bytes.Buffer
+			WriteMeasurement(&out, measurement)
+			expected := ""
+			if test.signal != "" {
+				expected = fmt.Sprintf("script_terminated_by_signal{script=\"%s\",signal=\"%s\"} 1\n", test.script.Name, test.signal)
+			}
+			metric := ""
+			for _, line := range strings.SplitAfter(out.String(), "\n") {
+				if strings.HasPrefix(line, "script_terminated_by_signal") {
+					metric += line
+				}
+			}
+			if metric != expected {
+				t.Errorf("Expected %q, got %q", expected, metric)
+			}
+		})
+	}
+}
+
+func TestProbeQuery(t *testing.T) {
+	var measurements []*Measurement
+	emit := func(m *Measurement) { measurements = append(measurements, m) }
+
+	if err := testExporter.ProbeQuery(context.Background(), config, url.Values{"name": {"failure"}}, emit); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+	if len(measurements) != 1 || measurements[0].ExitCode != 1 {
+		t.Errorf("Expected one failed measurement")
+	}
+
+	err := testExporter.ProbeQuery(context.Background(), config, url.Values{"name": {"success"}, "max_wait": {"-1"}}, emit)
+	if probeError, ok := err.(*ProbeError); !ok || probeError.Status != 400 {
+		t.Errorf("Expected 400 for invalid max_wait, got %v", err)
+	}
+
+	// The timeout parameter only ever shortens the timeout of a script.
+	measurements = nil
+	start := time.Now()
+	if err := testExporter.ProbeQuery(context.Background(), config, url.Values{"name": {"timeout"}, "timeout": {"0.3"}}, emit); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second || len(measurements) != 1 || measurements[0].ErrorReason != reasonTimeout {
+		t.Errorf("Expected the timeout parameter to cut the run short, took %s", elapsed)
+	}
+	if timeout := scriptTimeout(withTimeout(context.Background(), time.Hour), config.Scripts[2]); timeout != 2*time.Second {
+		t.Errorf("Expected the timeout of the script to bound the timeout parameter, got %s", timeout)
+	}
+
+	err = testExporter.ProbeQuery(context.Background(), config, url.Values{"name": {"success"}, "timeout": {"0"}}, emit)
+	if probeError, ok := err.(*ProbeError); !ok || probeError.Status != 400 {
+		t.Errorf("Expected 400 for invalid timeout, got %v", err)
+	}
+}
+
+func TestProbeStatusCodes(t *testing.T) {
+	e := New("")
+	probe := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		e.scriptRunHandler(w, httptest.NewRequest("GET", "/probe?"+query, nil), config)
+		return w
+	}
+
+	if w := probe("name=failure"); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a failed script by default, got %d", w.Code)
+	}
+
+	e.StatusCodes = true
+	for query, status := range map[string]int{
+		"name=failure":            http.StatusServiceUnavailable,
+		"pattern=success|failure": http.StatusOK,
+		"name=missing":            http.StatusNotFound,
+	} {
+		if w := probe(query); w.Code != status {
+			t.Errorf("Expected %d for %s, got %d", status, query, w.Code)
+		}
+	}
+	if w := probe("name=failure"); !strings.Contains(w.Body.String(), `script_success{script="failure"} 0`) {
+		t.Errorf("Expected the metrics of the failed script:\n%s", w.Body.String())
+	}
+
+	e.StatusCodes = false
+	if w := probe("name=missing"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `No script is named "missing"`) {
+		t.Errorf("Expected 400 for a name matching no script, got %d: %s", w.Code, w.Body.String())
+	}
+	e.AllowNoMatch = true
+	if w := probe("pattern=^none$"); w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("Expected an empty 200 with AllowNoMatch, got %d", w.Code)
+	}
+
+	failing := &Config{Scripts: []*Script{{Name: "down", Content: "exit 1", Timeout: 1, FailureStatus: 500}}}
+	w := httptest.NewRecorder()
+	e.scriptRunHandler(w, httptest.NewRequest("GET", "/probe?name=down", nil), failing)
+	if w.Code != 500 {
+		t.Errorf("Expected the failure_status of the script, got %d", w.Code)
+	}
+
+	if _, err := LoadConfig(writeConfig(t, "scripts:\n  - name: a\n    failure_status: 200\n    script: exit 0\n")); err == nil {
+		t.Errorf("Expected a failure_status below 400 to be rejected")
+	}
+}
+
+func TestWriteMeasurementTimestamps(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	var out bytes.Buffer
+
+	WriteMeasurement(&out, &Measurement{Script: config.Scripts[2], Start: start, Duration: 2.5, ErrorReason: reasonTimeout})
+
+	for _, line := range []string{
+		`script_timed_out{script="timeout"} 1`,
+		`script_start_time_seconds{script="timeout"} 1600000000.000000`,
+		`script_end_time_seconds{script="timeout"} 1600000002.500000`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("Expected %s in:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestOutputLimit(t *testing.T) {
+	script := &Script{Name: "chatty", Content: "head -c 5000 /dev/zero | tr '\\0' x", Timeout: 5, OutputLimit: 1000}
+
+	measurement := testExporter.runScripts([]*Script{script}, "")[0]
+
+	if measurement.OutputBytes != 5000 {
+		t.Errorf("Expected 5000 output bytes, got %d", measurement.OutputBytes)
+	}
+	if !strings.HasSuffix(measurement.Output, "\n[truncated 4000 bytes]") || len(measurement.Output) > 1100 {
+		t.Errorf("Expected output truncated to 1000 bytes, got %d bytes", len(measurement.Output))
+	}
+}
+
+func TestLargeScript(t *testing.T) {
+	// Scripts larger than the pipe buffer are fed to the shell as it reads
+	// them, even when it exits before reading them whole.
+	padding := strings.Repeat(": padding the script past the pipe buffer\n", 4000)
+	scripts := []*Script{
+		{Name: "large", Content: padding + "echo done", Timeout: 5},
+		{Name: "early", Content: "echo early; exit 0\n" + padding, Timeout: 5},
+	}
+	for _, script := range scripts {
+		m := testExporter.runScripts([]*Script{script}, "")[0]
+		if m.Success != 1 || m.Output != map[string]string{"large": "done\n", "early": "early\n"}[script.Name] {
+			t.Errorf("Expected %s to succeed, got %+v", script.Name, m)
+		}
+	}
+}
+
+func TestInterpreterArgs(t *testing.T) {
+	content := "false | true\necho $UNSET_VARIABLE_OF_THE_TEST\necho reached"
+	lax := &Script{Name: "lax", Content: content, Timeout: 5}
+	strict := &Script{Name: "strict", Content: content, Timeout: 5, InterpreterArgs: []string{"-e", "-u"}}
+
+	if m := testExporter.runScripts([]*Script{lax}, "")[0]; m.Success != 1 || !strings.Contains(m.Output, "reached") {
+		t.Errorf("Expected the script to run to the end without interpreter_args: %s", m.Output)
+	}
+	if m := testExporter.runScripts([]*Script{strict}, "")[0]; m.Success != 0 || strings.Contains(m.Output, "reached") {
+		t.Errorf("Expected -u to stop the script at the unset variable: %s", m.Output)
+	}
+
+	// The arguments come before those running the script of a POST probe.
+	strict.AcceptBody = true
+	measurement := testExporter.measureScript(WithStdin(context.Background(), []byte("input")), strict, "")
+	if measurement.Success != 0 || strings.Contains(measurement.Output, "reached") {
+		t.Errorf("Expected -u to apply to a script reading the body: %s", measurement.Output)
+	}
+
+	if err := (&Script{Name: "a", Runner: runnerStarlark, InterpreterArgs: []string{"-e"}}).setDefaults(); err == nil {
+		t.Errorf("Expected interpreter_args to require the shell runner")
+	}
+}
@@ -0,0 +1,97 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+)
+
+// targetGroup is a target group of a Prometheus file_sd document.
+type targetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// WriteFileSD writes a Prometheus file_sd document of the exporter at address,
+// a host:port, scraped over scheme, "http" or "https": a target group for
+// /metrics if scripts are scheduled or run as daemons, one per script probed
+// with /probe and one per target listed in each module. The paths, parameters
+// and scrape timeouts are set through the meta labels Prometheus scrapes a
+// target with, so a single job scrapes them all. Scripts reading the body of
+// a probe cannot be scraped and are left out.
+func (e *Exporter) WriteFileSD(w io.Writer, address, scheme string) error {
+	config := e.Config()
+	if config == nil {
+		return fmt.Errorf("no configuration loaded")
+	}
+
+	groups := []targetGroup{}
+	group := func(path string, job scrapeJob, params map[string]string) targetGroup {
+		labels := map[string]string{"__scheme__": scheme, "__metrics_path__": e.LinkPrefix + path}
+		for name, value := range params {
+			labels["__param_"+name] = value
+		}
+		if job.Timeout > 0 {
+			labels["__scrape_timeout__"] = strconv.FormatInt(job.Timeout, 10) + "s"
+		}
+		if job.Interval > 0 {
+			labels["__scrape_interval__"] = strconv.FormatInt(job.Interval, 10) + "s"
+		}
+		return targetGroup{Targets: []string{address}, Labels: labels}
+	}
+
+	metrics := false
+	for _, script := range config.Scripts {
+		switch {
+		case script.scheduled() || script.daemon():
+			metrics = true
+		case !script.AcceptBody:
+			groups = append(groups, group("/probe", newScrapeJob("script", script), map[string]string{"name": script.Name}))
+		}
+	}
+	if metrics {
+		groups = append([]targetGroup{group(e.MetricsPath, scrapeJob{}, nil)}, groups...)
+	}
+	for _, module := range config.Modules {
+		if module.AcceptBody {
+			continue
+		}
+		job := newScrapeJob("module", &module.Script)
+		for _, target := range module.Targets {
+			g := group("/probe", job, map[string]string{"module": module.Name, "target": target})
+			g.Labels["instance"] = target
+			groups = append(groups, g)
+		}
+	}
+
+	out, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(out, '\n'))
+	return err
+}
+
+// writeFileSD replaces the file_sd document at FileSDPath, if set, with one of
+// the active configuration.
+func (e *Exporter) writeFileSD() {
+	if e.FileSDPath == "" {
+		return
+	}
+	var doc bytes.Buffer
+	err := e.WriteFileSD(&doc, e.FileSDAddress, e.FileSDScheme)
+	if err == nil {
+		err = replaceFile(e.FileSDPath, doc.Bytes())
+	}
+	if err == nil {
+		// Prometheus often runs as another user.
+		err = os.Chmod(e.FileSDPath, 0644)
+	}
+	if err != nil {
+		log.Printf("ERROR: Cannot write the file_sd document %s: %s\n", e.FileSDPath, err)
+	}
+}
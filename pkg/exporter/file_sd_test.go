@@ -0,0 +1,63 @@
+package exporter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteFileSD(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script_exporter.json")
+	e := New(writeConfig(t, `
+scripts:
+  - name: quick
+    script: exit 0
+    timeout: 5
+  - name: slow
+    script: exit 0
+    timeout: 90
+  - name: scheduled
+    script: exit 0
+    interval: 60
+  - name: upload
+    script: cat
+    accept_body: true
+modules:
+  - name: ping
+    script: ping -c 1 $TARGET
+    timeout: 5
+    targets: [mlab1.lga03.measurement-lab.org, mlab2.lga03.measurement-lab.org]
+`))
+	e.LinkPrefix = "/exporter"
+	e.MetricsPath = "/metrics"
+	e.FileSDPath, e.FileSDAddress, e.FileSDScheme = path, "exporter:9172", "https"
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected the file_sd document to be written: %s", err)
+	}
+	var groups []targetGroup
+	if err := json.Unmarshal(data, &groups); err != nil {
+		t.Fatalf("Expected valid JSON, got %s:\n%s", err, data)
+	}
+
+	probe := func(labels map[string]string) map[string]string {
+		labels["__scheme__"], labels["__metrics_path__"] = "https", "/exporter/probe"
+		return labels
+	}
+	expected := []targetGroup{
+		{[]string{"exporter:9172"}, map[string]string{"__scheme__": "https", "__metrics_path__": "/exporter/metrics"}},
+		{[]string{"exporter:9172"}, probe(map[string]string{"__param_name": "quick"})},
+		{[]string{"exporter:9172"}, probe(map[string]string{"__param_name": "slow", "__scrape_timeout__": "91s", "__scrape_interval__": "91s"})},
+		{[]string{"exporter:9172"}, probe(map[string]string{"__param_module": "ping", "__param_target": "mlab1.lga03.measurement-lab.org", "instance": "mlab1.lga03.measurement-lab.org"})},
+		{[]string{"exporter:9172"}, probe(map[string]string{"__param_module": "ping", "__param_target": "mlab2.lga03.measurement-lab.org", "instance": "mlab2.lga03.measurement-lab.org"})},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, groups)
+	}
+}
@@ -0,0 +1,63 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// The formats config files may be written in.
+const (
+	formatYAML = "yaml"
+	formatJSON = "json"
+	formatTOML = "toml"
+)
+
+// ConfigFormats lists the formats config files may be written in.
+var ConfigFormats = []string{formatYAML, formatJSON, formatTOML}
+
+// configFormat returns the format of the config file at path, which is format
+// if set or else told by the extension of path.
+func configFormat(path, format string) string {
+	if format != "" {
+		return format
+	}
+	if isRemote(path) {
+		path = remotePath(path)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return formatJSON
+	case ".toml":
+		return formatTOML
+	}
+	return formatYAML
+}
+
+// convertConfig converts the config file at path with content data to YAML,
+// so that JSON and TOML files decode into the same Config as YAML files, keys
+// named as in YAML.
+func convertConfig(path, format string, data []byte) ([]byte, error) {
+	var config map[string]interface{}
+
+	switch configFormat(path, format) {
+	case formatYAML:
+		return data, nil
+	case formatJSON:
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, err
+		}
+	case formatTOML:
+		if err := toml.Unmarshal(data, &config); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown config format %q", format)
+	}
+
+	return yaml.Marshal(config)
+}
@@ -0,0 +1,49 @@
+package exporter
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.json": `{
+	"scripts": [
+		{"name": "json", "script": "exit 0", "timeout": 5, "env": {"SERVER": "ndt.example.org"}}
+	],
+	"include": "conf.d/*.toml"
+}`,
+		"conf.d/a.toml": `
+[[scripts]]
+name = "toml"
+script = "exit 1"
+timeout = 7
+
+[scripts.labels]
+team = "measurement"
+`,
+		"yaml.conf": "scripts:\n  - name: forced\n    script: exit 0\n",
+	})
+
+	config, err := LoadConfig(filepath.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	if len(config.Scripts) != 2 {
+		t.Fatalf("Expected 2 scripts, got %d", len(config.Scripts))
+	}
+	if s := config.Scripts[0]; s.Name != "json" || s.Timeout != 5 || s.Env["SERVER"] != "ndt.example.org" {
+		t.Errorf("Unexpected JSON script: %+v", s)
+	}
+	if s := config.Scripts[1]; s.Name != "toml" || s.Timeout != 7 || s.Labels["team"] != "measurement" {
+		t.Errorf("Unexpected TOML script: %+v", s)
+	}
+
+	if _, err := loadConfig([]string{filepath.Join(dir, "yaml.conf")}, loadOptions{format: formatJSON}); err == nil {
+		t.Errorf("Expected YAML not to parse as JSON")
+	}
+	if _, err := loadConfig([]string{filepath.Join(dir, "yaml.conf")}, loadOptions{format: formatYAML}); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
@@ -0,0 +1,245 @@
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/m-lab/script_exporter/pkg/exporter/pb"
+)
+
+// grpcServer serves the gRPC API of an Exporter.
+type grpcServer struct {
+	pb.UnimplementedScriptExporterServer
+
+	exporter *Exporter
+}
+
+// RegisterGRPC registers the ScriptExporter gRPC service on server.
+func (e *Exporter) RegisterGRPC(server *grpc.Server) {
+	pb.RegisterScriptExporterServer(server, &grpcServer{exporter: e})
+}
+
+// probeContext admits the call with the probe_auth and client rate limit of
+// config as an HTTP probe with the same metadata would be.
+func (s *grpcServer) probeContext(ctx context.Context, config *Config, req *pb.RunScriptRequest) (context.Context, url.Values, error) {
+	r := &http.Request{Method: http.MethodGet, Header: make(http.Header)}
+	r = r.WithContext(ctx)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for name, values := range md {
+			for _, value := range values {
+				r.Header.Add(name, value)
+			}
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &info.State
+		}
+	}
+
+	ctx, probeErr := s.exporter.probeContext(r, config)
+	if probeErr != nil {
+		return nil, nil, grpcError(probeErr)
+	}
+	if req.Stdin != nil {
+		ctx = WithStdin(ctx, req.Stdin)
+	}
+
+	params := url.Values{}
+	for name, value := range map[string]string{"name": req.Name, "pattern": req.Pattern, "module": req.Module, "target": req.Target} {
+		if value != "" {
+			params.Set(name, value)
+		}
+	}
+	if req.MaxWait != nil {
+		params.Set("max_wait", formatSample(req.MaxWait.AsDuration().Seconds()))
+	}
+	return ctx, params, nil
+}
+
+func (s *grpcServer) RunScript(ctx context.Context, req *pb.RunScriptRequest) (*pb.RunScriptResponse, error) {
+	config := s.exporter.Config()
+	ctx, params, err := s.probeContext(ctx, config, req)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &pb.RunScriptResponse{}
+	err = s.exporter.ProbeQuery(ctx, config, params, func(measurement *Measurement) {
+		response.Measurements = append(response.Measurements, measurementProto(measurement))
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return response, nil
+}
+
+func (s *grpcServer) StreamOutput(req *pb.RunScriptRequest, stream pb.ScriptExporter_StreamOutputServer) error {
+	config := s.exporter.Config()
+	ctx, params, err := s.probeContext(stream.Context(), config, req)
+	if err != nil {
+		return err
+	}
+
+	// Sending is not safe for concurrent use, and output is written
+	// concurrently by the scripts.
+	events := make(chan *pb.StreamOutputResponse)
+	sent := make(chan error, 1)
+	go func() {
+		var err error
+		for event := range events {
+			if err == nil {
+				err = stream.Send(event)
+			}
+		}
+		sent <- err
+	}()
+
+	ctx = WithStreamListener(ctx, func(event string, data StreamEvent) {
+		events <- &pb.StreamOutputResponse{
+			Event:   event,
+			Script:  data.Script,
+			Target:  data.Target,
+			Attempt: int32(data.Attempt),
+			Stream:  data.Stream,
+			Line:    data.Line,
+		}
+	})
+	err = s.exporter.ProbeQuery(ctx, config, params, func(measurement *Measurement) {
+		events <- &pb.StreamOutputResponse{
+			Event:       finishedEvent(measurement),
+			Script:      measurement.Script.Name,
+			Target:      measurement.Target,
+			Attempt:     int32(measurement.Attempts),
+			Measurement: measurementProto(measurement),
+		}
+	})
+	close(events)
+
+	if sendErr := <-sent; sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		return grpcError(err)
+	}
+	return nil
+}
+
+func (s *grpcServer) ListScripts(ctx context.Context, req *pb.ListScriptsRequest) (*pb.ListScriptsResponse, error) {
+	config := s.exporter.Config()
+	if _, _, err := s.probeContext(ctx, config, &pb.RunScriptRequest{}); err != nil {
+		return nil, err
+	}
+
+	response := &pb.ListScriptsResponse{}
+	for _, script := range config.allScripts() {
+		response.Scripts = append(response.Scripts, &pb.ScriptInfo{
+			Name:    script.Name,
+			Module:  config.module(script.Name) != nil,
+			Labels:  script.Labels,
+			Timeout: durationpb.New(time.Duration(script.Timeout) * time.Second),
+			Runner:  script.Runner,
+		})
+	}
+	return response, nil
+}
+
+// measurementProto converts measurement to its protobuf message.
+func measurementProto(measurement *Measurement) *pb.Measurement {
+	result := NewProbeResult(measurement)
+	message := &pb.Measurement{
+		Script:          result.Script,
+		Target:          result.Target,
+		Labels:          result.Labels,
+		Start:           timestamppb.New(result.Start),
+		Duration:        durationpb.New(time.Duration(result.Duration * float64(time.Second))),
+		Success:         result.Success,
+		ExitCode:        int32(result.ExitCode),
+		Attempts:        int32(result.Attempts),
+		ErrorReason:     result.ErrorReason,
+		CircuitOpen:     result.CircuitOpen,
+		Cancelled:       result.Cancelled,
+		Output:          result.Output,
+		OutputBytes:     result.OutputBytes,
+		OutputTruncated: result.OutputTruncated,
+	}
+
+	for _, family := range measurement.Metrics {
+		out := &pb.MetricFamily{
+			Name: family.GetName(),
+			Help: family.GetHelp(),
+			Type: strings.ToLower(family.GetType().String()),
+		}
+		for _, metric := range family.Metric {
+			sample := &pb.Sample{}
+			if len(metric.Label) > 0 {
+				sample.Labels = make(map[string]string, len(metric.Label))
+				for _, label := range metric.Label {
+					sample.Labels[label.GetName()] = label.GetValue()
+				}
+			}
+			switch {
+			case metric.Counter != nil:
+				sample.Value = metric.Counter.GetValue()
+			case metric.Gauge != nil:
+				sample.Value = metric.Gauge.GetValue()
+			case metric.Untyped != nil:
+				sample.Value = metric.Untyped.GetValue()
+			case metric.Histogram != nil:
+				sample.Count, sample.Sum = metric.Histogram.GetSampleCount(), metric.Histogram.GetSampleSum()
+				sample.Buckets = make(map[string]uint64, len(metric.Histogram.Bucket))
+				for _, bucket := range metric.Histogram.Bucket {
+					sample.Buckets[formatSample(bucket.GetUpperBound())] = bucket.GetCumulativeCount()
+				}
+			case metric.Summary != nil:
+				sample.Count, sample.Sum = metric.Summary.GetSampleCount(), metric.Summary.GetSampleSum()
+				sample.Quantiles = make(map[string]float64, len(metric.Summary.Quantile))
+				for _, quantile := range metric.Summary.Quantile {
+					sample.Quantiles[formatSample(quantile.GetQuantile())] = quantile.GetValue()
+				}
+			}
+			out.Samples = append(out.Samples, sample)
+		}
+		message.Metrics = append(message.Metrics, out)
+	}
+	return message
+}
+
+// grpcError converts a ProbeError to the status of a call failing the same
+// way, and returns other errors as they are.
+func grpcError(err error) error {
+	probeErr, ok := err.(*ProbeError)
+	if !ok {
+		return err
+	}
+
+	code := codes.Internal
+	switch probeErr.Status {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusRequestEntityTooLarge:
+		code = codes.InvalidArgument
+	}
+	return status.Error(code, probeErr.Message)
+}
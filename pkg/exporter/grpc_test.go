@@ -0,0 +1,122 @@
+package exporter
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/m-lab/script_exporter/pkg/exporter/pb"
+)
+
+func TestGRPC(t *testing.T) {
+	e := New(writeConfig(t, `
+probe_auth:
+  bearer_tokens: [s3cret]
+scripts:
+  - name: hello
+    output_metrics: true
+    labels: {team: ndt}
+    script: |
+      echo 'hello_total 3'
+      echo oops >&2
+  - name: failure
+    script: exit 2
+modules:
+  - name: ping
+    script: echo "ping $TARGET"
+`))
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	server := grpc.NewServer()
+	e.RegisterGRPC(server)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	defer conn.Close()
+	client := pb.NewScriptExporterClient(conn)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer s3cret")
+
+	if _, err := client.ListScripts(context.Background(), &pb.ListScriptsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Expected an unauthenticated call to be rejected, got %v", err)
+	}
+
+	list, err := client.ListScripts(ctx, &pb.ListScriptsRequest{})
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	if len(list.Scripts) != 3 {
+		t.Fatalf("Expected 3 scripts, got %v", list.Scripts)
+	}
+	for _, script := range list.Scripts {
+		if script.Module != (script.Name == "ping") {
+			t.Fatalf("Unexpected module flag of %s", script.Name)
+		}
+	}
+
+	response, err := client.RunScript(ctx, &pb.RunScriptRequest{Pattern: "hello|failure"})
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	if len(response.Measurements) != 2 {
+		t.Fatalf("Expected 2 measurements, got %v", response.Measurements)
+	}
+	for _, measurement := range response.Measurements {
+		switch measurement.Script {
+		case "hello":
+			if !measurement.Success || measurement.Labels["team"] != "ndt" {
+				t.Fatalf("Unexpected measurement %v", measurement)
+			}
+			if len(measurement.Metrics) != 1 || measurement.Metrics[0].Name != "hello_total" || measurement.Metrics[0].Samples[0].Value != 3 {
+				t.Fatalf("Unexpected metrics %v", measurement.Metrics)
+			}
+		case "failure":
+			if measurement.Success || measurement.ExitCode != 2 {
+				t.Fatalf("Unexpected measurement %v", measurement)
+			}
+		}
+	}
+
+	if _, err := client.RunScript(ctx, &pb.RunScriptRequest{Module: "missing", Target: "x"}); status.Code(err) == codes.OK {
+		t.Fatalf("Expected an unknown module to fail")
+	}
+
+	stream, err := client.StreamOutput(ctx, &pb.RunScriptRequest{Module: "ping", Target: "mlab1.lga03"})
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	var events []string
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			if status.Code(err) != codes.OK && !strings.Contains(err.Error(), "EOF") {
+				t.Fatalf("Unexpected: %s", err)
+			}
+			break
+		}
+		events = append(events, event.Event+" "+event.Line)
+		if event.Event == streamFinished && event.Measurement.Output != "ping mlab1.lga03\n" {
+			t.Fatalf("Unexpected output %q", event.Measurement.Output)
+		}
+	}
+	expected := []string{"started ", "output ping mlab1.lga03", "finished "}
+	if strings.Join(events, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected events %q, got %q", expected, events)
+	}
+}
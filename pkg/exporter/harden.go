@@ -0,0 +1,118 @@
+package exporter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+)
+
+// hardenedInitArg is the argv[0] the exporter re-executes itself with to apply
+// the hardening and sandbox of a script before executing the shell. They are
+// passed in hardeningEnv.
+const (
+	hardenedInitArg = "script_exporter-hardened-init"
+	hardeningEnv    = "SCRIPT_EXPORTER_HARDENING"
+)
+
+// Hardening restricts what the process running a script with the shell runner
+// may do on Linux.
+type Hardening struct {
+	// NoNewPrivileges sets PR_SET_NO_NEW_PRIVS, so that the script cannot gain
+	// privileges by executing setuid binaries or binaries with file
+	// capabilities.
+	NoNewPrivileges bool `yaml:"no_new_privileges,omitempty" json:"no_new_privileges,omitempty"`
+
+	// DropCapabilities drops all capabilities except KeepCapabilities, named
+	// as in capabilities(7) with or without the CAP_ prefix, e.g. net_raw
+	// for ping.
+	DropCapabilities bool     `yaml:"drop_capabilities,omitempty" json:"drop_capabilities,omitempty"`
+	KeepCapabilities []string `yaml:"keep_capabilities,omitempty" json:"keep_capabilities,omitempty"`
+
+	// SeccompProfile is a file holding a compiled seccomp BPF filter, such as
+	// written by libseccomp's seccomp_export_bpf, that is installed before
+	// the shell is executed. Unless the exporter runs with CAP_SYS_ADMIN it
+	// requires NoNewPrivileges.
+	SeccompProfile string `yaml:"seccomp_profile,omitempty" json:"seccomp_profile,omitempty"`
+}
+
+func (h *Hardening) compile() error {
+	if !isolationSupported {
+		return errors.New("not supported on this platform")
+	}
+
+	for _, name := range h.KeepCapabilities {
+		if _, ok := capabilityNumber(name); !ok {
+			return fmt.Errorf("unknown capability %q", name)
+		}
+	}
+	if len(h.KeepCapabilities) > 0 && !h.DropCapabilities {
+		return errors.New("keep_capabilities requires drop_capabilities")
+	}
+
+	if h.SeccompProfile != "" {
+		filter, err := ioutil.ReadFile(h.SeccompProfile)
+		if err != nil {
+			return err
+		}
+		if len(filter) == 0 || len(filter)%8 != 0 {
+			return fmt.Errorf("%s is not a BPF program", h.SeccompProfile)
+		}
+	}
+
+	return nil
+}
+
+// isolation is the hardening and sandbox of a script, passed to the
+// re-executed exporter in hardeningEnv.
+type isolation struct {
+	Hardening *Hardening `json:"hardening,omitempty"`
+	Sandbox   *Sandbox   `json:"sandbox,omitempty"`
+}
+
+// isolate makes cmd re-execute the exporter to apply the hardening and sandbox
+// of script before executing the original command, if it has either.
+func isolate(cmd *exec.Cmd, script *Script) error {
+	if script.Hardening == nil && script.Sandbox == nil {
+		return nil
+	}
+
+	encoded, err := json.Marshal(isolation{script.Hardening, script.Sandbox})
+	if err != nil {
+		return err
+	}
+
+	if script.Sandbox != nil {
+		newMountNamespace(cmd)
+	}
+
+	cmd.Args = append([]string{hardenedInitArg, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = selfExecutable
+	cmd.Env = append(cmd.Env, hardeningEnv+"="+string(encoded))
+	return nil
+}
+
+// capabilityNumber returns the number of the capability with the given name.
+func capabilityNumber(name string) (int, bool) {
+	name = strings.TrimPrefix(strings.ToLower(name), "cap_")
+	for number, capability := range capabilityNames {
+		if capability == name {
+			return number, true
+		}
+	}
+	return 0, false
+}
+
+// capabilityNames are the names of the Linux capabilities by number.
+var capabilityNames = []string{
+	"chown", "dac_override", "dac_read_search", "fowner", "fsetid", "kill",
+	"setgid", "setuid", "setpcap", "linux_immutable", "net_bind_service",
+	"net_broadcast", "net_admin", "net_raw", "ipc_lock", "ipc_owner",
+	"sys_module", "sys_rawio", "sys_chroot", "sys_ptrace", "sys_pacct",
+	"sys_admin", "sys_boot", "sys_nice", "sys_resource", "sys_time",
+	"sys_tty_config", "mknod", "lease", "audit_write", "audit_control",
+	"setfcap", "mac_override", "mac_admin", "syslog", "wake_alarm",
+	"block_suspend", "audit_read", "perfmon", "bpf", "checkpoint_restore",
+}
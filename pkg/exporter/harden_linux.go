@@ -0,0 +1,147 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const isolationSupported = true
+
+// selfExecutable re-executes the running binary, whatever its path.
+const selfExecutable = "/proc/self/exe"
+
+func init() {
+	if len(os.Args) > 1 && os.Args[0] == hardenedInitArg {
+		hardenedInit(os.Args[1:])
+	}
+}
+
+// newMountNamespace makes cmd start in a new mount namespace.
+func newMountNamespace(cmd *exec.Cmd) {
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNS
+}
+
+// hardenedInit applies the sandbox and hardening in hardeningEnv and executes
+// args, never returning. As prctl and capset affect only the calling thread,
+// they are applied and the command executed on a single locked thread.
+func hardenedInit(args []string) {
+	runtime.LockOSThread()
+
+	var setup isolation
+	err := json.Unmarshal([]byte(os.Getenv(hardeningEnv)), &setup)
+	if err == nil && setup.Sandbox != nil {
+		err = setup.Sandbox.enter()
+	}
+	if err == nil && setup.Hardening != nil {
+		err = setup.Hardening.apply()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "script_exporter: isolating script failed: %s\n", err)
+		os.Exit(126)
+	}
+	os.Unsetenv(hardeningEnv)
+
+	path, err := exec.LookPath(args[0])
+	if err == nil {
+		err = syscall.Exec(path, args, os.Environ())
+	}
+	fmt.Fprintf(os.Stderr, "script_exporter: executing %s failed: %s\n", args[0], err)
+	os.Exit(126)
+}
+
+// apply applies the hardening to the calling thread.
+func (h *Hardening) apply() error {
+	if h.DropCapabilities {
+		if err := dropCapabilities(h.KeepCapabilities); err != nil {
+			return err
+		}
+	}
+
+	if h.NoNewPrivileges {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			return fmt.Errorf("setting no_new_privs: %s", err)
+		}
+	}
+
+	// The filter is installed last, as it may forbid the calls above.
+	if h.SeccompProfile != "" {
+		if err := installSeccompFilter(h.SeccompProfile); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dropCapabilities removes all capabilities but keep from the bounding,
+// ambient, effective, permitted and inheritable sets. The bounding set can
+// only be changed with CAP_SETPCAP, without which the process cannot have
+// gained capabilities other than by executing binaries, which
+// no_new_privileges prevents.
+func dropCapabilities(keep []string) error {
+	var kept [2]uint32
+	for _, name := range keep {
+		number, _ := capabilityNumber(name)
+		kept[number/32] |= 1 << (uint(number) % 32)
+	}
+
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return fmt.Errorf("clearing ambient capabilities: %s", err)
+	}
+
+	for number := 0; number <= unix.CAP_LAST_CAP; number++ {
+		if kept[number/32]&(1<<(uint(number)%32)) != 0 {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(number), 0, 0, 0); err != nil && err != unix.EPERM && err != unix.EINVAL {
+			return fmt.Errorf("dropping %s from the bounding set: %s", capabilityNames[number], err)
+		}
+	}
+
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&header, &data[0]); err != nil {
+		return fmt.Errorf("getting capabilities: %s", err)
+	}
+	for i := range data {
+		data[i].Effective &= kept[i]
+		data[i].Permitted &= kept[i]
+		data[i].Inheritable &= kept[i]
+	}
+	if err := unix.Capset(&header, &data[0]); err != nil {
+		return fmt.Errorf("setting capabilities: %s", err)
+	}
+
+	return nil
+}
+
+// installSeccompFilter installs the BPF program in path as seccomp filter.
+func installSeccompFilter(path string) error {
+	program, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(program) == 0 || len(program)%8 != 0 {
+		return fmt.Errorf("%s is not a BPF program", path)
+	}
+
+	// The file holds struct sock_filter entries in host byte order.
+	filter := unix.SockFprog{
+		Len:    uint16(len(program) / 8),
+		Filter: (*unix.SockFilter)(unsafe.Pointer(&program[0])),
+	}
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&filter)), 0, 0); err != nil {
+		return fmt.Errorf("installing seccomp filter: %s", err)
+	}
+	runtime.KeepAlive(program)
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package exporter
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHardening(t *testing.T) {
+	// A filter of a single BPF_RET instruction returning SECCOMP_RET_ALLOW.
+	profile := filepath.Join(t.TempDir(), "allow.bpf")
+	allow := make([]byte, 8)
+	binary.LittleEndian.PutUint16(allow[0:], 0x06)
+	binary.LittleEndian.PutUint32(allow[4:], 0x7fff0000)
+	if err := ioutil.WriteFile(profile, allow, 0644); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	script := &Script{
+		Name:    "hardened",
+		Content: "grep -E '^(NoNewPrivs|CapEff|CapBnd|Seccomp):' /proc/self/status",
+		Timeout: 5,
+		Hardening: &Hardening{
+			NoNewPrivileges:  true,
+			DropCapabilities: true,
+			KeepCapabilities: []string{"NET_RAW"},
+			SeccompProfile:   profile,
+		},
+	}
+	if err := script.setDefaults(); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	measurement := testExporter.runScripts([]*Script{script}, "")[0]
+
+	if measurement.Success != 1 {
+		t.Fatalf("Expected success, got:\n%s", measurement.Output)
+	}
+
+	expected := []string{"NoNewPrivs:\t1", "Seccomp:\t2"}
+	if os.Geteuid() == 0 {
+		expected = append(expected, "CapEff:\t0000000000002000", "CapBnd:\t0000000000002000")
+	}
+	for _, line := range expected {
+		if !strings.Contains(measurement.Output, line) {
+			t.Errorf("Expected %q in:\n%s", line, measurement.Output)
+		}
+	}
+}
+
+func TestHardeningCompile(t *testing.T) {
+	for _, hardening := range []*Hardening{
+		{DropCapabilities: true, KeepCapabilities: []string{"net_magic"}},
+		{KeepCapabilities: []string{"net_raw"}},
+		{SeccompProfile: "/nonexistent.bpf"},
+	} {
+		if err := hardening.compile(); err == nil {
+			t.Errorf("Expected error for %+v", hardening)
+		}
+	}
+}
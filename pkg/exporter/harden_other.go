@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package exporter
+
+import "os/exec"
+
+const isolationSupported = false
+
+const selfExecutable = ""
+
+func newMountNamespace(cmd *exec.Cmd) {}
@@ -0,0 +1,60 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// StartupCheck probes every script of the active configuration once against
+// target in the background, logging the failures, and returns a channel that
+// is closed once all have completed. Until then /-/ready reports the exporter
+// as not ready.
+func (e *Exporter) StartupCheck(target string) <-chan struct{} {
+	atomic.AddInt32(&e.startupChecks, 1)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer atomic.AddInt32(&e.startupChecks, -1)
+
+		var scripts []*Script
+		for _, script := range e.Config().allScripts() {
+			if !script.daemon() {
+				scripts = append(scripts, script)
+			}
+		}
+		failures := 0
+
+		e.ProbeAll(context.Background(), scripts, target, 0, func(measurement *Measurement) {
+			if measurement.Success == 0 {
+				failures++
+				log.Printf("ERROR: startup check of %s failed with reason %s:\n%s\n", measurement.Script.Name, measurement.ErrorReason, measurement.Output)
+			}
+		})
+
+		log.Printf("Startup check completed: %d of %d scripts failed\n", failures, len(scripts))
+	}()
+
+	return done
+}
+
+// healthyHandler reports that the exporter is running.
+func healthyHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "Healthy.")
+}
+
+// readyHandler reports whether the exporter has loaded a configuration and
+// completed any startup check.
+func (e *Exporter) readyHandler(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case e.Config() == nil:
+		http.Error(w, "No configuration loaded.", 503)
+	case atomic.LoadInt32(&e.startupChecks) > 0:
+		http.Error(w, "Startup check in progress.", 503)
+	default:
+		fmt.Fprintln(w, "Ready.")
+	}
+}
@@ -0,0 +1,42 @@
+package exporter
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStartupCheck(t *testing.T) {
+	e := New(writeConfig(t, `
+scripts:
+  - name: slow
+    script: sleep 0.5
+  - name: broken
+    script: exit 1
+`))
+
+	ready := func() int {
+		w := httptest.NewRecorder()
+		e.readyHandler(w, httptest.NewRequest("GET", "/-/ready", nil))
+		return w.Code
+	}
+
+	if code := ready(); code != 503 {
+		t.Errorf("Expected 503 before the config is loaded, got %d", code)
+	}
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	done := e.StartupCheck("")
+	if code := ready(); code != 503 {
+		t.Errorf("Expected 503 during the startup check, got %d", code)
+	}
+
+	<-done
+	if code := ready(); code != 200 {
+		t.Errorf("Expected 200 after the startup check, got %d", code)
+	}
+	if history := e.Config().Script("broken").history.snapshot(); len(history) != 1 {
+		t.Errorf("Expected one run of each script, got %d", len(history))
+	}
+}
@@ -0,0 +1,113 @@
+package exporter
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// durationHistograms keeps the cumulative run durations of every script for
+// /metrics. Unlike a HistogramVec, each script may have its own buckets.
+type durationHistograms struct {
+	mu         sync.Mutex
+	histograms map[string]*scriptHistogram
+}
+
+type scriptHistogram struct {
+	name      string
+	labels    prometheus.Labels
+	buckets   []float64
+	histogram prometheus.Histogram
+}
+
+var durations = &durationHistograms{histograms: make(map[string]*scriptHistogram)}
+
+func init() {
+	prometheus.MustRegister(durations)
+}
+
+// Describe sends no descriptors, making durations an unchecked collector, as
+// the set of scripts changes with the configuration.
+func (d *durationHistograms) Describe(ch chan<- *prometheus.Desc) {}
+
+func (d *durationHistograms) Collect(ch chan<- prometheus.Metric) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, h := range d.histograms {
+		h.histogram.Collect(ch)
+	}
+}
+
+// observe records a run of script, named with its metric prefix and labeled
+// with its labels. A script whose name, labels or buckets changed with a
+// reload starts over with a new histogram.
+func (d *durationHistograms) observe(script *Script, buckets []float64, seconds float64) {
+	name := script.prefix() + "_duration_seconds_histogram"
+	labels := prometheus.Labels{"script": script.Name}
+	for label, value := range script.Labels {
+		labels[label] = value
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	h := d.histograms[script.Name]
+	if h == nil || h.name != name || !reflect.DeepEqual(h.labels, labels) || !equalBuckets(h.buckets, buckets) {
+		h = &scriptHistogram{
+			name:    name,
+			labels:  labels,
+			buckets: buckets,
+			histogram: prometheus.NewHistogram(prometheus.HistogramOpts{
+				Name:        name,
+				Help:        "Cumulative distribution of the run durations of a script.",
+				ConstLabels: labels,
+				Buckets:     buckets,
+			}),
+		}
+		d.histograms[script.Name] = h
+	}
+
+	h.histogram.Observe(seconds)
+}
+
+// prune drops the histograms of the scripts config no longer has.
+func (d *durationHistograms) prune(config *Config) {
+	names := make(map[string]bool)
+	for _, script := range config.allScripts() {
+		names[script.Name] = true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name := range d.histograms {
+		if !names[name] {
+			delete(d.histograms, name)
+		}
+	}
+}
+
+func equalBuckets(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// validateBuckets checks that buckets are in increasing order, as
+// prometheus.NewHistogram requires.
+func validateBuckets(buckets []float64) error {
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return errors.New("buckets must be in increasing order")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package exporter
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gatherHistogram returns the histogram of the named script in the family
+// name on /metrics, or nil.
+func gatherHistogram(t *testing.T, family, script string) *dto.Metric {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	for _, f := range families {
+		if f.GetName() != family {
+			continue
+		}
+		for _, metric := range f.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "script" && label.GetValue() == script {
+					return metric
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func TestDurationHistogram(t *testing.T) {
+	script := &Script{Name: "histogram", Content: "exit 0", Timeout: 1, DurationBuckets: []float64{0.5, 10}}
+	durations.prune(&Config{})
+
+	testExporter.runScripts([]*Script{script}, "")
+	testExporter.runScripts([]*Script{script}, "")
+
+	metric := gatherHistogram(t, "script_duration_seconds_histogram", "histogram")
+	if metric == nil {
+		t.Fatalf("Expected histogram of script on /metrics")
+	}
+	histogram := metric.GetHistogram()
+	if histogram.GetSampleCount() != 2 {
+		t.Errorf("Expected 2 observations, got %d", histogram.GetSampleCount())
+	}
+	if buckets := histogram.GetBucket(); len(buckets) != 2 || buckets[1].GetUpperBound() != 10 {
+		t.Errorf("Expected the configured buckets, got %v", buckets)
+	}
+
+	// The metric prefix and labels of the script apply, starting over.
+	script.MetricPrefix, script.Labels = "ndt", map[string]string{"site": "lga03"}
+	testExporter.runScripts([]*Script{script}, "")
+	if gatherHistogram(t, "script_duration_seconds_histogram", "histogram") != nil {
+		t.Errorf("Expected the histogram to be renamed")
+	}
+	metric = gatherHistogram(t, "ndt_duration_seconds_histogram", "histogram")
+	if metric == nil || metric.GetHistogram().GetSampleCount() != 1 || len(metric.GetLabel()) != 2 || metric.GetLabel()[0].GetValue() != "histogram" || metric.GetLabel()[1].GetValue() != "lga03" {
+		t.Errorf("Expected the histogram to have the prefix and labels of the script, got %v", metric)
+	}
+
+	// Scripts gone with a reload are pruned.
+	durations.prune(&Config{Scripts: []*Script{{Name: "other"}}})
+	if gatherHistogram(t, "ndt_duration_seconds_histogram", "histogram") != nil {
+		t.Errorf("Expected the histogram of a removed script to be pruned")
+	}
+
+	if err := validateBuckets([]float64{1, 1}); err == nil {
+		t.Errorf("Expected unordered buckets to be rejected")
+	}
+}
@@ -0,0 +1,220 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Output kept per history entry is truncated to this many bytes.
+const historyOutputLimit = 4096
+
+// HistoryEntry records a single execution of a script.
+type HistoryEntry struct {
+	Script    string    `json:"script"`
+	Timestamp time.Time `json:"timestamp"`
+	Target    string    `json:"target"`
+	Duration  float64   `json:"duration_seconds"`
+	Success   int       `json:"success"`
+	ExitCode  int       `json:"exit_code"`
+	Output    string    `json:"output"`
+
+	ErrorReason string `json:"error_reason,omitempty"`
+}
+
+// historyRing keeps the most recent executions of a script, bounded by
+// Exporter.HistorySize.
+type historyRing struct {
+	mu      sync.Mutex
+	entries []HistoryEntry
+	next    int
+}
+
+// newHistoryEntry returns the history entry of measurement, its output
+// truncated to historyOutputLimit.
+func newHistoryEntry(measurement *Measurement) HistoryEntry {
+	output := measurement.Output
+	if len(output) > historyOutputLimit {
+		output = output[:historyOutputLimit] + "\n[truncated]"
+	}
+
+	return HistoryEntry{
+		Script:      measurement.Script.Name,
+		Timestamp:   measurement.Start,
+		Target:      measurement.Target,
+		Duration:    measurement.Duration,
+		Success:     measurement.Success,
+		ExitCode:    measurement.ExitCode,
+		Output:      output,
+		ErrorReason: measurement.ErrorReason,
+	}
+}
+
+func (h *historyRing) record(measurement *Measurement, size int) {
+	if size <= 0 {
+		return
+	}
+
+	entry := newHistoryEntry(measurement)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.entries) < size {
+		h.entries = append(h.entries, entry)
+		return
+	}
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % len(h.entries)
+}
+
+// recent returns the entries oldest first. The caller must hold h.mu.
+func (h *historyRing) recent() []HistoryEntry {
+	return append(append([]HistoryEntry{}, h.entries[h.next:]...), h.entries[:h.next]...)
+}
+
+// snapshot returns the recorded entries, newest first.
+func (h *historyRing) snapshot() []HistoryEntry {
+	h.mu.Lock()
+	entries := h.recent()
+	h.mu.Unlock()
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+// history returns the recorded executions of all scripts and modules, or only
+// of the one named, newest first per script.
+func (c *Config) history(name string) []HistoryEntry {
+	entries := make([]HistoryEntry, 0)
+
+	for _, script := range c.allScripts() {
+		if name == "" || script.Name == name {
+			entries = append(entries, script.history.snapshot()...)
+		}
+	}
+
+	return entries
+}
+
+var historyTemplate = template.Must(template.New("history").Parse(`<html>
+	<head><title>Script Exporter History</title></head>
+	<body>
+	<h1>Recent Executions</h1>
+	<table border="1" cellpadding="4">
+	<tr><th>Script</th><th>Time</th><th>Target</th><th>Duration</th><th>Success</th><th>Exit Code</th><th>Output</th></tr>
+	{{range .}}<tr>
+	<td>{{.Script}}</td>
+	<td>{{.Timestamp.Format "2006-01-02T15:04:05Z07:00"}}</td>
+	<td>{{.Target}}</td>
+	<td>{{printf "%.3fs" .Duration}}</td>
+	<td>{{.Success}}</td>
+	<td>{{.ExitCode}}</td>
+	<td><pre>{{.Output}}</pre></td>
+	</tr>{{end}}
+	</table>
+	</body>
+	</html>`))
+
+// historyHandler renders the recent executions as an HTML page.
+func historyHandler(w http.ResponseWriter, r *http.Request, config *Config) {
+	if err := historyTemplate.Execute(w, config.history(r.URL.Query().Get("script"))); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+// apiHistoryHandler serves the recent executions, or those recorded in the
+// history database if any, as JSON or, with format=csv, as CSV. The since and
+// until parameters, RFC 3339 times or Unix timestamps, and limit select the
+// executions.
+func (e *Exporter) apiHistoryHandler(w http.ResponseWriter, r *http.Request, config *Config) {
+	params := r.URL.Query()
+	q := historyQuery{script: params.Get("script"), limit: defaultHistoryLimit}
+
+	var err error
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{{"since", &q.since}, {"until", &q.until}} {
+		if v := params.Get(param.name); v != "" {
+			if *param.value, err = parseTime(v); err != nil {
+				writeAPIError(w, &apiError{http.StatusBadRequest, "bad_data", fmt.Sprintf("invalid %s parameter", param.name)})
+				return
+			}
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if q.limit, err = strconv.Atoi(v); err != nil || q.limit <= 0 {
+			writeAPIError(w, &apiError{http.StatusBadRequest, "bad_data", "invalid limit parameter"})
+			return
+		}
+	}
+
+	var entries []HistoryEntry
+	if e.historyDB != nil {
+		if entries, err = e.historyDB.query(q); err != nil {
+			writeAPIError(w, &apiError{http.StatusInternalServerError, "internal", err.Error()})
+			return
+		}
+	} else {
+		entries = q.filter(config.history(q.script))
+	}
+
+	if params.Get("format") == "csv" {
+		writeHistoryCSV(w, entries)
+		return
+	}
+	writeAPIData(w, http.StatusOK, entries)
+}
+
+// defaultHistoryLimit bounds the executions served by /api/v1/history
+// without a limit parameter.
+const defaultHistoryLimit = 1000
+
+// filter returns the entries selected by the times and limit of q.
+func (q historyQuery) filter(entries []HistoryEntry) []HistoryEntry {
+	filtered := make([]HistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Timestamp.Before(q.since) || (!q.until.IsZero() && entry.Timestamp.After(q.until)) {
+			continue
+		}
+		if len(filtered) == q.limit {
+			break
+		}
+		filtered = append(filtered, entry)
+	}
+	return filtered
+}
+
+// parseTime parses an RFC 3339 time or a Unix timestamp in seconds.
+func parseTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+func writeHistoryCSV(w http.ResponseWriter, entries []HistoryEntry) {
+	w.Header().Set("Content-Type", "text/csv")
+	out := csv.NewWriter(w)
+	out.Write([]string{"script", "timestamp", "target", "duration_seconds", "success", "exit_code", "error_reason", "output"})
+	for _, entry := range entries {
+		out.Write([]string{
+			entry.Script,
+			entry.Timestamp.Format(time.RFC3339Nano),
+			entry.Target,
+			formatSample(entry.Duration),
+			strconv.Itoa(entry.Success),
+			strconv.Itoa(entry.ExitCode),
+			entry.ErrorReason,
+			entry.Output,
+		})
+	}
+	out.Flush()
+}
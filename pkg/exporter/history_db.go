@@ -0,0 +1,113 @@
+package exporter
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	// Registers the pure Go "sqlite" driver, keeping the exporter free of
+	// cgo.
+	_ "modernc.org/sqlite"
+)
+
+// historyPruneInterval is how often executions older than the retention of
+// the history database are deleted.
+const historyPruneInterval = time.Hour
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS executions (
+	script       TEXT NOT NULL,
+	target       TEXT NOT NULL,
+	start        INTEGER NOT NULL,
+	duration     REAL NOT NULL,
+	success      INTEGER NOT NULL,
+	exit_code    INTEGER NOT NULL,
+	error_reason TEXT NOT NULL,
+	output       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS executions_script_start ON executions (script, start);
+CREATE INDEX IF NOT EXISTS executions_start ON executions (start);
+`
+
+// historyQuery selects the executions recorded in a history database.
+type historyQuery struct {
+	script       string
+	since, until time.Time
+	limit        int
+}
+
+// historyDB records every execution of every script in a SQLite database,
+// keeping them for retention.
+type historyDB struct {
+	db        *sql.DB
+	retention time.Duration
+}
+
+// OpenHistoryDatabase records every execution of every script in the SQLite
+// database at path, deleting those older than retention unless it is zero,
+// and serves /api/v1/history from it.
+func (e *Exporter) OpenHistoryDatabase(path string, retention time.Duration) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	// SQLite allows a single writer.
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec(historySchema); err != nil {
+		db.Close()
+		return err
+	}
+
+	store := &historyDB{db: db, retention: retention}
+	if retention > 0 {
+		go func() {
+			for ; ; time.Sleep(historyPruneInterval) {
+				if err := store.prune(time.Now()); err != nil {
+					log.Printf("ERROR: pruning the history database failed: %s\n", err)
+				}
+			}
+		}()
+	}
+	e.historyDB = store
+	return nil
+}
+
+func (h *historyDB) record(entry HistoryEntry) error {
+	_, err := h.db.Exec(`INSERT INTO executions VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Script, entry.Target, entry.Timestamp.UnixNano(), entry.Duration, entry.Success, entry.ExitCode, entry.ErrorReason, entry.Output)
+	return err
+}
+
+// prune deletes the executions started before the retention up to now.
+func (h *historyDB) prune(now time.Time) error {
+	_, err := h.db.Exec(`DELETE FROM executions WHERE start < ?`, now.Add(-h.retention).UnixNano())
+	return err
+}
+
+// query returns the executions matching q, newest first.
+func (h *historyDB) query(q historyQuery) ([]HistoryEntry, error) {
+	until := q.until
+	if until.IsZero() {
+		until = time.Unix(0, 1<<63-1)
+	}
+	rows, err := h.db.Query(`SELECT script, target, start, duration, success, exit_code, error_reason, output
+		FROM executions WHERE (? = '' OR script = ?) AND start >= ? AND start <= ?
+		ORDER BY start DESC LIMIT ?`,
+		q.script, q.script, q.since.UnixNano(), until.UnixNano(), q.limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]HistoryEntry, 0)
+	for rows.Next() {
+		var entry HistoryEntry
+		var start int64
+		if err = rows.Scan(&entry.Script, &entry.Target, &start, &entry.Duration, &entry.Success, &entry.ExitCode, &entry.ErrorReason, &entry.Output); err != nil {
+			return nil, err
+		}
+		entry.Timestamp = time.Unix(0, start)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,65 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHistoryDatabase(t *testing.T) {
+	e := New("")
+	if err := e.OpenHistoryDatabase(filepath.Join(t.TempDir(), "history.db"), time.Hour); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	config := &Config{Scripts: []*Script{
+		{Name: "up", Content: "echo up", Timeout: 1},
+		{Name: "down", Content: "exit 3", Timeout: 1},
+	}}
+	e.runScripts(config.Scripts, "")
+	middle := time.Now()
+	e.runScripts(config.Scripts[:1], "")
+
+	query := func(params string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		e.apiHistoryHandler(w, httptest.NewRequest("GET", "/api/v1/history?"+params, nil), config)
+		return w
+	}
+
+	var response struct{ Data []HistoryEntry }
+	json.Unmarshal(query("script=up").Body.Bytes(), &response)
+	if len(response.Data) != 2 || response.Data[0].Script != "up" || !response.Data[0].Timestamp.After(response.Data[1].Timestamp) {
+		t.Errorf("Expected both runs of up, newest first: %+v", response.Data)
+	}
+
+	response.Data = nil
+	json.Unmarshal(query("since="+strconv.FormatInt(middle.Unix(), 10)+"&limit=1").Body.Bytes(), &response)
+	if len(response.Data) != 1 || response.Data[0].Script != "up" {
+		t.Errorf("Expected the last run only: %+v", response.Data)
+	}
+
+	records, err := csv.NewReader(query("script=down&format=csv").Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	if len(records) != 2 || records[0][0] != "script" || records[1][0] != "down" || records[1][5] != "3" || records[1][6] != reasonNonzeroExit {
+		t.Errorf("Unexpected CSV: %v", records)
+	}
+
+	if w := query("since=yesterday"); w.Code != 400 {
+		t.Errorf("Expected 400 for an invalid since parameter, got %d", w.Code)
+	}
+
+	if err := e.historyDB.prune(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	response.Data = nil
+	json.Unmarshal(query("").Body.Bytes(), &response)
+	if len(response.Data) != 0 {
+		t.Errorf("Expected executions past the retention to be deleted: %+v", response.Data)
+	}
+}
@@ -0,0 +1,52 @@
+package exporter
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHistoryRing(t *testing.T) {
+	script := &Script{Name: "ring"}
+	ring := &historyRing{}
+	size := 10
+
+	for i := 0; i < size+3; i++ {
+		ring.record(&Measurement{Script: script, Target: fmt.Sprintf("target-%d", i)}, size)
+	}
+
+	entries := ring.snapshot()
+
+	if len(entries) != size {
+		t.Fatalf("Expected %d entries, got %d", size, len(entries))
+	}
+
+	if newest := fmt.Sprintf("target-%d", size+2); entries[0].Target != newest {
+		t.Errorf("Expected newest entry %s first, got %s", newest, entries[0].Target)
+	}
+
+	if oldest := "target-3"; entries[len(entries)-1].Target != oldest {
+		t.Errorf("Expected oldest entry %s last, got %s", oldest, entries[len(entries)-1].Target)
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	script := &Script{Name: "echo", Content: "echo '<hello>'", Timeout: 1}
+	config := &Config{Scripts: []*Script{script}}
+	testExporter.runScripts(config.Scripts, "")
+
+	w := httptest.NewRecorder()
+	historyHandler(w, httptest.NewRequest("GET", "/history", nil), config)
+
+	if !strings.Contains(w.Body.String(), "&lt;hello&gt;") {
+		t.Errorf("Expected escaped script output in history page:\n%s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	testExporter.apiHistoryHandler(w, httptest.NewRequest("GET", "/api/v1/history?script=missing", nil), config)
+
+	if !strings.Contains(w.Body.String(), `"data":[]`) {
+		t.Errorf("Expected no history for unknown script:\n%s", w.Body.String())
+	}
+}
@@ -0,0 +1,171 @@
+package exporter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// includePatterns is the include setting, a glob pattern or a list of them.
+type includePatterns []string
+
+func (p *includePatterns) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var pattern string
+	if err := unmarshal(&pattern); err == nil {
+		*p = includePatterns{pattern}
+		return nil
+	}
+	return unmarshal((*[]string)(p))
+}
+
+// merge adds the scripts and modules of the config file at path, and of the
+// files it includes, to c, applying the defaults of the including files and
+// its own. Settings other than scripts and modules may only be given by one of
+// the files.
+func (c *Config) merge(path string, options loadOptions, sum hash.Hash, defaults []yaml.MapSlice) error {
+	// Relative paths in remote files are resolved against the working
+	// directory.
+	dir := ""
+	if !isRemote(path) {
+		path = filepath.Clean(path)
+		dir = filepath.Dir(path)
+	}
+	for _, merged := range c.configFiles {
+		if merged == path {
+			return fmt.Errorf("%s: included more than once", path)
+		}
+	}
+
+	var data []byte
+	var err error
+	if isRemote(path) {
+		data, err = options.remote.readRemote(path)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	sum.Write(data)
+	fileSum := sha256.Sum256(data)
+	c.sums[path] = hex.EncodeToString(fileSum[:])
+	c.configFiles = append(c.configFiles, path)
+	c.files = append(c.files, path)
+
+	if data, err = convertConfig(path, options.format, data); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	if options.expandEnv {
+		if data, err = expandEnv(data); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	var fragment Config
+	if err = yaml.Unmarshal(data, &fragment); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	if defaults, err = applyDefaults(&fragment, data, defaults); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	for name, set := range map[string][2]bool{
+		"allowed_targets":   {c.AllowedTargets != nil, fragment.AllowedTargets != nil},
+		"denied_targets":    {c.DeniedTargets != nil, fragment.DeniedTargets != nil},
+		"probe_auth":        {c.ProbeAuth != nil, fragment.ProbeAuth != nil},
+		"client_rate_limit": {c.ClientRateLimit != nil, fragment.ClientRateLimit != nil},
+		"scripts_api_auth":  {c.ScriptsAPIAuth != nil, fragment.ScriptsAPIAuth != nil},
+		"vault":             {c.Vault != nil, fragment.Vault != nil},
+	} {
+		if set[0] && set[1] {
+			return fmt.Errorf("%s: %s is already set by another config file", path, name)
+		}
+	}
+	if fragment.AllowedTargets != nil {
+		c.AllowedTargets = fragment.AllowedTargets
+	}
+	if fragment.DeniedTargets != nil {
+		c.DeniedTargets = fragment.DeniedTargets
+	}
+	if fragment.ProbeAuth != nil {
+		c.ProbeAuth = fragment.ProbeAuth
+	}
+	if fragment.ClientRateLimit != nil {
+		c.ClientRateLimit = fragment.ClientRateLimit
+	}
+	if fragment.ScriptsAPIAuth != nil {
+		c.ScriptsAPIAuth = fragment.ScriptsAPIAuth
+	}
+	if fragment.Vault != nil {
+		c.Vault = fragment.Vault
+	}
+	c.Webhooks = append(c.Webhooks, fragment.Webhooks...)
+	for name, snippet := range fragment.Snippets {
+		if _, ok := c.Snippets[name]; ok {
+			return fmt.Errorf("%s: snippet %q is already defined by another config file", path, name)
+		}
+		if c.Snippets == nil {
+			c.Snippets = make(map[string]string)
+		}
+		c.Snippets[name] = snippet
+	}
+	for name, signature := range fragment.SnippetSignatures {
+		if _, ok := c.SnippetSignatures[name]; ok {
+			return fmt.Errorf("%s: signature of snippet %q is already given by another config file", path, name)
+		}
+		if c.SnippetSignatures == nil {
+			c.SnippetSignatures = make(map[string]string)
+		}
+		c.SnippetSignatures[name] = signature
+	}
+	// Any config file may disable patterns for all.
+	if fragment.DisablePatterns {
+		c.DisablePatterns = true
+	}
+
+	for _, script := range fragment.Scripts {
+		script.origin = path
+		if err = c.loadScriptFile(script, dir, options.remote); err != nil {
+			return fmt.Errorf("script %s: %s", script.Name, err)
+		}
+		if err = c.loadWASMModule(script, dir); err != nil {
+			return fmt.Errorf("script %s: %s", script.Name, err)
+		}
+	}
+	for _, module := range fragment.Modules {
+		module.origin = path
+		if err = c.loadScriptFile(&module.Script, dir, options.remote); err != nil {
+			return fmt.Errorf("module %s: %s", module.Name, err)
+		}
+		if err = c.loadWASMModule(&module.Script, dir); err != nil {
+			return fmt.Errorf("module %s: %s", module.Name, err)
+		}
+	}
+	c.Scripts = append(c.Scripts, fragment.Scripts...)
+	c.Modules = append(c.Modules, fragment.Modules...)
+
+	for _, pattern := range fragment.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: include %s: %s", path, pattern, err)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if err := c.merge(match, options, sum, defaults); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package exporter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Unexpected: %s", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Unexpected: %s", err)
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.yml": `
+include: conf.d/*.yml
+scripts:
+  - name: main
+    script: exit 0
+`,
+		"conf.d/a.yml": `
+scripts:
+  - name: a
+    script_file: a.sh
+`,
+		"conf.d/a.sh": "exit 1",
+		"conf.d/b.yml": `
+modules:
+  - name: b
+    script: exit 0
+`,
+		"extra.yml": `
+scripts:
+  - name: extra
+    script: exit 0
+`,
+	})
+
+	config, err := loadConfig([]string{filepath.Join(dir, "config.yml"), filepath.Join(dir, "extra.yml")}, loadOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	var names []string
+	for _, script := range config.allScripts() {
+		names = append(names, script.Name)
+	}
+	if strings.Join(names, " ") != "main a extra b" {
+		t.Errorf("Expected scripts main a extra b, got %s", strings.Join(names, " "))
+	}
+	if config.Scripts[1].Content != "exit 1" {
+		t.Errorf("Expected script_file relative to the included file, got %q", config.Scripts[1].Content)
+	}
+	if len(config.files) != 5 {
+		t.Errorf("Expected 5 watched files, got %v", config.files)
+	}
+
+	t.Run("Conflicts", func(t *testing.T) {
+		for name, files := range map[string]map[string]string{
+			filepath.Join("conf.d", "a.yml") + `: name "main" is already used by script 1 in `: {
+				"config.yml":   "include: [conf.d/*.yml]\nscripts:\n  - name: main\n    script: exit 0\n",
+				"conf.d/a.yml": "scripts:\n  - name: main\n    script: exit 1\n",
+			},
+			"client_rate_limit is already set": {
+				"config.yml":   "include: conf.d/*.yml\nclient_rate_limit:\n  requests: 1\nscripts: []\n",
+				"conf.d/a.yml": "client_rate_limit:\n  requests: 2\n",
+			},
+			"included more than once": {
+				"config.yml":   "include: conf.d/*.yml\nscripts: []\n",
+				"conf.d/a.yml": "include: ../config.yml\n",
+			},
+		} {
+			dir := t.TempDir()
+			writeFiles(t, dir, files)
+
+			_, err := LoadConfig(filepath.Join(dir, "config.yml"))
+			if err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("Expected error %q, got %v", name, err)
+			}
+		}
+	})
+}
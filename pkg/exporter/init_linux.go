@@ -0,0 +1,82 @@
+package exporter
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// forwardedSignals are passed on to the scripts by Init.
+var forwardedSignals = []os.Signal{unix.SIGHUP, unix.SIGUSR1, unix.SIGUSR2}
+
+// Init makes the exporter behave as the init process of a container, which it
+// is meant to be as PID 1: orphans are reaped as by StartReaper, SIGHUP,
+// SIGUSR1 and SIGUSR2 are forwarded to the scripts, and SIGTERM or SIGINT
+// shut the exporter down. Scripts are then paused and the signal is forwarded
+// to those running, which are given grace to exit; shutdown is called to stop
+// the rest of the exporter, and whatever still runs is killed. The returned
+// channel is closed once done. As PID 1 signals go to every process of the
+// container, and otherwise to the process groups of running scripts.
+func (e *Exporter) Init(grace time.Duration, shutdown func()) (<-chan struct{}, error) {
+	if err := StartReaper(context.Background()); err != nil {
+		return nil, err
+	}
+	if os.Getpid() != 1 {
+		log.Printf("Running as init without being PID 1, signals are only passed to scripts\n")
+	}
+
+	forward := make(chan os.Signal, 8)
+	signal.Notify(forward, forwardedSignals...)
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, unix.SIGTERM, unix.SIGINT)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer signal.Stop(forward)
+		defer signal.Stop(terminate)
+
+		var sig os.Signal
+		for sig == nil {
+			select {
+			case s := <-forward:
+				signalScripts(s.(unix.Signal))
+			case sig = <-terminate:
+			}
+		}
+
+		log.Printf("OK: Received %s, shutting down\n", sig)
+		e.Pause()
+		signalScripts(sig.(unix.Signal))
+		deadline := time.Now().Add(grace)
+		for children.count() > 0 && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		shutdown()
+		if n := children.count(); n > 0 {
+			log.Printf("ERROR: Killing %d scripts still running after %s\n", n, grace)
+		}
+		signalScripts(unix.SIGKILL)
+		reapOrphans()
+	}()
+	return done, nil
+}
+
+// signalScripts sends sig to every process of the container when running as
+// PID 1, and else to the process groups of the scripts.
+func signalScripts(sig unix.Signal) {
+	if os.Getpid() == 1 {
+		unix.Kill(-1, sig)
+		return
+	}
+	for _, pid := range children.list() {
+		if err := unix.Kill(-pid, sig); err == unix.ESRCH {
+			unix.Kill(pid, sig)
+		}
+	}
+}
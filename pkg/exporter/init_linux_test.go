@@ -0,0 +1,61 @@
+package exporter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestInit(t *testing.T) {
+	if unix.Getpid() == 1 {
+		t.Skip("Signals would be sent to every process")
+	}
+	e := New("")
+	shutdown := make(chan struct{})
+	done, err := e.Init(5*time.Second, func() { close(shutdown) })
+	if err != nil {
+		t.Skipf("Cannot run as init: %s", err)
+	}
+
+	script := &Script{Name: "traps", Timeout: 10, Content: `
+trap 'echo usr1' USR1
+trap 'echo term; exit 0' TERM
+echo started
+for i in $(seq 100); do sleep 0.1; done
+echo missed`}
+	measurements := make(chan *Measurement, 1)
+	go func() { measurements <- e.runScripts([]*Script{script}, "")[0] }()
+
+	for children.count() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	unix.Kill(unix.Getpid(), unix.SIGUSR1)
+	time.Sleep(300 * time.Millisecond)
+	unix.Kill(unix.Getpid(), unix.SIGTERM)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the exporter to shut down")
+	}
+	select {
+	case <-shutdown:
+	default:
+		t.Error("Expected shutdown to be called")
+	}
+	if !e.Paused() {
+		t.Error("Expected scripts to be paused")
+	}
+
+	m := <-measurements
+	// The shell also reports the sleep of the script having been signalled.
+	if !strings.Contains(m.Output, "usr1\n") || !strings.Contains(m.Output, "term\n") {
+		t.Errorf("Expected the signals to be forwarded to the script, got %q", m.Output)
+	}
+	if strings.Contains(m.Output, "missed") || m.Success != 1 {
+		t.Errorf("Expected the script to exit on SIGTERM, got %+v", m)
+	}
+}
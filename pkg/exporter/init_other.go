@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package exporter
+
+import (
+	"fmt"
+	"time"
+)
+
+// Init is only supported on Linux, where the exporter runs in containers.
+func (e *Exporter) Init(grace time.Duration, shutdown func()) (<-chan struct{}, error) {
+	return nil, fmt.Errorf("running as init is only supported on Linux")
+}
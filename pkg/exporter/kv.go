@@ -0,0 +1,234 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The default agents of config files kept in a key of Consul or etcd.
+const (
+	defaultConsulAddress = "localhost:8500"
+	defaultEtcdAddress   = "localhost:2379"
+)
+
+const (
+	// consulWait is how long a blocking Consul query waits for a change.
+	consulWait = 5 * time.Minute
+
+	// kvRetry is how long watching a key pauses after an error.
+	kvRetry = 10 * time.Second
+)
+
+// isKV tells whether the config file path is a consul:// or etcd:// URL of the
+// key it is kept in.
+func isKV(path string) bool {
+	return strings.HasPrefix(path, "consul://") || strings.HasPrefix(path, "etcd://")
+}
+
+// kvKey splits the consul:// or etcd:// URL u into the base URL of the HTTP
+// API of the agent and the key.
+func kvKey(u *url.URL, defaultAddress string) (string, string) {
+	address := u.Host
+	if address == "" {
+		address = defaultAddress
+	}
+	return "http://" + address, strings.TrimPrefix(u.Path, "/")
+}
+
+// newConsulRequest returns the request for the raw value of the key of the
+// consul:// URL u, blocking until it changes from index unless index is 0. The
+// token is taken from CONSUL_HTTP_TOKEN.
+func newConsulRequest(ctx context.Context, u *url.URL, index uint64) (*http.Request, error) {
+	base, key := kvKey(u, defaultConsulAddress)
+	query := url.Values{"raw": {""}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", consulWait.String())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/v1/kv/"+key+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	return req, nil
+}
+
+// etcdKey is the key of an etcd v3 range or watch request.
+type etcdKey struct {
+	Key []byte `json:"key"`
+}
+
+// newEtcdRequest returns the range request for the key of the etcd:// URL u
+// to the gRPC gateway of etcd v3.
+func newEtcdRequest(ctx context.Context, u *url.URL) (*http.Request, error) {
+	base, key := kvKey(u, defaultEtcdAddress)
+	body, err := json.Marshal(etcdKey{[]byte(key)})
+	if err != nil {
+		return nil, err
+	}
+	return http.NewRequestWithContext(ctx, http.MethodPost, base+"/v3/kv/range", bytes.NewReader(body))
+}
+
+// readEtcdValue decodes the value of the key from the response to a range
+// request.
+func readEtcdValue(data []byte) ([]byte, error) {
+	var response struct {
+		Kvs []struct {
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Kvs) == 0 {
+		return nil, errors.New("key not found")
+	}
+	return response.Kvs[0].Value, nil
+}
+
+// WatchKV reloads the config whenever a config file kept in a Consul or etcd
+// key changes, watching the keys of every config loaded from now on.
+func (e *Exporter) WatchKV() {
+	e.kvMu.Lock()
+	if e.kvWatches == nil {
+		e.kvWatches = make(map[string]bool)
+	}
+	e.kvMu.Unlock()
+
+	e.watchKeys()
+}
+
+// watchKeys starts watching the keys of the active config not yet watched,
+// if WatchKV was called.
+func (e *Exporter) watchKeys() {
+	e.kvMu.Lock()
+	defer e.kvMu.Unlock()
+
+	if e.kvWatches == nil || e.Config() == nil {
+		return
+	}
+	for _, file := range e.Config().configFiles {
+		if isKV(file) && !e.kvWatches[file] {
+			e.kvWatches[file] = true
+			go e.watchKey(file)
+		}
+	}
+}
+
+// watchKey reloads the config whenever the key of the consul:// or etcd://
+// URL rawURL changes, until the active config no longer uses it.
+func (e *Exporter) watchKey(rawURL string) {
+	defer func() {
+		e.kvMu.Lock()
+		delete(e.kvWatches, rawURL)
+		e.kvMu.Unlock()
+	}()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	wait := watchConsulKey
+	if u.Scheme == "etcd" {
+		wait = watchEtcdKey
+	}
+
+	var index uint64
+	for {
+		changed, err := wait(u, &index)
+		if err != nil {
+			log.Printf("ERROR: watching %s: %s\n", rawURL, err)
+			time.Sleep(kvRetry)
+		}
+
+		if _, ok := e.Config().sums[rawURL]; !ok {
+			return
+		}
+		if changed {
+			if err := e.Reload(); err != nil {
+				log.Printf("ERROR: reloading changed config failed, keeping previous configuration: %s\n", err)
+			}
+		}
+	}
+}
+
+// watchConsulKey waits with a blocking query for the key of u to change from
+// the index given, which it updates. The first call only sets the index.
+func watchConsulKey(u *url.URL, index *uint64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), consulWait+remoteTimeout)
+	defer cancel()
+
+	req, err := newConsulRequest(ctx, u, *index)
+	if err != nil {
+		return false, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	next, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("invalid X-Consul-Index: %s", err)
+	}
+	changed := *index != 0 && next != *index
+	*index = next
+	return changed, nil
+}
+
+// watchEtcdKey waits on a watch stream of etcd v3 for the key of u to change.
+func watchEtcdKey(u *url.URL, _ *uint64) (bool, error) {
+	base, key := kvKey(u, defaultEtcdAddress)
+	body, err := json.Marshal(map[string]etcdKey{"create_request": {[]byte(key)}})
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := http.Post(base+"/v3/watch", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	// The stream sends a message confirming the watch, then one per batch
+	// of events.
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var message struct {
+			Result struct {
+				Events []json.RawMessage `json:"events"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			return false, err
+		}
+		if len(message.Result.Events) > 0 {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	return false, errors.New("watch stream closed")
+}
@@ -0,0 +1,96 @@
+package exporter
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKV serves a key like the HTTP APIs of Consul and etcd.
+type fakeKV struct {
+	mu      sync.Mutex
+	index   int
+	value   string
+	changed chan struct{}
+	done    chan struct{}
+}
+
+func (kv *fakeKV) set(value string) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.index++
+	kv.value = value
+	close(kv.changed)
+	kv.changed = make(chan struct{})
+}
+
+func (kv *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	kv.mu.Lock()
+	index, value, changed := kv.index, kv.value, kv.changed
+	kv.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/v1/kv/scripts/config.yml":
+		if r.URL.Query().Get("index") == fmt.Sprint(index) {
+			select {
+			case <-changed:
+			case <-kv.done:
+			}
+			kv.mu.Lock()
+			index, value = kv.index, kv.value
+			kv.mu.Unlock()
+		}
+		w.Header().Set("X-Consul-Index", fmt.Sprint(index))
+		w.Write([]byte(value))
+	case r.URL.Path == "/v3/kv/range":
+		fmt.Fprintf(w, `{"kvs": [{"key": "c2NyaXB0cw==", "value": %q}]}`, base64.StdEncoding.EncodeToString([]byte(value)))
+	case r.URL.Path == "/v3/watch":
+		fmt.Fprintln(w, `{"result": {"created": true}}`)
+		w.(http.Flusher).Flush()
+		select {
+		case <-changed:
+		case <-kv.done:
+			return
+		}
+		fmt.Fprintln(w, `{"result": {"events": [{"type": "PUT"}]}}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestKVConfig(t *testing.T) {
+	for _, scheme := range []string{"consul", "etcd"} {
+		t.Run(scheme, func(t *testing.T) {
+			kv := &fakeKV{index: 1, value: "scripts:\n  - name: first\n    script: exit 0\n", changed: make(chan struct{}), done: make(chan struct{})}
+			server := httptest.NewServer(kv)
+			defer server.Close()
+			defer close(kv.done)
+
+			e := New(scheme + "://" + strings.TrimPrefix(server.URL, "http://") + "/scripts/config.yml")
+			e.RemoteCacheDir = ""
+			if err := e.Reload(); err != nil {
+				t.Fatalf("Unexpected: %s", err)
+			}
+			if name := e.Config().Scripts[0].Name; name != "first" {
+				t.Fatalf("Expected script first, got %s", name)
+			}
+
+			e.WatchKV()
+			time.Sleep(100 * time.Millisecond)
+			kv.set("scripts:\n  - name: second\n    script: exit 0\n")
+
+			deadline := time.Now().Add(5 * time.Second)
+			for e.Config().Scripts[0].Name != "second" {
+				if time.Now().After(deadline) {
+					t.Fatalf("Expected the config to be reloaded")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
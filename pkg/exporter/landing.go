@@ -0,0 +1,58 @@
+package exporter
+
+import (
+	"html/template"
+	"net/http"
+)
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<html>
+	<head><title>Script Exporter</title></head>
+	<body>
+	<h1>Script Exporter</h1>
+	<p><a href="{{.LinkPrefix}}{{.MetricsPath}}">Metrics</a> | <a href="{{$.LinkPrefix}}/history">History</a> | <a href="{{$.LinkPrefix}}/config">Configuration</a></p>
+	{{if .Scripts}}<h2>Scripts</h2>
+	<table border="1" cellpadding="4">
+	<tr><th>Name</th><th>Description</th><th>Timeout</th><th>Labels</th><th>Probe</th><th>Debug</th></tr>
+	{{range .Scripts}}<tr>
+	<td>{{.Name}}</td>
+	<td>{{.Description}}</td>
+	<td>{{.Timeout}}s</td>
+	<td>{{range $name, $value := .Labels}}{{$name}}="{{$value}}" {{end}}</td>
+	<td><a href="{{$.LinkPrefix}}/probe?name={{urlquery .Name}}">probe</a></td>
+	<td><a href="{{$.LinkPrefix}}/history?script={{urlquery .Name}}">history</a></td>
+	</tr>{{end}}
+	</table>{{end}}
+	{{if .Modules}}<h2>Modules</h2>
+	<table border="1" cellpadding="4">
+	<tr><th>Name</th><th>Description</th><th>Timeout</th><th>Labels</th><th>Target Pattern</th><th>Probe</th><th>Debug</th></tr>
+	{{range .Modules}}<tr>
+	<td>{{.Name}}</td>
+	<td>{{.Description}}</td>
+	<td>{{.Timeout}}s</td>
+	<td>{{range $name, $value := .Labels}}{{$name}}="{{$value}}" {{end}}</td>
+	<td>{{.TargetPattern}}</td>
+	<td><a href="{{$.LinkPrefix}}/probe?module={{urlquery .Name}}&amp;target=">probe</a></td>
+	<td><a href="{{$.LinkPrefix}}/history?script={{urlquery .Name}}">history</a></td>
+	</tr>{{end}}
+	</table>{{end}}
+	</body>
+	</html>`))
+
+// landingHandler renders the index page listing the configured scripts and
+// modules with links to probe them.
+func (e *Exporter) landingHandler(w http.ResponseWriter, r *http.Request, config *Config) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := struct {
+		LinkPrefix  string
+		MetricsPath string
+		*Config
+	}{e.LinkPrefix, e.MetricsPath, config}
+
+	if err := landingTemplate.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
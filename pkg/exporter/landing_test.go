@@ -0,0 +1,42 @@
+package exporter
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLandingHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	testExporter.landingHandler(w, httptest.NewRequest("GET", "/", nil), config)
+
+	for _, script := range config.Scripts {
+		if link := `/probe?name=` + script.Name; !strings.Contains(w.Body.String(), link) {
+			t.Errorf("Expected probe link %s on landing page", link)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	testExporter.landingHandler(w, httptest.NewRequest("GET", "/missing", nil), config)
+
+	if w.Code != 404 {
+		t.Errorf("Expected 404 for unknown path, got %d", w.Code)
+	}
+
+	described := &Config{Scripts: []*Script{{Name: "ndt", Description: "Checks the NDT5 TLS handshake."}}}
+	w = httptest.NewRecorder()
+	testExporter.landingHandler(w, httptest.NewRequest("GET", "/", nil), described)
+	if !strings.Contains(w.Body.String(), "<td>Checks the NDT5 TLS handshake.</td>") {
+		t.Errorf("Expected the description on the landing page:\n%s", w.Body.String())
+	}
+
+	e := New("")
+	e.LinkPrefix = "/exporter"
+	w = httptest.NewRecorder()
+	e.landingHandler(w, httptest.NewRequest("GET", "/", nil), config)
+	for _, link := range []string{`href="/exporter/metrics"`, `href="/exporter/history"`, `href="/exporter/probe?name=success"`} {
+		if !strings.Contains(w.Body.String(), link) {
+			t.Errorf("Expected link %s on landing page:\n%s", link, w.Body.String())
+		}
+	}
+}
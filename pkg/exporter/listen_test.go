@@ -0,0 +1,64 @@
+package exporter
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "script_exporter.sock")
+
+	listener, err := Listen("unix://" + socket)
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, address string) (net.Conn, error) { return net.Dial("unix", socket) },
+	}}
+	resp, err := client.Get("http://localhost/")
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	resp.Body.Close()
+
+	if _, err := Listen("unix://" + socket); err == nil {
+		t.Errorf("Expected a socket in use to be refused")
+	}
+
+	// The socket file left behind by a listener that went away is replaced.
+	unixListener := listener.(*net.UnixListener)
+	unixListener.SetUnlinkOnClose(false)
+	listener.Close()
+	if listener, err = Listen("unix://" + socket); err != nil {
+		t.Fatalf("Expected a stale socket to be replaced, got %s", err)
+	}
+	listener.Close()
+
+	if listener, err = Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	if listener.Addr().Network() != "tcp" {
+		t.Errorf("Expected a TCP listener, got %s", listener.Addr().Network())
+	}
+	listener.Close()
+}
+
+func TestSystemdListeners(t *testing.T) {
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+
+	if _, err := SystemdListeners(); err == nil {
+		t.Errorf("Expected the sockets of another process to be ignored")
+	}
+	if os.Getenv("LISTEN_PID") != "" || os.Getenv("LISTEN_FDS") != "" {
+		t.Errorf("Expected the socket activation variables to be unset")
+	}
+}
@@ -0,0 +1,128 @@
+package exporter
+
+import (
+	"sync"
+	"time"
+)
+
+// liveBacklog is the number of recent output lines of a run sent to a client
+// attaching to it.
+const liveBacklog = 100
+
+// liveRuns tracks the runs in flight, so that clients can attach to them and
+// tail their output.
+type liveRuns struct {
+	mu   sync.Mutex
+	runs map[*liveRun]bool
+}
+
+// liveRun is a run in flight, which publishes its output lines to the
+// clients attached to it.
+type liveRun struct {
+	script *Script
+	target string
+	start  time.Time
+
+	mu          sync.Mutex
+	backlog     []StreamEvent
+	subscribers map[chan StreamEvent]bool
+	result      *Measurement
+
+	// done is closed with result set when the run completes.
+	done chan struct{}
+}
+
+// start registers a run of script against target.
+func (l *liveRuns) start(script *Script, target string) *liveRun {
+	run := &liveRun{
+		script:      script,
+		target:      target,
+		start:       time.Now(),
+		subscribers: make(map[chan StreamEvent]bool),
+		done:        make(chan struct{}),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.runs == nil {
+		l.runs = make(map[*liveRun]bool)
+	}
+	l.runs[run] = true
+	return run
+}
+
+// finish unregisters run, which completed with measurement.
+func (l *liveRuns) finish(run *liveRun, measurement *Measurement) {
+	l.mu.Lock()
+	delete(l.runs, run)
+	l.mu.Unlock()
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.result = measurement
+	for subscriber := range run.subscribers {
+		close(subscriber)
+	}
+	run.subscribers = nil
+	close(run.done)
+}
+
+// find returns the run of the script named against target started last, or
+// nil if none is in flight.
+func (l *liveRuns) find(name, target string) *liveRun {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var found *liveRun
+	for run := range l.runs {
+		if run.script.Name == name && run.target == target && (found == nil || run.start.After(found.start)) {
+			found = run
+		}
+	}
+	return found
+}
+
+// publish sends an output line to the attached clients. Clients that do not
+// keep up miss lines rather than stalling the script.
+func (r *liveRun) publish(event StreamEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.backlog = append(r.backlog, event)
+	if len(r.backlog) > liveBacklog {
+		r.backlog = r.backlog[len(r.backlog)-liveBacklog:]
+	}
+	for subscriber := range r.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// subscribe returns the recent output lines of r and a channel of the lines
+// that follow, closed when the run completes. It returns a closed channel if
+// r already completed.
+func (r *liveRun) subscribe() ([]StreamEvent, chan StreamEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch := make(chan StreamEvent, liveBacklog)
+	if r.subscribers == nil {
+		close(ch)
+	} else {
+		r.subscribers[ch] = true
+	}
+	return append([]StreamEvent(nil), r.backlog...), ch
+}
+
+// unsubscribe detaches the channel returned by subscribe.
+func (r *liveRun) unsubscribe(ch chan StreamEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.subscribers[ch] {
+		delete(r.subscribers, ch)
+		close(ch)
+	}
+}
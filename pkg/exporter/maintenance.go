@@ -0,0 +1,67 @@
+package exporter
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var maintenanceMode = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "script_exporter_maintenance_mode",
+	Help: "Whether running scripts is paused for maintenance.",
+})
+
+func init() {
+	prometheus.MustRegister(maintenanceMode)
+}
+
+// Pause stops running scripts, scheduled or probed, until Resume is called.
+// Probes are answered with the latest result kept for /metrics, if any,
+// marked as paused.
+func (e *Exporter) Pause() {
+	atomic.StoreInt32(&e.paused, 1)
+	maintenanceMode.Set(1)
+}
+
+// Resume runs scripts again after Pause.
+func (e *Exporter) Resume() {
+	atomic.StoreInt32(&e.paused, 0)
+	maintenanceMode.Set(0)
+}
+
+// Paused reports whether running scripts is paused.
+func (e *Exporter) Paused() bool {
+	return atomic.LoadInt32(&e.paused) == 1
+}
+
+// pausedMeasurement returns the result of probing script against target while
+// paused.
+func pausedMeasurement(script *Script, target string) *Measurement {
+	measurement := &Measurement{Script: script, Target: target}
+	if latest := results.latest(script, target); latest != nil {
+		*measurement = *latest
+	}
+	measurement.Paused = true
+	return measurement
+}
+
+// maintenanceHandler pauses running scripts on POST /-/pause and resumes it
+// on POST /-/resume.
+func (e *Exporter) maintenanceHandler(pause bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if pause {
+			e.Pause()
+			log.Printf("OK: running scripts paused through /-/pause\n")
+		} else {
+			e.Resume()
+			log.Printf("OK: running scripts resumed through /-/resume\n")
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package exporter
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	e := New(writeConfig(t, `
+scripts:
+  - name: cached
+    script: exit 0
+  - name: uncached
+    script: exit 0
+`))
+	e.ExposeResults = true
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	config := e.Config()
+	e.runScripts([]*Script{config.Script("cached")}, "")
+	defer results.prune(&Config{})
+
+	mux := http.NewServeMux()
+	e.RegisterHandlers(mux)
+	post := func(path string) int {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("POST", path, nil))
+		return rr.Code
+	}
+
+	if status := post("/-/pause"); status != 200 || !e.Paused() {
+		t.Fatalf("Expected /-/pause to pause running scripts, got %d", status)
+	}
+	var out bytes.Buffer
+	for _, measurement := range e.runScripts(config.Scripts, "") {
+		WriteMeasurement(&out, measurement)
+	}
+	metrics := out.String()
+	for _, line := range []string{
+		`script_paused{script="cached"} 1`,
+		`script_success{script="cached"} 1`,
+		`script_paused{script="uncached"} 1`,
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("Expected %s:\n%s", line, metrics)
+		}
+	}
+	if strings.Contains(metrics, `script_success{script="uncached"}`) {
+		t.Errorf("Expected no result of the script never run:\n%s", metrics)
+	}
+	if runs := len(config.Script("cached").history.snapshot()); runs != 1 {
+		t.Errorf("Expected no run while paused, got %d runs", runs)
+	}
+
+	if status := post("/-/resume"); status != 200 || e.Paused() {
+		t.Errorf("Expected /-/resume to resume running scripts, got %d", status)
+	}
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/-/pause", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to be refused, got %d", rr.Code)
+	}
+}
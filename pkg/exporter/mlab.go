@@ -0,0 +1,70 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// mlabLabels are the labels added to the metrics of scripts with mlab_labels
+// probing M-Lab machines.
+var mlabLabels = []string{"machine", "site", "metro", "project"}
+
+var (
+	// mlabV1Regexp matches M-Lab host names with dots, optionally prefixed
+	// by an experiment, as in ndt.iupui.mlab1.lga03.measurement-lab.org.
+	// The domain may be omitted.
+	mlabV1Regexp = regexp.MustCompile(`^(?:[a-z0-9.-]+\.)?(mlab[0-9]+)\.(([a-z]{3})[0-9][0-9t])(?:\.measurement-lab\.org)?$`)
+
+	// mlabV2Regexp matches M-Lab host names with dashes in a project, as in
+	// ndt-mlab1-lga03.mlab-oti.measurement-lab.org.
+	mlabV2Regexp = regexp.MustCompile(`^(?:[a-z0-9]+-)?(mlab[0-9]+)-(([a-z]{3})[0-9][0-9t])\.(mlab-[a-z]+)\.measurement-lab\.org$`)
+)
+
+// mlabHost is an M-Lab machine named by a target. Project is empty for the
+// names without it.
+type mlabHost struct {
+	Machine, Site, Metro, Project string
+}
+
+// parseMLabHost parses host as the name of an M-Lab machine, returning nil if
+// it is not one.
+func parseMLabHost(host string) *mlabHost {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if m := mlabV2Regexp.FindStringSubmatch(host); m != nil {
+		return &mlabHost{Machine: m[1], Site: m[2], Metro: m[3], Project: m[4]}
+	}
+	if m := mlabV1Regexp.FindStringSubmatch(host); m != nil {
+		return &mlabHost{Machine: m[1], Site: m[2], Metro: m[3]}
+	}
+	return nil
+}
+
+// env renders the machine as script environment variables.
+func (h *mlabHost) env() []string {
+	return []string{
+		"MACHINE=" + h.Machine,
+		"SITE=" + h.Site,
+		"METRO=" + h.Metro,
+		"PROJECT=" + h.Project,
+	}
+}
+
+// labels renders the machine as metric labels, in the order of mlabLabels,
+// omitting an empty project.
+func (h *mlabHost) labels() string {
+	labels := fmt.Sprintf(",machine=\"%s\",site=\"%s\",metro=\"%s\"", h.Machine, h.Site, h.Metro)
+	if h.Project != "" {
+		labels += fmt.Sprintf(",project=\"%s\"", h.Project)
+	}
+	return labels
+}
+
+// targetMLabHost returns the M-Lab machine target names, or nil.
+func targetMLabHost(target string) *mlabHost {
+	parsed, err := ParseTarget(target)
+	if err != nil {
+		return nil
+	}
+	return parseMLabHost(parsed.Host)
+}
@@ -0,0 +1,59 @@
+package exporter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseMLabHost(t *testing.T) {
+	for _, test := range []struct {
+		host     string
+		expected *mlabHost
+	}{
+		{"mlab1.lga03.measurement-lab.org", &mlabHost{"mlab1", "lga03", "lga", ""}},
+		{"mlab1.lga03", &mlabHost{"mlab1", "lga03", "lga", ""}},
+		{"ndt.iupui.mlab4.lga0t.measurement-lab.org.", &mlabHost{"mlab4", "lga0t", "lga", ""}},
+		{"mlab2-syd01.mlab-oti.measurement-lab.org", &mlabHost{"mlab2", "syd01", "syd", "mlab-oti"}},
+		{"ndt-MLAB1-lga03.mlab-staging.measurement-lab.org", &mlabHost{"mlab1", "lga03", "lga", "mlab-staging"}},
+		{"mlab1.lga03.example.com", nil},
+		{"mlab1-lga03.measurement-lab.org", nil},
+		{"mlab1.lga3.measurement-lab.org", nil},
+		{"example.com", nil},
+	} {
+		host := parseMLabHost(test.host)
+		if (host == nil) != (test.expected == nil) || host != nil && *host != *test.expected {
+			t.Errorf("Expected %s to parse as %+v, got %+v", test.host, test.expected, host)
+		}
+	}
+}
+
+func TestMLabTargets(t *testing.T) {
+	script := &Script{Name: "site", Content: "echo $MACHINE $SITE $METRO ${PROJECT:-none}", Timeout: 5, MLabLabels: true}
+	if err := script.setDefaults(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	measurement := testExporter.runScripts([]*Script{script}, "https://mlab1-lga03.mlab-oti.measurement-lab.org:443/ndt")[0]
+	if measurement.Output != "mlab1 lga03 lga mlab-oti\n" {
+		t.Errorf("Expected the machine in the environment, got %q", measurement.Output)
+	}
+	var out bytes.Buffer
+	WriteMeasurement(&out, measurement)
+	if expected := `script_success{script="site",machine="mlab1",site="lga03",metro="lga",project="mlab-oti"} 1`; !strings.Contains(out.String(), expected) {
+		t.Errorf("Expected %s in:\n%s", expected, out.String())
+	}
+
+	// Other targets get neither.
+	measurement = testExporter.runScripts([]*Script{script}, "example.com")[0]
+	out.Reset()
+	WriteMeasurement(&out, measurement)
+	if measurement.Output != "none\n" || strings.Contains(out.String(), "machine=") {
+		t.Errorf("Expected no machine for another target, got %q:\n%s", measurement.Output, out.String())
+	}
+
+	reserved := &Script{Name: "reserved", Content: "exit 0", MLabLabels: true, Labels: map[string]string{"site": "lga03"}}
+	if err := reserved.setDefaults(); err == nil {
+		t.Errorf("Expected the site label to be reserved")
+	}
+}
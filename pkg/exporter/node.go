@@ -0,0 +1,144 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The cloud metadata servers the zone and instance of the node can be taken
+// from.
+const (
+	metadataGCE = "gce"
+	metadataEC2 = "ec2"
+)
+
+// MetadataProviders lists the values of Exporter.MetadataProvider.
+var MetadataProviders = []string{metadataGCE, metadataEC2}
+
+var (
+	// gceMetadataURL and ec2MetadataURL are the metadata servers of Google
+	// Compute Engine and Amazon EC2.
+	gceMetadataURL = "http://metadata.google.internal/computeMetadata/v1"
+	ec2MetadataURL = "http://169.254.169.254/latest"
+
+	// metadataTimeout bounds every request to a metadata server.
+	metadataTimeout = 2 * time.Second
+)
+
+// nodeMetadata is the environment describing the node every run is given,
+// looked up once.
+type nodeMetadata struct {
+	once sync.Once
+	env  []string
+}
+
+// nodeEnv returns NODE_HOSTNAME, NODE_IP and, from the MetadataProvider if
+// any, NODE_ZONE and NODE_INSTANCE as script environment variables. Those that
+// cannot be told are left out.
+func (e *Exporter) nodeEnv() []string {
+	e.node.once.Do(func() {
+		if hostname, err := os.Hostname(); err == nil {
+			e.node.env = append(e.node.env, "NODE_HOSTNAME="+hostname)
+		}
+		if ip := nodeIP(); ip != "" {
+			e.node.env = append(e.node.env, "NODE_IP="+ip)
+		}
+		if e.MetadataProvider == "" {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), metadataTimeout)
+		defer cancel()
+		zone, instance, err := cloudMetadata(ctx, e.MetadataProvider)
+		if err != nil {
+			log.Printf("ERROR: Cannot get the node metadata from %s: %s\n", e.MetadataProvider, err)
+			return
+		}
+		e.node.env = append(e.node.env, "NODE_ZONE="+zone, "NODE_INSTANCE="+instance)
+	})
+	return append([]string(nil), e.node.env...)
+}
+
+// nodeIP returns the address the node reaches the Internet from, or else its
+// first global unicast address, or "".
+func nodeIP() string {
+	// Connecting a UDP socket sends nothing but picks the local address
+	// of the default route.
+	if conn, err := net.Dial("udp", "192.0.2.1:9"); err == nil {
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).IP.String()
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		if network, ok := addr.(*net.IPNet); ok && network.IP.IsGlobalUnicast() {
+			return network.IP.String()
+		}
+	}
+	return ""
+}
+
+// cloudMetadata returns the zone and instance of the node from the metadata
+// server of provider.
+func cloudMetadata(ctx context.Context, provider string) (zone, instance string, err error) {
+	switch provider {
+	case metadataGCE:
+		header := http.Header{"Metadata-Flavor": {"Google"}}
+		if zone, err = getMetadata(ctx, http.MethodGet, gceMetadataURL+"/instance/zone", header); err != nil {
+			return "", "", err
+		}
+		// The zone is given as projects/<number>/zones/<zone>.
+		zone = zone[strings.LastIndexByte(zone, '/')+1:]
+		instance, err = getMetadata(ctx, http.MethodGet, gceMetadataURL+"/instance/name", header)
+		return zone, instance, err
+
+	case metadataEC2:
+		// IMDSv2 requires a session token.
+		token, err := getMetadata(ctx, http.MethodPut, ec2MetadataURL+"/api/token", http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"60"}})
+		if err != nil {
+			return "", "", err
+		}
+		header := http.Header{"X-Aws-Ec2-Metadata-Token": {token}}
+		if zone, err = getMetadata(ctx, http.MethodGet, ec2MetadataURL+"/meta-data/placement/availability-zone", header); err != nil {
+			return "", "", err
+		}
+		instance, err = getMetadata(ctx, http.MethodGet, ec2MetadataURL+"/meta-data/instance-id", header)
+		return zone, instance, err
+	}
+	return "", "", fmt.Errorf("unknown metadata provider %q", provider)
+}
+
+// getMetadata returns the value served by a metadata server at url.
+func getMetadata(ctx context.Context, method, url string, header http.Header) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header = header
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
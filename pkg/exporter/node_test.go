@@ -0,0 +1,49 @@
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNodeEnv(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/gce/instance/zone" && r.Header.Get("Metadata-Flavor") == "Google":
+			w.Write([]byte("projects/123/zones/us-east1-b"))
+		case r.URL.Path == "/gce/instance/name" && r.Header.Get("Metadata-Flavor") == "Google":
+			w.Write([]byte("mlab1-lga03"))
+		case r.URL.Path == "/ec2/api/token" && r.Method == http.MethodPut:
+			w.Write([]byte("token"))
+		case r.URL.Path == "/ec2/meta-data/placement/availability-zone" && r.Header.Get("X-Aws-Ec2-Metadata-Token") == "token":
+			w.Write([]byte("us-east-1a\n"))
+		case r.URL.Path == "/ec2/meta-data/instance-id" && r.Header.Get("X-Aws-Ec2-Metadata-Token") == "token":
+			w.Write([]byte("i-0123"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer func(gce, ec2 string) { gceMetadataURL, ec2MetadataURL = gce, ec2 }(gceMetadataURL, ec2MetadataURL)
+	gceMetadataURL, ec2MetadataURL = server.URL+"/gce", server.URL+"/ec2"
+
+	script := &Script{Name: "node", Content: "echo $NODE_ZONE $NODE_INSTANCE; test -n \"$NODE_HOSTNAME\"", Timeout: 5, CleanEnv: true}
+	for provider, expected := range map[string]string{"gce": "us-east1-b mlab1-lga03\n", "ec2": "us-east-1a i-0123\n", "": "\n"} {
+		e := New("")
+		e.MetadataProvider = provider
+		if m := e.runScripts([]*Script{script}, "")[0]; m.Success != 1 || m.Output != expected {
+			t.Errorf("Expected %q from %q, got %q (success %d)", expected, provider, m.Output, m.Success)
+		}
+	}
+
+	// Metadata that cannot be fetched is left out.
+	e := New("")
+	e.MetadataProvider = "gce"
+	gceMetadataURL = server.URL + "/missing"
+	for _, variable := range e.nodeEnv() {
+		if strings.HasPrefix(variable, "NODE_ZONE=") {
+			t.Errorf("Expected no zone, got %s", variable)
+		}
+	}
+}
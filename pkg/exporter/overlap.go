@@ -0,0 +1,50 @@
+package exporter
+
+import (
+	"log"
+	"sync"
+)
+
+// overlapGuard prevents a script from being started while a previous run of
+// it is still in progress, returning the previous result instead if it was
+// against the same target.
+type overlapGuard struct {
+	mu      sync.Mutex
+	running bool
+	last    *Measurement
+	skipped int64
+}
+
+// run executes measure unless the script is already running.
+func (g *overlapGuard) run(script *Script, target string, measure func() *Measurement) *Measurement {
+	g.mu.Lock()
+	if g.running {
+		g.skipped++
+		last := g.last
+		g.mu.Unlock()
+
+		log.Printf("SKIP: %s to %s: previous run still in progress.\n", script.Name, target)
+		if last == nil || last.Target != target {
+			return &Measurement{Script: script, Target: target, ExitCode: 1}
+		}
+		previous := *last
+		return &previous
+	}
+	g.running = true
+	g.mu.Unlock()
+
+	measurement := measure()
+
+	g.mu.Lock()
+	g.running = false
+	g.last = measurement
+	g.mu.Unlock()
+
+	return measurement
+}
+
+func (g *overlapGuard) skippedCount() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.skipped
+}
@@ -0,0 +1,51 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOverlap(t *testing.T) {
+	allowOverlap := false
+	script := &Script{Name: "slow", Content: "sleep 1", Timeout: 5, AllowOverlap: &allowOverlap}
+
+	done := make(chan *Measurement)
+	go func() {
+		done <- testExporter.runScripts([]*Script{script}, "")[0]
+	}()
+
+	// Give the first run time to start.
+	time.Sleep(200 * time.Millisecond)
+
+	start := time.Now()
+	skipped := testExporter.runScripts([]*Script{script}, "")[0]
+
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Expected overlapping run to be skipped")
+	}
+
+	if skipped.Success != 0 {
+		t.Errorf("Expected skipped run without previous result to fail")
+	}
+
+	if first := <-done; first.Success != 1 {
+		t.Errorf("Expected first run to succeed")
+	}
+
+	go func() {
+		done <- testExporter.runScripts([]*Script{script}, "")[0]
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	if previous := testExporter.runScripts([]*Script{script}, "")[0]; previous.Success != 1 {
+		t.Errorf("Expected skipped run to return the previous result")
+	}
+	if other := testExporter.runScripts([]*Script{script}, "other")[0]; other.Success != 0 || other.Target != "other" {
+		t.Errorf("Expected skipped run against another target to fail, got %d for %q", other.Success, other.Target)
+	}
+	<-done
+
+	if n := script.overlap.skippedCount(); n != 3 {
+		t.Errorf("Expected 3 skipped runs, got %d", n)
+	}
+}
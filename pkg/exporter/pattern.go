@@ -0,0 +1,64 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sync"
+)
+
+// maxPatternLength and maxPatternInstructions bound the pattern parameter of
+// probes, as compiling a large regular expression per request is costly.
+const (
+	maxPatternLength       = 256
+	maxPatternInstructions = 2000
+)
+
+// maxCachedPatterns bounds the number of compiled patterns kept.
+const maxCachedPatterns = 128
+
+// patternCache keeps the compiled pattern parameters of recent probes, so that
+// the patterns Prometheus probes with on every scrape are compiled once.
+type patternCache struct {
+	mu       sync.Mutex
+	patterns map[string]*regexp.Regexp
+}
+
+var patterns = &patternCache{patterns: make(map[string]*regexp.Regexp)}
+
+// compile returns the compiled pattern, refusing patterns longer than
+// maxPatternLength or compiling to more than maxPatternInstructions.
+func (c *patternCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if re, ok := c.patterns[pattern]; ok {
+		return re, nil
+	}
+
+	if len(pattern) > maxPatternLength {
+		return nil, fmt.Errorf("pattern longer than %d bytes", maxPatternLength)
+	}
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, err
+	}
+	if len(prog.Inst) > maxPatternInstructions {
+		return nil, errors.New("pattern too complex")
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.patterns) >= maxCachedPatterns {
+		c.patterns = make(map[string]*regexp.Regexp)
+	}
+	c.patterns[pattern] = re
+	return re, nil
+}
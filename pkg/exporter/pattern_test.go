@@ -0,0 +1,43 @@
+package exporter
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPatternCache(t *testing.T) {
+	first, err := patterns.compile("^fail")
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	if second, _ := patterns.compile("^fail"); second != first {
+		t.Errorf("Expected the compiled pattern to be cached")
+	}
+
+	for pattern, message := range map[string]string{
+		strings.Repeat("a", maxPatternLength+1): "longer than",
+		"(":                                     "missing closing )",
+	} {
+		if _, err := patterns.compile(pattern); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected %.20q to be refused with %q, got %v", pattern, message, err)
+		}
+	}
+	if _, err := patterns.compile("[a-z]{1000}[0-9]{1000}"); err == nil || err.Error() != "pattern too complex" {
+		t.Errorf("Expected a complex pattern to be refused, got %v", err)
+	}
+
+	disabled, err := LoadConfig(writeConfig(t, "disable_patterns: true\nscripts:\n  - name: success\n    script: exit 0\n"))
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	err = testExporter.ProbeQuery(context.Background(), disabled, url.Values{"pattern": {".*"}}, func(*Measurement) {})
+	if probeError, ok := err.(*ProbeError); !ok || probeError.Status != 403 {
+		t.Errorf("Expected 403 with disable_patterns, got %v", err)
+	}
+	err = testExporter.ProbeQuery(context.Background(), disabled, url.Values{"name": {"success"}}, func(*Measurement) {})
+	if err != nil {
+		t.Errorf("Expected probes by name to be served with disable_patterns, got %s", err)
+	}
+}
@@ -0,0 +1,205 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: script_exporter.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// ScriptExporterClient is the client API for ScriptExporter service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ScriptExporterClient interface {
+	RunScript(ctx context.Context, in *RunScriptRequest, opts ...grpc.CallOption) (*RunScriptResponse, error)
+	ListScripts(ctx context.Context, in *ListScriptsRequest, opts ...grpc.CallOption) (*ListScriptsResponse, error)
+	StreamOutput(ctx context.Context, in *RunScriptRequest, opts ...grpc.CallOption) (ScriptExporter_StreamOutputClient, error)
+}
+
+type scriptExporterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewScriptExporterClient(cc grpc.ClientConnInterface) ScriptExporterClient {
+	return &scriptExporterClient{cc}
+}
+
+func (c *scriptExporterClient) RunScript(ctx context.Context, in *RunScriptRequest, opts ...grpc.CallOption) (*RunScriptResponse, error) {
+	out := new(RunScriptResponse)
+	err := c.cc.Invoke(ctx, "/scriptexporter.v1.ScriptExporter/RunScript", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *scriptExporterClient) ListScripts(ctx context.Context, in *ListScriptsRequest, opts ...grpc.CallOption) (*ListScriptsResponse, error) {
+	out := new(ListScriptsResponse)
+	err := c.cc.Invoke(ctx, "/scriptexporter.v1.ScriptExporter/ListScripts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *scriptExporterClient) StreamOutput(ctx context.Context, in *RunScriptRequest, opts ...grpc.CallOption) (ScriptExporter_StreamOutputClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ScriptExporter_ServiceDesc.Streams[0], "/scriptexporter.v1.ScriptExporter/StreamOutput", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &scriptExporterStreamOutputClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ScriptExporter_StreamOutputClient interface {
+	Recv() (*StreamOutputResponse, error)
+	grpc.ClientStream
+}
+
+type scriptExporterStreamOutputClient struct {
+	grpc.ClientStream
+}
+
+func (x *scriptExporterStreamOutputClient) Recv() (*StreamOutputResponse, error) {
+	m := new(StreamOutputResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ScriptExporterServer is the server API for ScriptExporter service.
+// All implementations must embed UnimplementedScriptExporterServer
+// for forward compatibility
+type ScriptExporterServer interface {
+	RunScript(context.Context, *RunScriptRequest) (*RunScriptResponse, error)
+	ListScripts(context.Context, *ListScriptsRequest) (*ListScriptsResponse, error)
+	StreamOutput(*RunScriptRequest, ScriptExporter_StreamOutputServer) error
+	mustEmbedUnimplementedScriptExporterServer()
+}
+
+// UnimplementedScriptExporterServer must be embedded to have forward compatible implementations.
+type UnimplementedScriptExporterServer struct {
+}
+
+func (UnimplementedScriptExporterServer) RunScript(context.Context, *RunScriptRequest) (*RunScriptResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RunScript not implemented")
+}
+func (UnimplementedScriptExporterServer) ListScripts(context.Context, *ListScriptsRequest) (*ListScriptsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListScripts not implemented")
+}
+func (UnimplementedScriptExporterServer) StreamOutput(*RunScriptRequest, ScriptExporter_StreamOutputServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamOutput not implemented")
+}
+func (UnimplementedScriptExporterServer) mustEmbedUnimplementedScriptExporterServer() {}
+
+// UnsafeScriptExporterServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ScriptExporterServer will
+// result in compilation errors.
+type UnsafeScriptExporterServer interface {
+	mustEmbedUnimplementedScriptExporterServer()
+}
+
+func RegisterScriptExporterServer(s grpc.ServiceRegistrar, srv ScriptExporterServer) {
+	s.RegisterService(&ScriptExporter_ServiceDesc, srv)
+}
+
+func _ScriptExporter_RunScript_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RunScriptRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScriptExporterServer).RunScript(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/scriptexporter.v1.ScriptExporter/RunScript",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScriptExporterServer).RunScript(ctx, req.(*RunScriptRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ScriptExporter_ListScripts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListScriptsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScriptExporterServer).ListScripts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/scriptexporter.v1.ScriptExporter/ListScripts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScriptExporterServer).ListScripts(ctx, req.(*ListScriptsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ScriptExporter_StreamOutput_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RunScriptRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ScriptExporterServer).StreamOutput(m, &scriptExporterStreamOutputServer{stream})
+}
+
+type ScriptExporter_StreamOutputServer interface {
+	Send(*StreamOutputResponse) error
+	grpc.ServerStream
+}
+
+type scriptExporterStreamOutputServer struct {
+	grpc.ServerStream
+}
+
+func (x *scriptExporterStreamOutputServer) Send(m *StreamOutputResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// ScriptExporter_ServiceDesc is the grpc.ServiceDesc for ScriptExporter service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ScriptExporter_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "scriptexporter.v1.ScriptExporter",
+	HandlerType: (*ScriptExporterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RunScript",
+			Handler:    _ScriptExporter_RunScript_Handler,
+		},
+		{
+			MethodName: "ListScripts",
+			Handler:    _ScriptExporter_ListScripts_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamOutput",
+			Handler:       _ScriptExporter_StreamOutput_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "script_exporter.proto",
+}
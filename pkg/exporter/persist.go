@@ -0,0 +1,158 @@
+package exporter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// resultsSaveInterval is how often changed results are saved by
+// PersistResults.
+var resultsSaveInterval = 10 * time.Second
+
+// persistedResult is a result kept for /metrics as saved by PersistResults.
+// The output of the script is not saved.
+type persistedResult struct {
+	Script   string    `json:"script"`
+	Target   string    `json:"target"`
+	Recorded time.Time `json:"recorded"`
+
+	Start       time.Time           `json:"start"`
+	Success     int                 `json:"success"`
+	ExitCode    int                 `json:"exit_code"`
+	Duration    float64             `json:"duration"`
+	Attempts    int                 `json:"attempts"`
+	CircuitOpen bool                `json:"circuit_open,omitempty"`
+	ErrorReason string              `json:"error_reason,omitempty"`
+	Signal      string              `json:"signal,omitempty"`
+	OutputBytes int64               `json:"output_bytes"`
+	DNSLookup   float64             `json:"dns_lookup,omitempty"`
+	LastSuccess time.Time           `json:"last_success"`
+	Metrics     []*dto.MetricFamily `json:"metrics,omitempty"`
+}
+
+// PersistResults restores the results kept for /metrics from the file at
+// path for the scripts of the active configuration, so that a restart does
+// not blank out the last known state of infrequently scheduled scripts, and
+// then saves them to it whenever they changed, every resultsSaveInterval.
+func (e *Exporter) PersistResults(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var saved []persistedResult
+		if err = json.Unmarshal(data, &saved); err != nil {
+			return err
+		}
+		results.restore(saved, e.Config())
+	}
+
+	go func() {
+		for range time.Tick(resultsSaveInterval) {
+			if err := results.save(path); err != nil {
+				log.Printf("ERROR: saving results to %s failed: %s\n", path, err)
+			}
+		}
+	}()
+	return nil
+}
+
+// restore records the saved results of the scripts config has.
+func (r *resultCache) restore(saved []persistedResult, config *Config) {
+	scripts := make(map[string]*Script)
+	for _, script := range config.allScripts() {
+		scripts[script.Name] = script
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, result := range saved {
+		script := scripts[result.Script]
+		if script == nil {
+			continue
+		}
+		r.results[resultKey{result.Script, result.Target}] = &cachedResult{
+			measurement: &Measurement{
+				Script:      script,
+				Target:      result.Target,
+				Start:       result.Start,
+				Success:     result.Success,
+				ExitCode:    result.ExitCode,
+				Duration:    result.Duration,
+				Attempts:    result.Attempts,
+				CircuitOpen: result.CircuitOpen,
+				ErrorReason: result.ErrorReason,
+				Signal:      result.Signal,
+				OutputBytes: result.OutputBytes,
+				DNSLookup:   result.DNSLookup,
+				LastSuccess: result.LastSuccess,
+				Metrics:     result.Metrics,
+			},
+			recorded: result.Recorded,
+		}
+	}
+}
+
+// save writes the results to the file at path if they changed since the
+// last save.
+func (r *resultCache) save(path string) error {
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return nil
+	}
+	saved := make([]persistedResult, 0, len(r.results))
+	for key, result := range r.results {
+		m := result.measurement
+		saved = append(saved, persistedResult{
+			Script:      key.script,
+			Target:      key.target,
+			Recorded:    result.recorded,
+			Start:       m.Start,
+			Success:     m.Success,
+			ExitCode:    m.ExitCode,
+			Duration:    m.Duration,
+			Attempts:    m.Attempts,
+			CircuitOpen: m.CircuitOpen,
+			ErrorReason: m.ErrorReason,
+			Signal:      m.Signal,
+			OutputBytes: m.OutputBytes,
+			DNSLookup:   m.DNSLookup,
+			LastSuccess: m.LastSuccess,
+			Metrics:     m.Metrics,
+		})
+	}
+	r.dirty = false
+	r.mu.Unlock()
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, data)
+}
+
+// replaceFile writes data to the file at path, replacing it by a rename so
+// that it is never left partly written.
+func replaceFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,51 @@
+package exporter
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPersistResults(t *testing.T) {
+	e := New(writeConfig(t, `
+scripts:
+  - name: weekly
+    schedule: '@weekly'
+    output_metrics: true
+    script: echo 'disk_errors 3'
+`))
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	defer results.prune(&Config{})
+
+	path := filepath.Join(t.TempDir(), "results.json")
+	e.runScripts(e.Config().Scripts, "")
+	if err := results.save(path); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	if data, _ := ioutil.ReadFile(path); !strings.Contains(string(data), `"script":"weekly"`) {
+		t.Errorf("Expected the result to be saved:\n%s", data)
+	}
+
+	// A restarted exporter restores the result of the script.
+	results.prune(&Config{})
+	if err := e.PersistResults(path); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	metrics := gatherDefault(t)
+	for _, line := range []string{`script_success{script="weekly"} 1`, "disk_errors 3"} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("Expected %s after restoring:\n%s", line, metrics)
+		}
+	}
+	if latest := results.latest(e.Config().Script("weekly"), ""); latest == nil || time.Since(latest.Start) > time.Minute || latest.LastSuccess.IsZero() {
+		t.Errorf("Expected the start and last success of the restored run to be kept")
+	}
+
+	if err := e.PersistResults(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("Expected a missing file to be ignored, got %s", err)
+	}
+}
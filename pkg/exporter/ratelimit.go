@@ -0,0 +1,132 @@
+package exporter
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Buckets of clients that have been idle long enough to refill are dropped
+// once a limiter tracks more keys than this, and the least recently used ones
+// if that is not enough.
+const rateLimiterPruneSize = 10000
+
+// RateLimit allows Requests probes every Period seconds, in bursts of up to
+// Burst probes.
+type RateLimit struct {
+	Requests int     `yaml:"requests"`
+	Period   float64 `yaml:"period,omitempty"`
+	Burst    int     `yaml:"burst,omitempty"`
+}
+
+func (l *RateLimit) setDefaults() error {
+	if l.Requests <= 0 {
+		return errors.New("requests must be positive")
+	}
+	if l.Period == 0 {
+		l.Period = 60
+	}
+	if l.Period < 0 {
+		return errors.New("period must be positive")
+	}
+	if l.Burst == 0 {
+		l.Burst = l.Requests
+	}
+	return nil
+}
+
+// rate returns the number of tokens added per second.
+func (l *RateLimit) rate() float64 {
+	return float64(l.Requests) / l.Period
+}
+
+// tokenBucket holds the tokens left for a key at the time of its last update.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket for the time passed since its last update and takes
+// a token if one is left.
+func (b *tokenBucket) take(limit *RateLimit, now time.Time) bool {
+	if b.last.IsZero() {
+		b.tokens = float64(limit.Burst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * limit.rate()
+		if b.tokens > float64(limit.Burst) {
+			b.tokens = float64(limit.Burst)
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimiter keeps a token bucket per key, e.g. per client address.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func (l *rateLimiter) allow(key string, limit *RateLimit) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	if len(l.buckets) > rateLimiterPruneSize {
+		refill := time.Duration(float64(limit.Burst) / limit.rate() * float64(time.Second))
+		for k, bucket := range l.buckets {
+			if now.Sub(bucket.last) > refill {
+				delete(l.buckets, k)
+			}
+		}
+	}
+	if _, ok := l.buckets[key]; !ok && len(l.buckets) > rateLimiterPruneSize {
+		oldest := ""
+		for k, bucket := range l.buckets {
+			if oldest == "" || bucket.last.Before(l.buckets[oldest].last) {
+				oldest = k
+			}
+		}
+		delete(l.buckets, oldest)
+	}
+
+	bucket := l.buckets[key]
+	if bucket == nil {
+		bucket = &tokenBucket{}
+		l.buckets[key] = bucket
+	}
+	return bucket.take(limit, now)
+}
+
+// clientAddress returns the IP address of the client of r.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+var probesRejected = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "script_exporter_probes_rejected_total",
+		Help: "Number of probes rejected with 429 because the client or a script exceeded its rate limit.",
+	},
+	[]string{"limit"},
+)
+
+func init() {
+	prometheus.MustRegister(probesRejected)
+}
@@ -0,0 +1,81 @@
+package exporter
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limit := &RateLimit{Requests: 2, Period: 60}
+	if err := limit.setDefaults(); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	now := time.Unix(1600000000, 0)
+	bucket := &tokenBucket{}
+
+	if !bucket.take(limit, now) || !bucket.take(limit, now) {
+		t.Fatalf("Expected a burst of 2 to be allowed")
+	}
+	if bucket.take(limit, now.Add(time.Second)) {
+		t.Errorf("Expected third request within the period to be rejected")
+	}
+	if !bucket.take(limit, now.Add(31*time.Second)) {
+		t.Errorf("Expected a token to be refilled after 30s")
+	}
+}
+
+func TestRateLimiterSize(t *testing.T) {
+	limit := &RateLimit{Requests: 1, Period: 3600}
+	if err := limit.setDefaults(); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	var limiter rateLimiter
+	for i := 0; i < rateLimiterPruneSize+10; i++ {
+		limiter.allow(fmt.Sprintf("client-%d", i), limit)
+	}
+	if n := len(limiter.buckets); n > rateLimiterPruneSize+1 {
+		t.Errorf("Expected at most %d buckets, got %d", rateLimiterPruneSize+1, n)
+	}
+	if limiter.allow(fmt.Sprintf("client-%d", rateLimiterPruneSize+9), limit) {
+		t.Errorf("Expected the bucket of a recent client to be kept")
+	}
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `
+client_rate_limit:
+  requests: 1
+scripts:
+  - name: limited
+    script: exit 0
+    rate_limit:
+      requests: 1
+      period: 3600
+`))
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	e := New("")
+	probe := func(client string) int {
+		r := httptest.NewRequest("GET", "/probe?name=limited", nil)
+		r.RemoteAddr = client + ":12345"
+		w := httptest.NewRecorder()
+		e.scriptRunHandler(w, r, config)
+		return w.Code
+	}
+
+	if code := probe("192.0.2.1"); code != 200 {
+		t.Errorf("Expected 200 for first probe, got %d", code)
+	}
+	if code := probe("192.0.2.1"); code != 429 {
+		t.Errorf("Expected 429 for client over its limit, got %d", code)
+	}
+	if code := probe("192.0.2.2"); code != 429 {
+		t.Errorf("Expected 429 for script over its limit, got %d", code)
+	}
+}
@@ -0,0 +1,102 @@
+package exporter
+
+import (
+	"os/exec"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var reapedProcesses = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "script_exporter_reaped_processes_total",
+	Help: "Number of orphaned descendants of scripts reaped by the exporter.",
+})
+
+var killedProcesses = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "script_exporter_killed_processes_total",
+	Help: "Number of scripts killed with their process group on timeout.",
+})
+
+var startFailures = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "script_exporter_process_start_failures_total",
+	Help: "Number of processes the exporter failed to start, e.g. for a missing shell.",
+})
+
+func init() {
+	prometheus.MustRegister(reapedProcesses, killedProcesses, startFailures)
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "script_exporter_running_processes",
+		Help: "Number of processes started by the exporter still running.",
+	}, func() float64 { return float64(children.count()) }))
+}
+
+// childTracker keeps the processes the exporter started and waits for
+// itself, which the reaper leaves alone so as not to steal their exit status.
+type childTracker struct {
+	// starting is held for reading while a process is started and
+	// registered, and for writing while the reaper reaps, so that a process
+	// exiting right after starting is not taken for an orphan.
+	starting sync.RWMutex
+
+	mu   sync.Mutex
+	pids map[int]bool
+}
+
+var children = &childTracker{pids: make(map[int]bool)}
+
+// start starts cmd, tracking its process until wait.
+func (c *childTracker) start(cmd *exec.Cmd) error {
+	c.starting.RLock()
+	defer c.starting.RUnlock()
+
+	if err := cmd.Start(); err != nil {
+		startFailures.Inc()
+		return err
+	}
+	c.mu.Lock()
+	c.pids[cmd.Process.Pid] = true
+	c.mu.Unlock()
+	return nil
+}
+
+// wait waits for cmd, started with start, to exit.
+func (c *childTracker) wait(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	c.mu.Lock()
+	delete(c.pids, cmd.Process.Pid)
+	c.mu.Unlock()
+	return err
+}
+
+// run starts cmd and waits for it to exit.
+func (c *childTracker) run(cmd *exec.Cmd) error {
+	if err := c.start(cmd); err != nil {
+		return err
+	}
+	return c.wait(cmd)
+}
+
+// tracked reports whether the process pid is waited for by the exporter.
+func (c *childTracker) tracked(pid int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pids[pid]
+}
+
+// count returns the number of processes waited for by the exporter.
+func (c *childTracker) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pids)
+}
+
+// list returns the processes waited for by the exporter.
+func (c *childTracker) list() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pids := make([]int, 0, len(c.pids))
+	for pid := range c.pids {
+		pids = append(pids, pid)
+	}
+	return pids
+}
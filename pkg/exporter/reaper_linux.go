@@ -0,0 +1,105 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "script_exporter_zombie_processes",
+		Help: "Number of exited children of the exporter not reaped yet.",
+	}, func() float64 { return float64(len(zombieChildren(os.Getpid()))) }))
+}
+
+// reaperInterval bounds how long zombies wait to be reaped should a SIGCHLD
+// have been missed.
+var reaperInterval = 10 * time.Second
+
+// StartReaper makes the exporter the subreaper of the processes its scripts
+// leave behind, such as background jobs outliving the script, which are then
+// reaped when they exit instead of accumulating as zombies, until ctx is
+// cancelled. Only zombies of processes the exporter does not wait for itself
+// are reaped, so an application embedding the exporter must not call
+// StartReaper if it starts children of its own.
+func StartReaper(ctx context.Context) error {
+	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("becoming a subreaper: %s", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, unix.SIGCHLD)
+	go func() {
+		defer signal.Stop(signals)
+		ticker := time.NewTicker(reaperInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-signals:
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			reapOrphans()
+		}
+	}()
+	return nil
+}
+
+// reapOrphans reaps the zombie children of the exporter it does not wait for
+// itself.
+func reapOrphans() {
+	children.starting.Lock()
+	defer children.starting.Unlock()
+
+	for _, pid := range zombieChildren(os.Getpid()) {
+		if children.tracked(pid) {
+			continue
+		}
+		var status unix.WaitStatus
+		if reaped, err := unix.Wait4(pid, &status, unix.WNOHANG, nil); err == nil && reaped == pid {
+			reapedProcesses.Inc()
+		}
+	}
+}
+
+// zombieChildren returns the zombie processes whose parent is ppid, as told
+// by /proc.
+func zombieChildren(ppid int) []int {
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return nil
+	}
+
+	var zombies []int
+	for _, path := range stats {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// The command name in parentheses may contain spaces; the state
+		// and the parent follow it.
+		stat := string(content)
+		fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+		if len(fields) < 2 || fields[0] != "Z" {
+			continue
+		}
+		if parent, err := strconv.Atoi(fields[1]); err != nil || parent != ppid {
+			continue
+		}
+		if pid, err := strconv.Atoi(filepath.Base(filepath.Dir(path))); err == nil {
+			zombies = append(zombies, pid)
+		}
+	}
+	return zombies
+}
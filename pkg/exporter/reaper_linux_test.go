@@ -0,0 +1,51 @@
+package exporter
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestReaper(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := StartReaper(ctx); err != nil {
+		t.Skipf("Cannot become a subreaper: %s", err)
+	}
+
+	reaped := func() float64 {
+		var metric dto.Metric
+		reapedProcesses.Write(&metric)
+		return metric.Counter.GetValue()
+	}
+	before := reaped()
+
+	// The background job of the script outlives it, is reparented to the
+	// exporter and reaped when it exits.
+	orphan := &Script{Name: "orphan", Content: "(sleep 0.2 &)", Timeout: 5}
+	if m := testExporter.runScripts([]*Script{orphan}, "")[0]; m.Success != 1 {
+		t.Fatalf("Expected the script to succeed: %s", m.Output)
+	}
+
+	// Scripts exiting while the reaper runs keep their exit status.
+	scripts := make([]*Script, 0, 20)
+	for i := 0; i < cap(scripts); i++ {
+		scripts = append(scripts, &Script{Name: "quick", Content: "exit 3", Timeout: 5})
+	}
+	for _, m := range testExporter.runScripts(scripts, "") {
+		if m.ExitCode != 3 {
+			t.Errorf("Expected the exit status of the script, got %d (%s)", m.ExitCode, m.ErrorReason)
+		}
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if after := reaped(); after-before != 1 {
+		t.Errorf("Expected 1 orphan reaped, got %g", after-before)
+	}
+	if zombies := zombieChildren(os.Getpid()); len(zombies) != 0 {
+		t.Errorf("Expected no zombies left, got %v", zombies)
+	}
+}
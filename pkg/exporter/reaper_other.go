@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package exporter
+
+import "context"
+
+// StartReaper does nothing where processes cannot be made subreapers, their
+// orphans being reaped by init.
+func StartReaper(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,46 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestProcessMetrics(t *testing.T) {
+	count := func(counter prometheus.Counter) float64 {
+		var metric dto.Metric
+		counter.Write(&metric)
+		return metric.Counter.GetValue()
+	}
+	killed, failed := count(killedProcesses), count(startFailures)
+
+	measurements := make(chan *Measurement, 1)
+	script := &Script{Name: "hang", Content: "sleep 5", Timeout: 1}
+	go func() { measurements <- testExporter.runScripts([]*Script{script}, "")[0] }()
+
+	time.Sleep(300 * time.Millisecond)
+	if running := children.count(); running != 1 {
+		t.Errorf("Expected 1 running process, got %d", running)
+	}
+	if m := <-measurements; m.ErrorReason != reasonTimeout {
+		t.Fatalf("Expected the script to time out, got %q", m.ErrorReason)
+	}
+	if after := count(killedProcesses); after-killed != 1 {
+		t.Errorf("Expected 1 process killed on timeout, got %g", after-killed)
+	}
+	if running := children.count(); running != 0 {
+		t.Errorf("Expected no running process, got %d", running)
+	}
+
+	// A shell that cannot be executed fails the run instead of the exporter.
+	e := New("")
+	e.Shell = "/nonexistent/sh"
+	if m := e.runScripts([]*Script{{Name: "start", Content: "exit 0", Timeout: 1}}, "")[0]; m.ErrorReason != reasonStartFailure {
+		t.Errorf("Expected a start failure, got %q", m.ErrorReason)
+	}
+	if after := count(startFailures); after-failed != 1 {
+		t.Errorf("Expected 1 start failure, got %g", after-failed)
+	}
+}
@@ -0,0 +1,177 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// metricNameLabel holds the metric name during relabeling.
+const metricNameLabel = "__name__"
+
+// Actions of a RelabelConfig.
+const (
+	relabelReplace   = "replace"
+	relabelKeep      = "keep"
+	relabelDrop      = "drop"
+	relabelLabelDrop = "labeldrop"
+	relabelLabelKeep = "labelkeep"
+)
+
+// RelabelConfig is a rule applied to the metrics a script writes to stdout,
+// following Prometheus' metric_relabel_configs. The metric name is available
+// as the label __name__.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,omitempty"`
+	Separator    string   `yaml:"separator,omitempty"`
+	Regex        string   `yaml:"regex,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+
+	// Action is one of "replace" (the default), "keep", "drop", "labeldrop"
+	// and "labelkeep".
+	Action string `yaml:"action,omitempty"`
+
+	regexp *regexp.Regexp
+}
+
+func (c *RelabelConfig) compile() (err error) {
+	if c.Action == "" {
+		c.Action = relabelReplace
+	}
+	if c.Separator == "" {
+		c.Separator = ";"
+	}
+	if c.Regex == "" {
+		c.Regex = "(.*)"
+	}
+	if c.Replacement == "" {
+		c.Replacement = "$1"
+	}
+
+	if c.regexp, err = regexp.Compile("^(?:" + c.Regex + ")$"); err != nil {
+		return fmt.Errorf("invalid regex: %s", err)
+	}
+
+	switch c.Action {
+	case relabelReplace:
+		if c.TargetLabel != metricNameLabel && !labelNameRegexp.MatchString(c.TargetLabel) {
+			return fmt.Errorf("invalid target_label %q", c.TargetLabel)
+		}
+	case relabelKeep, relabelDrop:
+		if len(c.SourceLabels) == 0 {
+			return fmt.Errorf("%s requires source_labels", c.Action)
+		}
+	case relabelLabelDrop, relabelLabelKeep:
+	default:
+		return fmt.Errorf("unknown action %q", c.Action)
+	}
+
+	return nil
+}
+
+// apply relabels labels in place, returning false if the series is dropped.
+func (c *RelabelConfig) apply(labels map[string]string) bool {
+	values := make([]string, len(c.SourceLabels))
+	for i, name := range c.SourceLabels {
+		values[i] = labels[name]
+	}
+	value := strings.Join(values, c.Separator)
+
+	switch c.Action {
+	case relabelKeep:
+		return c.regexp.MatchString(value)
+	case relabelDrop:
+		return !c.regexp.MatchString(value)
+	case relabelLabelDrop, relabelLabelKeep:
+		for name := range labels {
+			if name != metricNameLabel && c.regexp.MatchString(name) == (c.Action == relabelLabelDrop) {
+				delete(labels, name)
+			}
+		}
+	default:
+		match := c.regexp.FindStringSubmatchIndex(value)
+		if match == nil {
+			return true
+		}
+		replacement := string(c.regexp.ExpandString(nil, c.Replacement, value, match))
+		if replacement == "" {
+			delete(labels, c.TargetLabel)
+		} else {
+			labels[c.TargetLabel] = replacement
+		}
+	}
+	return true
+}
+
+// outputMetrics parses stdout of a run as metrics in the Prometheus text format
+// and applies the script's relabel rules to them.
+func (s *Script) outputMetrics(stdout string) ([]*dto.MetricFamily, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(stdout))
+	if err != nil {
+		return nil, err
+	}
+
+	relabeled := make(map[string]*dto.MetricFamily)
+	for _, name := range sortedFamilyNames(families) {
+		family := families[name]
+
+		for _, metric := range family.Metric {
+			labels := map[string]string{metricNameLabel: name}
+			for _, pair := range metric.Label {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+
+			keep := true
+			for _, config := range s.MetricRelabelConfigs {
+				if keep = config.apply(labels); !keep {
+					break
+				}
+			}
+			if !keep {
+				continue
+			}
+
+			newName := labels[metricNameLabel]
+			delete(labels, metricNameLabel)
+			if !metricNameRegexp.MatchString(newName) {
+				return nil, fmt.Errorf("relabeling %s produced invalid metric name %q", name, newName)
+			}
+
+			out := relabeled[newName]
+			if out == nil {
+				out = &dto.MetricFamily{Name: proto.String(newName), Help: family.Help, Type: family.Type}
+				relabeled[newName] = out
+			} else if out.GetType() != family.GetType() {
+				return nil, fmt.Errorf("relabeling %s into %s mixes metric types", name, newName)
+			}
+
+			metric.Label = metric.Label[:0]
+			for _, labelName := range sortedKeys(labels) {
+				metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(labelName), Value: proto.String(labels[labelName])})
+			}
+			out.Metric = append(out.Metric, metric)
+		}
+	}
+
+	result := make([]*dto.MetricFamily, 0, len(relabeled))
+	for _, name := range sortedFamilyNames(relabeled) {
+		result = append(result, relabeled[name])
+	}
+	return result, nil
+}
+
+func sortedFamilyNames(families map[string]*dto.MetricFamily) []string {
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
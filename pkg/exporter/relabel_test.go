@@ -0,0 +1,75 @@
+package exporter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestOutputMetricsRelabel(t *testing.T) {
+	script := &Script{
+		Name:          "relabel",
+		Content:       "printf 'tool_requests_total{path=\"/a\",pid=\"1\"} 3\\ntool_requests_total{path=\"/debug\",pid=\"2\"} 1\\ntool_up 1\\n'",
+		OutputMetrics: true,
+		MetricRelabelConfigs: []*RelabelConfig{
+			{SourceLabels: []string{"path"}, Regex: "/debug.*", Action: "drop"},
+			{Regex: "pid", Action: "labeldrop"},
+			{SourceLabels: []string{"__name__"}, Regex: "tool_(.*)", TargetLabel: "__name__", Replacement: "wrapped_$1"},
+			{TargetLabel: "source", Replacement: "tool"},
+		},
+	}
+	if err := script.setDefaults(); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	measurement := testExporter.runScripts([]*Script{script}, "")[0]
+
+	if measurement.Success != 1 {
+		t.Fatalf("Expected success, got error reason %q: %s", measurement.ErrorReason, measurement.Output)
+	}
+
+	var buf bytes.Buffer
+	WriteMeasurement(&buf, measurement)
+	out := buf.String()
+
+	for _, expected := range []string{
+		`wrapped_requests_total{path="/a",source="tool"} 3`,
+		`wrapped_up{source="tool"} 1`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %s in:\n%s", expected, out)
+		}
+	}
+
+	for _, unexpected := range []string{"/debug", "pid=", "tool_up"} {
+		if strings.Contains(out, unexpected) {
+			t.Errorf("Unexpected %s in:\n%s", unexpected, out)
+		}
+	}
+}
+
+func TestOutputMetricsParseError(t *testing.T) {
+	script := &Script{Name: "unparsable", Content: "echo not metrics", OutputMetrics: true}
+	if err := script.setDefaults(); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	measurement := testExporter.runScripts([]*Script{script}, "")[0]
+
+	if measurement.ErrorReason != reasonOutputParseError {
+		t.Errorf("Expected reason %q, got %q", reasonOutputParseError, measurement.ErrorReason)
+	}
+}
+
+func TestRelabelConfigCompile(t *testing.T) {
+	for _, config := range []*RelabelConfig{
+		{Action: "hashmod"},
+		{Action: "keep"},
+		{TargetLabel: "1invalid"},
+		{Regex: "(", TargetLabel: "valid"},
+	} {
+		if err := config.compile(); err == nil {
+			t.Errorf("Expected error for %+v", config)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Events on watched files are collected for this long before reloading, as
+// editors and config management tools often write a file in several steps.
+const watchDebounce = 200 * time.Millisecond
+
+// Time allowed for the syntax check of a single script.
+const dryRunTimeout = 5 * time.Second
+
+// syntaxCheckNone as syntax_check disables the syntax check of a script.
+const syntaxCheckNone = "none"
+
+var (
+	configLastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "script_exporter_config_last_reload_successful",
+		Help: "Whether the last configuration reload attempt was successful.",
+	})
+	configLastReloadSuccessTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "script_exporter_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload.",
+	})
+	requirementsMet = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "script_requirements_met",
+		Help: "Whether all binaries a script requires were found in PATH when the configuration was loaded.",
+	}, []string{"script"})
+	configHash = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "script_exporter_config_hash",
+		Help: "SHA-256 of the files the active configuration was loaded from.",
+	}, []string{"hash"})
+	configGeneration = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "script_exporter_config_generation_total",
+		Help: "Number of configurations activated since startup, the initial one included.",
+	})
+	scriptInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "script_info",
+		Help: "Configuration of a script: what it is run with, its timeout in seconds and a hash of its content.",
+	}, []string{"script", "interpreter", "timeout", "version_hash"})
+)
+
+func init() {
+	prometheus.MustRegister(configLastReloadSuccessful)
+	prometheus.MustRegister(configLastReloadSuccessTimestamp)
+	prometheus.MustRegister(requirementsMet)
+	prometheus.MustRegister(scriptInfo)
+	prometheus.MustRegister(configHash)
+	prometheus.MustRegister(configGeneration)
+}
+
+// Reload loads the config file and swaps it in. If the new config is invalid,
+// or fails the dry run when enabled, the previous config stays active.
+func (e *Exporter) Reload() error {
+	e.apiMu.Lock()
+	defer e.apiMu.Unlock()
+
+	scripts, err := e.loadAPIScripts()
+	if err != nil {
+		return err
+	}
+	return e.reload(scripts)
+}
+
+// reload is Reload with apiScripts as the scripts added through
+// /api/v1/scripts. apiMu must be held, so that a config loaded with scripts
+// the API has since replaced is never activated.
+func (e *Exporter) reload(apiScripts []*Script) error {
+	if e.MetricPrefix != "" && !metricNameRegexp.MatchString(e.MetricPrefix) {
+		return fmt.Errorf("invalid metric prefix %q", e.MetricPrefix)
+	}
+
+	if err := validateBuckets(e.DurationBuckets); err != nil {
+		return fmt.Errorf("duration buckets: %s", err)
+	}
+
+	options := e.loadOptions()
+	options.apiScripts = apiScripts
+
+	config, err := loadConfig(append([]string{e.ConfigFile}, e.AdditionalConfigFiles...), options)
+	if err == nil {
+		for _, script := range config.allScripts() {
+			script.metricPrefix = e.MetricPrefix
+		}
+	}
+
+	if err == nil && e.DryRun {
+		err = e.dryRun(config)
+	} else if err == nil && e.SyntaxCheck {
+		err = e.checkSyntax(config)
+	}
+
+	if err != nil {
+		configLastReloadSuccessful.Set(0)
+		return err
+	}
+
+	e.mu.Lock()
+	if e.config != nil {
+		config.generation = e.config.generation
+	}
+	config.generation++
+	e.config = config
+	e.mu.Unlock()
+
+	results.setTTL(e.ResultsTTL)
+	results.prune(config)
+	durations.prune(config)
+
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccessTimestamp.Set(float64(config.loadedAt.Unix()))
+	configHash.Reset()
+	configHash.WithLabelValues(config.hash).Set(1)
+	configGeneration.Inc()
+	checkRequirements(config)
+	e.exportScriptInfo(config)
+	e.watchKeys()
+	e.writeFileSD()
+
+	log.Printf("Loaded %d script configurations and %d modules\n", len(config.Scripts), len(config.Modules))
+
+	return nil
+}
+
+// reloadHandler reloads the configuration on POST or PUT /-/reload.
+func (e *Exporter) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "Only POST and PUT requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := e.Reload(); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to reload config: %s", err), http.StatusInternalServerError)
+		log.Printf("ERROR: reloading config through /-/reload failed, keeping previous configuration: %s\n", err)
+		return
+	}
+}
+
+func (e *Exporter) loadOptions() loadOptions {
+	return loadOptions{
+		expandEnv:   e.ExpandEnv,
+		format:      e.ConfigFormat,
+		signingKeys: e.SigningKeys,
+		remote: remoteOptions{
+			cacheDir:        e.RemoteCacheDir,
+			bearerTokenFile: e.RemoteBearerTokenFile,
+		},
+	}
+}
+
+// Watch reloads the config whenever the config file or a script file it
+// references is written, created or renamed. Parent directories are watched
+// rather than the files themselves so that files replaced by a rename are
+// still followed.
+func (e *Exporter) Watch() error {
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return err
+	}
+
+	files := e.watchFiles(watcher, nil)
+
+	go func() {
+		var debounce <-chan time.Time
+
+		for {
+			select {
+			case event := <-watcher.Events:
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				if _, ok := files[filepath.Clean(event.Name)]; ok || symlinksChanged(files) {
+					debounce = time.After(watchDebounce)
+				}
+			case err := <-watcher.Errors:
+				log.Printf("ERROR: watching config files: %s\n", err)
+			case <-debounce:
+				debounce = nil
+				if err := e.Reload(); err != nil {
+					log.Printf("ERROR: reloading config file failed, keeping previous configuration: %s\n", err)
+					continue
+				}
+				files = e.watchFiles(watcher, files)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// watchFiles adds the directories of all files of the active config to
+// watcher and returns the files to reload on, mapped to the paths their
+// symlinks resolve to.
+func (e *Exporter) watchFiles(watcher *fsnotify.Watcher, previous map[string]string) map[string]string {
+	files := make(map[string]string)
+
+	for _, file := range e.Config().files {
+		if isRemote(file) {
+			continue
+		}
+		file = filepath.Clean(file)
+		files[file] = resolveSymlinks(file)
+
+		if _, ok := previous[file]; ok {
+			continue
+		}
+		if err := watcher.Add(filepath.Dir(file)); err != nil {
+			log.Printf("ERROR: watching %s: %s\n", file, err)
+		}
+	}
+
+	return files
+}
+
+// resolveSymlinks returns the path file resolves to, or "" if it cannot be
+// resolved.
+func resolveSymlinks(file string) string {
+	resolved, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return ""
+	}
+	return resolved
+}
+
+// symlinksChanged tells whether any of files resolves to another path than
+// when it was watched. Kubernetes updates a mounted ConfigMap by swapping the
+// ..data symlink in its directory that the files link through, so that the
+// files themselves see no event.
+func symlinksChanged(files map[string]string) bool {
+	for file, resolved := range files {
+		if resolveSymlinks(file) != resolved {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRequirements looks up the binaries the scripts of config require in
+// their path and PATH, logging those that are missing, and exports the
+// result per script.
+func checkRequirements(config *Config) {
+	requirementsMet.Reset()
+
+	for _, script := range config.allScripts() {
+		if len(script.Requires) == 0 {
+			continue
+		}
+
+		met := true
+		for _, binary := range script.Requires {
+			if _, err := lookPath(script, binary); err != nil {
+				log.Printf("ERROR: script %s requires %s: %s\n", script.Name, binary, err)
+				met = false
+			}
+		}
+		requirementsMet.WithLabelValues(script.Name).Set(float64(boolToInt(met)))
+	}
+}
+
+// exportScriptInfo exports script_info for every script of config, so that
+// results can be joined with the configuration they were measured with.
+func (e *Exporter) exportScriptInfo(config *Config) {
+	scriptInfo.Reset()
+
+	for _, script := range config.allScripts() {
+		interpreter := script.Runner
+		if interpreter == "" || interpreter == runnerShell {
+			interpreter = strings.Join(append([]string{e.Shell}, script.InterpreterArgs...), " ")
+		}
+		sum := sha256.Sum256([]byte(script.Content))
+		scriptInfo.WithLabelValues(script.Name, interpreter, strconv.FormatInt(script.Timeout, 10), hex.EncodeToString(sum[:6])).Set(1)
+	}
+}
+
+// dryRun checks a loaded config more thoroughly than LoadConfig before it is
+// activated: every script must pass its syntax check. Script files have
+// already been read, so a missing file fails loading itself.
+func (e *Exporter) dryRun(config *Config) error {
+	return e.checkSyntax(config)
+}
+
+// checkSyntax runs the syntax check of every script of config.
+func (e *Exporter) checkSyntax(config *Config) error {
+	for _, script := range config.allScripts() {
+		if err := e.syntaxCheck(script); err != nil {
+			return fmt.Errorf("script %s: %s", script.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// syntaxCheck feeds script to its syntax_check command, or else to the shell
+// with the arguments that make it only check the syntax, where it has them.
+// Scripts of the starlark runner are parsed instead, and WebAssembly modules
+// are not checked. Other runners run scripts with a shell or interpreter the
+// local one says nothing about, so they require a syntax_check.
+func (e *Exporter) syntaxCheck(script *Script) error {
+	// Templates are checked as rendered without a target.
+	content, err := script.render("", nil)
+	if err != nil {
+		return err
+	}
+
+	name, args := e.Shell, syntaxCheckArgs()
+	switch script.SyntaxCheck {
+	case "":
+		switch script.Runner {
+		case "", runnerShell:
+		case runnerStarlark:
+			return checkStarlark(script, content)
+		case runnerWASM:
+			return nil
+		default:
+			return fmt.Errorf("runner %s requires a syntax_check, such as none", script.Runner)
+		}
+		if args == nil {
+			return nil
+		}
+		// Options the shell does not support fail the check.
+		args = append(append([]string(nil), script.InterpreterArgs...), args...)
+	case syntaxCheckNone:
+		return nil
+	default:
+		fields := strings.Fields(script.SyntaxCheck)
+		name, args = fields[0], fields[1:]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dryRunTimeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = strings.NewReader(content)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err = children.run(cmd); err != nil {
+		return fmt.Errorf("syntax check failed: %s: %s", err, strings.TrimSpace(output.String()))
+	}
+
+	return nil
+}
@@ -0,0 +1,291 @@
+package exporter
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestWatch(t *testing.T) {
+	path := writeConfig(t, `
+scripts:
+  - name: first
+    script_file: first.sh
+`)
+	scriptPath := filepath.Join(filepath.Dir(path), "first.sh")
+	if err := ioutil.WriteFile(scriptPath, []byte("exit 0"), 0644); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	e := New(path)
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+	if err := e.Watch(); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	waitFor := func(condition func(*Config) bool) bool {
+		for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+			if condition(e.Config()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Changing a referenced script file reloads its content.
+	if err := ioutil.WriteFile(scriptPath, []byte("exit 1"), 0644); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+	if !waitFor(func(c *Config) bool { return c.Scripts[0].Content == "exit 1" }) {
+		t.Fatalf("Expected script file change to be picked up")
+	}
+
+	// An invalid config keeps the previous one active.
+	previous := e.Config()
+	if err := ioutil.WriteFile(path, []byte("scripts: ["), 0644); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+	time.Sleep(4 * watchDebounce)
+	if e.Config() != previous {
+		t.Errorf("Expected invalid config to be rejected")
+	}
+
+	// Fixing the config reloads it.
+	if err := ioutil.WriteFile(path, []byte("scripts: [{name: second, script: exit 0}]"), 0644); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+	if !waitFor(func(c *Config) bool { return c.Scripts[0].Name == "second" }) {
+		t.Errorf("Expected fixed config to be loaded")
+	}
+}
+
+func TestWatchConfigMap(t *testing.T) {
+	// A mounted ConfigMap links its files through the ..data symlink to a
+	// timestamped directory, and is updated by swapping the symlink.
+	dir := t.TempDir()
+	writeVersion := func(version, content string) {
+		if err := os.Mkdir(filepath.Join(dir, version), 0755); err != nil {
+			t.Fatalf("Unexpected: %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, version, "config.yml"), []byte(content), 0644); err != nil {
+			t.Fatalf("Unexpected: %s", err)
+		}
+		if err := os.Symlink(version, filepath.Join(dir, "..data_tmp")); err != nil {
+			t.Fatalf("Unexpected: %s", err)
+		}
+		if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+			t.Fatalf("Unexpected: %s", err)
+		}
+	}
+	writeVersion("..2021_01_01", "scripts: [{name: first, script: exit 0}]")
+	if err := os.Symlink("..data/config.yml", filepath.Join(dir, "config.yml")); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	e := New(filepath.Join(dir, "config.yml"))
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	if err := e.Watch(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	writeVersion("..2021_01_02", "scripts: [{name: second, script: exit 0}]")
+
+	for deadline := time.Now().Add(3 * time.Second); e.Config().Scripts[0].Name != "second"; time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the ConfigMap update to be picked up")
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		config *Config
+		valid  bool
+	}{
+		{"Valid", &Config{Scripts: []*Script{{Name: "a", Content: "exit 0"}, {Name: "b", Content: "if true; then exit 0; fi"}}}, true},
+		{"SyntaxError", &Config{Scripts: []*Script{{Name: "a", Content: "if true; then exit 0"}}}, false},
+		{"ModuleSyntaxError", &Config{Modules: []*Module{{Script: Script{Name: "m", Content: "fi"}}}}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := testExporter.dryRun(test.config); (err == nil) != test.valid {
+				t.Errorf("Expected valid %t, got %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func TestSyntaxCheck(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		config string
+		valid  bool
+	}{
+		{"Valid", "scripts:\n  - name: a\n    script: 'if true; then exit 0; fi'\n", true},
+		{"SyntaxError", "scripts:\n  - name: broken\n    script: 'if true; then exit 0'\n", false},
+		{"Disabled", "scripts:\n  - name: broken\n    script: 'if true; then exit 0'\n    syntax_check: none\n", true},
+		{"CustomCheck", "scripts:\n  - name: python\n    script: 'print(1'\n    syntax_check: grep -q '^#!'\n", false},
+		{"InterpreterArgs", "scripts:\n  - name: strict\n    script: 'exit 0'\n    interpreter_args: [-e, -u]\n", true},
+		{"UnsupportedInterpreterArgs", "scripts:\n  - name: strict\n    script: 'exit 0'\n    interpreter_args: [-o, no-such-option]\n", false},
+		{"RemoteRunner", "scripts:\n  - name: remote\n    script: 'exit 0'\n    runner: ssh\n    ssh: {user: prometheus, use_agent: true}\n", false},
+		{"RemoteRunnerCheck", "scripts:\n  - name: remote\n    script: 'exit 0'\n    runner: ssh\n    ssh: {user: prometheus, use_agent: true}\n    syntax_check: sh -n\n", true},
+		{"RemoteRunnerDisabled", "scripts:\n  - name: remote\n    script: 'if'\n    runner: ssh\n    ssh: {user: prometheus, use_agent: true}\n    syntax_check: none\n", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := New(writeConfig(t, test.config)).Reload()
+			if (err == nil) != test.valid {
+				t.Errorf("Expected valid %t, got %v", test.valid, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "script ") {
+				t.Errorf("Expected the script name in the error, got %s", err)
+			}
+		})
+	}
+}
+
+func TestRequirements(t *testing.T) {
+	e := New(writeConfig(t, `
+scripts:
+  - name: met
+    script: exit 0
+    requires: [sh]
+  - name: unmet
+    script: exit 0
+    requires: [sh, ndt7-client-does-not-exist]
+`))
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	for name, expected := range map[string]float64{"met": 1, "unmet": 0} {
+		var metric dto.Metric
+		if err := requirementsMet.WithLabelValues(name).Write(&metric); err != nil {
+			t.Fatalf("Unexpected: %s", err.Error())
+		}
+		if metric.GetGauge().GetValue() != expected {
+			t.Errorf("Expected script_requirements_met %g for %s, got %g", expected, name, metric.GetGauge().GetValue())
+		}
+	}
+}
+
+func TestScriptInfo(t *testing.T) {
+	e := New(writeConfig(t, `
+scripts:
+  - name: strict
+    script: exit 0
+    timeout: 30
+    interpreter_args: [-e]
+  - name: starlark
+    runner: starlark
+    script: exit(0)
+`))
+	e.Shell = "/bin/sh"
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	metrics := gatherDefault(t)
+	for _, expected := range []string{
+		`script_info{interpreter="/bin/sh -e",script="strict",timeout="30",version_hash="` + fmt.Sprintf("%x", sha256.Sum256([]byte("exit 0")))[:12] + `"} 1`,
+		`script_info{interpreter="starlark",script="starlark",timeout="15",version_hash="`,
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("Expected %s in:\n%s", expected, metrics)
+		}
+	}
+
+	// Scripts removed by a reload are no longer described.
+	writeFiles(t, "", map[string]string{e.ConfigFile: "scripts:\n  - name: strict\n    script: exit 0\n"})
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+	if metrics := gatherDefault(t); strings.Contains(metrics, `script="starlark"`) {
+		t.Errorf("Expected the removed script to be gone:\n%s", metrics)
+	}
+}
+
+func TestConfigGeneration(t *testing.T) {
+	// Other tests activate configurations too.
+	generations := func() float64 {
+		var m dto.Metric
+		configGeneration.Write(&m)
+		return m.GetCounter().GetValue()
+	}
+	initial := generations()
+
+	e := New(writeConfig(t, "scripts:\n  - name: a\n    script: exit 0\n"))
+	for generation := 1; generation <= 2; generation++ {
+		if err := e.Reload(); err != nil {
+			t.Fatalf("Unexpected: %s", err.Error())
+		}
+		if expected := fmt.Sprintf(`script_exporter_config_hash{hash="%s"} 1`, e.Config().hash); !strings.Contains(gatherDefault(t), expected) {
+			t.Errorf("Expected %s in:\n%s", expected, gatherDefault(t))
+		}
+		if activated := generations() - initial; activated != float64(generation) {
+			t.Errorf("Expected %d activations to be counted, got %v", generation, activated)
+		}
+	}
+
+	// A failed reload keeps the generation and the hash.
+	hash := e.Config().hash
+	writeFiles(t, "", map[string]string{e.ConfigFile: "scripts: ["})
+	if err := e.Reload(); err == nil {
+		t.Fatal("Expected the reload to fail")
+	}
+	if metrics := gatherDefault(t); generations()-initial != 2 || strings.Count(metrics, "script_exporter_config_hash{") != 1 || !strings.Contains(metrics, hash) {
+		t.Errorf("Expected the active configuration to be reported:\n%s", metrics)
+	}
+
+	w := httptest.NewRecorder()
+	configHandler(w, httptest.NewRequest("GET", "/config", nil), e.Config())
+	if !strings.Contains(w.Body.String(), "# generation: 2\n") {
+		t.Errorf("Expected the generation on /config:\n%s", w.Body.String())
+	}
+}
+
+func TestReloadHandler(t *testing.T) {
+	path := writeConfig(t, "scripts:\n  - name: first\n    script: exit 0\n")
+	e := New(path)
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	rr := httptest.NewRecorder()
+	e.reloadHandler(rr, httptest.NewRequest("GET", "/-/reload", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to be refused, got %d", rr.Code)
+	}
+
+	writeFiles(t, "", map[string]string{path: "scripts:\n  - name: second\n    script: exit 0\n"})
+	rr = httptest.NewRecorder()
+	e.reloadHandler(rr, httptest.NewRequest("POST", "/-/reload", nil))
+	if rr.Code != http.StatusOK || e.Config().Script("second") == nil {
+		t.Errorf("Expected POST to reload the config, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	writeFiles(t, "", map[string]string{path: "scripts: ["})
+	rr = httptest.NewRecorder()
+	e.reloadHandler(rr, httptest.NewRequest("PUT", "/-/reload", nil))
+	if rr.Code != http.StatusInternalServerError || e.Config().Script("second") == nil {
+		t.Errorf("Expected an invalid config to fail keeping the previous one, got %d", rr.Code)
+	}
+
+	mux := http.NewServeMux()
+	e.RegisterPublicHandlers(mux)
+	if _, pattern := mux.Handler(httptest.NewRequest("GET", "/probe", nil)); pattern != "/" {
+		t.Errorf("Expected /probe not to be a public handler, got %q", pattern)
+	}
+}
@@ -0,0 +1,204 @@
+package exporter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// remoteTimeout limits fetching a remote config file.
+const remoteTimeout = 30 * time.Second
+
+// DefaultRemoteCacheDir is where the last fetched copy of remote config files
+// is kept by default.
+var DefaultRemoteCacheDir = filepath.Join(os.TempDir(), "script_exporter-config")
+
+// isRemote tells whether the config file path is a URL to fetch it from.
+func isRemote(path string) bool {
+	for _, scheme := range []string{"http://", "https://", "s3://", "gs://", "consul://", "etcd://"} {
+		if strings.HasPrefix(path, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// remotePath returns the path part of the URL of a remote config file, which
+// tells its format.
+func remotePath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return path.Join(u.Host, u.Path)
+}
+
+// remoteOptions configure fetching remote config files.
+type remoteOptions struct {
+	// cacheDir keeps the last fetched copy of each remote file, used if it
+	// cannot be fetched.
+	cacheDir string
+
+	// bearerTokenFile holds the token sent to http://, https:// and gs://
+	// URLs.
+	bearerTokenFile string
+}
+
+// readRemote fetches the remote config file at rawURL, falling back to the
+// cached copy if that fails.
+func (o remoteOptions) readRemote(rawURL string) ([]byte, error) {
+	cache := ""
+	if o.cacheDir != "" {
+		sum := sha256.Sum256([]byte(rawURL))
+		cache = filepath.Join(o.cacheDir, hex.EncodeToString(sum[:8])+path.Ext(remotePath(rawURL)))
+	}
+
+	data, err := o.fetch(rawURL)
+	if err != nil {
+		if cache == "" {
+			return nil, err
+		}
+		cached, cacheErr := ioutil.ReadFile(cache)
+		if cacheErr != nil {
+			return nil, err
+		}
+		log.Printf("ERROR: fetching %s failed, using the cached copy: %s\n", rawURL, err)
+		return cached, nil
+	}
+
+	if cache != "" {
+		if err := os.MkdirAll(o.cacheDir, 0700); err != nil {
+			log.Printf("ERROR: caching %s: %s\n", rawURL, err)
+		} else if err := ioutil.WriteFile(cache, data, 0600); err != nil {
+			log.Printf("ERROR: caching %s: %s\n", rawURL, err)
+		}
+	}
+
+	return data, nil
+}
+
+// fetch downloads the remote config file at rawURL.
+func (o remoteOptions) fetch(rawURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+
+	req, err := o.newRequest(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err == nil && strings.HasPrefix(rawURL, "etcd://") {
+		return readEtcdValue(data)
+	}
+	return data, err
+}
+
+// newRequest returns the authenticated request fetching rawURL, mapping s3://
+// and gs:// URLs to the HTTPS endpoints of the buckets, and consul:// and
+// etcd:// URLs to the HTTP APIs of the agents.
+func (o remoteOptions) newRequest(ctx context.Context, rawURL string) (*http.Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "s3":
+		return newS3Request(ctx, u.Host, strings.TrimPrefix(u.Path, "/"))
+	case "gs":
+		return o.newGCSRequest(ctx, u.Host, strings.TrimPrefix(u.Path, "/"))
+	case "consul":
+		return newConsulRequest(ctx, u, 0)
+	case "etcd":
+		return newEtcdRequest(ctx, u)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if o.bearerTokenFile != "" {
+		token, err := o.bearerToken()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+func (o remoteOptions) bearerToken() (string, error) {
+	token, err := ioutil.ReadFile(o.bearerTokenFile)
+	if err != nil {
+		return "", err
+	}
+	if len(strings.TrimSpace(string(token))) == 0 {
+		return "", errors.New("empty bearer token file")
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// remoteFiles maps the URLs of the remote config files of c to the SHA-256 of
+// the content they were loaded with.
+func (c *Config) remoteFiles() map[string]string {
+	files := make(map[string]string)
+	for file, sum := range c.sums {
+		if isRemote(file) {
+			files[file] = sum
+		}
+	}
+	return files
+}
+
+// RefreshRemote fetches the remote config files of the active config every
+// interval and reloads the config when one of them changed.
+func (e *Exporter) RefreshRemote(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if e.remoteChanged() {
+				if err := e.Reload(); err != nil {
+					log.Printf("ERROR: reloading changed remote config failed, keeping previous configuration: %s\n", err)
+				}
+			}
+		}
+	}()
+}
+
+// remoteChanged fetches the remote config files of the active config and
+// tells whether any of them differs from the content it was loaded with.
+func (e *Exporter) remoteChanged() bool {
+	options := e.loadOptions().remote
+	for file, loaded := range e.Config().remoteFiles() {
+		data, err := options.fetch(file)
+		if err != nil {
+			log.Printf("ERROR: refreshing %s: %s\n", file, err)
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != loaded {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package exporter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The endpoints remote config files are fetched from, replaced by tests.
+var (
+	// s3Endpoint returns the endpoint of the S3 bucket in region.
+	s3Endpoint = func(bucket, region string) string {
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region)
+	}
+
+	gcsEndpoint = "https://storage.googleapis.com"
+
+	// gceTokenURL serves the access token of the default service account
+	// on Google Compute Engine.
+	gceTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// newS3Request returns the request for object key of an S3 bucket, signed with
+// Signature Version 4 if AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are set.
+// The region is taken from AWS_REGION or AWS_DEFAULT_REGION, us-east-1 by
+// default.
+func newS3Request(ctx context.Context, bucket, key string) (*http.Request, error) {
+	region := awsRegion()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s3Endpoint(bucket, region)+"/"+s3EscapePath(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if credentials, ok := awsEnvCredentials(); ok {
+		signAWSRequest(req, "s3", region, nil, credentials, time.Now())
+	}
+	return req, nil
+}
+
+// awsRegion returns the region from AWS_REGION or AWS_DEFAULT_REGION,
+// us-east-1 by default.
+func awsRegion() string {
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(name); region != "" {
+			return region
+		}
+	}
+	return "us-east-1"
+}
+
+// awsCredentials sign requests to AWS.
+type awsCredentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// awsEnvCredentials returns the credentials in AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, if set.
+func awsEnvCredentials() (awsCredentials, bool) {
+	credentials := awsCredentials{
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	return credentials, credentials.accessKey != "" && credentials.secretKey != ""
+}
+
+// s3EscapePath escapes each segment of key as Signature Version 4 requires.
+func s3EscapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = strings.Replace(url.QueryEscape(segment), "+", "%20", -1)
+	}
+	return strings.Join(segments, "/")
+}
+
+// signAWSRequest adds the Signature Version 4 authorization for service of a
+// request without query and with body payload to req.
+func signAWSRequest(req *http.Request, service, region string, payload []byte, credentials awsCredentials, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	payloadSum := sha256.Sum256(payload)
+	payloadHash := hex.EncodeToString(payloadSum[:])
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if credentials.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, "", canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+	canonicalSum := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalSum[:])
+
+	key := []byte("AWS4" + credentials.secretKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// newGCSRequest returns the request for object name of a Google Cloud Storage
+// bucket, authorized with the bearer token file if set, or else with the
+// token of the default service account when running on Google Compute Engine.
+func (o remoteOptions) newGCSRequest(ctx context.Context, bucket, name string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcsEndpoint+"/"+bucket+"/"+s3EscapePath(name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var token string
+	if o.bearerTokenFile != "" {
+		if token, err = o.bearerToken(); err != nil {
+			return nil, err
+		}
+	} else {
+		token = gceToken(ctx)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// gceToken returns the access token of the default service account from the
+// metadata server, or "" when not running on Google Compute Engine.
+func gceToken(ctx context.Context) string {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gceTokenURL, nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&token) != nil {
+		return ""
+	}
+	return token.AccessToken
+}
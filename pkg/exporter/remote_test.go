@@ -0,0 +1,127 @@
+package exporter
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRemoteConfig(t *testing.T) {
+	var content atomic.Value
+	content.Store("scripts:\n  - name: remote\n    script: exit 0\n")
+	var authorization atomic.Value
+	authorization.Store("")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization.Store(r.Header.Get("Authorization"))
+		if r.URL.Path == "/missing.yml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content.Load().(string)))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	e := New(server.URL + "/config.yml")
+	e.RemoteCacheDir = filepath.Join(dir, "cache")
+	e.RemoteBearerTokenFile = tokenFile
+
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	if name := e.Config().Scripts[0].Name; name != "remote" {
+		t.Errorf("Expected script remote, got %s", name)
+	}
+	if authorization.Load() != "Bearer secret" {
+		t.Errorf("Expected bearer token, got %q", authorization.Load())
+	}
+
+	if e.remoteChanged() {
+		t.Errorf("Expected unchanged remote config")
+	}
+	content.Store("scripts:\n  - name: changed\n    script: exit 0\n")
+	if !e.remoteChanged() {
+		t.Errorf("Expected changed remote config")
+	}
+
+	t.Run("Cache", func(t *testing.T) {
+		cached := New(server.URL + "/config.yml")
+		cached.RemoteCacheDir = e.RemoteCacheDir
+		server.Close()
+
+		if err := cached.Reload(); err != nil {
+			t.Fatalf("Expected the cached copy to be used, got %s", err)
+		}
+		if name := cached.Config().Scripts[0].Name; name != "remote" {
+			t.Errorf("Expected cached script remote, got %s", name)
+		}
+	})
+}
+
+func TestRemoteStatus(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	_, err := LoadConfig(server.URL + "/config.yml")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected 404 error, got %v", err)
+	}
+}
+
+func TestRemoteBuckets(t *testing.T) {
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		w.Write([]byte("{\"scripts\": [{\"name\": \"bucket\", \"script\": \"exit 0\"}]}"))
+	}))
+	defer server.Close()
+
+	defer func(endpoint func(string, string) string, gcs, token string) {
+		s3Endpoint, gcsEndpoint, gceTokenURL = endpoint, gcs, token
+	}(s3Endpoint, gcsEndpoint, gceTokenURL)
+	s3Endpoint = func(bucket, region string) string { return server.URL + "/s3/" + bucket + "/" + region }
+	gcsEndpoint = server.URL + "/gcs"
+	gceTokenURL = server.URL + "/token"
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	os.Setenv("AWS_REGION", "eu-west-1")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	defer os.Unsetenv("AWS_REGION")
+
+	for _, url := range []string{"s3://configs/node a/config.json", "gs://configs/node/config.json"} {
+		config, err := LoadConfig(url)
+		if err != nil {
+			t.Fatalf("Unexpected: %s", err)
+		}
+		if config.Scripts[0].Name != "bucket" {
+			t.Errorf("Expected script bucket from %s, got %s", url, config.Scripts[0].Name)
+		}
+	}
+
+	s3 := requests[0]
+	if s3.URL.Path != "/s3/configs/eu-west-1/node a/config.json" {
+		t.Errorf("Unexpected S3 path %s", s3.URL.Path)
+	}
+	if auth := s3.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(auth, "/eu-west-1/s3/aws4_request") {
+		t.Errorf("Unexpected S3 authorization %q", auth)
+	}
+	if requests[1].URL.Path != "/token" || requests[1].Header.Get("Metadata-Flavor") != "Google" {
+		t.Errorf("Expected a metadata token request, got %s", requests[1].URL)
+	}
+	if requests[2].URL.Path != "/gcs/configs/node/config.json" {
+		t.Errorf("Unexpected GCS path %s", requests[2].URL.Path)
+	}
+}
@@ -0,0 +1,222 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// The least recently recorded results are forgotten once the cache holds more
+// than this, as every target a client probes adds one.
+const resultCacheSize = 10000
+
+// resultCache keeps the latest measurement of every script and target for
+// /metrics, rendered exactly as /probe would render it, so that scheduled
+// scripts need no probe of their own to be scraped.
+type resultCache struct {
+	mu      sync.Mutex
+	results map[resultKey]*cachedResult
+	ttl     time.Duration
+
+	// dirty is set when a result was recorded since the last save.
+	dirty bool
+}
+
+type resultKey struct {
+	script, target string
+}
+
+type cachedResult struct {
+	measurement *Measurement
+	recorded    time.Time
+}
+
+var results = &resultCache{results: make(map[resultKey]*cachedResult)}
+
+func init() {
+	prometheus.MustRegister(results)
+}
+
+// Describe sends no descriptors, making results an unchecked collector, as
+// the set of scripts and their metrics changes with every run.
+func (r *resultCache) Describe(ch chan<- *prometheus.Desc) {}
+
+func (r *resultCache) Collect(ch chan<- prometheus.Metric) {
+	var collected []*dto.MetricFamily
+	for _, result := range r.current(time.Now()) {
+		measurement := result.measurement
+		var buf bytes.Buffer
+		WriteMeasurement(&buf, measurement)
+		if measurement.Script.ExpireAfter > 0 {
+			fmt.Fprintf(&buf, "%s_result_expired{%s} %d\n", measurement.Script.prefix(), metricLabels(measurement.Script, measurement.Target), boolToInt(result.expired))
+		}
+
+		var parser expfmt.TextParser
+		families, err := parser.TextToMetricFamilies(&buf)
+		if err != nil {
+			log.Printf("ERROR: Cannot expose the result of %s: %s\n", measurement.Script.Name, err)
+			continue
+		}
+		for _, family := range families {
+			collected = append(collected, family)
+		}
+	}
+
+	// Scripts with different descriptions sharing metric names do not agree
+	// on their HELP.
+	unifyHelp(collected)
+	for _, family := range collected {
+		collectFamily(ch, family)
+	}
+}
+
+// unifyHelp blanks the HELP of the families whose name others share with a
+// different HELP, as metrics of the same name must have the same HELP.
+func unifyHelp(families []*dto.MetricFamily) {
+	help := make(map[string]string)
+	for _, family := range families {
+		if seen, ok := help[family.GetName()]; ok && seen != family.GetHelp() {
+			help[family.GetName()] = ""
+		} else if !ok {
+			help[family.GetName()] = family.GetHelp()
+		}
+	}
+	for _, family := range families {
+		family.Help = proto.String(help[family.GetName()])
+	}
+}
+
+// exposedResult is a cached measurement and whether it expired.
+type exposedResult struct {
+	measurement *Measurement
+	expired     bool
+}
+
+// current returns the results to expose: those recorded within the
+// expire_after of their script, or else the TTL, and the expired ones of
+// scripts with keep_expired. Other expired results are forgotten.
+func (r *resultCache) current(now time.Time) []exposedResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	exposed := make([]exposedResult, 0, len(r.results))
+	for key, result := range r.results {
+		script := result.measurement.Script
+		ttl := r.ttl
+		if script.ExpireAfter > 0 {
+			ttl = time.Duration(script.ExpireAfter) * time.Second
+		}
+
+		expired := ttl > 0 && now.Sub(result.recorded) > ttl
+		if expired && !script.KeepExpired {
+			delete(r.results, key)
+			continue
+		}
+		exposed = append(exposed, exposedResult{result.measurement, expired})
+	}
+	return exposed
+}
+
+// record makes measurement the latest result of its script and target.
+func (r *resultCache) record(measurement *Measurement) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := resultKey{measurement.Script.Name, measurement.Target}
+	if _, ok := r.results[key]; !ok && len(r.results) >= resultCacheSize {
+		var oldest resultKey
+		for k, result := range r.results {
+			if r.results[oldest] == nil || result.recorded.Before(r.results[oldest].recorded) {
+				oldest = k
+			}
+		}
+		delete(r.results, oldest)
+	}
+	r.results[key] = &cachedResult{measurement: measurement, recorded: time.Now()}
+	r.dirty = true
+}
+
+// latest returns the latest result of script and target, or nil.
+func (r *resultCache) latest(script *Script, target string) *Measurement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if result, ok := r.results[resultKey{script.Name, target}]; ok {
+		return result.measurement
+	}
+	return nil
+}
+
+// setTTL sets the age after which results are no longer exposed. Zero keeps
+// them until their script is removed.
+func (r *resultCache) setTTL(ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ttl = ttl
+}
+
+// prune forgets the results of the scripts config no longer has.
+func (r *resultCache) prune(config *Config) {
+	names := make(map[string]bool)
+	for _, script := range config.allScripts() {
+		names[script.Name] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key := range r.results {
+		if !names[key.script] {
+			delete(r.results, key)
+		}
+	}
+}
+
+// collectFamily sends the samples of family as constant metrics.
+func collectFamily(ch chan<- prometheus.Metric, family *dto.MetricFamily) {
+	for _, metric := range family.Metric {
+		names := make([]string, 0, len(metric.Label))
+		values := make([]string, 0, len(metric.Label))
+		for _, label := range metric.Label {
+			names = append(names, label.GetName())
+			values = append(values, label.GetValue())
+		}
+		desc := prometheus.NewDesc(family.GetName(), family.GetHelp(), names, nil)
+
+		var m prometheus.Metric
+		var err error
+		switch {
+		case metric.Counter != nil:
+			m, err = prometheus.NewConstMetric(desc, prometheus.CounterValue, metric.Counter.GetValue(), values...)
+		case metric.Gauge != nil:
+			m, err = prometheus.NewConstMetric(desc, prometheus.GaugeValue, metric.Gauge.GetValue(), values...)
+		case metric.Untyped != nil:
+			m, err = prometheus.NewConstMetric(desc, prometheus.UntypedValue, metric.Untyped.GetValue(), values...)
+		case metric.Histogram != nil:
+			buckets := make(map[float64]uint64, len(metric.Histogram.Bucket))
+			for _, bucket := range metric.Histogram.Bucket {
+				buckets[bucket.GetUpperBound()] = bucket.GetCumulativeCount()
+			}
+			m, err = prometheus.NewConstHistogram(desc, metric.Histogram.GetSampleCount(), metric.Histogram.GetSampleSum(), buckets, values...)
+		case metric.Summary != nil:
+			quantiles := make(map[float64]float64, len(metric.Summary.Quantile))
+			for _, quantile := range metric.Summary.Quantile {
+				quantiles[quantile.GetQuantile()] = quantile.GetValue()
+			}
+			m, err = prometheus.NewConstSummary(desc, metric.Summary.GetSampleCount(), metric.Summary.GetSampleSum(), quantiles, values...)
+		default:
+			continue
+		}
+		if err != nil {
+			log.Printf("ERROR: Cannot expose %s: %s\n", family.GetName(), err)
+			continue
+		}
+		ch <- m
+	}
+}
@@ -0,0 +1,214 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// gatherDefault renders the metrics of the default registry.
+func gatherDefault(t *testing.T) string {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	var buf bytes.Buffer
+	for _, family := range families {
+		expfmt.MetricFamilyToText(&buf, family)
+	}
+	return buf.String()
+}
+
+func TestExposeResults(t *testing.T) {
+	path := writeConfig(t, `
+scripts:
+  - name: exposed_a
+    labels: {site: lga03}
+    output_metrics: true
+    script: |
+      echo '# HELP exposed_total Things.'
+      echo '# TYPE exposed_total counter'
+      echo 'exposed_total 7'
+  - name: exposed_b
+    script: exit 3
+`)
+	e := New(path)
+	e.ExposeResults = true
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	e.runScripts(e.Config().Scripts, "mlab1.lga03")
+
+	metrics := gatherDefault(t)
+	for _, expected := range []string{
+		`script_success{script="exposed_a",site="lga03"} 1`,
+		`script_success{script="exposed_b"} 0`,
+		`script_exit_code{script="exposed_b"} 3`,
+		`script_error{reason="nonzero_exit",script="exposed_b"} 1`,
+		"# HELP exposed_total Things.\n# TYPE exposed_total counter\nexposed_total 7",
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, metrics)
+		}
+	}
+
+	// Results of removed scripts are forgotten on reload.
+	writeFiles(t, "", map[string]string{path: "scripts:\n  - name: exposed_a\n    script: exit 0\n"})
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	metrics = gatherDefault(t)
+	if strings.Contains(metrics, `script_success{script="exposed_b"}`) {
+		t.Errorf("Expected the result of the removed script to be gone:\n%s", metrics)
+	}
+	if !strings.Contains(metrics, `script_success{script="exposed_a"`) {
+		t.Errorf("Expected the result of the kept script:\n%s", metrics)
+	}
+
+	// Results older than the TTL expire.
+	e.ResultsTTL = 100 * time.Millisecond
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if metrics := gatherDefault(t); strings.Contains(metrics, `script_success{script="exposed_a"`) {
+		t.Errorf("Expected the result to expire:\n%s", metrics)
+	}
+	e.ResultsTTL = 0
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+}
+
+func TestResultCacheSize(t *testing.T) {
+	cache := &resultCache{results: make(map[resultKey]*cachedResult)}
+	script := &Script{Name: "probed"}
+	for i := 0; i <= resultCacheSize; i++ {
+		cache.record(&Measurement{Script: script, Target: fmt.Sprintf("target-%d", i)})
+	}
+
+	if n := len(cache.results); n != resultCacheSize {
+		t.Errorf("Expected %d results, got %d", resultCacheSize, n)
+	}
+	if cache.latest(script, fmt.Sprintf("target-%d", resultCacheSize)) == nil {
+		t.Errorf("Expected the latest result to be kept")
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	e := New("")
+	e.ExposeResults = true
+	defer results.prune(&Config{})
+
+	succeeded := e.runScripts([]*Script{{Name: "heartbeat", Content: "exit 0", Timeout: 5}}, "")[0]
+	failed := e.runScripts([]*Script{{Name: "heartbeat", Content: "exit 1", Timeout: 5}}, "")[0]
+	if !failed.LastSuccess.Equal(succeeded.end()) {
+		t.Errorf("Expected the failed run to keep the last success at %s, got %s", succeeded.end(), failed.LastSuccess)
+	}
+
+	var out bytes.Buffer
+	WriteMeasurement(&out, failed)
+	for _, expected := range []string{
+		fmt.Sprintf(`script_last_success_timestamp_seconds{script="heartbeat"} %f`, unixSeconds(succeeded.end())),
+		fmt.Sprintf(`script_last_run_timestamp_seconds{script="heartbeat"} %f`, unixSeconds(failed.end())),
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in:\n%s", expected, out.String())
+		}
+	}
+
+	// Without a known success, none is reported.
+	out.Reset()
+	WriteMeasurement(&out, e.runScripts([]*Script{{Name: "never", Content: "exit 1", Timeout: 5}}, "")[0])
+	if strings.Contains(out.String(), "last_success") {
+		t.Errorf("Expected no last success:\n%s", out.String())
+	}
+}
+
+func TestDescription(t *testing.T) {
+	described := &Script{Name: "ndt", Description: "Checks the NDT5\nTLS handshake.", Content: "echo 'ndt_rtt_seconds 0.1'", OutputMetrics: true, Timeout: 5}
+	measurement := testExporter.runScripts([]*Script{described}, "")[0]
+
+	var out bytes.Buffer
+	WriteMeasurement(&out, measurement)
+	for _, expected := range []string{
+		"# HELP script_success Checks the NDT5\\nTLS handshake.\nscript_success{",
+		"# HELP script_error Checks the NDT5\\nTLS handshake.\nscript_error{",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in:\n%s", expected, out.String())
+		}
+	}
+	if n := strings.Count(out.String(), "# HELP script_error "); n != 1 {
+		t.Errorf("Expected a single HELP line per metric, got %d", n)
+	}
+	if strings.Contains(out.String(), "# HELP ndt_rtt_seconds") {
+		t.Errorf("Expected the metrics of the output to be left alone:\n%s", out.String())
+	}
+
+	// The description is the HELP of the exposed results unless scripts
+	// sharing metric names disagree.
+	e := New("")
+	e.ExposeResults = true
+	defer results.prune(&Config{})
+	e.runScripts([]*Script{described}, "")
+	if metrics := gatherDefault(t); !strings.Contains(metrics, "# HELP script_success Checks the NDT5\\nTLS handshake.") {
+		t.Errorf("Expected the description as HELP:\n%s", metrics)
+	}
+	e.runScripts([]*Script{{Name: "other", Description: "Something else.", Content: "exit 0", Timeout: 5}}, "")
+	if metrics := gatherDefault(t); !strings.Contains(metrics, `script_success{script="other"} 1`) || !strings.Contains(metrics, "# HELP script_success \n") {
+		t.Errorf("Expected the results without HELP:\n%s", metrics)
+	}
+}
+
+func TestExpireAfter(t *testing.T) {
+	e := New(writeConfig(t, `
+scripts:
+  - name: expiring_dropped
+    expire_after: 1
+    script: exit 0
+  - name: expiring_kept
+    expire_after: 1
+    keep_expired: true
+    script: exit 0
+`))
+	e.ExposeResults = true
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	e.runScripts(e.Config().Scripts, "")
+
+	metrics := gatherDefault(t)
+	for _, expected := range []string{
+		`script_result_expired{script="expiring_dropped"} 0`,
+		`script_result_expired{script="expiring_kept"} 0`,
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, metrics)
+		}
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	metrics = gatherDefault(t)
+	if strings.Contains(metrics, `script_success{script="expiring_dropped"}`) {
+		t.Errorf("Expected the expired result to be removed:\n%s", metrics)
+	}
+	for _, expected := range []string{
+		`script_success{script="expiring_kept"} 1`,
+		`script_result_expired{script="expiring_kept"} 1`,
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, metrics)
+		}
+	}
+
+	if _, err := LoadConfig(writeConfig(t, "scripts:\n  - name: a\n    keep_expired: true\n    script: exit 0\n")); err == nil {
+		t.Errorf("Expected keep_expired without expire_after to be rejected")
+	}
+}
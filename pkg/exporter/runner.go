@@ -0,0 +1,224 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Runner executes a single run of a script. Runners are selected with the
+// runner setting of a script and registered with RegisterRunner.
+type Runner interface {
+	// Run runs script to completion or until ctx is done. A script that ran
+	// to completion, whatever its exit status, is reported in the Result;
+	// the error is for runs that could not be started or completed.
+	Run(ctx context.Context, script *Script, env Env) (Result, error)
+}
+
+// RunnerFunc adapts a function to the Runner interface.
+type RunnerFunc func(ctx context.Context, script *Script, env Env) (Result, error)
+
+// Run calls f.
+func (f RunnerFunc) Run(ctx context.Context, script *Script, env Env) (Result, error) {
+	return f(ctx, script, env)
+}
+
+// Env is the environment of a single run of a script.
+type Env struct {
+	// Target is the target the script is probed against.
+	Target string
+
+	// Dir, if set, is the working directory of a script run locally.
+	Dir string
+
+	// Vars are the variables the script runs with in addition to the
+	// environment of the exporter: the script's env, the components of the
+	// target and TARGET.
+	Vars []string
+
+	// Stdout and Stderr receive the output of the script.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Content is the content of the script to run, rendered if the script
+	// is a template. Runners given no Content run that of the script.
+	Content string
+
+	// Stdin, if not nil, is the body of a POST probe the script reads on
+	// stdin, so that the script itself must be passed to the shell
+	// otherwise.
+	Stdin []byte
+}
+
+// content returns the content of script to run.
+func (env Env) content(script *Script) string {
+	if env.Content != "" {
+		return env.Content
+	}
+	return script.Content
+}
+
+// Result is the outcome of a script that ran to completion.
+type Result struct {
+	ExitCode int
+
+	// Signal names the signal that terminated the script, if any, such as
+	// "SIGKILL".
+	Signal string
+}
+
+var (
+	runnersMu sync.Mutex
+	runners   = map[string]func(*Exporter) Runner{}
+)
+
+// RegisterRunner makes a runner available to scripts as runner: name.
+// newRunner is called once per Exporter, on the first run of a script with
+// the runner. RegisterRunner panics if name is already registered.
+func RegisterRunner(name string, newRunner func(*Exporter) Runner) {
+	runnersMu.Lock()
+	defer runnersMu.Unlock()
+
+	if _, ok := runners[name]; ok {
+		panic(fmt.Sprintf("runner %q registered twice", name))
+	}
+	runners[name] = newRunner
+}
+
+func runnerRegistered(name string) bool {
+	runnersMu.Lock()
+	defer runnersMu.Unlock()
+
+	_, ok := runners[name]
+	return ok
+}
+
+// runner returns the Exporter's instance of the named runner.
+func (e *Exporter) runner(name string) (Runner, error) {
+	if name == "" {
+		name = runnerShell
+	}
+
+	runnersMu.Lock()
+	newRunner, ok := runners[name]
+	runnersMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown runner %q", name)
+	}
+
+	e.runnersMu.Lock()
+	defer e.runnersMu.Unlock()
+
+	if e.runners == nil {
+		e.runners = make(map[string]Runner)
+	}
+	if e.runners[name] == nil {
+		e.runners[name] = newRunner(e)
+	}
+	return e.runners[name], nil
+}
+
+// scriptEnv returns the variables a script runs with in addition to the
+// environment of the exporter.
+func scriptEnv(script *Script, target string) []string {
+	env := make([]string, 0, len(script.Env)+9)
+	for _, name := range sortedKeys(script.Env) {
+		value, ok := script.secrets[name]
+		if !ok {
+			value = script.Env[name]
+		}
+		env = append(env, fmt.Sprintf("%s=%s", name, value))
+	}
+	if parsed, err := ParseTarget(target); err == nil {
+		env = append(env, parsed.env()...)
+		if host := parseMLabHost(parsed.Host); host != nil {
+			env = append(env, host.env()...)
+		}
+	}
+	return append(env, fmt.Sprintf("TARGET=%s", target))
+}
+
+// defaultPassthrough is passed to scripts with clean_env but no
+// env_passthrough.
+var defaultPassthrough = []string{"PATH", "HOME"}
+
+// inheritedEnv returns the variables of the exporter's environment a script
+// run locally inherits: all of them, or only those passed through if the
+// script has clean_env.
+func inheritedEnv(script *Script) []string {
+	if !script.CleanEnv {
+		return os.Environ()
+	}
+
+	passthrough := script.EnvPassthrough
+	if passthrough == nil {
+		passthrough = defaultPassthrough
+	}
+
+	env := make([]string, 0, len(passthrough))
+	for _, name := range passthrough {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// withPath returns env with the path of script prepended to its PATH.
+func withPath(env []string, script *Script) []string {
+	if len(script.Path) == 0 {
+		return env
+	}
+
+	path := strings.Join(script.Path, string(os.PathListSeparator))
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], "PATH=") {
+			path += string(os.PathListSeparator) + strings.TrimPrefix(env[i], "PATH=")
+			break
+		}
+	}
+	return append(env, "PATH="+path)
+}
+
+// lookPath looks binary up in the path of script, and then in PATH.
+func lookPath(script *Script, binary string) (string, error) {
+	if !strings.ContainsRune(binary, os.PathSeparator) {
+		for _, dir := range script.Path {
+			if path, err := exec.LookPath(filepath.Join(dir, binary)); err == nil {
+				return path, nil
+			}
+		}
+	}
+	return exec.LookPath(binary)
+}
+
+var unsafeDirRegexp = regexp.MustCompile("[^a-zA-Z0-9_-]+")
+
+// newWorkdir creates a temporary working directory for a run of script.
+func newWorkdir(script *Script) (string, error) {
+	return ioutil.TempDir("", "script_exporter-"+unsafeDirRegexp.ReplaceAllString(script.Name, "_")+"-")
+}
+
+// exportedEnv renders vars as POSIX shell exports, for runners that have no
+// other way to set the environment of the script.
+func exportedEnv(vars []string) string {
+	var exports strings.Builder
+	for _, variable := range vars {
+		parts := strings.SplitN(variable, "=", 2)
+		fmt.Fprintf(&exports, "export %s=%s\n", parts[0], shellQuote(parts[1]))
+	}
+	return exports.String()
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
@@ -0,0 +1,323 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	runnerShell  = "shell"
+	runnerDocker = "docker"
+)
+
+func init() {
+	RegisterRunner(runnerDocker, func(e *Exporter) Runner { return RunnerFunc(e.runDocker) })
+}
+
+// Docker Engine API version requested; 1.25 is the first with NanoCpus.
+const dockerAPIVersion = "v1.25"
+
+// DockerRunner configures the container a script with the docker runner
+// runs in.
+type DockerRunner struct {
+	Image string `yaml:"image"`
+
+	// Shell inside the container the script is passed to with -c.
+	Shell string `yaml:"shell,omitempty"`
+
+	// Mounts are bind mounts in the host-src:container-dest[:options] form
+	// of docker run -v.
+	Mounts      []string `yaml:"mounts,omitempty"`
+	NetworkMode string   `yaml:"network_mode,omitempty"`
+
+	// MemoryBytes and CPUs limit the resources of the container.
+	MemoryBytes int64   `yaml:"memory_bytes,omitempty"`
+	CPUs        float64 `yaml:"cpus,omitempty"`
+}
+
+// dockerClient talks to the Docker Engine API at Exporter.DockerHost.
+type dockerClient struct {
+	client *http.Client
+	base   string
+}
+
+func newDockerClient(host string) (*dockerClient, error) {
+	u, err := url.Parse(host)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "unix":
+		return &dockerClient{
+			client: &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", u.Path)
+				},
+			}},
+			base: "http://docker/" + dockerAPIVersion,
+		}, nil
+	case "tcp", "http":
+		return &dockerClient{client: http.DefaultClient, base: "http://" + u.Host + "/" + dockerAPIVersion}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported docker host %q", host)
+}
+
+// do sends a request with an optional JSON body and decodes a JSON response
+// into out, if given.
+func (c *dockerClient) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiError struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiError)
+		return &dockerError{status: resp.StatusCode, message: apiError.Message}
+	}
+
+	if out == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type dockerError struct {
+	status  int
+	message string
+}
+
+func (e *dockerError) Error() string {
+	return fmt.Sprintf("docker: %s (HTTP %d)", e.message, e.status)
+}
+
+// runDocker runs script in a new container, which is removed afterwards.
+func (e *Exporter) runDocker(ctx context.Context, script *Script, env Env) (Result, error) {
+	client, err := newDockerClient(e.DockerHost)
+
+	if err != nil {
+		return Result{}, err
+	}
+
+	id, err := client.createContainer(ctx, script, env)
+
+	if err != nil {
+		return Result{}, err
+	}
+
+	defer func() {
+		// Forcefully removing the container also kills it if the script
+		// timed out. The probe context may be done by now.
+		removeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := client.do(removeCtx, "DELETE", "/containers/"+id+"?force=1", nil, nil); err != nil {
+			log.Printf("ERROR: removing container %s of %s: %s\n", id, script.Name, err)
+		}
+	}()
+
+	if err = client.do(ctx, "POST", "/containers/"+id+"/start", nil, nil); err != nil {
+		return Result{}, err
+	}
+
+	// The logs are followed while the container runs, so that its output is
+	// streamed as it is written, until the container exits.
+	logsCtx, cancelLogs := context.WithCancel(ctx)
+	defer cancelLogs()
+	logsErr := make(chan error, 1)
+	go func() {
+		logsErr <- client.logs(logsCtx, id, env.Stdout, env.Stderr)
+	}()
+
+	var result struct {
+		StatusCode int `json:"StatusCode"`
+	}
+	if err = client.do(ctx, "POST", "/containers/"+id+"/wait", nil, &result); err != nil {
+		cancelLogs()
+		<-logsErr
+		return Result{}, err
+	}
+
+	if err = <-logsErr; err != nil {
+		return Result{}, err
+	}
+
+	// The kernel kills containers exceeding their memory limit, which is
+	// only told apart from an exit status of 137 by the container state.
+	var signal string
+	if result.StatusCode != 0 {
+		var inspect struct {
+			State struct {
+				OOMKilled bool `json:"OOMKilled"`
+			} `json:"State"`
+		}
+		if err = client.do(ctx, "GET", "/containers/"+id+"/json", nil, &inspect); err != nil {
+			return Result{}, err
+		}
+		if inspect.State.OOMKilled {
+			signal = "SIGKILL"
+		}
+	}
+
+	return Result{ExitCode: result.StatusCode, Signal: signal}, nil
+}
+
+// createContainer creates the container for a run of script, pulling its
+// image first if it is not present.
+func (c *dockerClient) createContainer(ctx context.Context, script *Script, env Env) (string, error) {
+	config := script.Docker
+
+	shell := config.Shell
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+
+	create := map[string]interface{}{
+		"Image": config.Image,
+		"Cmd":   []string{shell, "-c", env.content(script)},
+		"Env":   env.Vars,
+		"HostConfig": map[string]interface{}{
+			"Binds":       config.Mounts,
+			"NetworkMode": config.NetworkMode,
+			"Memory":      config.MemoryBytes,
+			"NanoCpus":    int64(config.CPUs * 1e9),
+		},
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+
+	err := c.do(ctx, "POST", "/containers/create", create, &created)
+
+	var apiError *dockerError
+	if errors.As(err, &apiError) && apiError.status == http.StatusNotFound {
+		if err = c.pull(ctx, config.Image); err != nil {
+			return "", err
+		}
+		err = c.do(ctx, "POST", "/containers/create", create, &created)
+	}
+
+	return created.ID, err
+}
+
+func (c *dockerClient) pull(ctx context.Context, image string) error {
+	name, tag := image, "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name, tag = image[:i], image[i+1:]
+	}
+
+	resp, err := c.stream(ctx, "POST", "/images/create?fromImage="+url.QueryEscape(name)+"&tag="+url.QueryEscape(tag), "pulling "+image+" failed")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The pull reports its progress, and whether it failed, in a stream of
+	// JSON messages sent with status 200. The progress is discarded.
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message struct {
+			Error string `json:"error"`
+		}
+		if err := decoder.Decode(&message); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if message.Error != "" {
+			return fmt.Errorf("docker: pulling %s: %s", image, message.Error)
+		}
+	}
+}
+
+// stream sends a request whose response is read as it arrives, reporting
+// message as the error of unsuccessful responses without a JSON body.
+func (c *dockerClient) stream(ctx context.Context, method, path, message string) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var apiError struct {
+			Message string `json:"message"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiError) == nil && apiError.Message != "" {
+			message = apiError.Message
+		}
+		return nil, &dockerError{status: resp.StatusCode, message: message}
+	}
+	return resp, nil
+}
+
+// logs copies the output of the container until it exits, which the API
+// multiplexes into frames prefixed by an 8 byte header holding the stream
+// and frame size.
+func (c *dockerClient) logs(ctx context.Context, id string, stdout, stderr io.Writer) error {
+	resp, err := c.stream(ctx, "GET", "/containers/"+id+"/logs?stdout=1&stderr=1&follow=1", "reading logs failed")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(resp.Body, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+
+		if _, err := io.CopyN(w, resp.Body, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package exporter
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDocker emulates the parts of the Docker Engine API used by the docker
+// runner. Containers "exit" with the status given in their command.
+type fakeDocker struct {
+	created  map[string]interface{}
+	removed  bool
+	imageErr bool
+	pullErr  bool
+
+	// release, if set, keeps the container running after its first line of
+	// output until it is closed.
+	release chan struct{}
+}
+
+func (d *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/"+dockerAPIVersion)
+
+	switch {
+	case path == "/containers/create":
+		if d.imageErr {
+			d.imageErr = false
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "No such image"}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&d.created)
+		w.Write([]byte(`{"Id": "c1"}`))
+	case path == "/images/create":
+		w.Write([]byte(`{"status": "Pulling from library/alpine"}` + "\n"))
+		if d.pullErr {
+			w.Write([]byte(`{"errorDetail": {"message": "manifest unknown"}, "error": "manifest unknown"}` + "\n"))
+			return
+		}
+		w.Write([]byte(`{"status": "Downloaded newer image"}` + "\n"))
+	case path == "/containers/c1/start":
+		w.WriteHeader(http.StatusNoContent)
+	case path == "/containers/c1/wait":
+		if d.release != nil {
+			<-d.release
+		}
+		cmd := d.created["Cmd"].([]interface{})
+		switch {
+		case strings.Contains(cmd[2].(string), "exit 3"):
+			w.Write([]byte(`{"StatusCode": 3}`))
+		case strings.Contains(cmd[2].(string), "oom"):
+			w.Write([]byte(`{"StatusCode": 137}`))
+		default:
+			w.Write([]byte(`{"StatusCode": 0}`))
+		}
+	case path == "/containers/c1/json":
+		cmd := d.created["Cmd"].([]interface{})
+		if strings.Contains(cmd[2].(string), "oom") {
+			w.Write([]byte(`{"State": {"OOMKilled": true}}`))
+			return
+		}
+		w.Write([]byte(`{"State": {"OOMKilled": false}}`))
+	case path == "/containers/c1/logs":
+		if r.URL.Query().Get("follow") != "1" {
+			http.Error(w, "expected to follow the logs", http.StatusBadRequest)
+			return
+		}
+		for i, frame := range []struct {
+			stream byte
+			data   string
+		}{{1, "out\n"}, {2, "err\n"}} {
+			header := make([]byte, 8)
+			header[0] = frame.stream
+			binary.BigEndian.PutUint32(header[4:], uint32(len(frame.data)))
+			w.Write(append(header, frame.data...))
+			w.(http.Flusher).Flush()
+			if i == 0 && d.release != nil {
+				<-d.release
+			}
+		}
+	case path == "/containers/c1" && r.Method == "DELETE":
+		d.removed = true
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestDockerRunner(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	docker := &fakeDocker{imageErr: true}
+	server := httptest.NewUnstartedServer(docker)
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	e := New("")
+	e.DockerHost = "unix://" + socket
+
+	script := &Script{
+		Name:    "docker",
+		Content: "echo out; echo err >&2",
+		Timeout: 5,
+		Runner:  runnerDocker,
+		Docker:  &DockerRunner{Image: "alpine:3.14", NetworkMode: "host"},
+	}
+
+	measurement := e.runScripts([]*Script{script}, "mlab1.lga03")[0]
+
+	if measurement.Success != 1 || measurement.Output != "out\nerr\n" {
+		t.Errorf("Expected successful run with container output, got %d %q", measurement.Success, measurement.Output)
+	}
+
+	if env := docker.created["Env"].([]interface{}); env[len(env)-1] != "TARGET=mlab1.lga03" {
+		t.Errorf("Expected TARGET in container environment, got %v", env)
+	}
+
+	if !docker.removed {
+		t.Errorf("Expected container to be removed")
+	}
+
+	script.Content = "exit 3"
+	if measurement := e.runScripts([]*Script{script}, "")[0]; measurement.ExitCode != 3 || measurement.ErrorReason != reasonNonzeroExit {
+		t.Errorf("Expected exit code 3 from container, got %d (%s)", measurement.ExitCode, measurement.ErrorReason)
+	}
+
+	script.Content = "allocate; oom"
+	if measurement := e.runScripts([]*Script{script}, "")[0]; measurement.Signal != "SIGKILL" || measurement.ErrorReason != reasonSignal {
+		t.Errorf("Expected an OOM-killed container to be killed by SIGKILL, got %q (%s)", measurement.Signal, measurement.ErrorReason)
+	}
+}
+
+func TestDockerRunnerStream(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	docker := &fakeDocker{release: make(chan struct{})}
+	server := httptest.NewUnstartedServer(docker)
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	e := New("")
+	e.DockerHost = "unix://" + socket
+
+	script := &Script{
+		Name:    "docker",
+		Content: "echo out; echo err >&2",
+		Timeout: 5,
+		Runner:  runnerDocker,
+		Docker:  &DockerRunner{Image: "alpine:3.14"},
+	}
+
+	// The first line must be streamed while the container is still running.
+	lines := make(chan string, 2)
+	ctx := WithStreamListener(context.Background(), func(event string, data StreamEvent) {
+		if event == streamOutput {
+			lines <- data.Line
+		}
+	})
+	done := make(chan *Measurement)
+	go func() {
+		done <- e.Probe(ctx, script, "mlab1.lga03")
+	}()
+
+	select {
+	case line := <-lines:
+		if line != "out" {
+			t.Errorf("Expected the first line to be streamed, got %q", line)
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("Expected output to be streamed while the container runs")
+	}
+	close(docker.release)
+
+	if measurement := <-done; measurement.Success != 1 || measurement.Output != "out\nerr\n" {
+		t.Errorf("Expected successful run with container output, got %d %q", measurement.Success, measurement.Output)
+	}
+}
+
+func TestDockerPullError(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	server := httptest.NewUnstartedServer(&fakeDocker{imageErr: true, pullErr: true})
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	client, err := newDockerClient("unix://" + socket)
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+	script := &Script{Name: "docker", Content: "true", Runner: runnerDocker, Docker: &DockerRunner{Image: "alpine:3.14"}}
+	if _, err := client.createContainer(context.Background(), script, Env{}); err == nil || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Errorf("Expected the error of the pull, got %v", err)
+	}
+}
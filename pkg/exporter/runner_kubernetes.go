@@ -0,0 +1,293 @@
+package exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+const runnerKubernetes = "kubernetes"
+
+func init() {
+	RegisterRunner(runnerKubernetes, func(*Exporter) Runner { return RunnerFunc(runKubernetes) })
+}
+
+// Files of the service account token mounted into every pod.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+// kubernetesExecProtocol multiplexes stdin, stdout, stderr and the exit
+// status of an exec over one WebSocket, prefixing each message with the
+// number of its stream.
+const kubernetesExecProtocol = "v4.channel.k8s.io"
+
+const (
+	kubernetesStdout = 1
+	kubernetesStderr = 2
+	kubernetesStatus = 3
+)
+
+// KubernetesRunner configures the container a script with the kubernetes
+// runner is executed in through the exec API.
+type KubernetesRunner struct {
+	// Namespace, Pod and Container select the container. Without Pod the
+	// target selects it as pod, namespace/pod or namespace/pod/container.
+	// Namespace defaults to the namespace of the exporter's own pod.
+	Namespace string `yaml:"namespace,omitempty"`
+	Pod       string `yaml:"pod,omitempty"`
+	Container string `yaml:"container,omitempty"`
+
+	// Shell inside the container the script is passed to with -c.
+	Shell string `yaml:"shell,omitempty"`
+
+	// APIServer, TokenFile and CAFile default to the in-cluster
+	// configuration of the exporter's service account.
+	APIServer             string `yaml:"api_server,omitempty"`
+	TokenFile             string `yaml:"token_file,omitempty"`
+	CAFile                string `yaml:"ca_file,omitempty"`
+	InsecureSkipTLSVerify bool   `yaml:"insecure_skip_tls_verify,omitempty"`
+}
+
+// kubernetesNameRegexp matches the names of namespaces, pods and containers,
+// which are DNS subdomains or labels.
+var kubernetesNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]{0,251}[a-z0-9])?$`)
+
+// targetSelects reports whether the target selects the container.
+func (r *KubernetesRunner) targetSelects() bool {
+	return r.Pod == ""
+}
+
+// parseKubernetesTarget validates target as pod, namespace/pod or
+// namespace/pod/container.
+func parseKubernetesTarget(target string) error {
+	parts := strings.Split(target, "/")
+	if len(parts) > 3 {
+		return errors.New("expected pod, namespace/pod or namespace/pod/container")
+	}
+	for _, part := range parts {
+		if !kubernetesNameRegexp.MatchString(part) {
+			return fmt.Errorf("invalid name %q", part)
+		}
+	}
+	return nil
+}
+
+// container returns the namespace, pod and container the script runs in for
+// target.
+func (r *KubernetesRunner) container(target string) (namespace, pod, container string) {
+	namespace, pod, container = r.Namespace, r.Pod, r.Container
+
+	if r.targetSelects() {
+		parts := strings.SplitN(target, "/", 3)
+		switch len(parts) {
+		case 1:
+			pod = parts[0]
+		case 2:
+			namespace, pod = parts[0], parts[1]
+		case 3:
+			namespace, pod, container = parts[0], parts[1], parts[2]
+		}
+	}
+
+	if namespace == "" {
+		if b, err := ioutil.ReadFile(serviceAccountDir + "namespace"); err == nil {
+			namespace = strings.TrimSpace(string(b))
+		} else {
+			namespace = "default"
+		}
+	}
+
+	return namespace, pod, container
+}
+
+// execConfig prepares the WebSocket connection for executing command in the
+// container.
+func (r *KubernetesRunner) execConfig(namespace, pod, container string, command []string) (*websocket.Config, error) {
+	server := r.APIServer
+	if server == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("not running in a cluster and no api_server configured")
+		}
+		server = "https://" + net.JoinHostPort(host, port)
+	}
+
+	location, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	origin := *location
+
+	switch location.Scheme {
+	case "https":
+		location.Scheme = "wss"
+	case "http":
+		location.Scheme = "ws"
+	default:
+		return nil, fmt.Errorf("unsupported api_server %q", server)
+	}
+
+	location.Path = fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/exec", url.PathEscape(namespace), url.PathEscape(pod))
+	query := url.Values{"command": command, "stdout": {"true"}, "stderr": {"true"}}
+	if container != "" {
+		query.Set("container", container)
+	}
+	location.RawQuery = query.Encode()
+
+	config := &websocket.Config{
+		Location: location,
+		Origin:   &origin,
+		Protocol: []string{kubernetesExecProtocol},
+		Version:  websocket.ProtocolVersionHybi13,
+		Header:   http.Header{},
+	}
+
+	tokenFile := r.TokenFile
+	if tokenFile == "" && r.APIServer == "" {
+		tokenFile = serviceAccountDir + "token"
+	}
+	if tokenFile != "" {
+		token, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	config.TlsConfig = &tls.Config{InsecureSkipVerify: r.InsecureSkipTLSVerify}
+
+	caFile := r.CAFile
+	if caFile == "" && r.APIServer == "" {
+		caFile = serviceAccountDir + "ca.crt"
+	}
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.TlsConfig.RootCAs = x509.NewCertPool()
+		if !config.TlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+	}
+
+	return config, nil
+}
+
+// kubernetesStatusMessage is the status reported on the status stream once
+// the command has exited.
+type kubernetesStatusMessage struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+	Details struct {
+		Causes []struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"causes"`
+	} `json:"details"`
+}
+
+// runKubernetes runs script in a running container through the exec API.
+// As exec has no way to set the environment, it is exported at the top of the
+// script.
+func runKubernetes(ctx context.Context, script *Script, env Env) (Result, error) {
+	runner := script.Kubernetes
+	namespace, pod, container := runner.container(env.Target)
+
+	if pod == "" {
+		return Result{}, errors.New("no pod to run in")
+	}
+
+	shell := runner.Shell
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+
+	config, err := runner.execConfig(namespace, pod, container, []string{shell, "-c", exportedEnv(env.Vars) + env.content(script)})
+	if err != nil {
+		return Result{}, err
+	}
+
+	config.Dialer = &net.Dialer{}
+	if deadline, ok := ctx.Deadline(); ok {
+		config.Dialer.Deadline = deadline
+	}
+
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return Result{}, fmt.Errorf("kubernetes: exec in %s/%s: %s", namespace, pod, err)
+	}
+	defer ws.Close()
+
+	// Closing the connection aborts the exec once the probe is done.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		var message []byte
+		if err = websocket.Message.Receive(ws, &message); err != nil {
+			if ctx.Err() != nil {
+				return Result{}, ctx.Err()
+			}
+			return Result{}, fmt.Errorf("kubernetes: exec in %s/%s ended without status: %s", namespace, pod, err)
+		}
+		if len(message) == 0 {
+			continue
+		}
+
+		switch message[0] {
+		case kubernetesStdout:
+			env.Stdout.Write(message[1:])
+		case kubernetesStderr:
+			env.Stderr.Write(message[1:])
+		case kubernetesStatus:
+			return kubernetesExitStatus(message[1:])
+		}
+	}
+}
+
+// kubernetesExitStatus translates the status of an exec into the result of
+// the run.
+func kubernetesExitStatus(message []byte) (Result, error) {
+	var status kubernetesStatusMessage
+
+	if err := json.Unmarshal(message, &status); err != nil {
+		return Result{}, err
+	}
+
+	if status.Status == "Success" {
+		return Result{}, nil
+	}
+
+	if status.Reason == "NonZeroExitCode" {
+		for _, cause := range status.Details.Causes {
+			if cause.Reason == "ExitCode" {
+				if rc, err := strconv.Atoi(cause.Message); err == nil {
+					return Result{ExitCode: rc}, nil
+				}
+			}
+		}
+	}
+
+	return Result{}, fmt.Errorf("kubernetes: %s", status.Message)
+}
@@ -0,0 +1,163 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+// fakeKubernetes emulates the exec API of a Kubernetes API server by running
+// the command with the local shell.
+func fakeKubernetes(t *testing.T) (*httptest.Server, *string) {
+	var path string
+
+	server := httptest.NewTLSServer(websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				return fmt.Errorf("unauthorized")
+			}
+			config.Protocol = []string{kubernetesExecProtocol}
+			path = r.URL.Path + "?container=" + r.URL.Query().Get("container")
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			ws.PayloadType = websocket.BinaryFrame
+			command := ws.Request().URL.Query()["command"]
+
+			var stdout, stderr bytes.Buffer
+			cmd := exec.Command("/bin/sh", command[1:]...)
+			cmd.Stdout, cmd.Stderr = &stdout, &stderr
+			cmd.Run()
+
+			ws.Write(append([]byte{kubernetesStdout}, stdout.Bytes()...))
+			ws.Write(append([]byte{kubernetesStderr}, stderr.Bytes()...))
+
+			status := `{"status": "Success"}`
+			if rc := cmd.ProcessState.ExitCode(); rc != 0 {
+				status = fmt.Sprintf(`{"status": "Failure", "message": "command terminated with non-zero exit code", "reason": "NonZeroExitCode", "details": {"causes": [{"reason": "ExitCode", "message": "%d"}]}}`, rc)
+			}
+			ws.Write(append([]byte{kubernetesStatus}, status...))
+		},
+	})
+	t.Cleanup(server.Close)
+
+	return server, &path
+}
+
+func TestRunKubernetes(t *testing.T) {
+	server, path := fakeKubernetes(t)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600)
+
+	runner := &KubernetesRunner{
+		Namespace:             "monitoring",
+		APIServer:             server.URL,
+		TokenFile:             tokenFile,
+		InsecureSkipTLSVerify: true,
+	}
+
+	tests := []struct {
+		target  string
+		content string
+		rc      int
+		output  string
+		path    string
+	}{
+		{"ndt-abc12", "echo $TARGET $GREETING", 0, "ndt-abc12 it's me\n", "/api/v1/namespaces/monitoring/pods/ndt-abc12/exec?container="},
+		{"mlab/ndt-abc12/ndt", "echo fail >&2; exit 3", 3, "fail\n", "/api/v1/namespaces/mlab/pods/ndt-abc12/exec?container=ndt"},
+	}
+
+	for _, test := range tests {
+		script := &Script{
+			Name:       "in-pod",
+			Content:    test.content,
+			Env:        map[string]string{"GREETING": "it's me"},
+			Runner:     runnerKubernetes,
+			Kubernetes: runner,
+		}
+
+		var output bytes.Buffer
+		result, err := runKubernetes(context.Background(), script, Env{Target: test.target, Vars: scriptEnv(script, test.target), Stdout: &output, Stderr: &output})
+
+		if err != nil || result.ExitCode != test.rc {
+			t.Errorf("Expected exit code %d, got %d (%v)", test.rc, result.ExitCode, err)
+		}
+		if output.String() != test.output {
+			t.Errorf("Unexpected output: %q", output.String())
+		}
+		if *path != test.path {
+			t.Errorf("Unexpected exec path: %s", *path)
+		}
+	}
+}
+
+func TestRunKubernetesUnauthorized(t *testing.T) {
+	server, _ := fakeKubernetes(t)
+
+	script := &Script{
+		Name:       "in-pod",
+		Content:    "true",
+		Runner:     runnerKubernetes,
+		Kubernetes: &KubernetesRunner{Pod: "ndt-abc12", APIServer: server.URL, InsecureSkipTLSVerify: true},
+	}
+
+	if _, err := runKubernetes(context.Background(), script, Env{Stdout: ioutil.Discard, Stderr: ioutil.Discard}); err == nil {
+		t.Errorf("Expected failure")
+	}
+}
+
+func TestProbeKubernetes(t *testing.T) {
+	server, path := fakeKubernetes(t)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600)
+
+	config, err := LoadConfig(writeConfig(t, fmt.Sprintf(`
+scripts:
+  - name: in-pod
+    script: echo in $TARGET
+    runner: kubernetes
+    kubernetes:
+      namespace: default
+      api_server: %s
+      token_file: %s
+      insecure_skip_tls_verify: true
+`, server.URL, tokenFile)))
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	e := New("")
+	for target, expected := range map[string]string{
+		"monitoring/ndt-0":     "/api/v1/namespaces/monitoring/pods/ndt-0/exec?container=",
+		"db":                   "/api/v1/namespaces/default/pods/db/exec?container=",
+		"mlab/ndt-abc12/ndt":   "/api/v1/namespaces/mlab/pods/ndt-abc12/exec?container=ndt",
+		"mlab/ndt/ndt/extra":   "",
+		"monitoring/$(reboot)": "",
+	} {
+		*path = ""
+		w := httptest.NewRecorder()
+		e.scriptRunHandler(w, httptest.NewRequest("GET", "/probe?name=in-pod&target="+url.QueryEscape(target), nil), config)
+
+		if expected == "" {
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400 for %s, got %d", target, w.Code)
+			}
+			continue
+		}
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `script_success{script="in-pod"} 1`) || *path != expected {
+			t.Errorf("Expected %s to run in %s, got %d from %s:\n%s", target, expected, w.Code, *path, w.Body.String())
+		}
+	}
+}
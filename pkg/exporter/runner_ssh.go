@@ -0,0 +1,189 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const runnerSSH = "ssh"
+
+func init() {
+	RegisterRunner(runnerSSH, func(*Exporter) Runner { return RunnerFunc(runSSH) })
+}
+
+// SSHRunner configures the remote machine a script with the ssh runner is
+// executed on.
+type SSHRunner struct {
+	// Host to connect to. If empty, the probe target is used.
+	Host string `yaml:"host,omitempty"`
+	Port int    `yaml:"port,omitempty"`
+	User string `yaml:"user"`
+
+	// KeyFile is a private key to authenticate with. With UseAgent the keys
+	// of the agent at $SSH_AUTH_SOCK are offered as well.
+	KeyFile  string `yaml:"key_file,omitempty"`
+	UseAgent bool   `yaml:"use_agent,omitempty"`
+
+	// KnownHosts verifies the host key of the remote machine, defaulting to
+	// ~/.ssh/known_hosts. InsecureIgnoreHostKey disables the verification.
+	KnownHosts            string `yaml:"known_hosts,omitempty"`
+	InsecureIgnoreHostKey bool   `yaml:"insecure_ignore_host_key,omitempty"`
+
+	// Shell on the remote machine the script is fed to on stdin.
+	Shell string `yaml:"shell,omitempty"`
+}
+
+// remoteHost returns the machine the script runs on for target.
+func (r *SSHRunner) remoteHost(target string) string {
+	if r.Host != "" {
+		return r.Host
+	}
+	if parsed, err := ParseTarget(target); err == nil {
+		return parsed.Host
+	}
+	return target
+}
+
+// clientConfig returns the configuration to connect with and, with UseAgent,
+// the connection to the agent, which the caller closes once authenticated.
+func (r *SSHRunner) clientConfig() (*ssh.ClientConfig, net.Conn, error) {
+	config := &ssh.ClientConfig{User: r.User}
+
+	if r.KeyFile != "" {
+		key, err := ioutil.ReadFile(r.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing %s: %s", r.KeyFile, err)
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+
+	if r.InsecureIgnoreHostKey {
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	} else {
+		knownHostsFile := r.KnownHosts
+		if knownHostsFile == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, nil, err
+			}
+			knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+		}
+
+		callback, err := knownhosts.New(knownHostsFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.HostKeyCallback = callback
+	}
+
+	if !r.UseAgent {
+		return config, nil, nil
+	}
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil, errors.New("use_agent requires SSH_AUTH_SOCK to be set")
+	}
+	agentConn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to ssh agent: %s", err)
+	}
+	config.Auth = append(config.Auth, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+	return config, agentConn, nil
+}
+
+// runSSH runs script on the remote machine by feeding it to the remote shell
+// on stdin. As sshd only accepts a configured set of variables, the script
+// environment is exported at the top of the script.
+func runSSH(ctx context.Context, script *Script, env Env) (Result, error) {
+	runner := script.SSH
+
+	config, agentConn, err := runner.clientConfig()
+	if err != nil {
+		return Result{}, err
+	}
+	if agentConn != nil {
+		defer agentConn.Close()
+	}
+
+	port := runner.Port
+	if port == 0 {
+		port = 22
+	}
+	address := net.JoinHostPort(runner.remoteHost(env.Target), strconv.Itoa(port))
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Closing the connection aborts the session once the probe is done.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		return Result{}, err
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return Result{}, err
+	}
+	defer session.Close()
+
+	session.Stdout = env.Stdout
+	session.Stderr = env.Stderr
+
+	shell := runner.Shell
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+
+	if env.Stdin != nil {
+		session.Stdin = bytes.NewReader(env.Stdin)
+		err = session.Run(shell + " -c " + shellQuote(exportedEnv(env.Vars)+env.content(script)))
+	} else {
+		session.Stdin = strings.NewReader(exportedEnv(env.Vars) + env.content(script))
+		err = session.Run(shell)
+	}
+
+	var exitError *ssh.ExitError
+	if errors.As(err, &exitError) {
+		signal := exitError.Signal()
+		if signal != "" {
+			// SSH names signals without their "SIG" prefix.
+			signal = "SIG" + signal
+		}
+		return Result{ExitCode: exitError.ExitStatus(), Signal: signal}, nil
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{}, nil
+}
@@ -0,0 +1,248 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startSSHServer runs an SSH server on a local port that executes sessions
+// with the local shell. It returns the address and the path of a private key
+// the server accepts.
+func startSSHServer(t *testing.T) (string, string) {
+	_, hostKey, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, _ := ssh.NewSignerFromKey(hostKey)
+
+	clientPublic, clientKey, _ := ed25519.GenerateKey(rand.Reader)
+	authorized, _ := ssh.NewPublicKey(clientPublic)
+
+	der, err := x509.MarshalPKCS8PrivateKey(clientKey)
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, errors.New("unauthorized")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+
+	return listener.Addr().String(), keyFile
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, _ := newChannel.Accept()
+
+		go func() {
+			defer channel.Close()
+
+			for req := range requests {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+
+				cmd := exec.Command("/bin/sh")
+				cmd.Stdin = channel
+				cmd.Stdout = channel
+				cmd.Stderr = channel.Stderr()
+				cmd.Run()
+
+				status := make([]byte, 4)
+				binary.BigEndian.PutUint32(status, uint32(cmd.ProcessState.ExitCode()))
+				channel.SendRequest("exit-status", false, status)
+				return
+			}
+		}()
+	}
+}
+
+func TestRunSSH(t *testing.T) {
+	address, keyFile := startSSHServer(t)
+	host, port, _ := net.SplitHostPort(address)
+
+	runner := &SSHRunner{User: "prometheus", KeyFile: keyFile, InsecureIgnoreHostKey: true}
+	runner.Port, _ = strconv.Atoi(port)
+
+	tests := []struct {
+		content string
+		rc      int
+		output  string
+	}{
+		{"echo $TARGET $GREETING", 0, host + " it's me\n"},
+		{"exit 3", 3, ""},
+	}
+
+	for _, test := range tests {
+		script := &Script{
+			Name:    "remote",
+			Content: test.content,
+			Env:     map[string]string{"GREETING": "it's me"},
+			Runner:  runnerSSH,
+			SSH:     runner,
+		}
+
+		// The session writes stdout and stderr from separate goroutines.
+		var stdout syncBuffer
+		result, err := runSSH(context.Background(), script, Env{Target: host, Vars: scriptEnv(script, host), Stdout: &stdout, Stderr: &stdout})
+
+		if err != nil || result.ExitCode != test.rc {
+			t.Errorf("Expected exit code %d, got %d (%v)", test.rc, result.ExitCode, err)
+		}
+		if stdout.String() != test.output {
+			t.Errorf("Unexpected output: %q", stdout.String())
+		}
+	}
+}
+
+func TestRunSSHAgent(t *testing.T) {
+	address, keyFile := startSSHServer(t)
+	host, port, _ := net.SplitHostPort(address)
+
+	pemKey, _ := ioutil.ReadFile(keyFile)
+	key, err := ssh.ParseRawPrivateKey(pemKey)
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	keyring := agent.NewKeyring()
+	keyring.Add(agent.AddedKey{PrivateKey: key})
+
+	socket := filepath.Join(t.TempDir(), "agent")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	defer listener.Close()
+
+	// Count the connections the runner has yet to close.
+	var mu sync.Mutex
+	open := 0
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			open++
+			mu.Unlock()
+			go func() {
+				agent.ServeAgent(keyring, conn)
+				conn.Close()
+				mu.Lock()
+				open--
+				mu.Unlock()
+			}()
+		}
+	}()
+
+	previous := os.Getenv("SSH_AUTH_SOCK")
+	defer os.Setenv("SSH_AUTH_SOCK", previous)
+
+	runner := &SSHRunner{User: "prometheus", UseAgent: true, InsecureIgnoreHostKey: true}
+	runner.Port, _ = strconv.Atoi(port)
+	script := &Script{Name: "remote", Content: "true", Runner: runnerSSH, SSH: runner}
+
+	os.Setenv("SSH_AUTH_SOCK", "")
+	if _, err := runSSH(context.Background(), script, Env{Target: host, Stdout: ioutil.Discard, Stderr: ioutil.Discard}); err == nil || !strings.Contains(err.Error(), "SSH_AUTH_SOCK") {
+		t.Errorf("Expected an error naming SSH_AUTH_SOCK, got %v", err)
+	}
+
+	os.Setenv("SSH_AUTH_SOCK", socket)
+	for i := 0; i < 3; i++ {
+		if result, err := runSSH(context.Background(), script, Env{Target: host, Stdout: ioutil.Discard, Stderr: ioutil.Discard}); err != nil || result.ExitCode != 0 {
+			t.Errorf("Expected the agent to authenticate, got %d (%v)", result.ExitCode, err)
+		}
+	}
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		n := open
+		mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the agent connections to be closed, %d still open", n)
+		}
+	}
+}
+
+func TestRunSSHHostKey(t *testing.T) {
+	address, keyFile := startSSHServer(t)
+	host, port, _ := net.SplitHostPort(address)
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	ioutil.WriteFile(knownHosts, nil, 0600)
+
+	runner := &SSHRunner{User: "prometheus", KeyFile: keyFile, KnownHosts: knownHosts}
+	runner.Port, _ = strconv.Atoi(port)
+
+	script := &Script{Name: "remote", Content: "true", Runner: runnerSSH, SSH: runner}
+	_, err := runSSH(context.Background(), script, Env{Target: host, Stdout: ioutil.Discard, Stderr: ioutil.Discard})
+
+	if err == nil || !strings.Contains(err.Error(), "key is unknown") {
+		t.Errorf("Expected unknown host key error, got %v", err)
+	}
+}
+
+func TestMetricLabelsRemoteHost(t *testing.T) {
+	script := &Script{Name: "remote", Runner: runnerSSH, SSH: &SSHRunner{}}
+
+	if labels := metricLabels(script, "db1"); labels != `script="remote",remote_host="db1"` {
+		t.Errorf("Unexpected labels: %s", labels)
+	}
+
+	script.SSH.Host = "bastion"
+	if labels := metricLabels(script, "db1"); labels != `script="remote",remote_host="bastion"` {
+		t.Errorf("Unexpected labels: %s", labels)
+	}
+}
@@ -0,0 +1,288 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.starlark.net/lib/json"
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+const runnerStarlark = "starlark"
+
+// starlarkHTTPTimeout is the default timeout of the requests of the http
+// module of Starlark scripts.
+const starlarkHTTPTimeout = 10 * time.Second
+
+// starlarkMaxBody bounds the response bodies read by the http module.
+const starlarkMaxBody = 1 << 20
+
+// starlarkOptions are the dialect of Starlark scripts. Checks are scripts
+// rather than BUILD files: they may use if, for and while statements at the
+// top level, reassign globals, recurse and use sets. The options apply to
+// each file, leaving other users of Starlark in the program unaffected.
+var starlarkOptions = &syntax.FileOptions{
+	Set:             true,
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+	Recursion:       true,
+}
+
+func init() {
+	RegisterRunner(runnerStarlark, func(*Exporter) Runner { return RunnerFunc(runStarlark) })
+}
+
+// starlarkExit is the error exit() stops a Starlark script with.
+type starlarkExit struct {
+	code int
+}
+
+func (e *starlarkExit) Error() string {
+	return fmt.Sprintf("exit(%d)", e.code)
+}
+
+// runStarlark runs script in the interpreter embedded in the exporter, with
+// the target, env and body of the probe and the http, dns, time and json
+// modules predeclared. Whatever the script prints and the metrics it emits
+// are written to stdout; an uncaught error is written to stderr and makes
+// the script exit with 1, and exit(code) ends it with code.
+func runStarlark(ctx context.Context, script *Script, env Env) (Result, error) {
+	thread := &starlark.Thread{
+		Name:  script.Name,
+		Print: func(_ *starlark.Thread, msg string) { fmt.Fprintln(env.Stdout, msg) },
+	}
+	thread.SetLocal("context", ctx)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel(ctx.Err().Error())
+		case <-done:
+		}
+	}()
+
+	_, err := starlark.ExecFileOptions(starlarkOptions, thread, script.Name+".star", env.content(script), starlarkPredeclared(script, env))
+	if ctx.Err() != nil {
+		return Result{}, ctx.Err()
+	}
+
+	var exit *starlarkExit
+	switch {
+	case err == nil:
+		return Result{}, nil
+	case errors.As(err, &exit):
+		return Result{ExitCode: exit.code}, nil
+	}
+	if evalError, ok := err.(*starlark.EvalError); ok {
+		fmt.Fprintln(env.Stderr, evalError.Backtrace())
+	} else {
+		fmt.Fprintln(env.Stderr, err)
+	}
+	return Result{ExitCode: 1}, nil
+}
+
+// checkStarlark compiles content, that of script, in place of the syntax
+// check of the shell, which catches undefined names as well.
+func checkStarlark(script *Script, content string) error {
+	predeclared := starlarkPredeclared(script, Env{Stdout: ioutil.Discard})
+	if _, _, err := starlark.SourceProgramOptions(starlarkOptions, script.Name+".star", content, predeclared.Has); err != nil {
+		return fmt.Errorf("syntax check failed: %s", err)
+	}
+	return nil
+}
+
+// starlarkNumber unpacks an int or float argument.
+type starlarkNumber float64
+
+func (n *starlarkNumber) Unpack(v starlark.Value) error {
+	f, ok := starlark.AsFloat(v)
+	if !ok {
+		return fmt.Errorf("got %s, want int or float", v.Type())
+	}
+	*n = starlarkNumber(f)
+	return nil
+}
+
+// starlarkPredeclared returns the names predeclared for a run of script.
+func starlarkPredeclared(script *Script, env Env) starlark.StringDict {
+	vars := starlark.NewDict(len(env.Vars))
+	for _, variable := range env.Vars {
+		parts := strings.SplitN(variable, "=", 2)
+		vars.SetKey(starlark.String(parts[0]), starlark.String(parts[1]))
+	}
+	var body starlark.Value = starlark.None
+	if env.Stdin != nil {
+		body = starlark.String(env.Stdin)
+	}
+
+	return starlark.StringDict{
+		"target": starlark.String(env.Target),
+		"env":    vars,
+		"body":   body,
+		"exit":   starlark.NewBuiltin("exit", starlarkExitBuiltin),
+		"sleep":  starlark.NewBuiltin("sleep", starlarkSleep),
+		"metric": starlark.NewBuiltin("metric", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return starlarkMetric(env.Stdout, b, args, kwargs)
+		}),
+		"http": &starlarkstruct.Module{Name: "http", Members: starlark.StringDict{
+			"get":  starlark.NewBuiltin("http.get", starlarkHTTP),
+			"post": starlark.NewBuiltin("http.post", starlarkHTTP),
+		}},
+		"dns": &starlarkstruct.Module{Name: "dns", Members: starlark.StringDict{
+			"lookup": starlark.NewBuiltin("dns.lookup", starlarkLookup),
+		}},
+		"time": starlarktime.Module,
+		"json": json.Module,
+	}
+}
+
+func starlarkContext(thread *starlark.Thread) context.Context {
+	return thread.Local("context").(context.Context)
+}
+
+// exit(code=0) ends the script with code.
+func starlarkExitBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	code := 0
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "code?", &code); err != nil {
+		return nil, err
+	}
+	return nil, &starlarkExit{code}
+}
+
+// sleep(seconds) pauses the script, which is cancelled by its timeout.
+func starlarkSleep(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var seconds starlarkNumber
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "seconds", &seconds); err != nil {
+		return nil, err
+	}
+	ctx := starlarkContext(thread)
+	select {
+	case <-time.After(time.Duration(float64(seconds) * float64(time.Second))):
+		return starlark.None, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// metric(name, value, labels={}) writes a sample in the Prometheus text
+// format to stdout, for scripts with output_metrics.
+func starlarkMetric(w io.Writer, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var value starlarkNumber
+	labels := &starlark.Dict{}
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "value", &value, "labels?", &labels); err != nil {
+		return nil, err
+	}
+	if !metricNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("%s: invalid metric name %q", b.Name(), name)
+	}
+
+	pairs := make([]string, 0, labels.Len())
+	for _, item := range labels.Items() {
+		key, ok := starlark.AsString(item[0])
+		if !ok || !labelNameRegexp.MatchString(key) {
+			return nil, fmt.Errorf("%s: invalid label name %s", b.Name(), item[0])
+		}
+		labelValue, ok := starlark.AsString(item[1])
+		if !ok {
+			labelValue = item[1].String()
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", key, labelValueEscaper.Replace(labelValue)))
+	}
+	sort.Strings(pairs)
+
+	if len(pairs) > 0 {
+		fmt.Fprintf(w, "%s{%s} %g\n", name, strings.Join(pairs, ","), float64(value))
+	} else {
+		fmt.Fprintf(w, "%s %g\n", name, float64(value))
+	}
+	return starlark.None, nil
+}
+
+// http.get(url, headers={}, timeout=10) and http.post(url, body="",
+// headers={}, timeout=10) return the status, body, headers and duration in
+// seconds of the response as a struct.
+func starlarkHTTP(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var url, body string
+	headers := &starlark.Dict{}
+	timeout := starlarkNumber(starlarkHTTPTimeout.Seconds())
+
+	method := http.MethodGet
+	var err error
+	if b.Name() == "http.post" {
+		method = http.MethodPost
+		err = starlark.UnpackArgs(b.Name(), args, kwargs, "url", &url, "body?", &body, "headers?", &headers, "timeout?", &timeout)
+	} else {
+		err = starlark.UnpackArgs(b.Name(), args, kwargs, "url", &url, "headers?", &headers, "timeout?", &timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(starlarkContext(thread), time.Duration(float64(timeout)*float64(time.Second)))
+	defer cancel()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", b.Name(), err)
+	}
+	req = req.WithContext(ctx)
+	for _, item := range headers.Items() {
+		name, _ := starlark.AsString(item[0])
+		value, _ := starlark.AsString(item[1])
+		req.Header.Set(name, value)
+	}
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", b.Name(), err)
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, starlarkMaxBody))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", b.Name(), err)
+	}
+
+	respHeaders := starlark.NewDict(len(resp.Header))
+	for name := range resp.Header {
+		respHeaders.SetKey(starlark.String(name), starlark.String(resp.Header.Get(name)))
+	}
+	return starlarkstruct.FromStringDict(starlark.String("response"), starlark.StringDict{
+		"status_code": starlark.MakeInt(resp.StatusCode),
+		"body":        starlark.String(content),
+		"headers":     respHeaders,
+		"duration":    starlark.Float(time.Since(start).Seconds()),
+	}), nil
+}
+
+// dns.lookup(host) returns the addresses of host.
+func starlarkLookup(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var host string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "host", &host); err != nil {
+		return nil, err
+	}
+	addresses, err := net.DefaultResolver.LookupHost(starlarkContext(thread), host)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", b.Name(), err)
+	}
+
+	values := make([]starlark.Value, 0, len(addresses))
+	for _, address := range addresses {
+		values = append(values, starlark.String(address))
+	}
+	return starlark.NewList(values), nil
+}
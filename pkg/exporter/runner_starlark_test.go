@@ -0,0 +1,99 @@
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.starlark.net/resolve"
+)
+
+func TestStarlarkRunner(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Region", "lga")
+		w.Write([]byte(`{"ok": true, "clients": 4}`))
+	}))
+	defer server.Close()
+
+	config, err := LoadConfig(writeConfig(t, `
+scripts:
+  - name: api
+    runner: starlark
+    output_metrics: true
+    env:
+      URL: `+server.URL+`
+    script: |
+      resp = http.get(env["URL"])
+      status = json.decode(resp.body)
+      metric("api_clients", status["clients"], labels={"region": resp.headers["X-Region"], "target": target})
+      if not status["ok"]:
+          exit(2)
+  - name: failing
+    runner: starlark
+    script: |
+      print("checking")
+      fail("out of " + "disk")
+  - name: exited
+    runner: starlark
+    script: exit(3)
+  - name: slow
+    runner: starlark
+    timeout: 1
+    script: sleep(10)
+  - name: resolve
+    runner: starlark
+    script: |
+      if "127.0.0.1" not in dns.lookup("localhost"):
+          exit(1)
+  - name: dialect
+    runner: starlark
+    script: |
+      def fact(n):
+          return 1 if n < 2 else n * fact(n - 1)
+      count = 0
+      while count < 3:
+          count += 1
+      count = fact(count)
+      if count != 6 or len(set([1, 1, 2])) != 2:
+          exit(1)
+`))
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	measurements := testExporter.runScripts(config.Scripts, "mlab1.lga03")
+	byName := make(map[string]*Measurement)
+	for _, measurement := range measurements {
+		byName[measurement.Script.Name] = measurement
+	}
+
+	if m := byName["api"]; m.Success != 1 || !strings.Contains(m.Output, `api_clients{region="lga",target="mlab1.lga03"} 4`) {
+		t.Errorf("Expected the metric emitted by the script, got %d: %s", m.Success, m.Output)
+	}
+	if m := byName["failing"]; m.Success != 0 || m.ExitCode != 1 || !strings.Contains(m.Output, "checking") || !strings.Contains(m.Output, "out of disk") {
+		t.Errorf("Expected an uncaught error to exit with 1, got %d: %s", m.ExitCode, m.Output)
+	}
+	if m := byName["exited"]; m.ExitCode != 3 {
+		t.Errorf("Expected exit(3) to exit with 3, got %d", m.ExitCode)
+	}
+	if m := byName["slow"]; m.ErrorReason != reasonTimeout {
+		t.Errorf("Expected the script to time out, got %q", m.ErrorReason)
+	}
+	if m := byName["resolve"]; m.Success != 1 {
+		t.Errorf("Expected localhost to be resolved: %s", m.Output)
+	}
+	if m := byName["dialect"]; m.Success != 1 {
+		t.Errorf("Expected top-level loops, globals reassigned, recursion and sets: %s", m.Output)
+	}
+	if resolve.AllowGlobalReassign || resolve.AllowRecursion {
+		t.Errorf("Expected the resolver globals to be left to other users of Starlark")
+	}
+
+	for _, content := range []string{"if x", "exit(undefined)"} {
+		e := New(writeConfig(t, "scripts:\n  - name: a\n    runner: starlark\n    script: '"+content+"'\n"))
+		if err := e.Reload(); err == nil || !strings.Contains(err.Error(), "syntax check failed") {
+			t.Errorf("Expected %q to fail the syntax check, got %v", content, err)
+		}
+	}
+}
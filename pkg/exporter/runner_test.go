@@ -0,0 +1,128 @@
+package exporter
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegisterRunner(t *testing.T) {
+	var ran []string
+	RegisterRunner("fake", func(e *Exporter) Runner {
+		return RunnerFunc(func(ctx context.Context, script *Script, env Env) (Result, error) {
+			ran = append(ran, env.Target)
+			env.Stdout.Write([]byte("ok\n"))
+			return Result{ExitCode: 2}, nil
+		})
+	})
+	t.Cleanup(func() {
+		runnersMu.Lock()
+		delete(runners, "fake")
+		runnersMu.Unlock()
+	})
+
+	script := &Script{Name: "fake", Runner: "fake"}
+	if err := script.setDefaults(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	// A new exporter, as exporters keep the runners they created.
+	w := httptest.NewRecorder()
+	New("").scriptRunHandler(w, httptest.NewRequest("GET", "/probe?name=fake&target=mlab1.lga03", nil), &Config{Scripts: []*Script{script}})
+
+	for _, line := range []string{`script_exit_code{script="fake"} 2`, `script_error{script="fake",reason="nonzero_exit"} 1`} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("Expected %s in:\n%s", line, w.Body.String())
+		}
+	}
+	if len(ran) != 1 || ran[0] != "mlab1.lga03" {
+		t.Errorf("Expected one run against mlab1.lga03, got %v", ran)
+	}
+
+	unknown := &Script{Name: "unknown", Runner: "missing"}
+	if err := unknown.setDefaults(); err == nil {
+		t.Errorf("Expected unknown runner to be rejected")
+	}
+}
+
+func TestCleanEnv(t *testing.T) {
+	os.Setenv("SCRIPT_EXPORTER_TEST_SECRET", "hunter2")
+	os.Setenv("SCRIPT_EXPORTER_TEST_PASSED", "yes")
+	defer os.Unsetenv("SCRIPT_EXPORTER_TEST_SECRET")
+	defer os.Unsetenv("SCRIPT_EXPORTER_TEST_PASSED")
+
+	content := "echo secret=$SCRIPT_EXPORTER_TEST_SECRET passed=$SCRIPT_EXPORTER_TEST_PASSED own=$OWN"
+	for _, test := range []struct {
+		script   *Script
+		expected string
+	}{
+		{&Script{Name: "inherit", Content: content, Timeout: 1, Env: map[string]string{"OWN": "1"}}, "secret=hunter2 passed=yes own=1\n"},
+		{&Script{Name: "clean", Content: content, Timeout: 1, Env: map[string]string{"OWN": "1"}, CleanEnv: true, EnvPassthrough: []string{"PATH", "SCRIPT_EXPORTER_TEST_PASSED"}}, "secret= passed=yes own=1\n"},
+	} {
+		if measurement := testExporter.runScripts([]*Script{test.script}, "")[0]; measurement.Output != test.expected {
+			t.Errorf("Expected %q for %s, got %q", test.expected, test.script.Name, measurement.Output)
+		}
+	}
+}
+
+func TestEphemeralWorkdir(t *testing.T) {
+	for _, test := range []struct {
+		script *Script
+		kept   bool
+	}{
+		{&Script{Name: "success/dir", Content: "pwd; echo $WORKDIR; touch file", EphemeralWorkdir: true, Timeout: 1}, false},
+		{&Script{Name: "failure", Content: "pwd; echo $WORKDIR; exit 1", EphemeralWorkdir: true, KeepWorkdirOnFailure: true, Timeout: 1}, true},
+	} {
+		measurement := testExporter.runScripts([]*Script{test.script}, "")[0]
+
+		lines := strings.Split(strings.TrimSpace(measurement.Output), "\n")
+		if len(lines) != 2 || lines[0] != lines[1] || !strings.Contains(lines[0], "script_exporter-") {
+			t.Fatalf("Expected the working directory in WORKDIR, got %q", measurement.Output)
+		}
+
+		_, err := os.Stat(lines[0])
+		if kept := err == nil; kept != test.kept {
+			t.Errorf("Expected working directory of %s kept=%t, got %t", test.script.Name, test.kept, kept)
+		}
+		os.RemoveAll(lines[0])
+	}
+}
+
+func TestPathAndWorkdir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"bin/mlab-check": "#!/bin/sh\necho vendored\n", "data/input": "x"})
+	if err := os.Chmod(filepath.Join(dir, "bin/mlab-check"), 0755); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	script := &Script{Name: "vendored", Content: "mlab-check; ls; command -v sh", Timeout: 1, Path: []string{filepath.Join(dir, "bin")}, Workdir: filepath.Join(dir, "data")}
+	if err := script.setDefaults(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	measurement := testExporter.runScripts([]*Script{script}, "")[0]
+	lines := strings.Split(strings.TrimSpace(measurement.Output), "\n")
+	if len(lines) != 3 || lines[0] != "vendored" || lines[1] != "input" || lines[2] == "" {
+		t.Errorf("Expected the vendored binary and PATH to be found in the workdir, got %q", measurement.Output)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "data/input")); err != nil {
+		t.Errorf("Expected the workdir to be kept: %s", err)
+	}
+
+	if _, err := lookPath(script, "mlab-check"); err != nil {
+		t.Errorf("Expected requires to be looked up in the path of the script: %s", err)
+	}
+
+	for _, script := range []*Script{
+		{Name: "relative", Path: []string{"bin"}},
+		{Name: "relative", Workdir: "data"},
+		{Name: "exclusive", Workdir: dir, EphemeralWorkdir: true},
+		{Name: "runner", Workdir: dir, Runner: runnerStarlark},
+	} {
+		if err := script.setDefaults(); err == nil {
+			t.Errorf("Expected %+v to be rejected", script)
+		}
+	}
+}
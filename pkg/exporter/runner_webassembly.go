@@ -0,0 +1,179 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+const runnerWASM = "wasm"
+
+// wasmPagesPerMiB is the number of 64 KiB pages of WebAssembly memory in a
+// MiB.
+const wasmPagesPerMiB = 16
+
+func init() {
+	RegisterRunner(runnerWASM, func(*Exporter) Runner {
+		cache := wazero.NewCompilationCache()
+		return RunnerFunc(func(ctx context.Context, script *Script, env Env) (Result, error) {
+			return runWASM(ctx, cache, script, env)
+		})
+	})
+}
+
+// WASMRunner configures the WebAssembly (WASI) module a script with the wasm
+// runner executes. The module is granted nothing but its arguments, the
+// variables of the script, stdin, stdout and stderr, the clocks and a source
+// of randomness: it sees no file system but its mounts and, WASI preview 1
+// having no sockets, has no network access.
+type WASMRunner struct {
+	// Module is the path of the .wasm file, relative to the config file.
+	Module string `yaml:"module"`
+
+	// Args are passed to the module after its name, with ${VAR} and $VAR
+	// replaced by the variables of the script such as TARGET.
+	Args []string `yaml:"args,omitempty"`
+
+	// Mounts are directories of the host the module may access, as
+	// "host_dir:guest_dir", read-only unless suffixed with ":rw".
+	Mounts []string `yaml:"mounts,omitempty"`
+
+	// MaxMemory limits the memory of the module, in MiB. The default is the
+	// 4 GiB WebAssembly allows.
+	MaxMemory uint32 `yaml:"max_memory,omitempty"`
+
+	binary []byte
+}
+
+// wasmMount is a parsed mount of a WASMRunner.
+type wasmMount struct {
+	host, guest string
+	writable    bool
+}
+
+func parseWASMMount(mount string) (wasmMount, error) {
+	parts := strings.Split(mount, ":")
+	switch {
+	case len(parts) == 3 && parts[2] == "rw":
+		return wasmMount{parts[0], parts[1], true}, nil
+	case len(parts) == 3 && parts[2] == "ro", len(parts) == 2:
+		return wasmMount{parts[0], parts[1], false}, nil
+	}
+	return wasmMount{}, fmt.Errorf("invalid mount %q, want host_dir:guest_dir[:ro|:rw]", mount)
+}
+
+func (r *WASMRunner) setDefaults() error {
+	if r.Module == "" {
+		return errors.New("wasm runner requires wasm.module")
+	}
+	for _, mount := range r.Mounts {
+		parsed, err := parseWASMMount(mount)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(parsed.host) || !strings.HasPrefix(parsed.guest, "/") {
+			return fmt.Errorf("mount %q must have absolute paths", mount)
+		}
+	}
+	if r.MaxMemory > 65536/wasmPagesPerMiB {
+		return errors.New("wasm.max_memory exceeds the 4096 MiB of WebAssembly")
+	}
+	return nil
+}
+
+// loadWASMModule reads the module of script, if it has the wasm runner, which
+// is then watched along with the config file.
+func (c *Config) loadWASMModule(script *Script, dir string) error {
+	if script.Runner != runnerWASM || script.WASM == nil || script.WASM.Module == "" {
+		return nil
+	}
+
+	path := script.WASM.Module
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	binary, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	script.WASM.binary = binary
+	c.files = append(c.files, path)
+	return nil
+}
+
+// runWASM instantiates the module of script, running its _start function, in
+// a runtime of its own sharing the compiled modules in cache.
+func runWASM(ctx context.Context, cache wazero.CompilationCache, script *Script, env Env) (Result, error) {
+	config := script.WASM
+	runtimeConfig := wazero.NewRuntimeConfig().WithCompilationCache(cache).WithCloseOnContextDone(true)
+	if config.MaxMemory > 0 {
+		runtimeConfig = runtimeConfig.WithMemoryLimitPages(config.MaxMemory * wasmPagesPerMiB)
+	}
+	runtime := wazero.NewRuntimeWithConfig(ctx, runtimeConfig)
+	defer runtime.Close(context.Background())
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+
+	vars := make(map[string]string, len(env.Vars))
+	moduleConfig := wazero.NewModuleConfig().
+		WithName(script.Name).
+		WithStdin(bytes.NewReader(env.Stdin)).
+		WithStdout(env.Stdout).
+		WithStderr(env.Stderr).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+	for _, variable := range env.Vars {
+		parts := strings.SplitN(variable, "=", 2)
+		vars[parts[0]] = parts[1]
+		moduleConfig = moduleConfig.WithEnv(parts[0], parts[1])
+	}
+
+	args := []string{filepath.Base(config.Module)}
+	for _, arg := range config.Args {
+		args = append(args, os.Expand(arg, func(name string) string { return vars[name] }))
+	}
+	moduleConfig = moduleConfig.WithArgs(args...)
+
+	fsConfig := wazero.NewFSConfig()
+	for _, mount := range config.Mounts {
+		parsed, _ := parseWASMMount(mount)
+		if parsed.writable {
+			fsConfig = fsConfig.WithDirMount(parsed.host, parsed.guest)
+		} else {
+			fsConfig = fsConfig.WithReadOnlyDirMount(parsed.host, parsed.guest)
+		}
+	}
+	moduleConfig = moduleConfig.WithFSConfig(fsConfig)
+
+	compiled, err := runtime.CompileModule(ctx, config.binary)
+	if err != nil {
+		return Result{}, fmt.Errorf("compiling %s: %s", config.Module, err)
+	}
+	module, err := runtime.InstantiateModule(ctx, compiled, moduleConfig)
+	if ctx.Err() != nil {
+		return Result{}, ctx.Err()
+	}
+	if module != nil {
+		module.Close(context.Background())
+	}
+
+	var exitError *sys.ExitError
+	switch {
+	case err == nil:
+		return Result{}, nil
+	case errors.As(err, &exitError):
+		return Result{ExitCode: int(exitError.ExitCode())}, nil
+	}
+	return Result{}, fmt.Errorf("running %s: %s", config.Module, err)
+}
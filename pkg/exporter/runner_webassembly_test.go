@@ -0,0 +1,89 @@
+package exporter
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// wasmHello is the WASI module
+//
+//	(module
+//	  (import "wasi_snapshot_preview1" "fd_write" (func (param i32 i32 i32 i32) (result i32)))
+//	  (import "wasi_snapshot_preview1" "proc_exit" (func (param i32)))
+//	  (memory (export "memory") 1)
+//	  (data (i32.const 16) "hello\n")
+//	  (func (export "_start")
+//	    (i32.store (i32.const 0) (i32.const 16))
+//	    (i32.store (i32.const 4) (i32.const 6))
+//	    (drop (call 0 (i32.const 1) (i32.const 0) (i32.const 1) (i32.const 8)))
+//	    (call 1 (i32.const 3))))
+//
+// wasmLoop has a _start function looping forever, and wasmLarge is wasmHello
+// with 100 pages of memory.
+const (
+	wasmHello = "0061736d0100000001100360047f7f7f7f017f60017f0060000002460216776173695f736e617073686f745f70726576696577310866645f7772697465000016776173695f736e617073686f745f70726576696577310970726f635f657869740001030201020503010001071302066d656d6f72790200065f737461727400020a21011f004100411036020041044106360200410141004101410810001a410310010b0b0c010041100b0668656c6c6f0a"
+	wasmLoop  = "0061736d0100000001100360047f7f7f7f017f60017f0060000002460216776173695f736e617073686f745f70726576696577310866645f7772697465000016776173695f736e617073686f745f70726576696577310970726f635f657869740001030201020503010001071302066d656d6f72790200065f737461727400020a0901070003400c000b0b0b0c010041100b0668656c6c6f0a"
+	wasmLarge = "0061736d0100000001100360047f7f7f7f017f60017f0060000002460216776173695f736e617073686f745f70726576696577310866645f7772697465000016776173695f736e617073686f745f70726576696577310970726f635f657869740001030201020503010064071302066d656d6f72790200065f737461727400020a21011f004100411036020041044106360200410141004101410810001a410310010b0b0c010041100b0668656c6c6f0a"
+)
+
+func TestWASMRunner(t *testing.T) {
+	files := make(map[string]string)
+	for name, module := range map[string]string{"hello.wasm": wasmHello, "loop.wasm": wasmLoop, "large.wasm": wasmLarge} {
+		binary, err := hex.DecodeString(module)
+		if err != nil {
+			t.Fatalf("Unexpected: %s", err)
+		}
+		files[name] = string(binary)
+	}
+	files["config.yml"] = `
+scripts:
+  - name: hello
+    runner: wasm
+    wasm:
+      module: hello.wasm
+      args: [$TARGET]
+  - name: loop
+    runner: wasm
+    timeout: 1
+    wasm:
+      module: loop.wasm
+  - name: large
+    runner: wasm
+    wasm:
+      module: large.wasm
+      max_memory: 1
+`
+	dir := t.TempDir()
+	writeFiles(t, dir, files)
+
+	config, err := LoadConfig(dir + "/config.yml")
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	byName := make(map[string]*Measurement)
+	for _, measurement := range testExporter.runScripts(config.Scripts, "mlab1.lga03") {
+		byName[measurement.Script.Name] = measurement
+	}
+
+	if m := byName["hello"]; m.ExitCode != 3 || m.Output != "hello\n" {
+		t.Errorf("Expected the module to print hello and exit with 3, got %d: %q", m.ExitCode, m.Output)
+	}
+	if m := byName["loop"]; m.ErrorReason != reasonTimeout {
+		t.Errorf("Expected the module looping forever to time out, got %q", m.ErrorReason)
+	}
+	if m := byName["large"]; m.Success != 0 || m.ErrorReason != reasonStartFailure {
+		t.Errorf("Expected the module exceeding max_memory to fail, got %q", m.ErrorReason)
+	}
+
+	for content, message := range map[string]string{
+		"scripts:\n  - name: a\n    runner: wasm\n":                                                                "requires wasm.module",
+		"scripts:\n  - name: a\n    runner: wasm\n    wasm: {module: missing.wasm}\n":                              "no such file",
+		"scripts:\n  - name: a\n    runner: wasm\n    wasm: {module: " + dir + "/hello.wasm, mounts: [/tmp]}\n":    "invalid mount",
+		"scripts:\n  - name: a\n    runner: wasm\n    wasm: {module: " + dir + "/hello.wasm, mounts: [data:/d]}\n": "absolute paths",
+	} {
+		if _, err := LoadConfig(writeConfig(t, content)); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected an error containing %q, got %v", message, err)
+		}
+	}
+}
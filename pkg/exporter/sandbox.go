@@ -0,0 +1,47 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// Sandbox runs a script with the shell runner in a private mount namespace on
+// Linux, in which all file systems are read-only except for a tmpfs scratch
+// directory. It requires the exporter to run with CAP_SYS_ADMIN.
+type Sandbox struct {
+	// Root, if set, is a directory the script is chrooted into, holding the
+	// shell and everything else the script needs.
+	Root string `yaml:"root,omitempty" json:"root,omitempty"`
+
+	// ScratchDir is the writable tmpfs within the sandbox, /tmp by default,
+	// of at most ScratchSizeMB megabytes (64 by default). It is also set as
+	// TMPDIR.
+	ScratchDir    string `yaml:"scratch_dir,omitempty" json:"scratch_dir,omitempty"`
+	ScratchSizeMB int    `yaml:"scratch_size_mb,omitempty" json:"scratch_size_mb,omitempty"`
+}
+
+func (s *Sandbox) setDefaults() error {
+	if !isolationSupported {
+		return errors.New("not supported on this platform")
+	}
+
+	if s.ScratchDir == "" {
+		s.ScratchDir = "/tmp"
+	}
+	if s.ScratchSizeMB == 0 {
+		s.ScratchSizeMB = 64
+	}
+
+	if s.Root != "" && !filepath.IsAbs(s.Root) {
+		return fmt.Errorf("root %q is not absolute", s.Root)
+	}
+	if !filepath.IsAbs(s.ScratchDir) {
+		return fmt.Errorf("scratch_dir %q is not absolute", s.ScratchDir)
+	}
+	if s.ScratchSizeMB < 0 {
+		return errors.New("scratch_size_mb must be positive")
+	}
+
+	return nil
+}
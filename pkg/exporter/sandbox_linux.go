@@ -0,0 +1,115 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// enter sets up the sandbox in the mount namespace the exporter re-executed
+// itself in: file systems are made private, so that nothing propagates to the
+// host, and read-only, then the scratch directory is mounted and the root
+// changed.
+func (s *Sandbox) enter() error {
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("making mounts private: %s", err)
+	}
+
+	mountPoints, err := mountPoints()
+	if err != nil {
+		return err
+	}
+	for _, mountPoint := range mountPoints {
+		if err := remountReadOnly(mountPoint); err != nil {
+			return err
+		}
+	}
+
+	root := s.Root
+	if root == "" {
+		root = "/"
+	}
+	scratch := filepath.Join(root, s.ScratchDir)
+	options := "mode=1777,size=" + strconv.Itoa(s.ScratchSizeMB) + "m"
+	if err := unix.Mount("tmpfs", scratch, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, options); err != nil {
+		return fmt.Errorf("mounting scratch directory %s: %s", scratch, err)
+	}
+	os.Setenv("TMPDIR", s.ScratchDir)
+
+	if s.Root != "" {
+		if err := unix.Chroot(s.Root); err != nil {
+			return fmt.Errorf("changing root to %s: %s", s.Root, err)
+		}
+	}
+	return unix.Chdir(s.ScratchDir)
+}
+
+// remountReadOnly makes the mount at mountPoint read-only, keeping the flags
+// that may be locked.
+func remountReadOnly(mountPoint string) error {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(mountPoint, &stat); err != nil {
+		// Mounts hidden by later mounts or not accessible are skipped.
+		return nil
+	}
+
+	flags := uintptr(unix.MS_BIND | unix.MS_REMOUNT | unix.MS_RDONLY)
+	for statFlag, mountFlag := range map[int64]uintptr{
+		unix.ST_NOSUID:   unix.MS_NOSUID,
+		unix.ST_NODEV:    unix.MS_NODEV,
+		unix.ST_NOEXEC:   unix.MS_NOEXEC,
+		unix.ST_NOATIME:  unix.MS_NOATIME,
+		unix.ST_RELATIME: unix.MS_RELATIME,
+	} {
+		if int64(stat.Flags)&statFlag != 0 {
+			flags |= mountFlag
+		}
+	}
+
+	if err := unix.Mount("", mountPoint, "", flags, ""); err != nil {
+		return fmt.Errorf("making %s read-only: %s", mountPoint, err)
+	}
+	return nil
+}
+
+// mountPoints returns the mount points of the mount namespace, parents first.
+func mountPoints() ([]string, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mountPoints []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mountPoints = append(mountPoints, unescapeMountPoint(fields[4]))
+	}
+	return mountPoints, scanner.Err()
+}
+
+// unescapeMountPoint decodes the octal escapes of whitespace and backslashes
+// in mountinfo.
+func unescapeMountPoint(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,30 @@
+package exporter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSandbox(t *testing.T) {
+	script := &Script{
+		Name:    "sandboxed",
+		Content: "touch /etc/sandbox-test 2>&1; echo $TMPDIR; pwd; echo scratch > file && cat file",
+		Timeout: 5,
+		Sandbox: &Sandbox{ScratchDir: "/tmp", ScratchSizeMB: 1},
+	}
+	if err := script.setDefaults(); err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	measurement := testExporter.runScripts([]*Script{script}, "")[0]
+
+	if strings.Contains(measurement.Output, "isolating script failed") || strings.Contains(measurement.Output, "operation not permitted") {
+		t.Skipf("Mount namespaces are not available: %s", measurement.Output)
+	}
+
+	for _, expected := range []string{"Read-only file system", "/tmp\n/tmp\nscratch\n"} {
+		if !strings.Contains(measurement.Output, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, measurement.Output)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+)
+
+// scheduleRecheck bounds how long the scheduler sleeps, so that the scripts
+// given an interval or schedule by a reload start without delay.
+var scheduleRecheck = time.Second
+
+// cronParser parses the schedule of scripts: standard cron expressions with
+// an optional leading seconds field, or descriptors such as "@daily".
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+var scheduleInterval = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "script_schedule_interval_seconds",
+	Help: "Current interval between the scheduled runs of a script, including the backoff after failures.",
+}, []string{"script"})
+
+func init() {
+	prometheus.MustRegister(scheduleInterval)
+}
+
+// scheduledScript is the schedule of a script with an interval or a cron
+// schedule, spec telling whether either changed with a reload. The interval
+// grows from that of the script while its runs keep failing.
+type scheduledScript struct {
+	spec     string
+	interval time.Duration
+	started  time.Time
+	next     time.Time
+	running  bool
+}
+
+// scheduledRun is a completed scheduled run.
+type scheduledRun struct {
+	script  *Script
+	success bool
+}
+
+// scheduled reports whether the script is run by Schedule.
+func (s *Script) scheduled() bool {
+	return s.Interval > 0 || s.cron != nil
+}
+
+// compileSchedule parses the cron schedule of the script in its timezone.
+func (s *Script) compileSchedule() error {
+	if s.Schedule == "" {
+		if s.Timezone != "" {
+			return errors.New("timezone requires schedule")
+		}
+		return nil
+	}
+	if s.Interval > 0 {
+		return errors.New("interval and schedule are exclusive")
+	}
+
+	var err error
+	if s.location, err = time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone: %s", err)
+	}
+	if s.cron, err = cronParser.Parse(s.Schedule); err != nil {
+		return fmt.Errorf("invalid schedule: %s", err)
+	}
+	return nil
+}
+
+// backoff returns the interval after a run of the script with the given
+// outcome: doubled after a failure up to the max_interval of the script, if
+// any, and reset after a success.
+func (s *scheduledScript) backoff(script *Script, success bool) time.Duration {
+	base := time.Duration(script.Interval) * time.Second
+	if success || script.MaxInterval == 0 {
+		return base
+	}
+	interval := 2 * s.interval
+	if max := time.Duration(script.MaxInterval) * time.Second; interval > max {
+		interval = max
+	}
+	return interval
+}
+
+// Schedule runs the scripts of the active configuration that have an
+// interval or a cron schedule in the background until ctx is cancelled:
+// every interval seconds, the first run being delayed by up to ScheduleSplay,
+// or at the times of the schedule, in both cases plus up to their jitter.
+// Scripts with a max_interval wait twice as long after every consecutive
+// failure, up to max_interval. A run does not start while the previous one of
+// the script is still going. The latest results are exposed on /metrics, and
+// the webhooks of the config are notified when a script changes state.
+func (e *Exporter) Schedule(ctx context.Context) {
+	go func() {
+		scheduled := make(map[string]*scheduledScript)
+		done := make(chan scheduledRun)
+
+		// Seeded per process, so that exporters started together spread
+		// their runs differently.
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		upTo := func(max time.Duration) time.Duration {
+			if max <= 0 {
+				return 0
+			}
+			return time.Duration(random.Int63n(int64(max)))
+		}
+
+		// next returns the time of the run of script after the one at
+		// start, or of the first one if first is set.
+		next := func(script *Script, s *scheduledScript, start time.Time, first bool) time.Time {
+			jitter := upTo(time.Duration(script.Jitter * float64(time.Second)))
+			if script.cron != nil {
+				return script.cron.Next(start.In(script.location)).Add(jitter)
+			}
+			if first {
+				return start.Add(upTo(e.ScheduleSplay))
+			}
+			return start.Add(s.interval + jitter)
+		}
+
+		for {
+			now := time.Now()
+			wake := now.Add(scheduleRecheck)
+			current := make(map[string]bool)
+
+			for _, script := range e.Config().Scripts {
+				if !script.scheduled() {
+					continue
+				}
+				current[script.Name] = true
+
+				spec := fmt.Sprintf("%d %d %s %s", script.Interval, script.MaxInterval, script.Schedule, script.Timezone)
+				s := scheduled[script.Name]
+				if s == nil || s.spec != spec {
+					if s == nil {
+						s = &scheduledScript{}
+						scheduled[script.Name] = s
+					}
+					s.spec, s.interval = spec, time.Duration(script.Interval)*time.Second
+					s.next = next(script, s, now, true)
+					if script.Interval > 0 {
+						scheduleInterval.WithLabelValues(script.Name).Set(s.interval.Seconds())
+					}
+				}
+
+				if !s.running && !now.Before(s.next) {
+					s.running, s.started = true, now
+					s.next = next(script, s, now, false)
+					go func(script *Script) {
+						measurement := e.Probe(ctx, script, "")
+						e.notifyWebhooks(e.Config(), measurement)
+						select {
+						case done <- scheduledRun{script, measurement.Success == 1 || measurement.Disabled || measurement.Paused}:
+						case <-ctx.Done():
+						}
+					}(script)
+				}
+				if s.next.Before(wake) {
+					wake = s.next
+				}
+			}
+
+			for name, s := range scheduled {
+				if !current[name] && !s.running {
+					delete(scheduled, name)
+					scheduleInterval.DeleteLabelValues(name)
+				}
+			}
+
+			timer := time.NewTimer(time.Until(wake))
+			select {
+			case run := <-done:
+				s := scheduled[run.script.Name]
+				if s == nil {
+					break
+				}
+				s.running = false
+				if interval := s.backoff(run.script, run.success); run.script.Interval > 0 && interval != s.interval {
+					s.interval = interval
+					s.next = next(run.script, s, s.started, false)
+					scheduleInterval.WithLabelValues(run.script.Name).Set(interval.Seconds())
+				}
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+			timer.Stop()
+		}
+	}()
+}
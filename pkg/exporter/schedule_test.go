@@ -0,0 +1,167 @@
+package exporter
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	path := writeConfig(t, `
+scripts:
+  - name: scheduled
+    interval: 1
+    script: sleep 0.2
+  - name: unscheduled
+    script: exit 0
+`)
+	e := New(path)
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e.Schedule(ctx)
+
+	time.Sleep(1500 * time.Millisecond)
+	if runs := len(e.Config().Script("scheduled").history.snapshot()); runs != 2 {
+		t.Errorf("Expected 2 scheduled runs, got %d", runs)
+	}
+	if runs := len(e.Config().Script("unscheduled").history.snapshot()); runs != 0 {
+		t.Errorf("Expected no runs of the unscheduled script, got %d", runs)
+	}
+	if metrics := gatherDefault(t); !strings.Contains(metrics, `script_success{script="scheduled"} 1`) {
+		t.Errorf("Expected the result of the scheduled script on /metrics:\n%s", metrics)
+	}
+
+	// A reload giving a script an interval schedules it.
+	writeFiles(t, "", map[string]string{path: "scripts:\n  - name: unscheduled\n    interval: 60\n    script: exit 0\n"})
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	time.Sleep(scheduleRecheck + 200*time.Millisecond)
+	if runs := len(e.Config().Script("unscheduled").history.snapshot()); runs != 1 {
+		t.Errorf("Expected 1 run once scheduled, got %d", runs)
+	}
+
+	if _, err := LoadConfig(writeConfig(t, "modules:\n  - name: m\n    interval: 10\n    script: exit 0\n")); err == nil {
+		t.Errorf("Expected a scheduled module to be rejected")
+	}
+}
+
+func TestScheduleSplay(t *testing.T) {
+	e := New(writeConfig(t, `
+scripts:
+  - name: splayed
+    interval: 1
+    jitter: 0.5
+    script: exit 0
+`))
+	e.ScheduleSplay = time.Hour
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e.Schedule(ctx)
+
+	// The first run is all but certain to be delayed by more than this.
+	time.Sleep(300 * time.Millisecond)
+	if runs := len(e.Config().Script("splayed").history.snapshot()); runs != 0 {
+		t.Errorf("Expected the first run to be delayed, got %d runs", runs)
+	}
+
+	if _, err := LoadConfig(writeConfig(t, "scripts:\n  - name: a\n    jitter: 1\n    script: exit 0\n")); err == nil {
+		t.Errorf("Expected jitter without interval to be rejected")
+	}
+}
+
+func TestCronSchedule(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `
+scripts:
+  - name: every_second
+    schedule: '* * * * * *'
+    script: exit 0
+  - name: disk_benchmark
+    schedule: 17 3 * * SUN
+    timezone: America/New_York
+    script: exit 0
+`))
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	script := config.Script("disk_benchmark")
+	from := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	next := script.cron.Next(from.In(script.location))
+	if expected := time.Date(2021, 6, 6, 7, 17, 0, 0, time.UTC); !next.Equal(expected) {
+		t.Errorf("Expected the next run at %s, got %s", expected, next.UTC())
+	}
+
+	e := New("")
+	e.config = config
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e.Schedule(ctx)
+
+	time.Sleep(2200 * time.Millisecond)
+	if runs := len(config.Script("every_second").history.snapshot()); runs < 2 {
+		t.Errorf("Expected a run every second, got %d runs", runs)
+	}
+	if runs := len(script.history.snapshot()); runs != 0 {
+		t.Errorf("Expected no run of the weekly script, got %d", runs)
+	}
+
+	for content, message := range map[string]string{
+		"scripts:\n  - name: a\n    schedule: '61 * * * *'\n    script: exit 0\n":                         "invalid schedule",
+		"scripts:\n  - name: a\n    schedule: '@daily'\n    timezone: Mars/Olympus\n    script: exit 0\n": "invalid timezone",
+		"scripts:\n  - name: a\n    interval: 10\n    schedule: '@daily'\n    script: exit 0\n":           "exclusive",
+		"scripts:\n  - name: a\n    timezone: UTC\n    script: exit 0\n":                                  "timezone requires schedule",
+		"modules:\n  - name: m\n    schedule: '@hourly'\n    script: exit 0\n":                            "cannot be scheduled",
+	} {
+		if _, err := LoadConfig(writeConfig(t, content)); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected an error containing %q, got %v", message, err)
+		}
+	}
+}
+
+func TestScheduleBackoff(t *testing.T) {
+	dir := t.TempDir()
+	e := New(writeConfig(t, `
+scripts:
+  - name: backoff
+    interval: 1
+    max_interval: 2
+    script: test -f `+dir+`/ok
+`))
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e.Schedule(ctx)
+
+	// Runs at 0s and 2s fail, doubling the interval up to max_interval.
+	time.Sleep(2500 * time.Millisecond)
+	if runs := len(e.Config().Script("backoff").history.snapshot()); runs != 2 {
+		t.Errorf("Expected 2 runs, got %d", runs)
+	}
+	if metrics := gatherDefault(t); !strings.Contains(metrics, `script_schedule_interval_seconds{script="backoff"} 2`) {
+		t.Errorf("Expected the interval to back off to 2s:\n%s", metrics)
+	}
+
+	// The run at 4s succeeds, resetting the interval.
+	writeFiles(t, dir, map[string]string{"ok": ""})
+	time.Sleep(2000 * time.Millisecond)
+	if metrics := gatherDefault(t); !strings.Contains(metrics, `script_schedule_interval_seconds{script="backoff"} 1`) {
+		t.Errorf("Expected the interval to be reset to 1s:\n%s", metrics)
+	}
+
+	if _, err := LoadConfig(writeConfig(t, "scripts:\n  - name: a\n    interval: 10\n    max_interval: 5\n    script: exit 0\n")); err == nil {
+		t.Errorf("Expected a max_interval below the interval to be rejected")
+	}
+}
@@ -0,0 +1,148 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+// defaultScrapeTimeout and defaultScrapeInterval are those of Prometheus, in
+// seconds.
+const (
+	defaultScrapeTimeout  = 10
+	defaultScrapeInterval = 60
+)
+
+var scrapeConfigTemplate = template.Must(template.New("scrape_config").Funcs(template.FuncMap{"quote": yamlQuote}).Parse(`# Prometheus scrape configs for the script_exporter at {{.Address}}.
+scrape_configs:
+{{- if .Metrics}}
+  # Results of scheduled scripts and daemons.
+  - job_name: script_exporter
+    scheme: {{.Scheme}}
+    metrics_path: {{quote .MetricsPath}}
+    static_configs:
+      - targets: [{{quote .Address}}]
+{{- end}}
+{{- range .Scripts}}
+{{- if .Description}}
+  # {{.Description}}
+{{- end}}
+  - job_name: {{quote .Job}}
+    scheme: {{$.Scheme}}
+    metrics_path: {{quote $.ProbePath}}
+    params:
+      name: [{{quote .Name}}]
+{{- if .Timeout}}
+    scrape_timeout: {{.Timeout}}s
+{{- end}}
+{{- if .Interval}}
+    scrape_interval: {{.Interval}}s
+{{- end}}
+    static_configs:
+      - targets: [{{quote $.Address}}]
+{{- end}}
+{{- range .Modules}}
+{{- if .Description}}
+  # {{.Description}}
+{{- end}}
+  - job_name: {{quote .Job}}
+    scheme: {{$.Scheme}}
+    metrics_path: {{quote $.ProbePath}}
+    params:
+      module: [{{quote .Name}}]
+{{- if .Timeout}}
+    scrape_timeout: {{.Timeout}}s
+{{- end}}
+{{- if .Interval}}
+    scrape_interval: {{.Interval}}s
+{{- end}}
+    static_configs:
+{{- if .Targets}}
+      - targets:
+{{- range .Targets}}
+          - {{quote .}}
+{{- end}}
+{{- else}}
+      # The targets to probe with the module{{if .TargetPattern}}, matching {{.TargetPattern}}{{end}}.
+      - targets: []
+{{- end}}
+    relabel_configs:
+      - source_labels: [__address__]
+        target_label: __param_target
+      - source_labels: [__param_target]
+        target_label: instance
+      - target_label: __address__
+        replacement: {{quote $.Address}}
+{{- end}}
+`))
+
+// scrapeJob is a job of the scrape config of a script or module probed with
+// /probe. Timeout and Interval are set if the defaults of Prometheus do not
+// leave the script enough time.
+type scrapeJob struct {
+	Job, Name, Description, TargetPattern string
+	Targets                               []string
+	Timeout, Interval                     int64
+}
+
+func newScrapeJob(kind string, script *Script) scrapeJob {
+	job := scrapeJob{Job: kind + "_" + script.Name, Name: script.Name, Description: strings.Join(strings.Fields(script.Description), " ")}
+	// Leave a second for the exporter to respond once the script timed out.
+	if timeout := script.Timeout + 1; timeout > defaultScrapeTimeout {
+		job.Timeout = timeout
+		if timeout > defaultScrapeInterval {
+			job.Interval = timeout
+		}
+	}
+	return job
+}
+
+// WriteScrapeConfig writes the Prometheus scrape configs scraping the exporter
+// at address, a host:port, over scheme, "http" or "https": a job for
+// /metrics if scripts are scheduled or run as daemons, one per script probed
+// with /probe, and one per module, relabeled to probe its targets, which are
+// left to be listed if it has none. Scripts reading the body of a probe cannot
+// be scraped and are left out.
+func (e *Exporter) WriteScrapeConfig(w io.Writer, address, scheme string) error {
+	config := e.Config()
+	if config == nil {
+		return fmt.Errorf("no configuration loaded")
+	}
+
+	data := struct {
+		Address, Scheme, MetricsPath, ProbePath string
+		Metrics                                 bool
+		Scripts, Modules                        []scrapeJob
+	}{
+		Address:     address,
+		Scheme:      scheme,
+		MetricsPath: e.LinkPrefix + e.MetricsPath,
+		ProbePath:   e.LinkPrefix + "/probe",
+	}
+	for _, script := range config.Scripts {
+		switch {
+		case script.scheduled() || script.daemon():
+			data.Metrics = true
+		case !script.AcceptBody:
+			data.Scripts = append(data.Scripts, newScrapeJob("script", script))
+		}
+	}
+	for _, module := range config.Modules {
+		if module.AcceptBody {
+			continue
+		}
+		job := newScrapeJob("module", &module.Script)
+		job.TargetPattern, job.Targets = module.TargetPattern, module.Targets
+		data.Modules = append(data.Modules, job)
+	}
+	return scrapeConfigTemplate.Execute(w, data)
+}
+
+// yamlQuote renders s as a YAML string.
+func yamlQuote(s string) string {
+	out, _ := yaml.Marshal(s)
+	return strings.TrimSuffix(string(out), "\n")
+}
@@ -0,0 +1,89 @@
+package exporter
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestWriteScrapeConfig(t *testing.T) {
+	e := New(writeConfig(t, `
+scripts:
+  - name: scheduled
+    script: exit 0
+    interval: 60
+  - name: "ndt: tls"
+    description: |
+      Checks the NDT5
+      TLS handshake.
+    script: exit 0
+    timeout: 90
+  - name: quick
+    script: exit 0
+    timeout: 5
+  - name: upload
+    script: cat
+    accept_body: true
+modules:
+  - name: ping
+    script: ping -c 1 $TARGET
+    target_pattern: 'mlab[0-9]\..*'
+  - name: ndt7
+    script: ndt7-client -server $TARGET
+    targets: [mlab1.lga03.measurement-lab.org]
+`))
+	e.LinkPrefix = "/exporter"
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := e.WriteScrapeConfig(&out, "exporter:9172", "https"); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	var generated struct {
+		ScrapeConfigs []map[string]interface{} `yaml:"scrape_configs"`
+	}
+	if err := yaml.UnmarshalStrict(out.Bytes(), &generated); err != nil {
+		t.Fatalf("Expected valid YAML, got %s:\n%s", err, out.String())
+	}
+
+	jobs := make(map[string]map[string]interface{})
+	for _, job := range generated.ScrapeConfigs {
+		jobs[job["job_name"].(string)] = job
+	}
+	if len(jobs) != 5 {
+		t.Errorf("Expected 5 jobs, got %d:\n%s", len(jobs), out.String())
+	}
+	for name, expected := range map[string]map[string]interface{}{
+		"script_exporter": {"metrics_path": "/exporter/metrics", "scheme": "https"},
+		"script_ndt: tls": {"metrics_path": "/exporter/probe", "scrape_timeout": "91s", "scrape_interval": "91s"},
+		"script_quick":    {"metrics_path": "/exporter/probe", "scrape_timeout": nil},
+		"module_ping":     {"metrics_path": "/exporter/probe"},
+	} {
+		job, ok := jobs[name]
+		if !ok {
+			t.Errorf("Expected job %s:\n%s", name, out.String())
+			continue
+		}
+		for key, value := range expected {
+			if job[key] != value {
+				t.Errorf("Expected %s of %s to be %v, got %v", key, name, value, job[key])
+			}
+		}
+	}
+
+	params := map[interface{}]interface{}{"module": []interface{}{"ping"}}
+	if module := jobs["module_ping"]; !reflect.DeepEqual(module["params"], params) || len(module["relabel_configs"].([]interface{})) != 3 {
+		t.Errorf("Expected the module to be relabeled to probe its targets, got %v", module)
+	}
+	targets := []interface{}{map[interface{}]interface{}{"targets": []interface{}{"mlab1.lga03.measurement-lab.org"}}}
+	if module := jobs["module_ndt7"]; !reflect.DeepEqual(module["static_configs"], targets) {
+		t.Errorf("Expected the targets of the module, got %v", module["static_configs"])
+	}
+	if !bytes.Contains(out.Bytes(), []byte("# Checks the NDT5 TLS handshake.\n")) {
+		t.Errorf("Expected the description as a comment:\n%s", out.String())
+	}
+}
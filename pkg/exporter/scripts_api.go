@@ -0,0 +1,361 @@
+package exporter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// apiScriptsOrigin is the origin of the scripts added through the scripts API.
+const apiScriptsOrigin = "/api/v1/scripts"
+
+// maxScriptBody limits the script definitions accepted by the scripts API.
+const maxScriptBody = 1 << 20
+
+// scriptsFile is the layout of the ScriptsFile.
+type scriptsFile struct {
+	Scripts []*Script `yaml:"scripts"`
+}
+
+// addAPIScripts appends copies of the scripts added through the scripts API
+// to c, so that they are unaffected by loading the copies.
+func (c *Config) addAPIScripts(scripts []*Script, sum hash.Hash) error {
+	if len(scripts) == 0 {
+		return nil
+	}
+
+	data, err := yaml.Marshal(scripts)
+	if err != nil {
+		return err
+	}
+	sum.Write(data)
+
+	var copies []*Script
+	if err = yaml.Unmarshal(data, &copies); err != nil {
+		return err
+	}
+	for _, script := range copies {
+		script.origin = apiScriptsOrigin
+	}
+	c.Scripts = append(c.Scripts, copies...)
+	return nil
+}
+
+// loadAPIScripts returns the scripts added through the scripts API, restoring
+// them from the ScriptsFile the first time. apiMu must be held.
+func (e *Exporter) loadAPIScripts() ([]*Script, error) {
+	if !e.apiLoaded && e.ScriptsFile != "" {
+		data, err := ioutil.ReadFile(e.ScriptsFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		var file scriptsFile
+		if err = yaml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("%s: %s", e.ScriptsFile, err)
+		}
+		e.apiScripts = file.Scripts
+	}
+	e.apiLoaded = true
+
+	return e.apiScripts, nil
+}
+
+// saveAPIScripts writes the scripts added through the scripts API to the
+// ScriptsFile, replacing it by a rename.
+func (e *Exporter) saveAPIScripts() error {
+	if e.ScriptsFile == "" {
+		return nil
+	}
+
+	data, err := yaml.Marshal(scriptsFile{e.apiScripts})
+	if err != nil {
+		return err
+	}
+	return replaceFile(e.ScriptsFile, data)
+}
+
+// updateAPIScripts replaces the scripts added through the scripts API with
+// the result of update, if the config reloaded with them is valid.
+func (e *Exporter) updateAPIScripts(update func([]*Script) ([]*Script, error)) error {
+	e.apiMu.Lock()
+	defer e.apiMu.Unlock()
+
+	if _, err := e.loadAPIScripts(); err != nil {
+		return err
+	}
+
+	scripts, err := update(append([]*Script(nil), e.apiScripts...))
+	if err != nil {
+		return err
+	}
+	if err = e.reload(scripts); err != nil {
+		return &apiError{http.StatusBadRequest, "bad_data", err.Error()}
+	}
+	e.apiScripts = scripts
+
+	if err = e.saveAPIScripts(); err != nil {
+		log.Printf("ERROR: saving scripts to %s: %s\n", e.ScriptsFile, err)
+	}
+	return nil
+}
+
+// apiError is an error response of the scripts API, following the layout of
+// the Prometheus HTTP API.
+type apiError struct {
+	status    int
+	errorType string
+	message   string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		apiErr = &apiError{http.StatusInternalServerError, "internal", err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":    "error",
+		"errorType": apiErr.errorType,
+		"error":     apiErr.message,
+	})
+}
+
+func writeAPIData(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data":   data,
+	})
+}
+
+// scriptsAPIHandler lists the scripts of config on GET /api/v1/scripts and
+// serves a single one on GET /api/v1/scripts/<name>. Scripts are added with
+// POST /api/v1/scripts, replaced with PUT /api/v1/scripts/<name> and removed
+// with DELETE /api/v1/scripts/<name>; only those added through the API may be
+// changed. Any script or module is disabled and enabled again with POST
+// /api/v1/scripts/<name>/disable and /enable, and daemons are restarted with
+// POST /api/v1/scripts/<name>/restart.
+func (e *Exporter) scriptsAPIHandler(w http.ResponseWriter, r *http.Request, config *Config) {
+	if !e.ScriptsAPI {
+		http.NotFound(w, r)
+		return
+	}
+
+	if config.ScriptsAPIAuth == nil {
+		writeAPIError(w, &apiError{http.StatusForbidden, "forbidden", "scripts_api_auth is not configured"})
+		return
+	}
+	if err := config.ScriptsAPIAuth.authorize(r); err != nil {
+		log.Printf("ERROR: Scripts API request from %s rejected: %s\n", clientAddress(r), err)
+		if err.status == 401 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		writeAPIError(w, &apiError{err.status, "unauthorized", err.message})
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, apiScriptsOrigin), "/")
+	var action string
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name, action = name[:i], name[i+1:]
+	}
+
+	var err error
+	switch {
+	case r.Method == http.MethodPost && name != "" && (action == "enable" || action == "disable"):
+		err = e.switchScript(w, config, name, action == "disable")
+	case r.Method == http.MethodPost && name != "" && action == "restart":
+		err = e.restartDaemon(w, config, name)
+	case action != "":
+		err = &apiError{http.StatusNotFound, "not_found", fmt.Sprintf("unknown action %q", action)}
+	case r.Method == http.MethodGet && name == "":
+		scripts := []map[string]interface{}{}
+		for _, script := range config.Scripts {
+			scripts = append(scripts, scriptJSON(script))
+		}
+		writeAPIData(w, http.StatusOK, scripts)
+	case r.Method == http.MethodGet:
+		script := config.Script(name)
+		if script == nil {
+			err = &apiError{http.StatusNotFound, "not_found", fmt.Sprintf("script %q not found", name)}
+			break
+		}
+		writeAPIData(w, http.StatusOK, scriptJSON(script))
+	case r.Method == http.MethodPost && name == "":
+		err = e.addScript(w, r, config)
+	case r.Method == http.MethodPut && name != "":
+		err = e.replaceScript(w, r, config, name)
+	case r.Method == http.MethodDelete && name != "":
+		err = e.deleteScript(w, config, name)
+	default:
+		err = &apiError{http.StatusMethodNotAllowed, "bad_data", fmt.Sprintf("method %s not allowed", r.Method)}
+	}
+
+	if err != nil {
+		writeAPIError(w, err)
+	}
+}
+
+func (e *Exporter) addScript(w http.ResponseWriter, r *http.Request, config *Config) error {
+	script, err := readScript(r, "")
+	if err != nil {
+		return err
+	}
+	if config.Script(script.Name) != nil {
+		return &apiError{http.StatusConflict, "conflict", fmt.Sprintf("script %q already exists", script.Name)}
+	}
+
+	err = e.updateAPIScripts(func(scripts []*Script) ([]*Script, error) {
+		return append(scripts, script), nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("OK: script %s added through the scripts API\n", script.Name)
+	writeAPIData(w, http.StatusCreated, scriptJSON(e.Config().Script(script.Name)))
+	return nil
+}
+
+func (e *Exporter) replaceScript(w http.ResponseWriter, r *http.Request, config *Config, name string) error {
+	script, err := readScript(r, name)
+	if err != nil {
+		return err
+	}
+
+	err = e.updateAPIScripts(func(scripts []*Script) ([]*Script, error) {
+		for i, existing := range scripts {
+			if existing.Name == name {
+				scripts[i] = script
+				return scripts, nil
+			}
+		}
+		return nil, scriptNotInAPI(config, name)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("OK: script %s replaced through the scripts API\n", name)
+	writeAPIData(w, http.StatusOK, scriptJSON(e.Config().Script(name)))
+	return nil
+}
+
+func (e *Exporter) deleteScript(w http.ResponseWriter, config *Config, name string) error {
+	err := e.updateAPIScripts(func(scripts []*Script) ([]*Script, error) {
+		for i, existing := range scripts {
+			if existing.Name == name {
+				return append(scripts[:i], scripts[i+1:]...), nil
+			}
+		}
+		return nil, scriptNotInAPI(config, name)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("OK: script %s deleted through the scripts API\n", name)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// scriptNotInAPI returns the error for changing a script that was not added
+// through the scripts API.
+func scriptNotInAPI(config *Config, name string) error {
+	if config.Script(name) != nil {
+		return &apiError{http.StatusConflict, "conflict", fmt.Sprintf("script %q is defined in the config file", name)}
+	}
+	return &apiError{http.StatusNotFound, "not_found", fmt.Sprintf("script %q not found", name)}
+}
+
+// readScript decodes the script in the JSON or YAML body of r, which must be
+// named name if given.
+func readScript(r *http.Request, name string) (*Script, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxScriptBody))
+	if err != nil {
+		return nil, &apiError{http.StatusBadRequest, "bad_data", err.Error()}
+	}
+
+	script := &Script{}
+	if err = yaml.UnmarshalStrict(body, script); err != nil {
+		return nil, &apiError{http.StatusBadRequest, "bad_data", err.Error()}
+	}
+	if name != "" && script.Name == "" {
+		script.Name = name
+	}
+
+	switch {
+	case name != "" && script.Name != name:
+		err = fmt.Errorf("name %q does not match %q", script.Name, name)
+	case script.Name == "":
+		err = errors.New("script has no name")
+	case script.File != "":
+		err = errors.New("script_file may not be set through the scripts API")
+	case script.Runner == runnerWASM:
+		err = errors.New("the wasm runner may not be used through the scripts API")
+	}
+	if err != nil {
+		return nil, &apiError{http.StatusBadRequest, "bad_data", err.Error()}
+	}
+	return script, nil
+}
+
+// scriptJSON renders script for the scripts API, with the values of its
+// environment variables redacted.
+func scriptJSON(script *Script) map[string]interface{} {
+	source := "config"
+	if script.origin == apiScriptsOrigin {
+		source = "api"
+	}
+
+	out, _ := yaml.Marshal(script)
+	var settings map[string]interface{}
+	yaml.Unmarshal(out, &settings)
+	if env, ok := settings["env"].(map[interface{}]interface{}); ok {
+		for name := range env {
+			env[name] = "<redacted>"
+		}
+	}
+
+	return map[string]interface{}{
+		"name":        script.Name,
+		"description": script.Description,
+		"source":      source,
+		"config":      jsonValue(settings),
+	}
+}
+
+// jsonValue converts the maps of a value decoded from YAML to maps with
+// string keys, which encoding/json requires.
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return converted
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = jsonValue(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonValue(item)
+		}
+	}
+	return value
+}
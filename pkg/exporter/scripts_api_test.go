@@ -0,0 +1,163 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScriptsAPI(t *testing.T) {
+	path := writeConfig(t, `
+scripts_api_auth:
+  bearer_tokens: [secret]
+scripts:
+  - name: static
+    description: Does nothing.
+    script: exit 0
+`)
+	scriptsFile := filepath.Join(filepath.Dir(path), "scripts.yml")
+
+	e := New(path)
+	e.ScriptsAPI = true
+	e.ScriptsFile = scriptsFile
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	mux := http.NewServeMux()
+	e.RegisterHandlers(mux)
+
+	request := func(method, path, token, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr.Code, response
+	}
+
+	for _, test := range []struct {
+		method, path, token, body string
+		status                    int
+	}{
+		{"GET", "/api/v1/scripts", "", "", 401},
+		{"GET", "/api/v1/scripts", "wrong", "", 401},
+		{"POST", "/api/v1/scripts", "secret", `{"name": "added", "script": "exit 1", "env": {"TOKEN": "x"}}`, 201},
+		{"POST", "/api/v1/scripts", "secret", `{"name": "added", "script": "exit 1"}`, 409},
+		{"POST", "/api/v1/scripts", "secret", `{"name": "typo", "scirpt": "exit 1"}`, 400},
+		{"POST", "/api/v1/scripts", "secret", `{"name": "file", "script_file": "/etc/passwd"}`, 400},
+		{"POST", "/api/v1/scripts", "secret", `{"name": "invalid", "script": "exit 0", "runner": "none"}`, 400},
+		{"GET", "/api/v1/scripts/added", "secret", "", 200},
+		{"PUT", "/api/v1/scripts/added", "secret", `{"script": "exit 2"}`, 200},
+		{"PUT", "/api/v1/scripts/static", "secret", `{"script": "exit 2"}`, 409},
+		{"DELETE", "/api/v1/scripts/static", "secret", "", 409},
+		{"DELETE", "/api/v1/scripts/missing", "secret", "", 404},
+	} {
+		if status, response := request(test.method, test.path, test.token, test.body); status != test.status {
+			t.Errorf("Expected %s %s to return %d, got %d: %v", test.method, test.path, test.status, status, response)
+		}
+	}
+
+	if script := e.Config().Script("added"); script == nil || script.Content != "exit 2" {
+		t.Fatalf("Expected the replaced script to be active, got %+v", script)
+	}
+
+	_, response := request("GET", "/api/v1/scripts", "secret", "")
+	scripts := response["data"].([]interface{})
+	if len(scripts) != 2 {
+		t.Fatalf("Expected 2 scripts, got %v", scripts)
+	}
+	if description := scripts[0].(map[string]interface{})["description"]; description != "Does nothing." {
+		t.Errorf("Expected the description of the script, got %v", description)
+	}
+	if source := scripts[1].(map[string]interface{})["source"]; source != "api" {
+		t.Errorf("Expected source api, got %v", source)
+	}
+	env := scripts[1].(map[string]interface{})["config"].(map[string]interface{})["env"]
+	if env != nil {
+		t.Errorf("Expected the replaced script to have no env, got %v", env)
+	}
+
+	// Added scripts survive a restart.
+	persisted, err := ioutil.ReadFile(scriptsFile)
+	if err != nil || !strings.Contains(string(persisted), "exit 2") {
+		t.Errorf("Expected the scripts to be persisted, got %q, %v", persisted, err)
+	}
+	restarted := New(path)
+	restarted.ScriptsFile = scriptsFile
+	if err := restarted.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	if restarted.Config().Script("added") == nil {
+		t.Errorf("Expected the added script to be restored")
+	}
+
+	if status, _ := request("DELETE", "/api/v1/scripts/added", "secret", ""); status != 204 {
+		t.Errorf("Expected delete to return 204, got %d", status)
+	}
+	if e.Config().Script("added") != nil {
+		t.Errorf("Expected the deleted script to be gone")
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		e.ScriptsAPI = false
+		defer func() { e.ScriptsAPI = true }()
+		if status, _ := request("GET", "/api/v1/scripts", "secret", ""); status != 404 {
+			t.Errorf("Expected 404, got %d", status)
+		}
+	})
+}
+
+func TestScriptsAPIConcurrentReload(t *testing.T) {
+	e := New(writeConfig(t, "scripts_api_auth:\n  bearer_tokens: [secret]\nscripts:\n  - name: static\n    script: exit 0\n"))
+	e.ScriptsAPI = true
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	mux := http.NewServeMux()
+	e.RegisterHandlers(mux)
+
+	// Reloads racing the API must never activate a config without a script
+	// the API reported as added.
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				e.Reload()
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	for i := 0; i < 20; i++ {
+		req := httptest.NewRequest("POST", "/api/v1/scripts", strings.NewReader(fmt.Sprintf(`{"name": "added-%d", "script": "exit 0"}`, i)))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		for j := 0; j <= i; j++ {
+			if e.Config().Script(fmt.Sprintf("added-%d", j)) == nil {
+				t.Fatalf("Expected script added-%d to stay active after adding added-%d", j, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// secretTimeout limits resolving the secrets of a config.
+const secretTimeout = 30 * time.Second
+
+// secretResolver resolves the references to secrets in the env of the scripts
+// of a config.
+type secretResolver struct {
+	config *Config
+
+	vault *vaultClient
+}
+
+// resolveSecrets fetches the secrets the env values of the scripts of c
+// refer to, e.g. vault:secret/data/ndt#key, which are passed to the scripts
+// in place of the references but never kept in Env, so they appear in no
+// rendering of the config.
+func (c *Config) resolveSecrets() error {
+	ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+	defer cancel()
+
+	resolver := &secretResolver{config: c}
+	for _, script := range c.allScripts() {
+		for _, name := range sortedKeys(script.Env) {
+			value, ok, err := resolver.resolve(ctx, script.Env[name])
+			if err != nil {
+				return fmt.Errorf("script %s: env %s: %s", script.Name, name, err)
+			}
+			if !ok {
+				continue
+			}
+			if script.secrets == nil {
+				script.secrets = make(map[string]string)
+			}
+			script.secrets[name] = value
+		}
+	}
+	return nil
+}
+
+// resolve returns the secret ref refers to, and whether it is a reference to
+// a secret at all.
+func (r *secretResolver) resolve(ctx context.Context, ref string) (string, bool, error) {
+	switch {
+	case strings.HasPrefix(ref, "vault:"):
+		if r.vault == nil {
+			r.vault = newVaultClient(r.config.Vault)
+		}
+		value, err := r.vault.secret(ctx, strings.TrimPrefix(ref, "vault:"))
+		return value, true, err
+	case strings.HasPrefix(ref, "gcp-secret:"):
+		value, err := gcpSecret(ctx, strings.TrimPrefix(ref, "gcp-secret:"))
+		return value, true, err
+	case strings.HasPrefix(ref, "aws-ssm:"):
+		value, err := awsSSMParameter(ctx, strings.TrimPrefix(ref, "aws-ssm:"))
+		return value, true, err
+	case strings.HasPrefix(ref, "aws-secret:"):
+		value, err := awsSecret(ctx, strings.TrimPrefix(ref, "aws-secret:"))
+		return value, true, err
+	}
+	return "", false, nil
+}
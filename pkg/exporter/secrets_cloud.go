@@ -0,0 +1,250 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The endpoints cloud secrets are fetched from, replaced by tests.
+var (
+	// awsEndpoint returns the endpoint of the AWS service in region.
+	awsEndpoint = func(service, region string) string {
+		return fmt.Sprintf("https://%s.%s.amazonaws.com", service, region)
+	}
+
+	// awsMetadataURL is the instance metadata service of EC2.
+	awsMetadataURL = "http://169.254.169.254"
+
+	secretManagerEndpoint = "https://secretmanager.googleapis.com"
+)
+
+// splitSecretKey splits the optional "#<key>" of a JSON field off the
+// reference ref.
+func splitSecretKey(ref string) (string, string) {
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// secretField returns the field key of the JSON object value, or value itself
+// if key is empty.
+func secretField(value, key string) (string, error) {
+	if key == "" {
+		return value, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object: %s", err)
+	}
+	field, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("secret has no key %q", key)
+	}
+	if s, ok := field.(string); ok {
+		return s, nil
+	}
+	out, err := json.Marshal(field)
+	return string(out), err
+}
+
+// gcpSecret returns the payload of the version of the Google Secret Manager
+// secret ref, projects/<project>/secrets/<secret>[/versions/<version>] with
+// an optional #<key>, authorized with the token of the service account of the
+// instance or, with workload identity, of the pod.
+func gcpSecret(ctx context.Context, ref string) (string, error) {
+	name, key := splitSecretKey(ref)
+	parts := strings.Split(name, "/")
+	if len(parts) == 4 {
+		name += "/versions/latest"
+	} else if len(parts) != 6 {
+		return "", fmt.Errorf("invalid Secret Manager secret %q, expected projects/<project>/secrets/<secret>[/versions/<version>]", name)
+	}
+
+	token := gceToken(ctx)
+	if token == "" {
+		return "", errors.New("no Google credentials: not running on Google Cloud")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, secretManagerEndpoint+"/v1/"+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	var response struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err = doCloudRequest(req, &response); err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(response.Payload.Data)
+	if err != nil {
+		return "", err
+	}
+	return secretField(string(data), key)
+}
+
+// awsSSMParameter returns the decrypted value of the AWS Systems Manager
+// parameter ref.
+func awsSSMParameter(ctx context.Context, ref string) (string, error) {
+	var response struct {
+		Parameter struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	name, key := splitSecretKey(ref)
+	err := awsJSONRequest(ctx, "ssm", "AmazonSSM.GetParameter", map[string]interface{}{"Name": name, "WithDecryption": true}, &response)
+	if err != nil {
+		return "", err
+	}
+	return secretField(response.Parameter.Value, key)
+}
+
+// awsSecret returns the AWS Secrets Manager secret ref, an ID or ARN with an
+// optional #<key>.
+func awsSecret(ctx context.Context, ref string) (string, error) {
+	var response struct {
+		SecretString string `json:"SecretString"`
+	}
+	id, key := splitSecretKey(ref)
+	if err := awsJSONRequest(ctx, "secretsmanager", "secretsmanager.GetSecretValue", map[string]string{"SecretId": id}, &response); err != nil {
+		return "", err
+	}
+	return secretField(response.SecretString, key)
+}
+
+// awsJSONRequest makes the request of the JSON API target of an AWS service,
+// signed with the credentials from the environment or else of the instance
+// profile, and decodes the response into out.
+func awsJSONRequest(ctx context.Context, service, target string, body, out interface{}) error {
+	credentials, ok := awsEnvCredentials()
+	if !ok {
+		var err error
+		if credentials, err = awsInstanceCredentials(ctx); err != nil {
+			return fmt.Errorf("no AWS credentials: %s", err)
+		}
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	region := awsRegion()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, awsEndpoint(service, region)+"/", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	signAWSRequest(req, service, region, payload, credentials, time.Now())
+
+	return doCloudRequest(req, out)
+}
+
+// awsInstanceCredentials returns the credentials of the role of the EC2
+// instance profile, using IMDSv2.
+func awsInstanceCredentials(ctx context.Context) (awsCredentials, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, awsMetadataURL+"/latest/api/token", nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "60")
+	token, err := metadataGet(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+
+	get := func(path string) (string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, awsMetadataURL+path, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("X-Aws-Ec2-Metadata-Token", token)
+		return metadataGet(req)
+	}
+	const rolesPath = "/latest/meta-data/iam/security-credentials/"
+	roles, err := get(rolesPath)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	role := strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+	data, err := get(rolesPath + role)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+
+	var credentials struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+	}
+	if err = json.Unmarshal([]byte(data), &credentials); err != nil {
+		return awsCredentials{}, err
+	}
+	return awsCredentials{credentials.AccessKeyID, credentials.SecretAccessKey, credentials.Token}, nil
+}
+
+// metadataGet returns the body of the response to a request to a metadata
+// service.
+func metadataGet(req *http.Request) (string, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: unexpected status %s", req.URL.Path, resp.Status)
+	}
+	return string(data), nil
+}
+
+// doCloudRequest makes req and decodes the JSON response into out, returning
+// the message of an error response.
+func doCloudRequest(req *http.Request, out interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Google APIs nest the message in error, AWS returns it as
+		// message or Message.
+		var response struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &response)
+		for _, message := range []string{response.Error.Message, response.Message} {
+			if message != "" {
+				return fmt.Errorf("unexpected status %s: %s", resp.Status, message)
+			}
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.Unmarshal(data, out)
+}
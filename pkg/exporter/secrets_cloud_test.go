@@ -0,0 +1,108 @@
+package exporter
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCloudSecrets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			w.Write([]byte(`{"access_token": "gce-token"}`))
+		case r.URL.Path == "/latest/api/token" && r.Method == http.MethodPut:
+			w.Write([]byte("imds-token"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/" && r.Header.Get("X-Aws-Ec2-Metadata-Token") == "imds-token":
+			w.Write([]byte("exporter-role\n"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/exporter-role":
+			w.Write([]byte(`{"AccessKeyId": "ASIAINSTANCE", "SecretAccessKey": "secret", "Token": "session"}`))
+		case strings.HasPrefix(r.URL.Path, "/gcp/v1/projects/mlab/secrets/"):
+			if r.Header.Get("Authorization") != "Bearer gce-token" {
+				http.Error(w, `{"error": {"message": "unauthenticated"}}`, http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Path != "/gcp/v1/projects/mlab/secrets/ndt/versions/latest:access" {
+				http.Error(w, `{"error": {"message": "secret not found"}}`, http.StatusNotFound)
+				return
+			}
+			fmt.Fprintf(w, `{"payload": {"data": %q}}`, base64.StdEncoding.EncodeToString([]byte(`{"key": "gcp-value"}`)))
+		case strings.HasPrefix(r.URL.Path, "/aws/"):
+			if !strings.Contains(r.Header.Get("Authorization"), "Credential="+os.Getenv("EXPECTED_ACCESS_KEY")+"/") {
+				http.Error(w, `{"message": "bad credentials"}`, http.StatusForbidden)
+				return
+			}
+			switch r.Header.Get("X-Amz-Target") {
+			case "AmazonSSM.GetParameter":
+				w.Write([]byte(`{"Parameter": {"Value": "ssm-value"}}`))
+			case "secretsmanager.GetSecretValue":
+				w.Write([]byte(`{"SecretString": "{\"user\": \"ndt\", \"password\": \"aws-value\"}"}`))
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	defer func(aws func(string, string) string, metadata, secretManager, token string) {
+		awsEndpoint, awsMetadataURL, secretManagerEndpoint, gceTokenURL = aws, metadata, secretManager, token
+	}(awsEndpoint, awsMetadataURL, secretManagerEndpoint, gceTokenURL)
+	awsEndpoint = func(service, region string) string { return server.URL + "/aws/" + service + "/" + region }
+	awsMetadataURL = server.URL
+	secretManagerEndpoint = server.URL + "/gcp"
+	gceTokenURL = server.URL + "/token"
+
+	config := `
+scripts:
+  - name: ndt
+    script: exit 0
+    env:
+      GCP: gcp-secret:projects/mlab/secrets/ndt#key
+      SSM: aws-ssm:/mlab/ndt/token
+      AWS: aws-secret:mlab/ndt#password
+`
+	for _, credentials := range []string{"environment", "instance"} {
+		t.Run(credentials, func(t *testing.T) {
+			if credentials == "environment" {
+				os.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+				os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+				os.Setenv("EXPECTED_ACCESS_KEY", "AKIDEXAMPLE")
+			} else {
+				os.Unsetenv("AWS_ACCESS_KEY_ID")
+				os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+				os.Setenv("EXPECTED_ACCESS_KEY", "ASIAINSTANCE")
+			}
+			defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+			defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+			defer os.Unsetenv("EXPECTED_ACCESS_KEY")
+
+			loaded, err := LoadConfig(writeConfig(t, config))
+			if err != nil {
+				t.Fatalf("Unexpected: %s", err)
+			}
+			env := strings.Join(scriptEnv(loaded.Scripts[0], ""), " ")
+			if env != "AWS=aws-value GCP=gcp-value SSM=ssm-value TARGET=" {
+				t.Errorf("Unexpected env: %s", env)
+			}
+		})
+	}
+
+	for ref, expected := range map[string]string{
+		"gcp-secret:projects/mlab/secrets/other":    "secret not found",
+		"gcp-secret:mlab/ndt":                       "invalid Secret Manager secret",
+		"gcp-secret:projects/mlab/secrets/ndt#user": `secret has no key "user"`,
+		"aws-secret:mlab/ndt#missing":               `secret has no key "missing"`,
+		"aws-ssm:/mlab/ndt/token#key":               "not a JSON object",
+	} {
+		os.Setenv("EXPECTED_ACCESS_KEY", "ASIAINSTANCE")
+		_, err := LoadConfig(writeConfig(t, "scripts:\n  - name: ndt\n    script: exit 0\n    env:\n      SECRET: "+ref+"\n"))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+	os.Unsetenv("EXPECTED_ACCESS_KEY")
+}
@@ -0,0 +1,126 @@
+package exporter
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// signatureExt is appended to the path of a script_file to find its detached
+// signature.
+const signatureExt = ".sig"
+
+// LoadSigningKeys reads the Ed25519 public keys in the file at path, given as
+// PEM "PUBLIC KEY" blocks or as base64 keys, one per line.
+func LoadSigningKeys(path string) ([]ed25519.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []ed25519.PublicKey
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		data = rest
+
+		if block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("%s: unexpected PEM block %s", path, block.Type)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		ed25519Key, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+		}
+		keys = append(keys, ed25519Key)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%s: invalid Ed25519 key %q", path, line)
+		}
+		keys = append(keys, ed25519.PublicKey(key))
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+	return keys, nil
+}
+
+// verifySignatures checks that every script and snippet of c is signed with
+// one of keys, if any are given.
+func (c *Config) verifySignatures(keys []ed25519.PublicKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	for _, script := range c.allScripts() {
+		if err := script.verifySignature(keys); err != nil {
+			return fmt.Errorf("script %s: %s", script.Name, err)
+		}
+	}
+	for _, name := range sortedKeys(c.Snippets) {
+		if err := verifySignature(keys, []byte(c.Snippets[name]), c.SnippetSignatures[name]); err != nil {
+			return fmt.Errorf("snippet %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Script) verifySignature(keys []ed25519.PublicKey) error {
+	// Vars are rendered into the content of templates as they are.
+	if len(s.Vars) > 0 {
+		return errors.New("vars are not covered by the signature")
+	}
+	// A custom syntax check is run on every load, before any probe.
+	if s.SyntaxCheck != "" && s.SyntaxCheck != syntaxCheckNone {
+		return errors.New("syntax_check is not covered by the signature")
+	}
+	return verifySignature(keys, s.signedContent(), s.Signature)
+}
+
+// verifySignature checks that the base64 signature of content is made with
+// one of keys.
+func verifySignature(keys []ed25519.PublicKey, content []byte, encoded string) error {
+	if encoded == "" {
+		return errors.New("not signed")
+	}
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %s", err)
+	}
+
+	for _, key := range keys {
+		if ed25519.Verify(key, content, signature) {
+			return nil
+		}
+	}
+	return errors.New("signature does not match any signing key")
+}
+
+// signedContent returns what the signature of the script covers: its
+// content, preceded by its interpreter_args, each terminated by a NUL byte,
+// since they change what the shell runs.
+func (s *Script) signedContent() []byte {
+	var signed []byte
+	for _, arg := range s.InterpreterArgs {
+		signed = append(append(signed, arg...), 0)
+	}
+	return append(signed, s.Content...)
+}
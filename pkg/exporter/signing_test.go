@@ -0,0 +1,82 @@
+package exporter
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSigning(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	sign := func(content string) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(private, []byte(content)))
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"keys.pem":    string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		"keys.txt":    "# fleet keys\n" + base64.StdEncoding.EncodeToString(other) + "\n" + base64.StdEncoding.EncodeToString(public) + "\n",
+		"ping.sh":     "ping -c 1 ${TARGET}",
+		"ping.sh.sig": sign("ping -c 1 ${TARGET}") + "\n",
+		"bad.sh":      "rm -rf /",
+		"bad.sh.sig":  sign("ping -c 1 ${TARGET}"),
+	})
+
+	for _, file := range []string{"keys.pem", "keys.txt"} {
+		keys, err := LoadSigningKeys(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatalf("Unexpected: %s", err)
+		}
+		if !keys[len(keys)-1].Equal(public) {
+			t.Errorf("Expected the key of %s to be loaded", file)
+		}
+	}
+
+	keys := []ed25519.PublicKey{other, public}
+	for config, expected := range map[string]string{
+		fmt.Sprintf("scripts:\n  - name: inline\n    script: exit 0\n    signature: %s\n", sign("exit 0")):     "",
+		"scripts:\n  - name: detached\n    script_file: ping.sh\n":                                             "",
+		"scripts:\n  - name: unsigned\n    script: exit 0\n":                                                   "script unsigned: not signed",
+		"scripts:\n  - name: tampered\n    script_file: bad.sh\n":                                              "script tampered: signature does not match",
+		fmt.Sprintf("scripts:\n  - name: tampered\n    script: exit 1\n    signature: %s\n", sign("exit 0")):   "signature does not match",
+		"modules:\n  - name: module\n    script: exit 0\n":                                                     "script module: not signed",
+		fmt.Sprintf("snippets:\n  preamble: set -eu\nsnippet_signatures:\n  preamble: %s\n", sign("set -eu")):  "",
+		fmt.Sprintf("snippets:\n  preamble: rm -rf /\nsnippet_signatures:\n  preamble: %s\n", sign("set -eu")): "snippet preamble: signature does not match",
+		"snippets:\n  preamble: set -eu\n":                                                                     "snippet preamble: not signed",
+		fmt.Sprintf("scripts:\n  - name: vars\n    template: true\n    script: ping {{ .Vars.host }}\n    vars: {host: x}\n    signature: %s\n", sign("ping {{ .Vars.host }}")): "script vars: vars are not covered",
+		fmt.Sprintf("scripts:\n  - name: checked\n    script: exit 0\n    syntax_check: cat\n    signature: %s\n", sign("exit 0")):                                              "script checked: syntax_check is not covered",
+		fmt.Sprintf("scripts:\n  - name: unchecked\n    script: exit 0\n    syntax_check: none\n    signature: %s\n", sign("exit 0")):                                           "",
+		fmt.Sprintf("scripts:\n  - name: strict\n    script: exit 0\n    interpreter_args: [-e, -u]\n    signature: %s\n", sign("-e\x00-u\x00exit 0")):                          "",
+		fmt.Sprintf("scripts:\n  - name: injected\n    script: exit 0\n    interpreter_args: [-c, rm -rf /]\n    signature: %s\n", sign("exit 0")):                              "script injected: signature does not match",
+	} {
+		path := filepath.Join(dir, "config.yml")
+		writeFiles(t, dir, map[string]string{"config.yml": config})
+
+		_, err := loadConfig([]string{path}, loadOptions{signingKeys: keys})
+		if expected == "" && err != nil {
+			t.Errorf("Unexpected: %s", err)
+		}
+		if expected != "" && (err == nil || !strings.Contains(err.Error(), expected)) {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+
+		// Without keys, signatures are not checked.
+		if _, err := LoadConfig(path); err != nil {
+			t.Errorf("Unexpected: %s", err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package exporter
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// flightGroup coalesces concurrent measurements of a script against the same
+// target into a single execution whose result is shared by every caller.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	done        chan struct{}
+	measurement *Measurement
+}
+
+// do runs measure for target, or waits for and copies the result of an
+// execution already in flight.
+func (g *flightGroup) do(target string, measure func() *Measurement) *Measurement {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	if f, ok := g.flights[target]; ok {
+		g.mu.Unlock()
+		<-f.done
+		shared := *f.measurement
+		return &shared
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[target] = f
+	g.mu.Unlock()
+
+	f.measurement = measure()
+
+	g.mu.Lock()
+	delete(g.flights, target)
+	g.mu.Unlock()
+	close(f.done)
+
+	return f.measurement
+}
+
+// flightKey returns the key of the measurement of script against target with
+// ctx among those in flight: the target and whatever else changes the result,
+// the parameters of the probe the script renders and its timeout. Probes with
+// their own input are never coalesced.
+func flightKey(ctx context.Context, script *Script, target string) string {
+	params := url.Values{}
+	for _, param := range script.Params {
+		if value, ok := paramsFrom(ctx)[param]; ok {
+			params[param] = value
+		}
+	}
+	return strings.Join([]string{target, params.Encode(), scriptTimeout(ctx, script).String()}, "\x00")
+}
+
+// detachedContext has the values of a context but is never cancelled.
+type detachedContext struct {
+	parent context.Context
+}
+
+// detach returns a context with the values of ctx, such as the parameters and
+// the stream listener of a probe, that is not cancelled with it. The listener
+// is no longer called once ctx is done, as the probe it streams to is gone.
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	value := c.parent.Value(key)
+	if listener, ok := value.(func(string, StreamEvent)); ok && key == (streamKey{}) {
+		return func(event string, data StreamEvent) {
+			if c.parent.Err() == nil {
+				listener(event, data)
+			}
+		}
+	}
+	return value
+}
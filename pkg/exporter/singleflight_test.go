@@ -0,0 +1,74 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCoalesce(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "runs")
+	script := &Script{
+		Name:     "coalesced",
+		Content:  fmt.Sprintf("echo run >> %s; sleep 1", log),
+		Timeout:  5,
+		Coalesce: true,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if m := testExporter.runScripts([]*Script{script}, "fake-target")[0]; m.Success != 1 {
+				t.Errorf("Expected coalesced probe to succeed")
+			}
+		}()
+	}
+	wg.Wait()
+
+	runs, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	if n := strings.Count(string(runs), "run"); n != 1 {
+		t.Errorf("Expected 1 execution, got %d", n)
+	}
+}
+
+func TestCoalesceParams(t *testing.T) {
+	e := New(writeConfig(t, `
+scripts:
+  - name: coalesced
+    coalesce: true
+    template: true
+    params: [count]
+    script: sleep 1; echo {{ .Params.count | quote }}
+`))
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, count := range []string{"1", "2"} {
+		wg.Add(1)
+		go func(count string) {
+			defer wg.Done()
+			query := map[string][]string{"name": {"coalesced"}, "target": {"fake-target"}, "count": {count}}
+			err := e.ProbeQuery(context.Background(), e.Config(), query, func(m *Measurement) {
+				if m.Output != count+"\n" {
+					t.Errorf("Expected the output of count %s, got %q", count, m.Output)
+				}
+			})
+			if err != nil {
+				t.Errorf("Unexpected: %s", err)
+			}
+		}(count)
+	}
+	wg.Wait()
+}
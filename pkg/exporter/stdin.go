@@ -0,0 +1,45 @@
+package exporter
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+)
+
+// maxProbeBody limits the body of a POST probe, which is buffered so that
+// every script probed and every retry reads all of it.
+const maxProbeBody = 10 << 20
+
+type stdinKey struct{}
+
+// WithStdin returns a copy of ctx that makes the scripts probed with it read
+// stdin on their stdin, as for the body of a POST probe. Only scripts with
+// accept_body may be probed with it.
+func WithStdin(ctx context.Context, stdin []byte) context.Context {
+	if stdin == nil {
+		stdin = []byte{}
+	}
+	return context.WithValue(ctx, stdinKey{}, stdin)
+}
+
+// stdinFrom returns the input set by WithStdin, or nil.
+func stdinFrom(ctx context.Context) []byte {
+	stdin, _ := ctx.Value(stdinKey{}).([]byte)
+	return stdin
+}
+
+// readProbeBody returns the body of the POST probe r, or nil for other
+// methods.
+func readProbeBody(r *http.Request) ([]byte, error) {
+	if r.Method != http.MethodPost {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxProbeBody))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		body = []byte{}
+	}
+	return body, nil
+}
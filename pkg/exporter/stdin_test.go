@@ -0,0 +1,49 @@
+package exporter
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbeBody(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `
+scripts:
+  - name: validate
+    script: grep -q '^{.*}$'
+    accept_body: true
+    allow_overlap: false
+  - name: plain
+    script: exit 0
+`))
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	for _, test := range []struct {
+		method, query, body string
+		status              int
+		expected            string
+	}{
+		{"POST", "name=validate", `{"download": 1}`, 200, `script_success{script="validate"} 1`},
+		{"POST", "name=validate", "not json", 200, `script_success{script="validate"} 0`},
+		{"POST", "name=validate", strings.Repeat("x", 1<<17) + "\n{}", 200, `script_success{script="validate"} 1`},
+		{"GET", "name=plain", "", 200, `script_success{script="plain"} 1`},
+		{"POST", "pattern=.*", "{}", 400, "Script plain does not accept a request body"},
+		{"POST", "name=validate", strings.Repeat("x", maxProbeBody+1), 413, "request body too large"},
+	} {
+		w := httptest.NewRecorder()
+		testExporter.scriptRunHandler(w, httptest.NewRequest(test.method, "/probe?"+test.query, strings.NewReader(test.body)), config)
+
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.expected) {
+			t.Errorf("%s %s: expected %d with %q, got %d:\n%s", test.method, test.query, test.status, test.expected, w.Code, w.Body.String())
+		}
+	}
+
+	for _, runner := range []string{runnerDocker, runnerKubernetes} {
+		_, err := LoadConfig(writeConfig(t, "scripts:\n  - name: validate\n    script: exit 0\n    accept_body: true\n    runner: "+runner+"\n    docker:\n      image: alpine\n"))
+		if err == nil || !strings.Contains(err.Error(), "accept_body is not supported") {
+			t.Errorf("Expected accept_body to be rejected for the %s runner, got %v", runner, err)
+		}
+	}
+}
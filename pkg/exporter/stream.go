@@ -0,0 +1,138 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// The events of a run streamed by /probe/stream.
+const (
+	streamStarted  = "started"
+	streamOutput   = "output"
+	streamRetry    = "retry"
+	streamFinished = "finished"
+	streamTimedOut = "timed_out"
+	streamEnd      = "end"
+)
+
+// StreamEvent is an event of a run of a script, streamed as it happens.
+type StreamEvent struct {
+	Script  string `json:"script"`
+	Target  string `json:"target"`
+	Attempt int    `json:"attempt,omitempty"`
+
+	// Stream is "stdout" or "stderr" for a Line of output.
+	Stream string `json:"stream,omitempty"`
+	Line   string `json:"line,omitempty"`
+}
+
+type streamKey struct{}
+
+// WithStreamListener returns a copy of ctx that makes the scripts probed with
+// it call listener with the type of each event of their runs: "started" and
+// "retry" with the attempt, and "output" with each line the script writes.
+// Runs coalesced with another probe report their events to the probe that
+// started them only.
+func WithStreamListener(ctx context.Context, listener func(event string, data StreamEvent)) context.Context {
+	return context.WithValue(ctx, streamKey{}, listener)
+}
+
+func streamListener(ctx context.Context) func(string, StreamEvent) {
+	listener, _ := ctx.Value(streamKey{}).(func(string, StreamEvent))
+	return listener
+}
+
+// lineWriter calls emit with each complete line written to it.
+type lineWriter struct {
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	emit func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := string(w.buf.Next(i + 1))
+		w.emit(line[:len(line)-1])
+	}
+	return len(p), nil
+}
+
+// Flush emits the last line if it was not terminated.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf.Len() > 0 {
+		w.emit(w.buf.String())
+		w.buf.Reset()
+	}
+}
+
+// sseWriter writes Server-Sent Events, starting the response with the first.
+type sseWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	started bool
+}
+
+func (s *sseWriter) send(event string, data interface{}) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started {
+		s.w.Header().Set("Content-Type", "text/event-stream")
+		s.w.Header().Set("Cache-Control", "no-cache")
+		s.started = true
+	}
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, encoded)
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// streamHandler runs the probe described by the same parameters as /probe and
+// streams the lifecycle and output of the runs as Server-Sent Events: each
+// run is "started", writes "output" lines and is "finished" or "timed_out"
+// with its result, and "end" follows the last run.
+func (e *Exporter) streamHandler(w http.ResponseWriter, r *http.Request, config *Config) {
+	ctx, probeErr := e.probeContext(r, config)
+	if probeErr != nil {
+		if probeErr.Status == 401 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, probeErr.Message, probeErr.Status)
+		return
+	}
+
+	sse := &sseWriter{w: w}
+	ctx = WithStreamListener(ctx, func(event string, data StreamEvent) {
+		sse.send(event, data)
+	})
+
+	err := e.ProbeQuery(ctx, config, r.URL.Query(), func(measurement *Measurement) {
+		sse.send(finishedEvent(measurement), NewProbeResult(measurement))
+	})
+
+	if probeError, ok := err.(*ProbeError); ok {
+		http.Error(w, probeError.Message, probeError.Status)
+		return
+	}
+	sse.send(streamEnd, struct{}{})
+}
@@ -0,0 +1,56 @@
+package exporter
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `
+scripts:
+  - name: measure
+    script: |
+      echo connecting
+      echo slow >&2
+      printf done
+      exit 1
+    retries: 1
+  - name: hang
+    script: sleep 10
+    timeout: 1
+`))
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	w := httptest.NewRecorder()
+	testExporter.streamHandler(w, httptest.NewRequest("GET", "/probe/stream?pattern=.*", nil), config)
+
+	if w.Code != 200 || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	for _, expected := range []string{
+		"event: started\ndata: {\"script\":\"measure\",\"target\":\"\",\"attempt\":1}\n\n",
+		"event: output\ndata: {\"script\":\"measure\",\"target\":\"\",\"attempt\":1,\"stream\":\"stdout\",\"line\":\"connecting\"}\n\n",
+		"event: output\ndata: {\"script\":\"measure\",\"target\":\"\",\"attempt\":1,\"stream\":\"stderr\",\"line\":\"slow\"}\n\n",
+		"event: output\ndata: {\"script\":\"measure\",\"target\":\"\",\"attempt\":1,\"stream\":\"stdout\",\"line\":\"done\"}\n\n",
+		"event: retry\ndata: {\"script\":\"measure\",\"target\":\"\",\"attempt\":2}\n\n",
+		"event: finished\ndata: {\"script\":\"measure\"",
+		"event: timed_out\ndata: {\"script\":\"hang\"",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, body)
+		}
+	}
+	if !strings.HasSuffix(body, "event: end\ndata: {}\n\n") {
+		t.Errorf("Expected the stream to end with an end event:\n%s", body)
+	}
+
+	w = httptest.NewRecorder()
+	testExporter.streamHandler(w, httptest.NewRequest("GET", "/probe/stream?module=missing", nil), config)
+	if w.Code != 400 || strings.Contains(w.Body.String(), "event:") {
+		t.Errorf("Expected a plain 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,100 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// SuccessCriteria decides whether a completed run of a script succeeded.
+// Without criteria a run succeeds if the script exits with status 0.
+type SuccessCriteria struct {
+	// ExitCodes lists the exit statuses that count as success.
+	ExitCodes []int `yaml:"exit_codes,omitempty"`
+
+	// OutputMatches is a regular expression stdout must match.
+	OutputMatches string `yaml:"output_matches,omitempty"`
+
+	// Value extracts a number from stdout and compares it to thresholds.
+	Value *ValueCriterion `yaml:"value,omitempty"`
+
+	outputRegexp *regexp.Regexp
+}
+
+// ValueCriterion extracts the first capture group of Regexp from stdout as a
+// number, which must lie within the inclusive bounds Min and Max if set.
+type ValueCriterion struct {
+	Regexp string   `yaml:"regex"`
+	Min    *float64 `yaml:"min,omitempty"`
+	Max    *float64 `yaml:"max,omitempty"`
+
+	regexp *regexp.Regexp
+}
+
+func (c *SuccessCriteria) compile() (err error) {
+	if c.OutputMatches != "" {
+		if c.outputRegexp, err = regexp.Compile(c.OutputMatches); err != nil {
+			return fmt.Errorf("invalid output_matches: %s", err)
+		}
+	}
+
+	if c.Value != nil {
+		if c.Value.regexp, err = regexp.Compile(c.Value.Regexp); err != nil {
+			return fmt.Errorf("invalid value regex: %s", err)
+		}
+		if c.Value.regexp.NumSubexp() < 1 {
+			return fmt.Errorf("value regex %q has no capture group", c.Value.Regexp)
+		}
+	}
+
+	return nil
+}
+
+// evaluate checks the exit status and stdout of a run that was not killed,
+// returning the failure reason and an error describing it if the run failed.
+func (c *SuccessCriteria) evaluate(rc int, stdout string) (string, error) {
+	exitCodes := []int{0}
+	if c != nil && len(c.ExitCodes) > 0 {
+		exitCodes = c.ExitCodes
+	}
+
+	accepted := false
+	for _, code := range exitCodes {
+		accepted = accepted || code == rc
+	}
+	if !accepted {
+		if rc == 0 {
+			return reasonCriteriaNotMet, fmt.Errorf("exit status 0 not in %v", exitCodes)
+		}
+		return reasonNonzeroExit, fmt.Errorf("exit status %d", rc)
+	}
+
+	if c == nil {
+		return "", nil
+	}
+
+	if c.outputRegexp != nil && !c.outputRegexp.MatchString(stdout) {
+		return reasonCriteriaNotMet, fmt.Errorf("output does not match %q", c.OutputMatches)
+	}
+
+	if v := c.Value; v != nil {
+		match := v.regexp.FindStringSubmatch(stdout)
+		if match == nil {
+			return reasonOutputParseError, fmt.Errorf("output does not match value regex %q", v.Regexp)
+		}
+
+		value, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			return reasonOutputParseError, fmt.Errorf("invalid value %q: %s", match[1], err)
+		}
+
+		if v.Min != nil && value < *v.Min {
+			return reasonCriteriaNotMet, fmt.Errorf("value %g below minimum %g", value, *v.Min)
+		}
+		if v.Max != nil && value > *v.Max {
+			return reasonCriteriaNotMet, fmt.Errorf("value %g above maximum %g", value, *v.Max)
+		}
+	}
+
+	return "", nil
+}
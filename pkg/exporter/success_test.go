@@ -0,0 +1,51 @@
+package exporter
+
+import (
+	"testing"
+)
+
+func TestSuccessCriteria(t *testing.T) {
+	min, max := 10.0, 100.0
+
+	for _, test := range []struct {
+		name     string
+		content  string
+		criteria *SuccessCriteria
+		reason   string
+	}{
+		{"Default", "exit 2", nil, reasonNonzeroExit},
+		{"ExitCodes", "exit 2", &SuccessCriteria{ExitCodes: []int{0, 2}}, ""},
+		{"ExitCodesExcludeZero", "exit 0", &SuccessCriteria{ExitCodes: []int{1}}, reasonCriteriaNotMet},
+		{"OutputMatches", "echo status: OK", &SuccessCriteria{OutputMatches: "status: OK"}, ""},
+		{"OutputMismatch", "echo status: DEGRADED", &SuccessCriteria{OutputMatches: "status: OK"}, reasonCriteriaNotMet},
+		{"OutputMatchesStdoutOnly", "echo status: OK >&2", &SuccessCriteria{OutputMatches: "status: OK"}, reasonCriteriaNotMet},
+		{"ValueInRange", "echo rtt=42.5", &SuccessCriteria{Value: &ValueCriterion{Regexp: `rtt=([0-9.]+)`, Min: &min, Max: &max}}, ""},
+		{"ValueAboveMax", "echo rtt=420", &SuccessCriteria{Value: &ValueCriterion{Regexp: `rtt=([0-9.]+)`, Max: &max}}, reasonCriteriaNotMet},
+		{"ValueMissing", "echo timeout", &SuccessCriteria{Value: &ValueCriterion{Regexp: `rtt=([0-9.]+)`, Max: &max}}, reasonOutputParseError},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			script := &Script{Name: test.name, Content: test.content, SuccessWhen: test.criteria}
+			if err := script.setDefaults(); err != nil {
+				t.Fatalf("Unexpected: %s", err.Error())
+			}
+
+			measurement := testExporter.runScripts([]*Script{script}, "")[0]
+
+			if measurement.ErrorReason != test.reason {
+				t.Errorf("Expected reason %q, got %q", test.reason, measurement.ErrorReason)
+			}
+
+			if expected := boolToInt(test.reason == ""); measurement.Success != expected {
+				t.Errorf("Expected success %d, got %d", expected, measurement.Success)
+			}
+		})
+	}
+}
+
+func TestSuccessCriteriaCompile(t *testing.T) {
+	criteria := &SuccessCriteria{Value: &ValueCriterion{Regexp: `rtt=[0-9.]+`}}
+
+	if err := criteria.compile(); err == nil {
+		t.Errorf("Expected error for value regex without capture group")
+	}
+}
@@ -0,0 +1,125 @@
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// streamAttached is the event starting the tail of a run in flight.
+const streamAttached = "attached"
+
+// tailMessage is a message of the /probe/tail WebSocket.
+type tailMessage struct {
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+// tailHandler serves a WebSocket that tails the output of the script or module
+// named by the name or module parameter against target. It attaches to the
+// run in flight started last, replaying its recent output, or else starts a
+// run as /probe would. The tail ends with the result of the run.
+func (e *Exporter) tailHandler(w http.ResponseWriter, r *http.Request, config *Config) {
+	ctx, probeErr := e.probeContext(r, config)
+	if probeErr != nil {
+		if probeErr.Status == 401 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, probeErr.Message, probeErr.Status)
+		return
+	}
+
+	params := r.URL.Query()
+	params.Del("pattern")
+	name := params.Get("name")
+	if name == "" {
+		name = params.Get("module")
+	}
+	if name == "" {
+		http.Error(w, "`name` or `module` required", 400)
+		return
+	}
+	if config.Script(name) == nil && config.module(name) == nil {
+		http.Error(w, "Unknown script "+name, 404)
+		return
+	}
+
+	websocket.Server{
+		// Clients are authorized by probe_auth rather than by their
+		// origin.
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			// The client closing the connection ends the tail.
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			go func() {
+				var message string
+				for websocket.Message.Receive(ws, &message) == nil {
+				}
+				cancel()
+			}()
+
+			var mu sync.Mutex
+			send := func(event string, data interface{}) {
+				mu.Lock()
+				defer mu.Unlock()
+				websocket.JSON.Send(ws, tailMessage{event, data})
+			}
+
+			if run := e.live.find(name, params.Get("target")); run != nil {
+				tailRun(ctx, run, send)
+			} else {
+				ctx = WithStreamListener(ctx, func(event string, data StreamEvent) {
+					send(event, data)
+				})
+				err := e.ProbeQuery(ctx, config, params, func(measurement *Measurement) {
+					send(finishedEvent(measurement), NewProbeResult(measurement))
+				})
+				if err != nil {
+					send("error", map[string]string{"error": err.Error()})
+				}
+			}
+			if ctx.Err() == nil {
+				send(streamEnd, struct{}{})
+			}
+		},
+	}.ServeHTTP(w, r)
+}
+
+// tailRun sends the recent and following output of the run in flight, then
+// its result, until ctx is cancelled.
+func tailRun(ctx context.Context, run *liveRun, send func(string, interface{})) {
+	backlog, lines := run.subscribe()
+	defer run.unsubscribe(lines)
+
+	send(streamAttached, StreamEvent{Script: run.script.Name, Target: run.target})
+	for _, event := range backlog {
+		send(streamOutput, event)
+	}
+
+	for {
+		select {
+		case event, ok := <-lines:
+			if !ok {
+				<-run.done
+				send(finishedEvent(run.result), NewProbeResult(run.result))
+				return
+			}
+			send(streamOutput, event)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// finishedEvent returns the event reporting the result of measurement.
+func finishedEvent(measurement *Measurement) string {
+	if measurement.ErrorReason == reasonTimeout {
+		return streamTimedOut
+	}
+	return streamFinished
+}
@@ -0,0 +1,91 @@
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestTail(t *testing.T) {
+	e := New(writeConfig(t, `
+scripts:
+  - name: slow
+    script: |
+      echo first
+      sleep 1
+      echo second
+  - name: quick
+    script: echo hello >&2
+`))
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	mux := http.NewServeMux()
+	e.RegisterHandlers(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tail := func(query string) []string {
+		ws, err := websocket.Dial(strings.Replace(server.URL, "http", "ws", 1)+"/probe/tail?"+query, "", server.URL)
+		if err != nil {
+			t.Fatalf("Unexpected: %s", err)
+		}
+		defer ws.Close()
+
+		var messages []string
+		for {
+			var message string
+			if err := websocket.Message.Receive(ws, &message); err != nil {
+				return messages
+			}
+			messages = append(messages, message)
+		}
+	}
+
+	// Attaching to a run in flight replays its output so far.
+	done := make(chan *Measurement)
+	go func() {
+		done <- e.runScripts([]*Script{e.Config().Script("slow")}, "mlab1.lga03")[0]
+	}()
+	time.Sleep(300 * time.Millisecond)
+	messages := tail("name=slow&target=mlab1.lga03")
+	<-done
+
+	expected := []string{
+		`{"event":"attached","data":{"script":"slow","target":"mlab1.lga03"}}`,
+		`{"event":"output","data":{"script":"slow","target":"mlab1.lga03","attempt":1,"stream":"stdout","line":"first"}}`,
+		`{"event":"output","data":{"script":"slow","target":"mlab1.lga03","attempt":1,"stream":"stdout","line":"second"}}`,
+		`{"event":"finished","data":{"script":"slow","target":"mlab1.lga03"`,
+		`{"event":"end","data":{}}`,
+	}
+	if len(messages) != len(expected) {
+		t.Fatalf("Expected %d messages, got:\n%s", len(expected), strings.Join(messages, "\n"))
+	}
+	for i, message := range messages {
+		if !strings.HasPrefix(message, expected[i]) {
+			t.Errorf("Expected %s, got %s", expected[i], message)
+		}
+	}
+
+	// Without a run in flight, a run is started.
+	messages = tail("name=quick")
+	if len(messages) != 4 || !strings.Contains(messages[0], `"event":"started"`) || !strings.Contains(messages[1], `"line":"hello"`) ||
+		!strings.Contains(messages[2], `"event":"finished"`) {
+		t.Errorf("Unexpected messages:\n%s", strings.Join(messages, "\n"))
+	}
+
+	for query, status := range map[string]int{"": 400, "name=missing": 404} {
+		resp, err := http.Get(server.URL + "/probe/tail?" + query)
+		if err != nil {
+			t.Fatalf("Unexpected: %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%q: expected status %d, got %d", query, status, resp.StatusCode)
+		}
+	}
+}
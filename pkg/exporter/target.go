@@ -0,0 +1,214 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Characters a shell would interpret within an unquoted $TARGET, which are
+// rejected in every target. Brackets are only allowed around an IPv6 host.
+const unsafeTargetChars = " \t\r\n`$'\"\\;|&<>(){}*?#~!"
+
+var schemeRegexp = regexp.MustCompile("^[a-zA-Z][a-zA-Z0-9+.-]*$")
+
+// Target is a probe target broken into the components made available to
+// scripts as TARGET_HOST, TARGET_PORT, TARGET_SCHEME and TARGET_PATH.
+type Target struct {
+	Host   string
+	Port   string
+	Scheme string
+	Path   string
+}
+
+// ParseTarget validates target, which may be a host name, an IP address, either
+// with a port, or an absolute URL. IPv6 addresses with a port are bracketed,
+// as in [2001:db8::1]:443.
+func ParseTarget(target string) (*Target, error) {
+	if strings.ContainsAny(target, unsafeTargetChars) {
+		return nil, errors.New("target contains unsafe characters")
+	}
+
+	if strings.Contains(target, "://") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		if !schemeRegexp.MatchString(u.Scheme) || u.Host == "" {
+			return nil, errors.New("URL targets require a scheme and a host")
+		}
+		if strings.ContainsAny(strings.Replace(target, "["+u.Hostname()+"]", "", 1), "[]") {
+			return nil, errors.New("target contains unsafe characters")
+		}
+		return newTarget(u.Hostname(), u.Port(), u.Scheme, u.Path)
+	}
+
+	if net.ParseIP(target) != nil {
+		return newTarget(target, "", "", "")
+	}
+
+	host, port := target, ""
+	if strings.HasPrefix(target, "[") && strings.HasSuffix(target, "]") {
+		host = target[1 : len(target)-1]
+	} else if h, p, err := net.SplitHostPort(target); err == nil {
+		host, port = h, p
+	}
+	if strings.HasPrefix(target, "[") && net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("invalid IPv6 address %q", host)
+	}
+
+	return newTarget(host, port, "", "")
+}
+
+// validateTarget checks that the script can be probed against target: a
+// container for scripts with the kubernetes runner selecting it by target, as
+// parsed by ParseTarget otherwise.
+func (s *Script) validateTarget(target string) error {
+	if s.Runner == runnerKubernetes && s.Kubernetes != nil && s.Kubernetes.targetSelects() {
+		return parseKubernetesTarget(target)
+	}
+	_, err := ParseTarget(target)
+	return err
+}
+
+func newTarget(host, port, scheme, path string) (*Target, error) {
+	if net.ParseIP(host) == nil && !targetRegexp.MatchString(host) {
+		return nil, fmt.Errorf("invalid host %q", host)
+	}
+
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("invalid port %q", port)
+		}
+	}
+
+	return &Target{Host: host, Port: port, Scheme: scheme, Path: path}, nil
+}
+
+// env renders the components of the target as script environment variables.
+func (t *Target) env() []string {
+	return []string{
+		"TARGET_HOST=" + t.Host,
+		"TARGET_PORT=" + t.Port,
+		"TARGET_SCHEME=" + t.Scheme,
+		"TARGET_PATH=" + t.Path,
+	}
+}
+
+// resolveError is returned by resolveTarget if the host of the target cannot
+// be resolved.
+type resolveError struct {
+	err error
+}
+
+func (e *resolveError) Error() string {
+	return "resolving target: " + e.err.Error()
+}
+
+// resolveTarget returns an address of the host of target, of the IP version
+// protocol ("ip4" or "ip6", the default) if it has one and of the other one
+// otherwise.
+func resolveTarget(ctx context.Context, target, protocol string) (string, error) {
+	parsed, err := ParseTarget(target)
+	if err != nil {
+		return "", &resolveError{err}
+	}
+	if ip := net.ParseIP(parsed.Host); ip != nil {
+		return ip.String(), nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, parsed.Host)
+	if err != nil {
+		return "", &resolveError{err}
+	}
+	if len(addrs) == 0 {
+		return "", &resolveError{fmt.Errorf("no addresses for %s", parsed.Host)}
+	}
+
+	for _, addr := range addrs {
+		if (addr.IP.To4() != nil) == (protocol == "ip4") {
+			return addr.IP.String(), nil
+		}
+	}
+	return addrs[0].IP.String(), nil
+}
+
+// TargetList matches targets by the network their IP address lies in, their
+// domain or a regular expression.
+type TargetList struct {
+	// CIDRs match targets whose host is an IP address within one of them.
+	// Host names are not resolved.
+	CIDRs []string `yaml:"cidrs,omitempty"`
+
+	// Domains match targets whose host is one of them or a subdomain.
+	Domains []string `yaml:"domains,omitempty"`
+
+	// Regexes match targets matching one of them in full.
+	Regexes []string `yaml:"regexes,omitempty"`
+
+	networks []*net.IPNet
+	regexps  []*regexp.Regexp
+}
+
+func (l *TargetList) compile() error {
+	l.networks = l.networks[:0]
+	for _, cidr := range l.CIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		l.networks = append(l.networks, network)
+	}
+
+	l.regexps = l.regexps[:0]
+	for _, expr := range l.Regexes {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return err
+		}
+		l.regexps = append(l.regexps, re)
+	}
+
+	return nil
+}
+
+func (l *TargetList) empty() bool {
+	return len(l.CIDRs) == 0 && len(l.Domains) == 0 && len(l.Regexes) == 0
+}
+
+// matches reports whether target is in the list.
+func (l *TargetList) matches(target string) bool {
+	for _, re := range l.regexps {
+		if re.MatchString(target) {
+			return true
+		}
+	}
+
+	parsed, err := ParseTarget(target)
+	if err != nil {
+		return false
+	}
+
+	if ip := net.ParseIP(parsed.Host); ip != nil {
+		for _, network := range l.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(parsed.Host, "."))
+	for _, domain := range l.Domains {
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
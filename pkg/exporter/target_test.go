@@ -0,0 +1,138 @@
+package exporter
+
+import (
+	"context"
+	"net/url"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	for _, test := range []struct {
+		target   string
+		expected Target
+	}{
+		{"mlab1.lga03.measurement-lab.org", Target{Host: "mlab1.lga03.measurement-lab.org"}},
+		{"mlab1.lga03:9090", Target{Host: "mlab1.lga03", Port: "9090"}},
+		{"192.0.2.1", Target{Host: "192.0.2.1"}},
+		{"2001:db8::1", Target{Host: "2001:db8::1"}},
+		{"[2001:db8::1]", Target{Host: "2001:db8::1"}},
+		{"[2001:db8::1]:443", Target{Host: "2001:db8::1", Port: "443"}},
+		{"https://ndt.example.com:4443/ndt/v7", Target{Host: "ndt.example.com", Port: "4443", Scheme: "https", Path: "/ndt/v7"}},
+		{"http://[2001:db8::1]/", Target{Host: "2001:db8::1", Scheme: "http", Path: "/"}},
+	} {
+		parsed, err := ParseTarget(test.target)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", test.target, err)
+			continue
+		}
+		if *parsed != test.expected {
+			t.Errorf("Expected %+v for %s, got %+v", test.expected, test.target, *parsed)
+		}
+	}
+
+	for _, target := range []string{
+		"'; rm -rf /'",
+		"$(reboot).example.com",
+		"example.com:99999",
+		"[not-an-ip]:80",
+		"[not-an-ip]",
+		"file:///etc/passwd",
+		"https://example.com/`id`",
+		"http://h/a&b",
+		"http://h/a!b",
+		"https://example.com/ndt?x=1",
+		"https://example.com/#top",
+		"http://[2001:db8::1]/[x]",
+		"[2001:db8::1]]:443",
+		"~root",
+	} {
+		if _, err := ParseTarget(target); err == nil {
+			t.Errorf("Expected error for %s", target)
+		}
+	}
+}
+
+func TestTargetEnv(t *testing.T) {
+	script := &Script{Name: "env", Content: "echo $TARGET_SCHEME $TARGET_HOST $TARGET_PORT $TARGET_PATH", Timeout: 1}
+
+	measurement := testExporter.runScripts([]*Script{script}, "https://[2001:db8::1]:8443/status")[0]
+
+	if expected := "https 2001:db8::1 8443 /status\n"; measurement.Output != expected {
+		t.Errorf("Expected output %q, got %q", expected, measurement.Output)
+	}
+}
+
+func TestResolveTarget(t *testing.T) {
+	for _, test := range []struct {
+		target   string
+		protocol string
+		expected string
+	}{
+		{"localhost", "ip4", "127.0.0.1"},
+		{"http://localhost:8080/", "ip4", "127.0.0.1"},
+		{"[::1]:443", "ip4", "::1"},
+	} {
+		ip, err := resolveTarget(context.Background(), test.target, test.protocol)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", test.target, err)
+		} else if ip != test.expected {
+			t.Errorf("Expected %s for %s, got %s", test.expected, test.target, ip)
+		}
+	}
+
+	script := &Script{Name: "resolve", Content: "echo $TARGET_IP", Timeout: 5, ResolveTarget: true}
+	measurement := testExporter.runScripts([]*Script{script}, "does-not-exist.invalid")[0]
+
+	if measurement.ErrorReason != reasonDNSFailure {
+		t.Errorf("Expected reason %q, got %q", reasonDNSFailure, measurement.ErrorReason)
+	}
+}
+
+func TestTargetAllowed(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `
+allowed_targets:
+  cidrs: [192.0.2.0/24, "2001:db8::/32"]
+  domains: [measurement-lab.org]
+  regexes: ['mlab[1-4]\.[a-z]{3}[0-9t]{2}']
+denied_targets:
+  domains: [internal.measurement-lab.org]
+scripts:
+  - name: default
+    script: exit 0
+  - name: open
+    script: exit 0
+    allowed_targets: {}
+`))
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err.Error())
+	}
+
+	script, open := config.Script("default"), config.Script("open")
+
+	for _, test := range []struct {
+		script  *Script
+		target  string
+		allowed bool
+	}{
+		{script, "192.0.2.10", true},
+		{script, "[2001:db8::1]:443", true},
+		{script, "198.51.100.1", false},
+		{script, "ndt.mlab1.lga03.measurement-lab.org", true},
+		{script, "https://measurement-lab.org/", true},
+		{script, "evil-measurement-lab.org", false},
+		{script, "db.internal.measurement-lab.org", false},
+		{script, "mlab2.lga0t", true},
+		{script, "localhost", false},
+		{open, "localhost", true},
+		{open, "db.internal.measurement-lab.org", false},
+	} {
+		if allowed := config.targetAllowed(test.script, test.target); allowed != test.allowed {
+			t.Errorf("Expected %s allowed=%t for %s, got %t", test.target, test.allowed, test.script.Name, allowed)
+		}
+	}
+
+	err = testExporter.ProbeQuery(context.Background(), config, url.Values{"name": {"default"}, "target": {"localhost"}}, func(*Measurement) {})
+	if probeError, ok := err.(*ProbeError); !ok || probeError.Status != 403 {
+		t.Errorf("Expected 403 for denied target, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+type paramsKey struct{}
+
+// withParams returns a copy of ctx that renders the templates of the scripts
+// probed with it with the query parameters params.
+func withParams(ctx context.Context, params url.Values) context.Context {
+	return context.WithValue(ctx, paramsKey{}, params)
+}
+
+// paramsFrom returns the parameters set by withParams, or nil.
+func paramsFrom(ctx context.Context) url.Values {
+	params, _ := ctx.Value(paramsKey{}).(url.Values)
+	return params
+}
+
+// templateData is what the content of a script with template: true is
+// rendered with.
+type templateData struct {
+	// Name is the name of the script and Target the probe target, whose
+	// components are Host, Port, Scheme and Path. The target is given by the
+	// client, so these are quoted for the shell; Raw holds them as given,
+	// for scripts that are not shell.
+	Name string
+	targetData
+	Raw targetData
+
+	// Params are the query parameters of the probe listed in the params of
+	// the script, empty if not given, and Vars the vars of the script.
+	Params map[string]string
+	Vars   map[string]string
+}
+
+// targetData is a probe target and its components.
+type targetData struct {
+	Target                   string
+	Host, Port, Scheme, Path string
+}
+
+// quoted returns t with every value quoted for a POSIX shell.
+func (t targetData) quoted() targetData {
+	return targetData{
+		Target: shellQuote(t.Target),
+		Host:   shellQuote(t.Host),
+		Port:   shellQuote(t.Port),
+		Scheme: shellQuote(t.Scheme),
+		Path:   shellQuote(t.Path),
+	}
+}
+
+var templateFuncs = template.FuncMap{
+	// quote quotes a value for a POSIX shell.
+	"quote": shellQuote,
+	// default returns value, or else fallback if value is empty.
+	"default": func(fallback, value string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	},
+}
+
+// compileTemplate parses the content of the script as a template, if it has
+// template: true, along with the snippets it may include.
+func (s *Script) compileTemplate(snippets map[string]string) error {
+	if !s.Template {
+		if len(s.Params) > 0 || len(s.Vars) > 0 {
+			return fmt.Errorf("params and vars require template: true")
+		}
+		return nil
+	}
+	for _, param := range s.Params {
+		if !labelNameRegexp.MatchString(param) {
+			return fmt.Errorf("invalid param %q", param)
+		}
+	}
+
+	t := template.New(s.Name).Funcs(templateFuncs).Option("missingkey=error")
+	for _, name := range sortedKeys(snippets) {
+		if _, err := t.New(name).Parse(snippets[name]); err != nil {
+			return fmt.Errorf("invalid snippet %s: %s", name, err)
+		}
+	}
+	if _, err := t.Parse(s.Content); err != nil {
+		return fmt.Errorf("invalid template: %s", err)
+	}
+	s.template = t
+	return nil
+}
+
+// render returns the content of the script to run against target with the
+// query parameters params: that rendered as a template, or as is.
+func (s *Script) render(target string, params url.Values) (string, error) {
+	if s.template == nil {
+		return s.Content, nil
+	}
+
+	data := templateData{
+		Name:   s.Name,
+		Raw:    targetData{Target: target},
+		Params: make(map[string]string, len(s.Params)),
+		Vars:   s.Vars,
+	}
+	if data.Vars == nil {
+		data.Vars = map[string]string{}
+	}
+	if parsed, err := ParseTarget(target); err == nil {
+		data.Raw.Host, data.Raw.Port, data.Raw.Scheme, data.Raw.Path = parsed.Host, parsed.Port, parsed.Scheme, parsed.Path
+	}
+	data.targetData = data.Raw.quoted()
+	for _, param := range s.Params {
+		data.Params[param] = params.Get(param)
+	}
+
+	var content strings.Builder
+	if err := s.template.Execute(&content, data); err != nil {
+		return "", fmt.Errorf("rendering template: %s", err)
+	}
+	return content.String(), nil
+}
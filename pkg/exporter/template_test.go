@@ -0,0 +1,118 @@
+package exporter
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTemplate(t *testing.T) {
+	e := New(writeConfig(t, `
+scripts:
+  - name: ping
+    template: true
+    params: [count, note]
+    vars:
+      interval: "0.2"
+    script: |
+      echo {{ .Name }} {{ .Host }} {{ .Port }} -c {{ .Params.count | default "3" }} -i {{ .Vars.interval }}
+      echo {{ .Params.note | quote }}
+  - name: plain
+    script: echo '{{ .Host }}'
+  - name: echo
+    template: true
+    script: echo {{ .Target }} {{ .Host }}/{{ .Raw.Path }}
+`))
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	probe := func(query map[string][]string) map[string]string {
+		outputs := make(map[string]string)
+		err := e.ProbeQuery(context.Background(), e.Config(), query, func(m *Measurement) {
+			outputs[m.Script.Name] = m.Output
+		})
+		if err != nil {
+			t.Fatalf("Unexpected: %s", err)
+		}
+		return outputs
+	}
+
+	outputs := probe(map[string][]string{"pattern": {".*"}, "target": {"mlab1.lga03:8080"}, "count": {"5"}, "note": {"it's $(rm -rf /)"}})
+	if expected := "ping mlab1.lga03 8080 -c 5 -i 0.2\nit's $(rm -rf /)\n"; outputs["ping"] != expected {
+		t.Errorf("Expected %q, got %q", expected, outputs["ping"])
+	}
+	if expected := "{{ .Host }}\n"; outputs["plain"] != expected {
+		t.Errorf("Expected scripts without template: true to run as is, got %q", outputs["plain"])
+	}
+	if outputs := probe(map[string][]string{"name": {"ping"}, "target": {"mlab1.lga03"}}); !strings.HasPrefix(outputs["ping"], "ping mlab1.lga03  -c 3 ") {
+		t.Errorf("Expected an empty port and the default count without the parameter, got %q", outputs["ping"])
+	}
+
+	var echo *Script
+	for _, script := range e.Config().Scripts {
+		if script.Name == "echo" {
+			echo = script
+		}
+	}
+	for target, expected := range map[string]string{
+		"https://host.example.com/ndt":  `echo 'https://host.example.com/ndt' 'host.example.com'//ndt`,
+		"http://host.example.com/a&id&": `echo 'http://host.example.com/a&id&' ''/`,
+	} {
+		if content, err := echo.render(target, nil); err != nil || content != expected {
+			t.Errorf("Expected %q for %s, got %q: %v", expected, target, content, err)
+		}
+	}
+
+	for content, message := range map[string]string{
+		"scripts:\n  - name: a\n    template: true\n    script: 'echo {{ .Host'\n":              "invalid template",
+		"scripts:\n  - name: a\n    template: true\n    script: 'echo {{ .Vars.missing }}'\n":   "rendering template",
+		"scripts:\n  - name: a\n    template: true\n    script: 'echo {{ .Params.missing }}'\n": "rendering template",
+		"scripts:\n  - name: a\n    params: [count]\n    script: 'echo'\n":                      "require template: true",
+	} {
+		e := New(writeConfig(t, content))
+		if err := e.Reload(); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected an error containing %q, got %v", message, err)
+		}
+	}
+}
+
+func TestSnippets(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.yml": `
+include: [helpers.yml]
+snippets:
+  preamble: |
+    set -e
+    {{ template "metric" "script_runs_total 1" }}
+scripts:
+  - name: check
+    template: true
+    script: |
+      {{ template "preamble" . }}
+      {{ template "metric" (printf "target_checked{host=%q} 1" .Raw.Host) }}
+`,
+		"helpers.yml": `
+snippets:
+  metric: echo '{{ . }}'
+`,
+	})
+
+	e := New(dir + "/config.yml")
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	measurement := e.runScripts(e.Config().Scripts, "mlab1.lga03")[0]
+	if expected := "script_runs_total 1\ntarget_checked{host=\"mlab1.lga03\"} 1\n"; measurement.Output != expected {
+		t.Errorf("Expected %q, got %q", expected, measurement.Output)
+	}
+
+	writeFiles(t, dir, map[string]string{"helpers.yml": "snippets:\n  preamble: set -u\n"})
+	if _, err := LoadConfig(dir + "/config.yml"); err == nil || !strings.Contains(err.Error(), "already defined") {
+		t.Errorf("Expected a snippet defined twice to be rejected, got %v", err)
+	}
+	if _, err := LoadConfig(writeConfig(t, "snippets:\n  broken: '{{ .Host'\nscripts:\n  - name: a\n    template: true\n    script: echo\n")); err == nil || !strings.Contains(err.Error(), "invalid snippet broken") {
+		t.Errorf("Expected an invalid snippet to be rejected, got %v", err)
+	}
+}
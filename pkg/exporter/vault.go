@@ -0,0 +1,248 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// defaultKubernetesJWTFile is the service account token Kubernetes mounts
+// into pods.
+const defaultKubernetesJWTFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// The methods of authenticating to Vault.
+const (
+	vaultAuthToken      = "token"
+	vaultAuthAppRole    = "approle"
+	vaultAuthKubernetes = "kubernetes"
+)
+
+// Vault configures fetching the secrets referenced as vault:<path>#<key> in
+// the env of scripts from HashiCorp Vault.
+type Vault struct {
+	// Address is the URL of the Vault server, VAULT_ADDR by default.
+	Address string `yaml:"address,omitempty"`
+
+	// Namespace is the Vault Enterprise namespace, VAULT_NAMESPACE by
+	// default.
+	Namespace string `yaml:"namespace,omitempty"`
+
+	Auth VaultAuth `yaml:"auth,omitempty"`
+}
+
+// VaultAuth configures how the exporter authenticates to Vault.
+type VaultAuth struct {
+	// Method is "token" (the default), "approle" or "kubernetes".
+	Method string `yaml:"method,omitempty"`
+
+	// Mount is the path the auth method is enabled at, the name of the
+	// method by default.
+	Mount string `yaml:"mount,omitempty"`
+
+	// TokenFile holds the token of the token method, which is taken from
+	// VAULT_TOKEN if unset.
+	TokenFile string `yaml:"token_file,omitempty"`
+
+	// RoleID and the content of SecretIDFile log in with the approle
+	// method.
+	RoleID       string `yaml:"role_id,omitempty"`
+	SecretIDFile string `yaml:"secret_id_file,omitempty"`
+
+	// Role and the service account token in JWTFile log in with the
+	// kubernetes method.
+	Role    string `yaml:"role,omitempty"`
+	JWTFile string `yaml:"jwt_file,omitempty"`
+}
+
+func (v *Vault) setDefaults() error {
+	if v.Address == "" {
+		v.Address = os.Getenv("VAULT_ADDR")
+	}
+	if v.Namespace == "" {
+		v.Namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+
+	auth := &v.Auth
+	if auth.Method == "" {
+		auth.Method = vaultAuthToken
+	}
+	if auth.Mount == "" {
+		auth.Mount = auth.Method
+	}
+	switch auth.Method {
+	case vaultAuthToken:
+	case vaultAuthAppRole:
+		if auth.RoleID == "" || auth.SecretIDFile == "" {
+			return errors.New("approle auth requires role_id and secret_id_file")
+		}
+	case vaultAuthKubernetes:
+		if auth.Role == "" {
+			return errors.New("kubernetes auth requires role")
+		}
+		if auth.JWTFile == "" {
+			auth.JWTFile = defaultKubernetesJWTFile
+		}
+	default:
+		return fmt.Errorf("unknown auth method %q", auth.Method)
+	}
+	return nil
+}
+
+// vaultClient fetches secrets from Vault, logging in on first use.
+type vaultClient struct {
+	config *Vault
+	token  string
+
+	// secrets caches the data of the secrets fetched by path.
+	secrets map[string]map[string]interface{}
+}
+
+func newVaultClient(config *Vault) *vaultClient {
+	if config == nil {
+		config = &Vault{}
+	}
+	return &vaultClient{config: config, secrets: make(map[string]map[string]interface{})}
+}
+
+// secret returns the value of the key of the secret ref, a path and key
+// separated by "#". The data of secrets of the KV version 2 engine is
+// unwrapped from their metadata.
+func (v *vaultClient) secret(ctx context.Context, ref string) (string, error) {
+	i := strings.LastIndex(ref, "#")
+	if i < 0 || ref[:i] == "" || ref[i+1:] == "" {
+		return "", fmt.Errorf("invalid vault reference %q, expected vault:<path>#<key>", ref)
+	}
+	path, key := strings.Trim(ref[:i], "/"), ref[i+1:]
+
+	data, ok := v.secrets[path]
+	if !ok {
+		var response struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := v.do(ctx, http.MethodGet, path, nil, &response); err != nil {
+			return "", err
+		}
+		data = response.Data
+		if inner, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+			data = inner
+		}
+		v.secrets[path] = data
+	}
+
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no key %q", path, key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	out, err := json.Marshal(value)
+	return string(out), err
+}
+
+// login obtains the token requests to Vault are made with.
+func (v *vaultClient) login(ctx context.Context) error {
+	auth := v.config.Auth
+
+	var body map[string]string
+	switch auth.Method {
+	case vaultAuthToken:
+		v.token = os.Getenv("VAULT_TOKEN")
+		if auth.TokenFile != "" {
+			token, err := ioutil.ReadFile(auth.TokenFile)
+			if err != nil {
+				return err
+			}
+			v.token = strings.TrimSpace(string(token))
+		}
+		if v.token == "" {
+			return errors.New("no vault token, set VAULT_TOKEN or vault.auth.token_file")
+		}
+		return nil
+	case vaultAuthAppRole:
+		secretID, err := ioutil.ReadFile(auth.SecretIDFile)
+		if err != nil {
+			return err
+		}
+		body = map[string]string{"role_id": auth.RoleID, "secret_id": strings.TrimSpace(string(secretID))}
+	case vaultAuthKubernetes:
+		jwt, err := ioutil.ReadFile(auth.JWTFile)
+		if err != nil {
+			return err
+		}
+		body = map[string]string{"role": auth.Role, "jwt": strings.TrimSpace(string(jwt))}
+	}
+
+	var response struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := v.do(ctx, http.MethodPost, "auth/"+strings.Trim(auth.Mount, "/")+"/login", body, &response); err != nil {
+		return fmt.Errorf("vault %s login: %s", auth.Method, err)
+	}
+	if response.Auth.ClientToken == "" {
+		return fmt.Errorf("vault %s login returned no token", auth.Method)
+	}
+	v.token = response.Auth.ClientToken
+	return nil
+}
+
+// do makes a request to the Vault API at path and decodes the response into
+// out, logging in first unless the request is a login.
+func (v *vaultClient) do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	if v.config.Address == "" {
+		return errors.New("no vault address, set VAULT_ADDR or vault.address")
+	}
+	login := strings.HasPrefix(path, "auth/")
+	if v.token == "" && !login {
+		if err := v.login(ctx); err != nil {
+			return err
+		}
+	}
+
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(v.config.Address, "/")+"/v1/"+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if !login {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if v.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.config.Namespace)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var response struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(data, &response) == nil && len(response.Errors) > 0 {
+			return fmt.Errorf("%s: %s", path, strings.Join(response.Errors, "; "))
+		}
+		return fmt.Errorf("%s: unexpected status %s", path, resp.Status)
+	}
+	return json.Unmarshal(data, out)
+}
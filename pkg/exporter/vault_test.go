@@ -0,0 +1,136 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeVault serves a KV version 2 secret and the approle and kubernetes
+// logins.
+func fakeVault() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			if body["role_id"] != "exporter" || body["secret_id"] != "s3cr3t" {
+				http.Error(w, `{"errors": ["invalid role or secret ID"]}`, http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"auth": {"client_token": "approle-token"}}`))
+		case "/v1/auth/k8s/login":
+			if body["role"] != "exporter" || body["jwt"] != "jwt" {
+				http.Error(w, `{"errors": ["permission denied"]}`, http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"auth": {"client_token": "k8s-token"}}`))
+		case "/v1/secret/data/ndt":
+			switch r.Header.Get("X-Vault-Token") {
+			case "root-token", "approle-token", "k8s-token":
+				w.Write([]byte(`{"data": {"data": {"key": "abc123", "port": 443}, "metadata": {"version": 1}}}`))
+			default:
+				http.Error(w, `{"errors": ["permission denied"]}`, http.StatusForbidden)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestVault(t *testing.T) {
+	server := fakeVault()
+	defer server.Close()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"token":     "root-token\n",
+		"bad-token": "guess",
+		"secret-id": "s3cr3t",
+		"jwt":       "jwt",
+	})
+
+	auths := map[string]string{
+		"token":      "token_file: " + filepath.Join(dir, "token"),
+		"approle":    "method: approle\n    role_id: exporter\n    secret_id_file: " + filepath.Join(dir, "secret-id"),
+		"kubernetes": "method: kubernetes\n    mount: k8s\n    role: exporter\n    jwt_file: " + filepath.Join(dir, "jwt"),
+	}
+	for name, auth := range auths {
+		t.Run(name, func(t *testing.T) {
+			config, err := LoadConfig(writeConfig(t, `
+vault:
+  address: `+server.URL+`
+  auth:
+    `+auth+`
+scripts:
+  - name: ndt
+    script: exit 0
+    env:
+      API_KEY: vault:secret/data/ndt#key
+      PORT: vault:secret/data/ndt#port
+      MODE: plain
+`))
+			if err != nil {
+				t.Fatalf("Unexpected: %s", err)
+			}
+
+			env := strings.Join(scriptEnv(config.Scripts[0], ""), " ")
+			if env != "API_KEY=abc123 MODE=plain PORT=443 TARGET=" {
+				t.Errorf("Unexpected env: %s", env)
+			}
+
+			// The secrets are never part of the rendered config.
+			out, _ := config.redactedYAML()
+			if strings.Contains(string(out), "abc123") || config.Scripts[0].Env["API_KEY"] != "vault:secret/data/ndt#key" {
+				t.Errorf("Expected the secret to be kept out of the config")
+			}
+		})
+	}
+
+	for auth, expected := range map[string]string{
+		"token_file: " + filepath.Join(dir, "bad-token"):                                        "permission denied",
+		"method: approle\n    role_id: other\n    secret_id_file: " + filepath.Join(dir, "jwt"): "invalid role or secret ID",
+		"method: approle":  "requires role_id and secret_id_file",
+		"method: password": `unknown auth method "password"`,
+	} {
+		_, err := LoadConfig(writeConfig(t, `
+vault:
+  address: `+server.URL+`
+  auth:
+    `+auth+`
+scripts:
+  - name: ndt
+    script: exit 0
+    env:
+      API_KEY: vault:secret/data/ndt#key
+`))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+
+	for ref, expected := range map[string]string{
+		"vault:secret/data/ndt":         "expected vault:<path>#<key>",
+		"vault:secret/data/ndt#missing": `has no key "missing"`,
+		"vault:secret/data/other#key":   "404",
+	} {
+		_, err := LoadConfig(writeConfig(t, `
+vault:
+  address: `+server.URL+`
+  auth:
+    `+auths["token"]+`
+scripts:
+  - name: ndt
+    script: exit 0
+    env:
+      API_KEY: `+ref+`
+`))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// webhookTimeout bounds the delivery of a webhook notification.
+const webhookTimeout = 10 * time.Second
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+var webhookFailures = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "script_exporter_webhook_failures_total",
+	Help: "Number of webhook notifications that could not be delivered.",
+})
+
+func init() {
+	prometheus.MustRegister(webhookFailures)
+}
+
+// Webhook is notified with a JSON POST when a scheduled script turns from
+// succeeding to failing or back.
+type Webhook struct {
+	URL string `yaml:"url"`
+
+	// Debounce is the number of consecutive runs a script must have had the
+	// new outcome for before it is notified, 1 by default.
+	Debounce int `yaml:"debounce,omitempty"`
+}
+
+func (w *Webhook) setDefaults() error {
+	target, err := url.Parse(w.URL)
+	if err != nil {
+		return err
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return errors.New("url must be an http:// or https:// URL")
+	}
+	if w.Debounce < 0 {
+		return errors.New("debounce must not be negative")
+	}
+	if w.Debounce == 0 {
+		w.Debounce = 1
+	}
+	return nil
+}
+
+// webhookPayload is the body of a webhook notification.
+type webhookPayload struct {
+	Script      string            `json:"script"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	State       string            `json:"state"`
+	Previous    string            `json:"previous_state"`
+	ErrorReason string            `json:"error_reason,omitempty"`
+	ExitCode    int               `json:"exit_code"`
+	Time        time.Time         `json:"time"`
+}
+
+// webhookStates tracks the outcome of the scheduled scripts last notified to
+// every webhook.
+type webhookStates struct {
+	mu     sync.Mutex
+	states map[string]*webhookState
+}
+
+type webhookState struct {
+	success bool
+	pending int
+}
+
+// observe records an outcome of script for hook, reporting whether it
+// changed the state of the script after hook.Debounce runs. The first
+// outcome observed only sets the state.
+func (s *webhookStates) observe(hook *Webhook, script string, success bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.states == nil {
+		s.states = make(map[string]*webhookState)
+	}
+	key := hook.URL + "\x00" + script
+	state, ok := s.states[key]
+	if !ok {
+		s.states[key] = &webhookState{success: success}
+		return false
+	}
+	if success == state.success {
+		state.pending = 0
+		return false
+	}
+	if state.pending++; state.pending < hook.Debounce {
+		return false
+	}
+	state.success, state.pending = success, 0
+	return true
+}
+
+// notifyWebhooks notifies the webhooks of config in the background if
+// measurement changed the state of its script.
+func (e *Exporter) notifyWebhooks(config *Config, measurement *Measurement) {
+	if measurement.Disabled || measurement.Paused || measurement.Cancelled {
+		return
+	}
+
+	success := measurement.Success == 1
+	for _, hook := range config.Webhooks {
+		if !e.webhooks.observe(hook, measurement.Script.Name, success) {
+			continue
+		}
+		payload := webhookPayload{
+			Script:      measurement.Script.Name,
+			Labels:      measurement.Script.Labels,
+			State:       outcome(success),
+			Previous:    outcome(!success),
+			ErrorReason: measurement.ErrorReason,
+			ExitCode:    measurement.ExitCode,
+			Time:        measurement.Start,
+		}
+		go func(hook *Webhook) {
+			if err := hook.send(payload); err != nil {
+				webhookFailures.Inc()
+				log.Printf("ERROR: notifying %s of %s failed: %s\n", hook.URL, payload.Script, err)
+			}
+		}(hook)
+	}
+}
+
+func outcome(success bool) string {
+	if success {
+		return "success"
+	}
+	return "failure"
+}
+
+func (w *Webhook) send(payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
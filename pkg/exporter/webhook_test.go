@@ -0,0 +1,56 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhooks(t *testing.T) {
+	payloads := make(chan webhookPayload, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhookPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+	}))
+	defer server.Close()
+
+	config, err := LoadConfig(writeConfig(t, `
+webhooks:
+  - url: `+server.URL+`
+    debounce: 2
+scripts:
+  - name: flapping
+    interval: 60
+    script: exit 0
+`))
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+	script := config.Script("flapping")
+
+	e := New("")
+	for _, success := range []int{1, 0, 1, 0, 0, 0} {
+		e.notifyWebhooks(config, &Measurement{Script: script, Success: success, ExitCode: 1 - success})
+	}
+
+	select {
+	case payload := <-payloads:
+		if payload.Script != "flapping" || payload.State != "failure" || payload.Previous != "success" || payload.ExitCode != 1 {
+			t.Errorf("Unexpected payload: %+v", payload)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Expected a notification of the script failing twice in a row")
+	}
+	select {
+	case payload := <-payloads:
+		t.Errorf("Expected a single notification, got %+v", payload)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if _, err := LoadConfig(writeConfig(t, "webhooks:\n  - url: ftp://example.org\nscripts: []\n")); err == nil {
+		t.Errorf("Expected a webhook URL other than http:// or https:// to be rejected")
+	}
+}
@@ -2,231 +2,466 @@ package main
 
 import (
 	"context"
-	"errors"
-	"flag"
+	"crypto/tls"
+	"crypto/x509"
+	"expvar"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
 	"os"
-	"os/exec"
-	"regexp"
-	"syscall"
-	"time"
-
-	"gopkg.in/yaml.v2"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/version"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/m-lab/script_exporter/pkg/exporter"
 )
 
 var (
-	showVersion   = flag.Bool("version", false, "Print version information.")
-	configFile    = flag.String("config.file", "script-exporter.yml", "Script exporter configuration file.")
-	listenAddress = flag.String("web.listen-address", ":9172", "The address to listen on for HTTP requests.")
-	metricsPath   = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
-	shell         = flag.String("config.shell", "/bin/sh", "Shell to execute script")
-	// A regex pattern that only matches valid ASCII domain name characters to
-	// prevent inadvertent or malicious injection of special shell characters
-	// into the scripts environment.
-	targetRegexp = regexp.MustCompile("^[a-zA-Z0-9-.]{4,253}$")
+	app = kingpin.New("script_exporter", "Prometheus exporter running scripts on request.").DefaultEnvars()
+
+	configFiles   = app.Flag("config.file", "Script exporter configuration file or http://, https://, s3:// or gs:// URL; repeat to merge the scripts of several files.").Default("script-exporter.yml").Strings()
+	listenAddress = app.Flag("web.listen-address", "The address to listen on for HTTP requests, or unix:///path/to/socket for a Unix domain socket; repeat to listen on several addresses.").Default(":9172").Strings()
+	adminAddress  = app.Flag("web.admin-listen-address", "The address to serve /probe, the configuration, /-/reload and the other endpoints running scripts or revealing the configuration on instead of --web.listen-address, which keeps serving metrics, health and the landing page.").String()
+	systemdSocket = app.Flag("web.systemd-socket", "Serve HTTP on the sockets passed by systemd socket activation instead of --web.listen-address.").Bool()
+	metricsPath   = app.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+	externalURL   = app.Flag("web.external-url", "The URL the exporter is reachable at, e.g. through a reverse proxy; its path prefixes the links of the landing page and is the default of --web.route-prefix.").URL()
+	routePrefix   = app.Flag("web.route-prefix", "Prefix of the paths of all HTTP endpoints.").String()
+	metricPrefix  = app.Flag("web.metric-prefix", "Prefix of the names of the metrics reported for scripts without a metric_prefix.").Default("script").String()
+	shell         = app.Flag("config.shell", "Shell to execute script").Default(exporter.DefaultShell).String()
+	dryRunConfig  = app.Flag("config.dry-run", "Check script syntax and name uniqueness before activating a loaded configuration.").Bool()
+	syntaxCheck   = app.Flag("config.syntax-check", "Check script syntax before activating a loaded configuration.").Default("true").Bool()
+	remoteCache   = app.Flag("config.remote-cache-dir", "Directory keeping the last fetched copy of remote configuration files.").Default(exporter.DefaultRemoteCacheDir).String()
+	remoteToken   = app.Flag("config.remote-bearer-token-file", "File holding the bearer token remote configuration files are fetched with.").String()
+	remoteRefresh = app.Flag("config.remote-refresh-interval", "Interval remote configuration files are checked for changes at; 0 disables the check.").Default("5m").Duration()
+	configFormat  = app.Flag("config.format", "Format of the configuration files; told by their extension if unset.").Enum(exporter.ConfigFormats...)
+	expandEnv     = app.Flag("config.expand-env", "Expand ${VAR} and ${VAR:-default} references to environment variables in the configuration values, except in inline scripts.").Bool()
+	dockerHost    = app.Flag("docker.host", "Docker daemon socket used by scripts with the docker runner.").Default(exporter.DefaultDockerHost).String()
+	watchConfig   = app.Flag("config.watch", "Reload the configuration when the config file, a script file or a Consul or etcd key changes.").Bool()
+	grpcAddress   = app.Flag("grpc.listen-address", "The address to serve the gRPC API on, or unix:///path/to/socket; disabled if unset.").String()
+	tlsCertFile   = app.Flag("web.tls-cert-file", "Certificate to serve HTTPS with.").String()
+	tlsKeyFile    = app.Flag("web.tls-key-file", "Private key of --web.tls-cert-file.").String()
+	tlsClientCA   = app.Flag("web.tls-client-ca-file", "CA certificates client certificates are verified against; required by probe_auth.client_certificate.").String()
+	signingKeys   = app.Flag("script.signing-keys", "File of Ed25519 public keys one of which every script must be signed with; repeat for several files.").Strings()
+	scriptsAPI    = app.Flag("web.enable-scripts-api", "Enable adding, updating and deleting scripts at runtime through /api/v1/scripts, protected by scripts_api_auth.").Bool()
+	scriptsFile   = app.Flag("scripts-api.file", "File persisting the scripts added through the scripts API.").String()
+	enablePprof   = app.Flag("web.enable-pprof", "Expose pprof and expvar diagnostics under /debug/.").Bool()
+	historySize   = app.Flag("history.size", "Number of recent executions kept per script for /history.").Default("10").Int()
+	historyDB     = app.Flag("history.database", "SQLite database every execution is recorded in and /api/v1/history is served from.").String()
+	historyKeep   = app.Flag("history.retention", "How long executions are kept in --history.database; 0 keeps them forever.").Default("168h").Duration()
+	outputLimit   = app.Flag("script.max-output-bytes", "Bytes of output captured per run of scripts without an output_limit; 0 for no limit.").Default("1MiB").Bytes()
+	artifactsDir  = app.Flag("artifacts.dir", "Directory the artifacts of scripts are kept in.").Default(exporter.DefaultArtifactsDir).String()
+	noReaper      = app.Flag("runner.disable-reaper", "Do not become the subreaper of the processes scripts leave behind, leaving them to init, e.g. when not running as PID 1 of a container.").Bool()
+	initMode      = app.Flag("init", "Act as the init process of a container: reap orphans, forward SIGHUP, SIGUSR1 and SIGUSR2 to scripts, and shut down in order on SIGTERM or SIGINT.").Bool()
+	initGrace     = app.Flag("init.grace-period", "How long scripts are given to exit on shutdown with --init before being killed.").Default("10s").Duration()
+	nodeMetadata  = app.Flag("node.metadata-provider", "Cloud metadata server the zone and instance of the node are passed to scripts from.").Enum(exporter.MetadataProviders...)
+	fileSDOutput  = app.Flag("file-sd.output", "File a Prometheus file_sd document of the scripts and module targets is written to whenever the configuration is loaded.").String()
+	fileSDAddress = app.Flag("file-sd.address", "Address Prometheus reaches the exporter at in --file-sd.output; the host of --web.external-url or localhost:9172 by default.").String()
+	startupCheck  = app.Flag("runner.startup-check", "Probe every script once at startup, reporting not ready on /-/ready until done.").Bool()
+	checkTarget   = app.Flag("runner.startup-check-target", "Target the scripts are probed against by --runner.startup-check.").String()
+	exposeResults = app.Flag("metrics.expose-results", "Expose the latest result of every probed script on --web.telemetry-path, as is always done for scripts with an interval.").Bool()
+	resultsTTL    = app.Flag("metrics.results-ttl", "Age after which results exposed on --web.telemetry-path expire; 0 keeps them until their script is removed.").Default("0s").Duration()
+	resultsFile   = app.Flag("metrics.results-file", "File the results exposed on --web.telemetry-path are saved to and restored from on startup.").String()
+	scheduleSplay = app.Flag("schedule.splay", "Maximum random delay of the first run of every script with an interval.").Default("0s").Duration()
+	probeMaxWait  = app.Flag("probe.max-wait", "Overall deadline of a probe across all the scripts it matches, also bounding its max_wait parameter; scripts still running are reported as timed out. 0 waits for every script.").Default("0s").Duration()
+	statusCodes   = app.Flag("probe.status-codes", "Respond to probes with 503 instead of 200 when all scripts probed fail, and 404 instead of 400 when none match.").Bool()
+	allowNoMatch  = app.Flag("probe.allow-no-match", "Respond to probes matching no script with an empty 200 response instead of 400.").Bool()
+	maintenance   = app.Flag("maintenance", "Start with running scripts paused, as by POST /-/pause, until POST /-/resume.").Bool()
+	buckets       = app.Flag("metrics.duration-buckets", "Bucket of the run duration histograms of scripts without duration_buckets; repeat for several buckets.").Float64List()
+
+	serveCommand = app.Command("serve", "Serve probes over HTTP.").Default()
+
+	runCommand = app.Command("run", "Run scripts once as /probe would, print their metrics and exit with the exit code of the first failed script.")
+	runScript  = runCommand.Arg("script", "Name of the script to run, like --name.").String()
+	runName    = runCommand.Flag("name", "Name of the script to run.").String()
+	runPattern = runCommand.Flag("pattern", "Regular expression matching the names of the scripts to run.").String()
+	runModule  = runCommand.Flag("module", "Name of the module to run.").String()
+	runTarget  = runCommand.Flag("target", "Target to run the scripts against.").String()
+	runMaxWait = runCommand.Flag("max-wait", "Seconds to wait for the scripts, like the max_wait parameter.").String()
+	runDebug   = runCommand.Flag("debug", "Print the output and error reason of every script to stderr.").Bool()
+
+	checkConfigCommand = app.Command("check-config", "Check the configuration file, including a dry run, and exit.")
+
+	scrapeConfigCommand = app.Command("generate-scrape-config", "Print the Prometheus scrape configs of the configured scripts and modules, and exit.")
+	scrapeAddress       = scrapeConfigCommand.Flag("address", "Address Prometheus reaches the exporter at; the host of --web.external-url or localhost:9172 by default.").String()
 )
 
-type Config struct {
-	Scripts []*Script `yaml:"scripts"`
+func init() {
+	prometheus.MustRegister(version.NewCollector("script_exporter"))
 }
 
-type Script struct {
-	Name    string `yaml:"name"`
-	Content string `yaml:"script"`
-	Timeout int64  `yaml:"timeout"`
-}
+func main() {
+	app.Version(version.Print("script_exporter"))
+	app.HelpFlag.Short('h')
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+
+	e := exporter.New((*configFiles)[0])
+	e.AdditionalConfigFiles = (*configFiles)[1:]
+	e.Shell = *shell
+	e.DockerHost = *dockerHost
+	e.HistorySize = *historySize
+	e.OutputLimit = int64(*outputLimit)
+	e.ArtifactsDir = *artifactsDir
+	e.DryRun = *dryRunConfig
+	e.SyntaxCheck = *syntaxCheck
+	e.ExpandEnv = *expandEnv
+	e.ConfigFormat = *configFormat
+	e.RemoteCacheDir = *remoteCache
+	e.RemoteBearerTokenFile = *remoteToken
+	e.ScriptsAPI = *scriptsAPI
+	for _, path := range *signingKeys {
+		keys, err := exporter.LoadSigningKeys(path)
+		if err != nil {
+			log.Fatalf("Error loading signing keys: %s\n", err)
+		}
+		e.SigningKeys = append(e.SigningKeys, keys...)
+	}
+	e.ScriptsFile = *scriptsFile
+	e.MetricsPath = *metricsPath
+	e.LinkPrefix = strings.TrimRight(*routePrefix, "/")
+	if *externalURL != nil {
+		e.LinkPrefix = strings.TrimRight((*externalURL).Path, "/")
+		if *routePrefix == "" {
+			*routePrefix = e.LinkPrefix
+		}
+	}
+	e.MetricPrefix = *metricPrefix
+	e.ExposeResults = *exposeResults
+	e.ResultsTTL = *resultsTTL
+	e.ScheduleSplay = *scheduleSplay
+	e.MaxWait = *probeMaxWait
+	e.StatusCodes = *statusCodes
+	e.AllowNoMatch = *allowNoMatch
+	e.MetadataProvider = *nodeMetadata
+	if *fileSDOutput != "" {
+		e.FileSDPath = *fileSDOutput
+		e.FileSDAddress, e.FileSDScheme = scrapeTarget(*fileSDAddress)
+	}
+	if *maintenance {
+		e.Pause()
+	}
+	if len(*buckets) > 0 {
+		e.DurationBuckets = *buckets
+	}
 
-type Measurement struct {
-	Script   *Script
-	Success  int
-	ExitCode int
-	Duration float64
+	switch command {
+	case runCommand.FullCommand():
+		run(e)
+	case checkConfigCommand.FullCommand():
+		checkConfig(e)
+	case scrapeConfigCommand.FullCommand():
+		generateScrapeConfig(e)
+	case serveCommand.FullCommand():
+		serve(e)
+	}
 }
 
-func runScript(script *Script, target string) (err error, rc int) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(script.Timeout)*time.Second)
-	defer cancel()
+// serve loads the configuration and serves probes until the process is
+// terminated.
+func serve(e *exporter.Exporter) {
+	log.Println("Starting script_exporter", version.Info())
 
-	cmd := exec.CommandContext(ctx, *shell)
-	cmd.Env = append(os.Environ(), fmt.Sprintf("TARGET=%s", target))
+	// With --init, shutting down cancels ctx, which stops the scheduled
+	// scripts, the daemons and the HTTP servers.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var stopped <-chan struct{}
+	if *initMode {
+		if *noReaper {
+			log.Fatalf("--init cannot be used with --runner.disable-reaper\n")
+		}
+		var err error
+		if stopped, err = e.Init(*initGrace, cancel); err != nil {
+			log.Fatalf("Error running as init: %s\n", err)
+		}
+	} else if !*noReaper {
+		if err := exporter.StartReaper(context.Background()); err != nil {
+			log.Printf("ERROR: Cannot reap the orphans of scripts: %s\n", err)
+		}
+	}
 
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return err, 1
+	if err := e.Reload(); err != nil {
+		log.Fatalf("Error loading config file: %s\n", err)
 	}
 
-	if _, err = stdin.Write([]byte(script.Content)); err != nil {
-		return err, 1
+	if *watchConfig {
+		if err := e.Watch(); err != nil {
+			log.Fatalf("Error watching config file: %s\n", err)
+		}
+		e.WatchKV()
 	}
-	stdin.Close()
 
-	if err = cmd.Run(); err != nil {
-		exitError, ok := err.(*exec.ExitError)
-		if ok {
-			rc = exitError.Sys().(syscall.WaitStatus).ExitStatus()
+	if *remoteRefresh > 0 {
+		e.RefreshRemote(*remoteRefresh)
+	}
 
-		} else {
-			log.Printf("ERROR: cmd.Run() failed with error: %v\n", err)
-			rc = 1
+	if *historyDB != "" {
+		if err := e.OpenHistoryDatabase(*historyDB, *historyKeep); err != nil {
+			log.Fatalf("Error opening history database: %s\n", err)
 		}
-	} else {
-		rc = cmd.ProcessState.Sys().(syscall.WaitStatus).ExitStatus()
 	}
 
-	return err, rc
-}
+	if *resultsFile != "" {
+		if err := e.PersistResults(*resultsFile); err != nil {
+			log.Fatalf("Error restoring results: %s\n", err)
+		}
+	}
 
-func runScripts(scripts []*Script, target string) []*Measurement {
-	measurements := make([]*Measurement, 0)
+	if *startupCheck {
+		e.StartupCheck(*checkTarget)
+	}
 
-	ch := make(chan *Measurement)
+	e.Schedule(ctx)
+	e.RunDaemons(ctx)
+
+	// A dedicated mux keeps the handlers net/http/pprof and expvar register
+	// on http.DefaultServeMux from being exposed unless enabled. With an
+	// admin listener, the endpoints running scripts or revealing the
+	// configuration are only served on it.
+	mux := http.NewServeMux()
+	admin := mux
+	if *adminAddress != "" {
+		admin = http.NewServeMux()
+		admin.Handle(*metricsPath, promhttp.Handler())
+		e.RegisterPublicHandlers(admin)
+	}
 
-	for _, script := range scripts {
-		go func(script *Script) {
-			start := time.Now()
-			success := 0
-			err, rc := runScript(script, target)
-			duration := time.Since(start).Seconds()
+	mux.Handle(*metricsPath, promhttp.Handler())
+	e.RegisterPublicHandlers(mux)
+	e.RegisterAdminHandlers(admin)
+
+	if *enablePprof {
+		admin.HandleFunc("/debug/pprof/", pprof.Index)
+		admin.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		admin.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		admin.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		admin.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		admin.Handle("/debug/vars", expvar.Handler())
+	}
 
-			if err == nil {
-				log.Printf("OK: %s to %s (after %fs).\n", script.Name, target, duration)
-				success = 1
-			} else {
-				log.Printf("ERROR: %s to %s: %s (failed after %fs).\n", script.Name, target, err, duration)
-			}
+	if *grpcAddress != "" {
+		go serveGRPC(e)
+	}
 
-			ch <- &Measurement{
-				Script:   script,
-				Duration: duration,
-				Success:  success,
-				ExitCode: rc,
+	var listeners []net.Listener
+	var err error
+	if *systemdSocket {
+		listeners, err = exporter.SystemdListeners()
+	} else {
+		for _, address := range *listenAddress {
+			var listener net.Listener
+			if listener, err = exporter.Listen(address); err != nil {
+				break
 			}
-		}(script)
+			listeners = append(listeners, listener)
+		}
+	}
+	var adminListener net.Listener
+	if err == nil && *adminAddress != "" {
+		adminListener, err = exporter.Listen(*adminAddress)
+	}
+	if err != nil {
+		log.Fatalf("Error starting HTTP server: %s\n", err)
 	}
 
-	for i := 0; i < len(scripts); i++ {
-		measurements = append(measurements, <-ch)
+	var tlsConfig *tls.Config
+	if *tlsCertFile != "" {
+		if tlsConfig, err = serverTLSConfig(); err != nil {
+			log.Fatalf("Error loading TLS configuration: %s\n", err)
+		}
 	}
 
-	return measurements
+	errs := make(chan error)
+	serveHTTP := func(listener net.Listener, handler http.Handler) {
+		server := &http.Server{Handler: prefixHandler(*routePrefix, handler), TLSConfig: tlsConfig}
+		go func() {
+			<-ctx.Done()
+			server.Close()
+		}()
+		if *tlsCertFile != "" {
+			errs <- server.ServeTLS(listener, *tlsCertFile, *tlsKeyFile)
+		} else {
+			errs <- server.Serve(listener)
+		}
+	}
+	for _, listener := range listeners {
+		log.Println("Listening on", listener.Addr())
+		go serveHTTP(listener, mux)
+	}
+	if adminListener != nil {
+		log.Println("Serving admin endpoints on", adminListener.Addr())
+		go serveHTTP(adminListener, admin)
+	}
+	select {
+	case err := <-errs:
+		if err != http.ErrServerClosed {
+			log.Fatalf("Error starting HTTP server: %s\n", err)
+		}
+		<-stopped
+	case <-stopped:
+	}
+	log.Println("Stopped script_exporter")
 }
 
-func scriptFilter(scripts []*Script, name, pattern string) (filteredScripts []*Script, err error) {
-	if name == "" && pattern == "" {
-		err = errors.New("`name` or `pattern` required")
-		return
+// prefixHandler serves the paths of handler under prefix, redirecting the
+// root to the prefix.
+func prefixHandler(prefix string, handler http.Handler) http.Handler {
+	prefix = "/" + strings.Trim(prefix, "/")
+	if prefix == "/" {
+		return handler
 	}
 
-	var patternRegexp *regexp.Regexp
-
-	if pattern != "" {
-		patternRegexp, err = regexp.Compile(pattern)
-
-		if err != nil {
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, handler))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
 			return
 		}
-	}
+		http.Redirect(w, r, prefix+"/", http.StatusFound)
+	})
+	return mux
+}
 
-	for _, script := range scripts {
-		if script.Name == name || (pattern != "" && patternRegexp.MatchString(script.Name)) {
-			filteredScripts = append(filteredScripts, script)
+// serveGRPC serves the gRPC API on --grpc.listen-address, with the TLS
+// configuration of the HTTPS server if enabled.
+func serveGRPC(e *exporter.Exporter) {
+	var options []grpc.ServerOption
+	if *tlsCertFile != "" {
+		config, err := serverTLSConfig()
+		if err != nil {
+			log.Fatalf("Error loading TLS configuration: %s\n", err)
 		}
+		certificate, err := tls.LoadX509KeyPair(*tlsCertFile, *tlsKeyFile)
+		if err != nil {
+			log.Fatalf("Error loading TLS certificate: %s\n", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+		options = append(options, grpc.Creds(credentials.NewTLS(config)))
 	}
 
-	return
-}
-
-func scriptRunHandler(w http.ResponseWriter, r *http.Request, config *Config) {
-	params := r.URL.Query()
-	name := params.Get("name")
-	pattern := params.Get("pattern")
-	target := params.Get("target")
-
-	scripts, err := scriptFilter(config.Scripts, name, pattern)
+	server := grpc.NewServer(options...)
+	e.RegisterGRPC(server)
 
+	listener, err := exporter.Listen(*grpcAddress)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
+		log.Fatalf("Error starting gRPC server: %s\n", err)
 	}
-
-	// If the passed target does not validate return an error.
-	if target != "" && !targetRegexp.MatchString(target) {
-		log.Printf("ERROR: Target %s failed to match targetRegexp\n", target)
-		http.Error(w, "Invalid target parameter", 400)
-		return
+	log.Println("Serving gRPC on", *grpcAddress)
+	if err := server.Serve(listener); err != nil {
+		log.Fatalf("Error starting gRPC server: %s\n", err)
 	}
+}
 
-	measurements := runScripts(scripts, target)
+// serverTLSConfig returns the TLS configuration of the HTTPS server. Client
+// certificates are verified if given, so that they can be required for /probe
+// without being required for /metrics.
+func serverTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
 
-	for _, measurement := range measurements {
-		fmt.Fprintf(w, "script_duration_seconds{script=\"%s\"} %f\n", measurement.Script.Name, measurement.Duration)
-		fmt.Fprintf(w, "script_success{script=\"%s\"} %d\n", measurement.Script.Name, measurement.Success)
-		fmt.Fprintf(w, "script_exit_code{script=\"%s\"} %d\n", measurement.Script.Name, measurement.ExitCode)
+	if *tlsClientCA != "" {
+		pem, err := ioutil.ReadFile(*tlsClientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", *tlsClientCA)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
 	}
-}
 
-func init() {
-	prometheus.MustRegister(version.NewCollector("script_exporter"))
+	return config, nil
 }
 
-func main() {
-	flag.Parse()
-
-	if *showVersion {
-		fmt.Fprintln(os.Stdout, version.Print("script_exporter"))
-		os.Exit(0)
+// run probes the scripts selected by the run flags once, exactly like /probe
+// with the corresponding query parameters, and prints their metrics to
+// stdout.
+func run(e *exporter.Exporter) {
+	if err := e.Reload(); err != nil {
+		log.Fatalf("Error loading config file: %s\n", err)
 	}
 
-	log.Println("Starting script_exporter", version.Info())
-
-	yamlFile, err := ioutil.ReadFile(*configFile)
+	name := *runName
+	if name == "" {
+		name = *runScript
+	}
 
-	if err != nil {
-		log.Fatalf("Error reading config file: %s\n", err)
+	params := url.Values{}
+	for key, value := range map[string]string{"name": name, "pattern": *runPattern, "module": *runModule, "target": *runTarget, "max_wait": *runMaxWait} {
+		if value != "" {
+			params.Set(key, value)
+		}
 	}
 
-	config := Config{}
+	code := 0
+	err := e.ProbeQuery(context.Background(), e.Config(), params, func(measurement *exporter.Measurement) {
+		if *runDebug {
+			fmt.Fprintf(os.Stderr, "# %s: success=%d exit_code=%d reason=%q\n%s", measurement.Script.Name, measurement.Success, measurement.ExitCode, measurement.ErrorReason, measurement.Output)
+		}
+		exporter.WriteMeasurement(os.Stdout, measurement)
 
-	err = yaml.Unmarshal(yamlFile, &config)
+		if measurement.Success == 0 && code == 0 {
+			code = measurement.ExitCode
+			if code <= 0 {
+				code = 1
+			}
+		}
+	})
 
 	if err != nil {
-		log.Fatalf("Error parsing config file: %s\n", err)
+		log.Fatalf("Error: %s\n", err)
 	}
 
-	log.Printf("Loaded %d script configurations\n", len(config.Scripts))
+	os.Exit(code)
+}
 
-	for _, script := range config.Scripts {
-		if script.Timeout == 0 {
-			script.Timeout = 15
-		}
-	}
+// checkConfig loads the configuration with a dry run and exits.
+func checkConfig(e *exporter.Exporter) {
+	e.DryRun = true
 
-	http.Handle("/metrics", promhttp.Handler())
+	if err := e.Reload(); err != nil {
+		log.Fatalf("Error loading config file: %s\n", err)
+	}
 
-	http.HandleFunc("/probe", func(w http.ResponseWriter, r *http.Request) {
-		scriptRunHandler(w, r, &config)
-	})
+	fmt.Printf("%s: OK\n", strings.Join(*configFiles, ", "))
+}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<html>
-			<head><title>Script Exporter</title></head>
-			<body>
-			<h1>Script Exporter</h1>
-			<p><a href="` + *metricsPath + `">Metrics</a></p>
-			</body>
-			</html>`))
-	})
+// generateScrapeConfig loads the configuration and prints the scrape configs
+// of its scripts and modules.
+func generateScrapeConfig(e *exporter.Exporter) {
+	e.SyntaxCheck = false
+	if err := e.Reload(); err != nil {
+		log.Fatalf("Error loading config file: %s\n", err)
+	}
 
-	log.Println("Listening on", *listenAddress)
+	address, scheme := scrapeTarget(*scrapeAddress)
+	if err := e.WriteScrapeConfig(os.Stdout, address, scheme); err != nil {
+		log.Fatalf("Error: %s\n", err)
+	}
+}
 
-	if err := http.ListenAndServe(*listenAddress, nil); err != nil {
-		log.Fatalf("Error starting HTTP server: %s\n", err)
+// scrapeTarget returns the address and scheme Prometheus scrapes the exporter
+// at: address if set, or else the host of --web.external-url or
+// localhost:9172.
+func scrapeTarget(address string) (string, string) {
+	host, scheme := "localhost:9172", "http"
+	if *tlsCertFile != "" {
+		scheme = "https"
+	}
+	if *externalURL != nil {
+		host, scheme = (*externalURL).Host, (*externalURL).Scheme
+	}
+	if address != "" {
+		host = address
 	}
+	return host, scheme
 }